@@ -22,12 +22,14 @@ import (
 	"github.com/mattermost/mattermost/server/public/plugin"
 	"github.com/mattermost/mattermost/server/public/pluginapi"
 
+	"github.com/mattermost/mattermost-plugin-jira/server/markup"
 	"github.com/mattermost/mattermost-plugin-jira/server/utils"
 )
 
 const autocompleteSearchRoute = "2/jql/autocompletedata/suggestions"
 const userSearchRoute = "2/user/assignable/search"
 const unrecognizedEndpoint = "_unrecognized"
+const groupMembersPageSize = 50
 
 // Client is the combined interface for all upstream APIs and convenience methods.
 type Client interface {
@@ -50,6 +52,7 @@ type RESTService interface {
 type UserService interface {
 	GetSelf() (*jira.User, error)
 	GetUserGroups(connection *Connection) ([]*jira.UserGroup, error)
+	GetGroupMembers(groupName string) ([]jira.GroupMember, error)
 }
 
 // ProjectService is the interface for project-related APIs.
@@ -73,15 +76,30 @@ type IssueService interface {
 	GetIssue(key string, options *jira.GetQueryOptions) (*jira.Issue, error)
 	CreateIssue(issue *jira.Issue) (*jira.Issue, error)
 
-	AddAttachment(mmClient pluginapi.Client, issueKey, fileID string, maxSize utils.ByteSize) (mattermostName, jiraName, mime string, err error)
+	AddAttachment(mmClient pluginapi.Client, issueKey, fileID string, maxSize utils.ByteSize) (mattermostName string, attachment *jira.Attachment, mime string, err error)
 	AddComment(issueKey string, comment *jira.Comment) (*jira.Comment, error)
 	DoTransition(issueKey, transitionID string) error
 	GetCreateMetaInfo(api plugin.API, options *jira.GetQueryOptions) (*jira.CreateMetaInfo, error)
 	GetTransitions(issueKey string) ([]jira.Transition, error)
+	GetWatchers(issueKey string) ([]jira.User, error)
 	UpdateAssignee(issueKey string, user *jira.User) error
 	UpdateComment(issueKey string, comment *jira.Comment) (*jira.Comment, error)
 }
 
+// ADFCommentService is implemented by the clients of the Jira instances that
+// take comments as Atlassian Document Format documents, i.e. Jira Cloud.
+type ADFCommentService interface {
+	AddADFComment(issueKey string, body *markup.ADFNode) (*jira.Comment, error)
+	UpdateADFComment(issueKey, commentID string, body *markup.ADFNode) (*jira.Comment, error)
+}
+
+// ADFIssueService is implemented by the clients of the Jira instances that
+// take the rich text fields of new issues as Atlassian Document Format
+// documents, i.e. Jira Cloud.
+type ADFIssueService interface {
+	CreateADFIssue(issue *jira.Issue, richText map[string]*markup.ADFNode) (*jira.Issue, error)
+}
+
 // JiraClient is the common implementation of most Jira APIs, except those that are
 // Jira Server or Jira Cloud specific.
 type JiraClient struct {
@@ -233,6 +251,43 @@ func (client JiraClient) UpdateComment(issueKey string, comment *jira.Comment) (
 	return updated, err
 }
 
+// GetGroupMembers returns the active members of a group, loading all the
+// pages of the group member API.
+func (client JiraClient) GetGroupMembers(groupName string) ([]jira.GroupMember, error) {
+	members := []jira.GroupMember{}
+	for {
+		result := struct {
+			Members []jira.GroupMember `json:"values"`
+			IsLast  bool               `json:"isLast"`
+		}{}
+		err := client.RESTGet("2/group/member", map[string]string{
+			"groupname":  groupName,
+			"startAt":    strconv.Itoa(len(members)),
+			"maxResults": strconv.Itoa(groupMembersPageSize),
+		}, &result)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "failed to get the members of group %s", groupName)
+		}
+		members = append(members, result.Members...)
+		if result.IsLast || len(result.Members) == 0 {
+			return members, nil
+		}
+	}
+}
+
+// GetWatchers returns the users watching an issue. Unlike the go-jira
+// implementation, it does not load every watcher with a separate request.
+func (client JiraClient) GetWatchers(issueKey string) ([]jira.User, error) {
+	result := struct {
+		Watchers []jira.User `json:"watchers"`
+	}{}
+	err := client.RESTGet(fmt.Sprintf("2/issue/%s/watchers", issueKey), nil, &result)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to get the watchers of issue %s", issueKey)
+	}
+	return result.Watchers, nil
+}
+
 // SearchIssues searches issues as specified by jql and options.
 func (client JiraClient) SearchIssues(jql string, options *jira.SearchOptions) ([]jira.Issue, error) {
 	found, resp, err := client.Jira.Issue.Search(jql, options)
@@ -277,26 +332,26 @@ func (client JiraClient) DoTransition(issueKey, transitionID string) error {
 
 // AddAttachment uploads a file attachment
 func (client JiraClient) AddAttachment(mmClient pluginapi.Client, issueKey, fileID string, maxSize utils.ByteSize) (
-	mattermostName, jiraName, mime string, err error) {
+	mattermostName string, attachment *jira.Attachment, mime string, err error) {
 	fileinfo, err := mmClient.File.GetInfo(fileID)
 	if err != nil {
-		return "", "", "", err
+		return "", nil, "", err
 	}
 	if utils.ByteSize(fileinfo.Size) > maxSize {
-		return fileinfo.Name, "", fileinfo.MimeType,
+		return fileinfo.Name, nil, fileinfo.MimeType,
 			errors.Errorf("Maximum attachment size %v exceeded, file size %v", maxSize, utils.ByteSize(fileinfo.Size))
 	}
 
 	fileBytes, err := mmClient.File.GetByPath(fileinfo.Path)
 	if err != nil {
-		return "", "", "", err
+		return "", nil, "", err
 	}
-	attachment, err := client.RESTPostAttachment(issueKey, fileBytes, fileinfo.Name)
+	attachment, err = client.RESTPostAttachment(issueKey, fileBytes, fileinfo.Name)
 	if err != nil {
-		return fileinfo.Name, "", fileinfo.MimeType, err
+		return fileinfo.Name, nil, fileinfo.MimeType, err
 	}
 
-	return fileinfo.Name, attachment.Filename, fileinfo.MimeType, nil
+	return fileinfo.Name, attachment, fileinfo.MimeType, nil
 }
 
 // GetSelf returns a user associated with this Jira client
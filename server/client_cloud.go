@@ -6,11 +6,14 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"strconv"
 
 	jira "github.com/andygrunwald/go-jira"
 	"github.com/mattermost/mattermost/server/public/plugin"
 	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/markup"
 )
 
 type jiraCloudClient struct {
@@ -52,6 +55,69 @@ func (client jiraCloudClient) GetCreateMetaInfo(api plugin.API, options *jira.Ge
 	return cimd, nil
 }
 
+// GetIssue returns an issue, with its description rendered as Markdown.
+func (client jiraCloudClient) GetIssue(key string, options *jira.GetQueryOptions) (*jira.Issue, error) {
+	issue, resp, err := getCloudIssue(client.Jira, key, options)
+	if err != nil {
+		return nil, userFriendlyJiraError(resp, err)
+	}
+	return issue, nil
+}
+
+// AddADFComment adds a comment to an issue with the v3 API.
+func (client jiraCloudClient) AddADFComment(issueKey string, body *markup.ADFNode) (*jira.Comment, error) {
+	return client.sendADFComment(http.MethodPost, fmt.Sprintf("rest/api/3/issue/%s/comment", issueKey), body)
+}
+
+// UpdateADFComment changes a comment of an issue with the v3 API.
+func (client jiraCloudClient) UpdateADFComment(issueKey, commentID string, body *markup.ADFNode) (*jira.Comment, error) {
+	return client.sendADFComment(http.MethodPut, fmt.Sprintf("rest/api/3/issue/%s/comment/%s", issueKey, commentID), body)
+}
+
+func (client jiraCloudClient) sendADFComment(method, endpoint string, body *markup.ADFNode) (*jira.Comment, error) {
+	req, err := client.Jira.NewRequest(method, endpoint, map[string]interface{}{"body": body})
+	if err != nil {
+		return nil, err
+	}
+	raw := json.RawMessage{}
+	resp, err := client.Jira.Do(req, &raw)
+	if err != nil {
+		return nil, userFriendlyJiraError(resp, err)
+	}
+	comment := &jira.Comment{}
+	if err = unmarshalADFJSON(raw, comment); err != nil {
+		return nil, errors.Wrap(err, "failed to decode the comment")
+	}
+	return comment, nil
+}
+
+// CreateADFIssue creates an issue with the v3 API, with the rich text fields
+// given as ADF documents and the other fields as for the v2 API.
+func (client jiraCloudClient) CreateADFIssue(issue *jira.Issue, richText map[string]*markup.ADFNode) (*jira.Issue, error) {
+	data, err := json.Marshal(issue.Fields)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode the issue fields")
+	}
+	fields := map[string]interface{}{}
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return nil, errors.Wrap(err, "failed to encode the issue fields")
+	}
+	for id, doc := range richText {
+		fields[id] = doc
+	}
+
+	req, err := client.Jira.NewRequest(http.MethodPost, "rest/api/3/issue", map[string]interface{}{"fields": fields})
+	if err != nil {
+		return nil, err
+	}
+	created := &jira.Issue{}
+	resp, err := client.Jira.Do(req, created)
+	if err != nil {
+		return nil, userFriendlyJiraError(resp, err)
+	}
+	return created, nil
+}
+
 // SearchUsersAssignableToIssue finds all users that can be assigned to an issue.
 func (client jiraCloudClient) SearchUsersAssignableToIssue(issueKey, query string, maxResults int) ([]jira.User, error) {
 	return SearchUsersAssignableToIssue(client, issueKey, "query", query, maxResults)
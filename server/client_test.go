@@ -5,8 +5,11 @@ package main
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"testing"
 
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -17,6 +20,7 @@ func TestEndpointNameFromRequest(t *testing.T) {
 		{"GetCreateIssueMetadata", "https://hostname/rest/api/2/issue", "GET", "api/jira/2/issue/GET"},
 		{"AddAttachment", "https://hostname/2/issue/MM-1543/attachments", "POST", "api/jira/2/issue/attachments/POST"},
 		{"GetUserGroups", "https://hostname/3/user/groups", "GET", "api/jira/3/user/groups/GET"},
+		{"GetGroupMembers", "https://hostname/2/group/member", "GET", "api/jira/2/group/member/GET"},
 		{"Myself", "https://hostname/2/myself", "GET", "api/jira/2/myself/GET"},
 		{"SearchUserAssignableToIssue", "https://hostname/2/user/assignable/search", "GET", "api/jira/2/user/assignable/search/GET"},
 		{"GetProject", "https://hostname/2/project/XYZ", "GET", "api/jira/2/project/GET"},
@@ -41,3 +45,25 @@ func TestEndpointNameFromRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestGetGroupMembers(t *testing.T) {
+	var queries []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/rest/api/2/group/member", r.URL.Path)
+		queries = append(queries, r.URL.Query().Get("groupname")+"@"+r.URL.Query().Get("startAt"))
+		if r.URL.Query().Get("startAt") == "0" {
+			_, _ = w.Write([]byte(`{"isLast": false, "values": [{"accountId": "user1"}, {"accountId": "user2"}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"isLast": true, "values": [{"accountId": "user3"}]}`))
+	}))
+	defer ts.Close()
+	jiraClient, err := jira.NewClient(ts.Client(), ts.URL)
+	require.NoError(t, err)
+
+	members, err := JiraClient{Jira: jiraClient}.GetGroupMembers("jira developers")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"jira developers@0", "jira developers@2"}, queries)
+	require.Len(t, members, 3)
+	assert.Equal(t, "user3", members[2].AccountID)
+}
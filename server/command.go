@@ -6,6 +6,7 @@ import (
 	"net/url"
 	"sort"
 	"strings"
+	"time"
 
 	jira "github.com/andygrunwald/go-jira"
 	"github.com/pkg/errors"
@@ -26,6 +27,7 @@ var jiraCommandHandler = CommandHandler{
 	handlers: map[string]CommandHandlerFunc{
 		"assign":                       executeAssign,
 		"connect":                      executeConnect,
+		"create":                       executeIssueCreate,
 		"disconnect":                   executeDisconnect,
 		"help":                         executeHelp,
 		"me":                           executeMe,
@@ -45,17 +47,29 @@ var jiraCommandHandler = CommandHandler{
 		"instance/uninstall":           executeInstanceUninstall,
 		"instance/v2":                  executeInstanceV2Legacy,
 		"issue/assign":                 executeAssign,
+		"issue/create":                 executeIssueCreate,
+		"issue/link-thread":            executeIssueLinkThread,
+		"issue/previews":               executeIssuePreviews,
 		"issue/transition":             executeTransition,
 		"issue/unassign":               executeUnassign,
+		"issue/unlink-thread":          executeIssueUnlinkThread,
 		"issue/view":                   executeView,
 		"settings":                     executeSettings,
+		"search":                       executeSearch,
 		"subscribe/list":               executeSubscribeList,
+		"subscribe/test":               executeSubscribeTest,
+		"subscribe/export":             executeSubscribeExport,
+		"subscribe/import":             executeSubscribeImport,
+		"subscribe/policy":             executeSubscribePolicy,
+		"subscribe/mute":               executeSubscribeMute,
+		"subscribe/unmute":             executeSubscribeUnmute,
 		"transition":                   executeTransition,
 		"unassign":                     executeUnassign,
 		"uninstall":                    executeInstanceUninstall,
 		"view":                         executeView,
 		"v2revert":                     executeV2Revert,
 		"webhook":                      executeWebhookURL,
+		"webhook/deadletter":           executeWebhookDeadLetter,
 		"setup":                        executeSetup,
 	},
 	defaultHandler: executeJiraDefault,
@@ -68,16 +82,24 @@ const commonHelpText = "\n" +
 	"* `/jira disconnect [jiraURL]` - Disconnect your Mattermost account from your Jira account\n" +
 	"* `/jira [issue] assign [issue-key] [assignee]` - Change the assignee of a Jira issue\n" +
 	"* `/jira [issue] create [text]` - Create a new Issue with 'text' inserted into the description field\n" +
+	"* `/jira [issue] create [--project=KEY] [--type=TYPE] [--priority=NAME] [--labels=a,b] \"Summary\" [description]` - Create a new Issue without the dialog. The project and type default to the last ones used\n" +
 	"* `/jira [issue] transition [issue-key] [state]` - Change the state of a Jira issue\n" +
 	"* `/jira [issue] unassign [issue-key]` - Unassign the Jira issue\n" +
 	"* `/jira [issue] view [issue-key]` - View the details of a specific Jira issue\n" +
+	"* `/jira issue link-thread [issue-key]` - Sync the comments of a Jira issue with this thread, or with a new thread\n" +
+	"* `/jira issue unlink-thread` - Stop syncing this thread with its Jira issue\n" +
+	"* `/jira issue previews [on|off]` - Turn the previews of the Jira issues mentioned in this channel on or off\n" +
+	"* `/jira search [JQL or text] [--limit=N]` - Search for Jira issues\n" +
 	"* `/jira help` - Launch the Jira plugin command line help syntax\n" +
 	"* `/jira me` - Display information about the current user\n" +
 	"* `/jira about` - Display build info\n" +
 	"* `/jira instance list` - List installed Jira instances\n" +
 	"* `/jira instance settings [setting] [value]` - Update your user settings\n" +
-	"  * [setting] can be `notifications`\n" +
-	"  * [value] can be `on` or `off`\n" +
+	"  * [setting] can be `notifications`, `delivery` or `quiet-hours`\n" +
+	"  * [value] can be `on` or `off` for `notifications`\n" +
+	"  * [value] can also be `[category] on` or `[category] off` for `notifications`, where [category] is `assigned`, `mentioned`, `reporter-comments`, `assignee-comments`, `status-changes` or `watching`\n" +
+	"  * [value] can be `instant`, `hourly`, `daily [HH:MM]` or `weekly [HH:MM] [day]` for `delivery`, to receive your notifications in a digest\n" +
+	"  * [value] can be `[HH:MM] [HH:MM]` or `off` for `quiet-hours`, to hold your notifications between these times\n" +
 	""
 
 const sysAdminHelpText = "\n###### For System Administrators:\n" +
@@ -90,11 +112,18 @@ const sysAdminHelpText = "\n###### For System Administrators:\n" +
 	"Manage channel subscriptions:\n" +
 	"* `/jira subscribe ` - Configure the Jira notifications sent to this channel\n" +
 	"* `/jira subscribe list` - Display all the the subscription rules setup across all the channels and teams on your Mattermost instance\n" +
+	"* `/jira subscribe test [subscription-name]` - Show which recent issues and events would match a subscription of this channel, and why the others would not\n" +
+	"* `/jira subscribe export [--channel|--team|--all] [--format=json|yaml]` - Export the subscriptions of this channel, of this team, or of all channels, to a file sent to you in a direct message\n" +
+	"* `/jira subscribe import [--dry-run] [--on-conflict=skip|overwrite|rename] [permalink]` - Import exported subscriptions in the channels with the same team and channel names, from the file attached to a post or pasted on the next lines\n" +
+	"* `/jira subscribe mute <subscription-name> [duration]` - Stop posting the events of a subscription of this channel, until it is unmuted or for a duration, as 30m, 4h, 3d or 2w\n" +
+	"* `/jira subscribe unmute <subscription-name>` - Post the events of a muted subscription of this channel again\n" +
+	"* `/jira subscribe policy [first|merge|each]` - Show or set what is posted to this channel when several of its subscriptions match an event: the subscription of the highest priority, all the subscription names in one post, or one post per subscription\n" +
 	"Other:\n" +
 	"* `/jira instance alias [URL] [alias-name]` - assign an alias to an instance\n" +
 	"* `/jira instance unalias [alias-name]` - remve an alias from an instance\n" +
 	"* `/jira instance v2 <jiraURL>` - Set the Jira instance to process \"v2\" webhooks and subscriptions (not prefixed with the instance ID)\n" +
 	"* `/jira webhook [--instance=<jiraURL>]` -  Show the Mattermost webhook to receive JQL queries\n" +
+	"* `/jira webhook deadletter [list|replay|purge] [id|--all]` - Inspect, replay or purge the webhook events that failed to be processed\n" +
 	"* `/jira v2revert ` - Revert to V2 jira plugin data model\n" +
 	""
 
@@ -143,12 +172,14 @@ func (p *Plugin) createJiraCommand(enableAutocomplete, enableOptInstance bool) (
 func addSubCommands(jira *model.AutocompleteData, optInstance bool) {
 	// Top-level common commands
 	jira.AddCommand(createViewCommand(optInstance))
+	jira.AddCommand(createCreateCommand(optInstance))
 	jira.AddCommand(createTransitionCommand(optInstance))
 	jira.AddCommand(createAssignCommand(optInstance))
 	jira.AddCommand(createUnassignCommand(optInstance))
 	jira.AddCommand(createConnectCommand())
 	jira.AddCommand(createDisconnectCommand())
 	jira.AddCommand(createSettingsCommand(optInstance))
+	jira.AddCommand(createSearchCommand(optInstance))
 
 	// Generic commands
 	jira.AddCommand(createIssueCommand(optInstance))
@@ -206,14 +237,37 @@ func createInstanceCommand(optInstance bool) *model.AutocompleteData {
 
 func createIssueCommand(optInstance bool) *model.AutocompleteData {
 	issue := model.NewAutocompleteData(
-		"issue", "[view|assign|transition]", "View and manage Jira issues")
+		"issue", "[view|create|assign|transition|link-thread|unlink-thread|previews]", "View and manage Jira issues")
 	issue.AddCommand(createViewCommand(optInstance))
+	issue.AddCommand(createCreateCommand(optInstance))
 	issue.AddCommand(createTransitionCommand(optInstance))
 	issue.AddCommand(createAssignCommand(optInstance))
 	issue.AddCommand(createUnassignCommand(optInstance))
+	issue.AddCommand(createLinkThreadCommand(optInstance))
+	issue.AddCommand(model.NewAutocompleteData(
+		"unlink-thread", "", "Stop syncing this thread with its Jira issue"))
+	issue.AddCommand(createIssuePreviewsCommand())
 	return issue
 }
 
+func createLinkThreadCommand(optInstance bool) *model.AutocompleteData {
+	linkThread := model.NewAutocompleteData(
+		"link-thread", "[issue]", "Sync the comments of a Jira issue with this thread")
+	withParamIssueKey(linkThread)
+	withFlagInstance(linkThread, optInstance, makeAutocompleteRoute(routeAutocompleteInstalledInstanceWithAlias))
+	return linkThread
+}
+
+func createIssuePreviewsCommand() *model.AutocompleteData {
+	previews := model.NewAutocompleteData(
+		"previews", "[on|off]", "Turn the previews of the Jira issues mentioned in this channel on or off")
+	previews.AddStaticListArgument("Turn the previews on or off", false, []model.AutocompleteListItem{
+		{HelpText: "Preview the Jira issues mentioned in this channel", Item: "on"},
+		{HelpText: "Do not preview the Jira issues mentioned in this channel", Item: "off"},
+	})
+	return previews
+}
+
 func withFlagInstance(cmd *model.AutocompleteData, optInstance bool, route string) {
 	if !optInstance {
 		return
@@ -226,6 +280,15 @@ func withParamIssueKey(cmd *model.AutocompleteData) {
 	cmd.AddTextArgument("Jira issue key", "", "")
 }
 
+func createSearchCommand(optInstance bool) *model.AutocompleteData {
+	search := model.NewAutocompleteData(
+		"search", "[JQL or text]", "Search for Jira issues")
+	search.AddTextArgument("JQL query, or text to search for", "[JQL or text]", "")
+	search.AddNamedTextArgument("limit", "Number of issues per page", "N", "", false)
+	withFlagInstance(search, optInstance, makeAutocompleteRoute(routeAutocompleteInstalledInstanceWithAlias))
+	return search
+}
+
 func createConnectCommand() *model.AutocompleteData {
 	connect := model.NewAutocompleteData(
 		"connect", "", "Connect your Mattermost account to your Jira account")
@@ -256,7 +319,7 @@ func createDisconnectCommand() *model.AutocompleteData {
 
 func createSettingsCommand(optInstance bool) *model.AutocompleteData {
 	settings := model.NewAutocompleteData(
-		"settings", "[list|notifications]", "View or update your user settings")
+		"settings", "[list|notifications|delivery|quiet-hours]", "View or update your user settings")
 
 	list := model.NewAutocompleteData(
 		"list", "", "View your current settings")
@@ -271,6 +334,24 @@ func createSettingsCommand(optInstance bool) *model.AutocompleteData {
 	withFlagInstance(notifications, optInstance, makeAutocompleteRoute(routeAutocompleteInstalledInstanceWithAlias))
 	settings.AddCommand(notifications)
 
+	delivery := model.NewAutocompleteData(
+		"delivery", "[instant|hourly|daily|weekly] [HH:MM] [day]", "Receive your notifications instantly, or in a digest")
+	delivery.AddStaticListArgument("mode", true, []model.AutocompleteListItem{
+		{HelpText: "Send each notification as it happens", Item: DeliveryModeInstant},
+		{HelpText: "Send a digest every hour", Item: DeliveryModeHourly},
+		{HelpText: "Send a digest every day, at 09:00 unless specified", Item: DeliveryModeDaily},
+		{HelpText: "Send a digest every week, on monday at 09:00 unless specified", Item: DeliveryModeWeekly},
+	})
+	delivery.AddTextArgument("Time of the digest in your timezone, and day of the weekly digest", "[HH:MM] [day]", "")
+	withFlagInstance(delivery, optInstance, makeAutocompleteRoute(routeAutocompleteInstalledInstanceWithAlias))
+	settings.AddCommand(delivery)
+
+	quietHours := model.NewAutocompleteData(
+		"quiet-hours", "[HH:MM] [HH:MM]|off", "Hold your notifications between these times, in your timezone")
+	quietHours.AddTextArgument("Start and end of your quiet hours, or off", "[HH:MM] [HH:MM]|off", "")
+	withFlagInstance(quietHours, optInstance, makeAutocompleteRoute(routeAutocompleteInstalledInstanceWithAlias))
+	settings.AddCommand(quietHours)
+
 	return settings
 }
 
@@ -282,6 +363,18 @@ func createViewCommand(optInstance bool) *model.AutocompleteData {
 	return view
 }
 
+func createCreateCommand(optInstance bool) *model.AutocompleteData {
+	create := model.NewAutocompleteData(
+		"create", "[\"Summary\"] [description]", "Create a new Jira issue")
+	create.AddTextArgument("Summary, quoted if it has spaces, followed by the description", "[\"Summary\"] [description]", "")
+	create.AddNamedTextArgument("project", "Project key, defaults to the last one used", "KEY", "", false)
+	create.AddNamedTextArgument("type", "Issue type, defaults to the last one used", "TYPE", "", false)
+	create.AddNamedTextArgument("priority", "Priority", "NAME", "", false)
+	create.AddNamedTextArgument("labels", "Comma-separated labels", "a,b", "", false)
+	withFlagInstance(create, optInstance, makeAutocompleteRoute(routeAutocompleteInstalledInstanceWithAlias))
+	return create
+}
+
 func createTransitionCommand(optInstance bool) *model.AutocompleteData {
 	transition := model.NewAutocompleteData(
 		"transition", "[Jira issue] [To state]", "Change the state of a Jira issue")
@@ -312,7 +405,7 @@ func createUnassignCommand(optInstance bool) *model.AutocompleteData {
 
 func createSubscribeCommand(optInstance bool) *model.AutocompleteData {
 	subscribe := model.NewAutocompleteData(
-		"subscribe", "[edit|list]", "List or configure the Jira notifications sent to this channel")
+		"subscribe", "[edit|list|test|export|import|mute|unmute|policy]", "List or configure the Jira notifications sent to this channel")
 	subscribe.AddCommand(model.NewAutocompleteData(
 		"edit", "", "Configure the Jira notifications sent to this channel"))
 
@@ -320,6 +413,55 @@ func createSubscribeCommand(optInstance bool) *model.AutocompleteData {
 		"list", "", "List the Jira notifications sent to this channel")
 	withFlagInstance(list, optInstance, makeAutocompleteRoute(routeAutocompleteInstalledInstanceWithAlias))
 	subscribe.AddCommand(list)
+
+	test := model.NewAutocompleteData(
+		"test", "[subscription-name]", "Show which recent issues and events would match a subscription of this channel")
+	withFlagInstance(test, optInstance, makeAutocompleteRoute(routeAutocompleteInstalledInstanceWithAlias))
+	subscribe.AddCommand(test)
+
+	export := model.NewAutocompleteData(
+		"export", "[--channel|--team|--all]", "Export the subscriptions of this channel, of this team, or of all channels")
+	export.AddStaticListArgument("Scope", false, []model.AutocompleteListItem{
+		{HelpText: "Export the subscriptions of this channel", Item: "--channel"},
+		{HelpText: "Export the subscriptions of the channels of this team", Item: "--team"},
+		{HelpText: "Export the subscriptions of all channels", Item: "--all"},
+	})
+	export.AddStaticListArgument("Format of the exported file", false, []model.AutocompleteListItem{
+		{HelpText: "JSON", Item: "--format=json"},
+		{HelpText: "YAML", Item: "--format=yaml"},
+	})
+	withFlagInstance(export, optInstance, makeAutocompleteRoute(routeAutocompleteInstalledInstanceWithAlias))
+	subscribe.AddCommand(export)
+
+	imp := model.NewAutocompleteData(
+		"import", "[--dry-run] [permalink]", "Import subscriptions from the file attached to a post, or pasted on the next lines")
+	imp.AddStaticListArgument("What to do with the subscriptions named like an existing one", false, []model.AutocompleteListItem{
+		{HelpText: "Keep the existing subscription", Item: "--on-conflict=" + importConflictSkip},
+		{HelpText: "Replace the existing subscription", Item: "--on-conflict=" + importConflictOverwrite},
+		{HelpText: "Import the subscription with another name", Item: "--on-conflict=" + importConflictRename},
+	})
+	withFlagInstance(imp, optInstance, makeAutocompleteRoute(routeAutocompleteInstalledInstanceWithAlias))
+	subscribe.AddCommand(imp)
+
+	mute := model.NewAutocompleteData(
+		"mute", "[subscription-name] [duration]", "Stop posting the events of a subscription of this channel, until it is unmuted or for a duration")
+	withFlagInstance(mute, optInstance, makeAutocompleteRoute(routeAutocompleteInstalledInstanceWithAlias))
+	subscribe.AddCommand(mute)
+
+	unmute := model.NewAutocompleteData(
+		"unmute", "[subscription-name]", "Post the events of a muted subscription of this channel again")
+	withFlagInstance(unmute, optInstance, makeAutocompleteRoute(routeAutocompleteInstalledInstanceWithAlias))
+	subscribe.AddCommand(unmute)
+
+	policy := model.NewAutocompleteData(
+		"policy", "[first|merge|each]", "Show or set what is posted when several subscriptions of this channel match an event")
+	policy.AddStaticListArgument("Policy", false, []model.AutocompleteListItem{
+		{HelpText: "Post once, with the subscription of the highest priority", Item: SubscriptionPolicyFirst},
+		{HelpText: "Post once, with the names of all the matching subscriptions", Item: SubscriptionPolicyMerge},
+		{HelpText: "Post once for each matching subscription", Item: SubscriptionPolicyEach},
+	})
+	withFlagInstance(policy, optInstance, makeAutocompleteRoute(routeAutocompleteInstalledInstanceWithAlias))
+	subscribe.AddCommand(policy)
 	return subscribe
 }
 
@@ -604,6 +746,10 @@ func executeSettings(p *Plugin, c *plugin.Context, header *model.CommandArgs, ar
 		return p.responsef(header, "Current settings:\n%s", conn.Settings.String())
 	case "notifications":
 		return p.settingsNotifications(header, instance.GetID(), user.MattermostUserID, conn, args)
+	case "delivery":
+		return p.settingsDelivery(header, instance.GetID(), user.MattermostUserID, conn, args)
+	case "quiet-hours":
+		return p.settingsQuietHours(header, instance.GetID(), user.MattermostUserID, conn, args)
 	default:
 		return p.responsef(header, "Unknown setting.")
 	}
@@ -1143,6 +1289,76 @@ func executeWebhookURL(p *Plugin, c *plugin.Context, header *model.CommandArgs,
 		instanceID, instance.GetManageWebhooksURL(), subWebhookURL, subWebhookURL, legacyWebhookURL, legacyWebhookURL)
 }
 
+func executeWebhookDeadLetter(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	authorized, err := authorizedSysAdmin(p, header.UserId)
+	if err != nil {
+		return p.responsef(header, "%v", err)
+	}
+	if !authorized {
+		return p.responsef(header, "`/jira webhook deadletter` can only be run by a system administrator.")
+	}
+
+	const helpText = "`/jira webhook deadletter [list|replay|purge] [id|--all]`"
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+
+	switch args[0] {
+	case "list":
+		if len(args) != 1 {
+			return p.responsef(header, helpText)
+		}
+		msgs, err := p.webhookQueueStore.ListDeadLetters()
+		if err != nil {
+			return p.responsef(header, "Failed to load the dead-letter webhook events. Error: %v.", err)
+		}
+		if len(msgs) == 0 {
+			return p.responsef(header, "There are no dead-letter webhook events.")
+		}
+		text := "|ID|Instance|Event|Issue|Attempts|Failed at|Last error|\n|--|--|--|--|--|--|--|\n"
+		for _, msg := range msgs {
+			event, issueKey := msg.describe()
+			text += fmt.Sprintf("|`%s`|%s|%s|%s|%d|%s|%s|\n",
+				msg.ID, msg.InstanceID, event, issueKey, msg.Attempts,
+				time.UnixMilli(msg.FailedAt).UTC().Format(time.RFC3339), strings.ReplaceAll(msg.LastError, "|", "\\|"))
+		}
+		return p.responsef(header, text)
+
+	case "replay", "purge":
+		if len(args) != 2 {
+			return p.responsef(header, helpText)
+		}
+		ids := []string{args[1]}
+		if args[1] == "--all" {
+			msgs, err := p.webhookQueueStore.ListDeadLetters()
+			if err != nil {
+				return p.responsef(header, "Failed to load the dead-letter webhook events. Error: %v.", err)
+			}
+			ids = []string{}
+			for _, msg := range msgs {
+				ids = append(ids, msg.ID)
+			}
+		}
+
+		action, f := "replayed", p.replayDeadLetter
+		if args[0] == "purge" {
+			action, f = "purged", p.webhookQueueStore.DeleteDeadLetter
+		}
+		for i, id := range ids {
+			if _, err := p.webhookQueueStore.LoadDeadLetter(id); err != nil {
+				return p.responsef(header, "Failed to load dead-letter webhook event `%s`, %d event(s) %s. Error: %v.", id, i, action, err)
+			}
+			if err := f(id); err != nil {
+				return p.responsef(header, "Failed to process dead-letter webhook event `%s`, %d event(s) %s. Error: %v.", id, i, action, err)
+			}
+		}
+		return p.responsef(header, "Successfully %s %d dead-letter webhook event(s).", action, len(ids))
+
+	default:
+		return p.responsef(header, helpText)
+	}
+}
+
 func executeSetup(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
 	authorized, err := authorizedSysAdmin(p, header.UserId)
 	if err != nil {
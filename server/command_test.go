@@ -201,6 +201,57 @@ func TestPlugin_ExecuteCommand_Settings(t *testing.T) {
 			numInstances: 1,
 			expectedMsg:  "Settings updated. Notifications off.",
 		},
+		"daily digest": {
+			commandArgs:  &model.CommandArgs{Command: "/jira settings delivery daily 08:30", UserId: mockUserIDWithNotifications},
+			numInstances: 1,
+			expectedMsg:  "Settings updated. Notifications delivery: daily digest at 08:30.",
+		},
+		"weekly digest": {
+			commandArgs:  &model.CommandArgs{Command: "/jira settings delivery weekly 17:00 Friday", UserId: mockUserIDWithNotifications},
+			numInstances: 1,
+			expectedMsg:  "Settings updated. Notifications delivery: weekly digest on friday at 17:00.",
+		},
+		"disable notification category": {
+			commandArgs:  &model.CommandArgs{Command: "/jira settings notifications status-changes off", UserId: mockUserIDWithNotifications},
+			numInstances: 1,
+			expectedMsg:  "Settings updated. Notifications for `status-changes` off.",
+		},
+		"invalid notification category": {
+			commandArgs:  &model.CommandArgs{Command: "/jira settings notifications everything off", UserId: mockUserIDWithNotifications},
+			numInstances: 1,
+			expectedMsg: "`/jira settings notifications [category] [value]`\n* Accepted categories are: `assigned`, `mentioned`, " +
+				"`reporter-comments`, `assignee-comments`, `status-changes`, `watching`.\n* Accepted values are: `on` or `off`.",
+		},
+		"instant delivery": {
+			commandArgs:  &model.CommandArgs{Command: "/jira settings delivery instant", UserId: mockUserIDWithNotifications},
+			numInstances: 1,
+			expectedMsg:  "Settings updated. Notifications delivery: instant.",
+		},
+		"quiet hours": {
+			commandArgs:  &model.CommandArgs{Command: "/jira settings quiet-hours 22:00 07:00", UserId: mockUserIDWithNotifications},
+			numInstances: 1,
+			expectedMsg:  "Settings updated. Quiet hours: 22:00 to 07:00.",
+		},
+		"quiet hours off": {
+			commandArgs:  &model.CommandArgs{Command: "/jira settings quiet-hours off", UserId: mockUserIDWithNotifications},
+			numInstances: 1,
+			expectedMsg:  "Settings updated. Quiet hours off.",
+		},
+		"invalid quiet hours": {
+			commandArgs:  &model.CommandArgs{Command: "/jira settings quiet-hours 22:00 22:00", UserId: mockUserIDWithNotifications},
+			numInstances: 1,
+			expectedMsg: "The quiet hours must start and end at different times.\n`/jira settings quiet-hours [start] [end]` or `/jira settings quiet-hours off`\n" +
+				"* [start] and [end] are times in your timezone, e.g. `22:00` and `07:00`\n" +
+				"* Notifications received during your quiet hours, or while your status is Do Not Disturb, are delivered as a summary afterwards",
+		},
+		"invalid delivery time": {
+			commandArgs:  &model.CommandArgs{Command: "/jira settings delivery daily 25:00", UserId: mockUserIDWithNotifications},
+			numInstances: 1,
+			expectedMsg: "`25:00` is not a valid time, use HH:MM.\n`/jira settings delivery [mode] [time] [day]`\n" +
+				"* [mode] can be `instant`, `hourly`, `daily` or `weekly`\n" +
+				"* [time] is the time of the daily and weekly digests in your timezone, e.g. `09:00`\n" +
+				"* [day] is the day of the weekly digest, e.g. `monday`",
+		},
 	}
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
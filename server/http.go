@@ -44,7 +44,10 @@ const (
 	routeAPISubscribeWebhook                    = "/webhook"
 	routeAPISubscriptionsChannel                = "/subscriptions/channel"
 	routeAPISubscriptionsChannelWithID          = routeAPISubscriptionsChannel + "/{id:[A-Za-z0-9]+}"
+	routeAPISubscriptionsExport                 = "/subscriptions/export"
+	routeAPISubscriptionsImport                 = "/subscriptions/import"
 	routeAPISettingsInfo                        = "/settingsinfo"
+	routeAPISettingsNotificationCategories      = "/settings/notification-categories"
 	routeIssueTransition                        = "/transition"
 	routeAPIUserDisconnect                      = "/api/v3/disconnect"
 	routeACInstalled                            = "/ac/installed"
@@ -61,6 +64,7 @@ const (
 	routeUserDisconnect                         = "/user/disconnect"
 	routeGetIssueByKey                          = "/get-issue-by-key"
 	routeSharePublicly                          = "/share-issue-publicly"
+	routeSearchIssuesPage                       = "/search-issues-page"
 	routeOAuth2Complete                         = "/oauth2/complete.html"
 )
 
@@ -109,11 +113,13 @@ func (p *Plugin) initializeRouter() {
 	apiRouter.HandleFunc(routeAPIAttachCommentToIssue, p.checkAuth(p.handleResponse(p.httpAttachCommentToIssue))).Methods(http.MethodPost)
 	apiRouter.HandleFunc(routeIssueTransition, p.handleResponse(p.httpTransitionIssuePostAction)).Methods(http.MethodPost)
 	apiRouter.HandleFunc(routeSharePublicly, p.handleResponse(p.httpShareIssuePublicly)).Methods(http.MethodPost)
+	apiRouter.HandleFunc(routeSearchIssuesPage, p.checkAuth(p.handleResponse(p.httpSearchIssuesPage))).Methods(http.MethodPost)
 	apiRouter.HandleFunc(routeGetIssueByKey, p.handleResponse(p.httpGetIssueByKey)).Methods(http.MethodGet)
 
 	// User APIs
 	apiRouter.HandleFunc(routeAPIUserInfo, p.checkAuth(p.handleResponse(p.httpGetUserInfo))).Methods(http.MethodGet)
 	apiRouter.HandleFunc(routeAPISettingsInfo, p.checkAuth(p.handleResponse(p.httpGetSettingsInfo))).Methods(http.MethodGet)
+	apiRouter.HandleFunc(routeAPISettingsNotificationCategories, p.checkAuth(p.handleResponse(p.httpSetNotificationCategories))).Methods(http.MethodPost)
 
 	// Atlassian Connect application
 	instanceRouter.HandleFunc(routeACJSON, p.handleResponseWithCallbackInstance(p.httpACJSON)).Methods(http.MethodGet)
@@ -146,10 +152,13 @@ func (p *Plugin) initializeRouter() {
 	instanceRouter.HandleFunc(routeIncomingWebhook, p.handleResponseWithCallbackInstance(p.httpWebhook)).Methods(http.MethodPost)
 
 	// Channel Subscriptions
+	apiRouter.HandleFunc(routeAPISubscriptionsChannelPreview, p.checkAuth(p.handleResponse(p.httpChannelPreviewSubscription))).Methods(http.MethodPost)
 	apiRouter.HandleFunc(routeAPISubscriptionsChannelWithID, p.checkAuth(p.handleResponse(p.httpChannelGetSubscriptions))).Methods(http.MethodGet)
 	apiRouter.HandleFunc(routeAPISubscriptionsChannel, p.checkAuth(p.handleResponse(p.httpChannelCreateSubscription))).Methods(http.MethodPost)
 	apiRouter.HandleFunc(routeAPISubscriptionsChannel, p.checkAuth(p.handleResponse(p.httpChannelEditSubscription))).Methods(http.MethodPut)
 	apiRouter.HandleFunc(routeAPISubscriptionsChannelWithID, p.checkAuth(p.handleResponse(p.httpChannelDeleteSubscription))).Methods(http.MethodDelete)
+	apiRouter.HandleFunc(routeAPISubscriptionsExport, p.checkAuth(p.handleResponse(p.httpExportSubscriptions))).Methods(http.MethodGet)
+	apiRouter.HandleFunc(routeAPISubscriptionsImport, p.checkAuth(p.handleResponse(p.httpImportSubscriptions))).Methods(http.MethodPost)
 }
 
 func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Request) {
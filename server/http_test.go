@@ -45,56 +45,31 @@ func checkSubscriptionsEqual(t *testing.T, ls1 []ChannelSubscription, ls2 []Chan
 	}
 }
 
+// checkNotSubscriptions mocks the existing subscriptions, and checks that
+// none of subsToCheck are stored once the test is done.
 func checkNotSubscriptions(subsToCheck []ChannelSubscription, existing *Subscriptions, t *testing.T) func(api *plugintest.API) {
 	return func(api *plugintest.API) {
-		var existingBytes []byte
-		if existing != nil {
-			var err error
-			existingBytes, err = json.Marshal(existing)
-			assert.Nil(t, err)
-		}
-
 		api.On("HasPermissionTo", mock.AnythingOfType("string"), mock.Anything).Return(true)
-		api.On("KVGet", testSubKey).Return(existingBytes, nil)
-
-		api.On("KVCompareAndSet", testSubKey, existingBytes, mock.MatchedBy(func(data []byte) bool {
-			t.Log(string(data))
-			var savedSubs Subscriptions
-			err := json.Unmarshal(data, &savedSubs)
-			assert.Nil(t, err)
+		mockSubscriptionStore(t, api, existing)
 
+		t.Cleanup(func() {
+			savedSubs := loadTestSubscriptions(t, api)
 			for _, subToCheck := range subsToCheck {
-				for _, savedSub := range savedSubs.Channel.ByID {
-					if subToCheck.ID == savedSub.ID {
-						return false
-					}
-				}
+				assert.NotContains(t, savedSubs.Channel.ByID, subToCheck.ID)
 			}
-
-			return true
-		})).Return(true, nil)
+		})
 	}
 }
 
+// checkHasSubscriptions mocks the existing subscriptions, and checks that all
+// of subsToCheck are stored and indexed once the test is done.
 func checkHasSubscriptions(subsToCheck []ChannelSubscription, existing *Subscriptions, t *testing.T) func(api *plugintest.API) {
 	return func(api *plugintest.API) {
-		var existingBytes []byte
-		if existing != nil {
-			var err error
-			existingBytes, err = json.Marshal(existing)
-			assert.Nil(t, err)
-		}
-
 		api.On("HasPermissionTo", mock.AnythingOfType("string"), mock.Anything).Return(true)
+		mockSubscriptionStore(t, api, existing)
 
-		api.On("KVGet", testSubKey).Return(existingBytes, nil)
-
-		api.On("KVCompareAndSet", testSubKey, existingBytes, mock.MatchedBy(func(data []byte) bool {
-			t.Log(string(data))
-			var savedSubs Subscriptions
-			err := json.Unmarshal(data, &savedSubs)
-			assert.Nil(t, err)
-
+		t.Cleanup(func() {
+			savedSubs := loadTestSubscriptions(t, api)
 			for _, subToCheck := range subsToCheck {
 				var foundSub *ChannelSubscription
 				for _, savedSub := range savedSubs.Channel.ByID {
@@ -109,8 +84,8 @@ func checkHasSubscriptions(subsToCheck []ChannelSubscription, existing *Subscrip
 				}
 
 				// Check subscription exists
-				if foundSub == nil {
-					return false
+				if !assert.NotNil(t, foundSub) {
+					continue
 				}
 
 				// Check it's properly attached
@@ -119,22 +94,14 @@ func checkHasSubscriptions(subsToCheck []ChannelSubscription, existing *Subscrip
 					assert.Contains(t, savedSubs.Channel.IDByEvent[event], foundSub.ID)
 				}
 			}
-
-			return true
-		})).Return(true, nil)
+		})
 	}
 }
 
 func hasSubscriptions(subscriptions []ChannelSubscription, t *testing.T) func(api *plugintest.API) {
 	return func(api *plugintest.API) {
-		subs := withExistingChannelSubscriptions(subscriptions)
-
-		existingBytes, err := json.Marshal(&subs)
-		assert.Nil(t, err)
-
 		api.On("HasPermissionTo", mock.AnythingOfType("string"), mock.Anything).Return(true)
-
-		api.On("KVGet", testSubKey).Return(existingBytes, nil)
+		mockSubscriptionStore(t, api, withExistingChannelSubscriptions(subscriptions))
 	}
 }
 
@@ -292,7 +259,7 @@ func TestSubscribe(t *testing.T) {
 				{
 					ChannelID: "aaaaaaaaaaaaaaaaaaaaaaaaab",
 					Filters: SubscriptionFilters{
-						Events:     NewStringSet("jira:issue_created"),
+						Events:     NewStringSet("jira:issue_updated"),
 						Projects:   NewStringSet("myproject"),
 						IssueTypes: NewStringSet("10001"),
 					},
@@ -323,11 +290,10 @@ func TestSubscribe(t *testing.T) {
 
 			api.On("GetChannelMember", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(&model.ChannelMember{}, (*model.AppError)(nil))
 			api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{}, nil)
-			api.On("KVSetWithOptions", mock.AnythingOfType("string"), mock.Anything, mock.AnythingOfType("model.PluginKVSetOptions")).Return(true, nil)
-
 			if tc.apiCalls != nil {
 				tc.apiCalls(api)
 			}
+			api.On("KVSetWithOptions", mock.AnythingOfType("string"), mock.Anything, mock.AnythingOfType("model.PluginKVSetOptions")).Return(true, nil)
 
 			p.updateConfig(func(conf *config) {
 				conf.Secret = someSecret
@@ -371,9 +337,7 @@ func TestDeleteSubscription(t *testing.T) {
 			subscriptionID:     "aaaaaaaaaaaaaaaaaaaaaaaaab",
 			expectedStatusCode: http.StatusForbidden,
 			apiCalls: func(api *plugintest.API) {
-				var existingBytes []byte
-				var err error
-				existingBytes, err = json.Marshal(withExistingChannelSubscriptions([]ChannelSubscription{
+				mockSubscriptionStore(t, api, withExistingChannelSubscriptions([]ChannelSubscription{
 					{
 						ID:        "aaaaaaaaaaaaaaaaaaaaaaaaab",
 						ChannelID: "aaaaaaaaaaaaaaaaaaaaaaaaab",
@@ -384,9 +348,6 @@ func TestDeleteSubscription(t *testing.T) {
 						},
 					},
 				}))
-				assert.Nil(t, err)
-
-				api.On("KVGet", testSubKey).Return(existingBytes, nil)
 				api.On("HasPermissionTo", mock.AnythingOfType("string"), mock.Anything).Return(false)
 			},
 		},
@@ -437,11 +398,10 @@ func TestDeleteSubscription(t *testing.T) {
 
 			api.On("GetChannelMember", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(&model.ChannelMember{}, (*model.AppError)(nil))
 			api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{}, nil)
-			api.On("KVSetWithOptions", mock.AnythingOfType("string"), mock.Anything, mock.AnythingOfType("model.PluginKVSetOptions")).Return(true, nil)
-
 			if tc.apiCalls != nil {
 				tc.apiCalls(api)
 			}
+			api.On("KVSetWithOptions", mock.AnythingOfType("string"), mock.Anything, mock.AnythingOfType("model.PluginKVSetOptions")).Return(true, nil)
 
 			p.updateConfig(func(conf *config) {
 				conf.Secret = someSecret
@@ -659,11 +619,10 @@ func TestEditSubscription(t *testing.T) {
 
 			api.On("GetChannelMember", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(&model.ChannelMember{}, (*model.AppError)(nil))
 			api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{}, nil)
-			api.On("KVSetWithOptions", mock.AnythingOfType("string"), mock.Anything, mock.AnythingOfType("model.PluginKVSetOptions")).Return(true, nil)
-
 			if tc.apiCalls != nil {
 				tc.apiCalls(api)
 			}
+			api.On("KVSetWithOptions", mock.AnythingOfType("string"), mock.Anything, mock.AnythingOfType("model.PluginKVSetOptions")).Return(true, nil)
 
 			p.updateConfig(func(conf *config) {
 				conf.Secret = someSecret
@@ -202,6 +202,12 @@ func (p *Plugin) CreateIssue(in *InCreateIssue) (*jira.Issue, error) {
 
 	var post *model.Post
 
+	// The description is written in Markdown. Jira Cloud takes it as an ADF
+	// document, converted when the issue is created, Jira Server as wiki
+	// markup.
+	description := in.Fields.Description
+	in.Fields.Description = p.markdownToWiki(instance.GetID(), in.Fields.Description)
+
 	// If this issue is attached to a post, lets add a permalink to the post in the Jira Description
 	if in.PostID != "" {
 		post, err = p.client.Post.GetPost(in.PostID)
@@ -215,8 +221,10 @@ func (p *Plugin) CreateIssue(in *InCreateIssue) (*jira.Issue, error) {
 
 		if len(in.Fields.Description) > 0 {
 			in.Fields.Description += fmt.Sprintf("\n\n_Issue created from a [message in Mattermost|%v]_.", permalink)
+			description += fmt.Sprintf("\n\n_Issue created from a [message in Mattermost](%v)_.", permalink)
 		} else {
 			in.Fields.Description = fmt.Sprintf("_Issue created from a [message in Mattermost|%v]_.", permalink)
+			description = fmt.Sprintf("_Issue created from a [message in Mattermost](%v)_.", permalink)
 		}
 	}
 
@@ -278,7 +286,12 @@ func (p *Plugin) CreateIssue(in *InCreateIssue) (*jira.Issue, error) {
 		return nil, errors.Errorf("issue can not be created via API: %s", message)
 	}
 
-	created, err := client.CreateIssue(issue)
+	var created *jira.Issue
+	if adfClient, ok := client.(ADFIssueService); ok {
+		created, err = adfClient.CreateADFIssue(issue, p.issueRichTextADF(client, issue, instance.GetID(), description))
+	} else {
+		created, err = client.CreateIssue(issue)
+	}
 	if err != nil {
 		// if have an error and Jira tells us there are required fields send user
 		// link to jira with fields already filled in.  Note the user will also see
@@ -629,13 +642,11 @@ func (p *Plugin) AttachCommentToIssue(in *InAttachCommentToIssue) (*jira.Comment
 
 	permalink := getPermaLink(instance, in.PostID, in.CurrentTeam)
 
-	permalinkMessage := fmt.Sprintf("*@%s attached a* [message|%s] *from @%s*\n", connection.DisplayName, permalink, commentUser.Username)
+	permalinkMessage := fmt.Sprintf("**\\@%s attached a** [message](%s) **from @%s**\n", connection.DisplayName, permalink, commentUser.Username)
 
-	jiraComment := jira.Comment{
-		Body: permalinkMessage + post.Message,
-	}
+	comment := p.newMarkdownComment(client, instance.GetID(), permalinkMessage+post.Message)
 
-	added, err := client.AddComment(in.IssueKey, &jiraComment)
+	added, err := comment.add(client, in.IssueKey)
 	if err != nil {
 		if strings.Contains(err.Error(), "you do not have the permission to comment on this issue") {
 			return nil, errors.New("you do not have permission to create a comment in the selected Jira issue. Please choose another issue or contact your Jira admin")
@@ -647,26 +658,21 @@ func (p *Plugin) AttachCommentToIssue(in *InAttachCommentToIssue) (*jira.Comment
 
 	go func() {
 		conf := instance.Common().getConfig()
-		extraText := ""
+		attached := false
 		for _, fileID := range post.FileIds {
-			mattermostName, jiraName, mime, e := client.AddAttachment(*p.client, in.IssueKey, fileID, conf.maxAttachmentSize)
+			mattermostName, attachment, mime, e := client.AddAttachment(*p.client, in.IssueKey, fileID, conf.maxAttachmentSize)
 			if e != nil {
 				notifyOnFailedAttachment(instance, in.mattermostUserID.String(), in.IssueKey, e, "file: %s", mattermostName)
 				continue
 			}
-			if isImageMIME(mime) || isEmbbedableMIME(mime) {
-				extraText += "\n\nAttachment: !" + jiraName + "!"
-			} else {
-				extraText += "\n\nAttachment: [^" + jiraName + "]"
-			}
+			comment.appendAttachment(attachment, isImageMIME(mime) || isEmbbedableMIME(mime))
+			attached = true
 		}
-		if extraText == "" {
+		if !attached {
 			return
 		}
 
-		jiraComment.ID = added.ID
-		jiraComment.Body += extraText
-		_, err = client.UpdateComment(in.IssueKey, &jiraComment)
+		_, err = comment.update(client, in.IssueKey, added.ID)
 		if err != nil {
 			notifyOnFailedAttachment(instance, in.mattermostUserID.String(), in.IssueKey, err, "failed to completely update comment with attachments")
 		}
@@ -770,13 +776,15 @@ func (p *Plugin) getIssueDataForCloudWebhook(instance Instance, issueKey string)
 		return nil, err
 	}
 
-	issue, resp, err := jiraClient.Issue.Get(issueKey, nil)
+	issue, resp, err := getCloudIssue(jiraClient, issueKey, nil)
 	if err != nil {
 		switch {
 		case resp == nil:
 			return nil, errors.WithMessage(userFriendlyJiraError(nil, err), "request to Jira failed")
 		case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusUnauthorized:
 			return nil, errors.New(`we couldn't find the issue key, or the cloud "bot" client does not have the appropriate permissions to view the issue`)
+		default:
+			return nil, userFriendlyJiraError(resp, err)
 		}
 	}
 
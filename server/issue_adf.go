@@ -0,0 +1,161 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/markup"
+)
+
+// fieldDescriptionMarkdown is the key of the unknown issue fields where the
+// description of the issues fetched with the v3 API is kept as Markdown. It
+// is not a Jira field.
+const fieldDescriptionMarkdown = "mattermost_description_markdown"
+
+// getCloudIssue gets an issue with the v3 API of Jira Cloud. The rich text
+// fields of v3 are Atlassian Document Format documents, which do not fit the
+// string fields of jira.Issue, so they are replaced with their Markdown.
+func getCloudIssue(jiraClient *jira.Client, key string, options *jira.GetQueryOptions) (*jira.Issue, *jira.Response, error) {
+	req, err := jiraClient.NewRequest(http.MethodGet, "rest/api/3/issue/"+url.PathEscape(key), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if options != nil {
+		q := req.URL.Query()
+		for name, value := range map[string]string{
+			"fields":      options.Fields,
+			"expand":      options.Expand,
+			"properties":  options.Properties,
+			"projectKeys": options.ProjectKeys,
+		} {
+			if value != "" {
+				q.Add(name, value)
+			}
+		}
+		if options.FieldsByKeys {
+			q.Add("fieldsByKeys", "true")
+		}
+		if options.UpdateHistory {
+			q.Add("updateHistory", "true")
+		}
+		req.URL.RawQuery = q.Encode()
+	}
+
+	raw := json.RawMessage{}
+	resp, err := jiraClient.Do(req, &raw)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	issue, err := decodeCloudIssue(raw)
+	if err != nil {
+		return nil, resp, errors.Wrapf(err, "failed to decode issue %s", key)
+	}
+	return issue, resp, nil
+}
+
+func decodeCloudIssue(data []byte) (*jira.Issue, error) {
+	value, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	descriptionIsADF := false
+	if fields, ok := value.(map[string]interface{})["fields"].(map[string]interface{}); ok {
+		descriptionIsADF = toADF(fields["description"]) != nil
+	}
+
+	issue := &jira.Issue{}
+	if err = unmarshalADF(value, issue); err != nil {
+		return nil, err
+	}
+	if descriptionIsADF && issue.Fields != nil {
+		if issue.Fields.Unknowns == nil {
+			issue.Fields.Unknowns = map[string]interface{}{}
+		}
+		issue.Fields.Unknowns[fieldDescriptionMarkdown] = issue.Fields.Description
+	}
+	return issue, nil
+}
+
+// unmarshalADFJSON unmarshals a response of the v3 API, with its ADF
+// documents replaced with their Markdown.
+func unmarshalADFJSON(data []byte, dest interface{}) error {
+	value, err := decodeJSON(data)
+	if err != nil {
+		return err
+	}
+	return unmarshalADF(value, dest)
+}
+
+func unmarshalADF(value interface{}, dest interface{}) error {
+	data, err := json.Marshal(replaceADF(value))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dest)
+}
+
+// decodeJSON decodes a JSON value, keeping its numbers as they are.
+func decodeJSON(data []byte) (interface{}, error) {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err := decoder.Decode(&value)
+	return value, err
+}
+
+// toADF returns the ADF document of a JSON value, or nil if the value is not
+// one.
+func toADF(value interface{}) *markup.ADFNode {
+	object, ok := value.(map[string]interface{})
+	if !ok || object["type"] != "doc" {
+		return nil
+	}
+	data, err := json.Marshal(object)
+	if err != nil {
+		return nil
+	}
+	return markup.ParseADF(data)
+}
+
+// replaceADF replaces the ADF documents of a JSON value, such as the
+// description, the environment, the comments and the text custom fields of an
+// issue, with their Markdown.
+func replaceADF(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if doc := toADF(v); doc != nil {
+			return markup.ADFToMarkdown(doc)
+		}
+		for key, child := range v {
+			v[key] = replaceADF(child)
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = replaceADF(child)
+		}
+	}
+	return value
+}
+
+// issueDescriptionMarkdown returns the description of an issue as Markdown.
+// The issues of Jira Cloud fetched with the v3 API have it already converted,
+// the others in wiki markup.
+func issueDescriptionMarkdown(issue *jira.Issue) string {
+	if issue.Fields == nil {
+		return ""
+	}
+	if description, ok := issue.Fields.Unknowns[fieldDescriptionMarkdown].(string); ok {
+		return description
+	}
+	return markup.WikiToMarkdown(issue.Fields.Description)
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testCloudIssueV3 = `{
+	"id": "10001",
+	"key": "TES-41",
+	"fields": {
+		"summary": "Unable to save",
+		"status": {"id": "1", "name": "Open"},
+		"description": {"type": "doc", "version": 1, "content": [
+			{"type": "paragraph", "content": [
+				{"type": "text", "text": "Fails for "},
+				{"type": "mention", "attrs": {"id": "5c5f880629be9642ba529340", "text": "@Jane"}},
+				{"type": "text", "text": " too", "marks": [{"type": "strong"}]}
+			]},
+			{"type": "codeBlock", "content": [{"type": "text", "text": "save()"}]}
+		]},
+		"customfield_10050": {"type": "doc", "version": 1, "content": [
+			{"type": "paragraph", "content": [{"type": "text", "text": "Steps", "marks": [{"type": "em"}]}]}
+		]},
+		"customfield_10051": 42,
+		"comment": {"comments": [
+			{"id": "100", "body": {"type": "doc", "version": 1, "content": [
+				{"type": "paragraph", "content": [{"type": "text", "text": "Same here"}]}
+			]}}
+		]}
+	}
+}`
+
+func TestGetCloudIssue(t *testing.T) {
+	var requestURI string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestURI = r.URL.RequestURI()
+		_, _ = w.Write([]byte(testCloudIssueV3))
+	}))
+	defer ts.Close()
+
+	jiraClient, err := jira.NewClient(ts.Client(), ts.URL)
+	require.NoError(t, err)
+
+	issue, _, err := getCloudIssue(jiraClient, "TES-41", &jira.GetQueryOptions{Fields: "summary,description"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "/rest/api/3/issue/TES-41?fields=summary%2Cdescription", requestURI)
+	assert.Equal(t, "Unable to save", issue.Fields.Summary)
+	assert.Equal(t, "Fails for [~accountid:5c5f880629be9642ba529340] **too**\n\n```\nsave()\n```", issue.Fields.Description)
+	assert.Equal(t, "_Steps_", issue.Fields.Unknowns["customfield_10050"])
+	assert.Equal(t, float64(42), issue.Fields.Unknowns["customfield_10051"])
+	require.Len(t, issue.Fields.Comments.Comments, 1)
+	assert.Equal(t, "Same here", issue.Fields.Comments.Comments[0].Body)
+	assert.Nil(t, issue.RenderedFields)
+	assert.Equal(t, "Fails for [~accountid:5c5f880629be9642ba529340] **too**\n\n```\nsave()\n```", issueDescriptionMarkdown(issue))
+}
+
+func TestIssueDescriptionMarkdown(t *testing.T) {
+	issue := &jira.Issue{Fields: &jira.IssueFields{Description: "*bold*"}}
+	assert.Equal(t, "**bold**", issueDescriptionMarkdown(issue))
+
+	// Rendered by Jira as HTML
+	issue.RenderedFields = &jira.IssueRenderedFields{Description: "<b>bold</b>"}
+	assert.Equal(t, "**bold**", issueDescriptionMarkdown(issue))
+
+	issue.Fields.Unknowns = map[string]interface{}{fieldDescriptionMarkdown: "_converted_"}
+	assert.Equal(t, "_converted_", issueDescriptionMarkdown(issue))
+
+	assert.Equal(t, "", issueDescriptionMarkdown(&jira.Issue{}))
+}
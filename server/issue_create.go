@@ -0,0 +1,293 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/pkg/errors"
+	"github.com/trivago/tgo/tcontainer"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+)
+
+// issueCreateArgs are the arguments of `/jira issue create`.
+type issueCreateArgs struct {
+	ProjectKey  string
+	IssueType   string
+	Priority    string
+	Labels      []string
+	Summary     string
+	Description string
+}
+
+func executeIssueCreate(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	user, instance, args, err := p.loadFlagUserInstance(header.UserId, args)
+	if err != nil {
+		return p.responsef(header, "Failed to load your connection to Jira. Error: %v.", err)
+	}
+	in, err := parseIssueCreateArgs(args)
+	if err != nil {
+		return p.responsef(header, "%v", err)
+	}
+	if in.Summary == "" {
+		return p.responsef(header, "Please specify a summary in the form "+
+			"`/jira issue create [--project=KEY] [--type=TYPE] [--priority=NAME] [--labels=a,b] \"Summary\" [description]`.")
+	}
+
+	client, _, connection, err := p.getClient(instance.GetID(), user.MattermostUserID)
+	if err != nil {
+		return p.responsef(header, "Failed to load your connection to Jira. Error: %v.", err)
+	}
+
+	// Fill in what was not specified with the values used the last time
+	if saved := connection.SavedFieldValues; saved != nil {
+		if in.ProjectKey == "" {
+			in.ProjectKey = saved.ProjectKey
+		}
+		if in.IssueType == "" {
+			in.IssueType = saved.IssueType
+		}
+	}
+	if in.ProjectKey == "" {
+		return p.responsef(header, "Please specify the project with `--project=KEY`.")
+	}
+	if in.IssueType == "" {
+		return p.responsef(header, "Please specify the issue type with `--type=TYPE`.")
+	}
+
+	meta, err := client.GetCreateMetaInfo(p.API, &jira.GetQueryOptions{
+		Expand:      "projects.issuetypes.fields",
+		ProjectKeys: in.ProjectKey,
+	})
+	if err != nil {
+		return p.responsef(header, "Failed to load the create metadata of project `%s`. Error: %v.", in.ProjectKey, err)
+	}
+
+	fields, requiredNotCovered, err := makeIssueCreateFields(meta, in)
+	if err != nil {
+		return p.responsef(header, "%v", err)
+	}
+
+	// The reporter is filled in by CreateIssue, anything else must be
+	// completed in Jira
+	var missing []string
+	for _, field := range requiredNotCovered {
+		if field[0] != reporterField {
+			missing = append(missing, fmt.Sprintf("* %s (`%s`)", field[1], field[0]))
+		}
+	}
+	if len(missing) > 0 {
+		project, err := client.GetProject(fields.Project.Key)
+		if err != nil || project == nil {
+			return p.responsef(header, "Project `%s` has required fields that can not be set with this command:\n%s",
+				fields.Project.Key, strings.Join(missing, "\n"))
+		}
+		return p.responsef(header, "Project `%s` has required fields that can not be set with this command. "+
+			"[Please create your Jira issue in Jira](%s):\n%s",
+			fields.Project.Key, MakeCreateIssueURL(instance, project, &jira.Issue{Fields: fields}), strings.Join(missing, "\n"))
+	}
+
+	_, err = p.CreateIssue(&InCreateIssue{
+		mattermostUserID:         user.MattermostUserID,
+		InstanceID:               instance.GetID(),
+		RequiredFieldsNotCovered: requiredNotCovered,
+		ChannelID:                header.ChannelId,
+		Fields:                   *fields,
+	})
+	if err != nil {
+		return p.responsef(header, "Failed to create the issue. Error: %v.", err)
+	}
+
+	return &model.CommandResponse{}
+}
+
+// parseIssueCreateArgs parses the flags and the summary and description of
+// `/jira issue create`. The summary may be quoted.
+func parseIssueCreateArgs(args []string) (*issueCreateArgs, error) {
+	args, err := splitQuotedArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	in := &issueCreateArgs{}
+	text := []string{}
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "--") {
+			text = append(text, arg)
+			continue
+		}
+		name, value, ok := strings.Cut(arg[2:], "=")
+		if !ok || value == "" {
+			return nil, errors.Errorf("`%s` is not valid, flags must be in the form `--name=value`", arg)
+		}
+		switch name {
+		case "project":
+			in.ProjectKey = strings.ToUpper(value)
+		case "type":
+			in.IssueType = value
+		case "priority":
+			in.Priority = value
+		case "labels":
+			for _, label := range strings.Split(value, ",") {
+				if label = strings.TrimSpace(label); label != "" {
+					in.Labels = append(in.Labels, label)
+				}
+			}
+		default:
+			return nil, errors.Errorf("`--%s` is not a supported flag", name)
+		}
+	}
+
+	if len(text) > 0 {
+		in.Summary = text[0]
+		in.Description = strings.Join(text[1:], " ")
+	}
+	return in, nil
+}
+
+// splitQuotedArgs re-splits the command arguments so that quoted text is a
+// single argument. The command is split on whitespace before it gets to the
+// handlers, so the original spacing inside quotes is not preserved.
+func splitQuotedArgs(args []string) ([]string, error) {
+	out := []string{}
+	current := &strings.Builder{}
+	inArg := false
+	var quote rune
+	for _, r := range strings.Join(args, " ") {
+		switch {
+		case quote != 0 && (r == quote || (quote == '“' && r == '”')):
+			quote = 0
+		case quote != 0:
+			current.WriteRune(r)
+		case r == '"' || r == '“':
+			quote = r
+			inArg = true
+		case r == ' ':
+			if inArg {
+				out = append(out, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if inArg {
+		out = append(out, current.String())
+	}
+	return out, nil
+}
+
+// makeIssueCreateFields validates the arguments against the create metadata of
+// the project, and returns the fields of the issue along with the required
+// fields it does not cover, in the form expected by InCreateIssue.
+func makeIssueCreateFields(meta *jira.CreateMetaInfo, in *issueCreateArgs) (*jira.IssueFields, [][]string, error) {
+	project := meta.GetProjectWithKey(in.ProjectKey)
+	if project == nil {
+		return nil, nil, errors.Errorf("Project `%s` was not found, or you do not have permission to create issues in it.", in.ProjectKey)
+	}
+
+	var issueType *jira.MetaIssueType
+	names := []string{}
+	for _, t := range project.IssueTypes {
+		if t.Id == in.IssueType || strings.EqualFold(t.Name, in.IssueType) {
+			issueType = t
+		}
+		names = append(names, "`"+t.Name+"`")
+	}
+	if issueType == nil {
+		return nil, nil, errors.Errorf("Issue type `%s` is not available in project `%s`. Available types: %s.",
+			in.IssueType, project.Key, strings.Join(names, ", "))
+	}
+
+	fields := &jira.IssueFields{
+		Project:     jira.Project{Key: project.Key},
+		Type:        jira.IssueType{ID: issueType.Id, Name: issueType.Name},
+		Summary:     in.Summary,
+		Description: in.Description,
+	}
+	covered := map[string]bool{
+		"project":        true,
+		"issuetype":      true,
+		"summary":        true,
+		descriptionField: in.Description != "",
+	}
+
+	if in.Priority != "" {
+		priority, err := findAllowedValue(issueType, priorityField, in.Priority)
+		if err != nil {
+			return nil, nil, err
+		}
+		fields.Priority = &jira.Priority{ID: priority["id"], Name: priority["name"]}
+		covered[priorityField] = true
+	}
+
+	if len(in.Labels) > 0 {
+		if _, ok := issueType.Fields.Value(labelsField); !ok {
+			return nil, nil, errors.Errorf("Labels can not be set on `%s` issues in project `%s`.", issueType.Name, project.Key)
+		}
+		fields.Labels = in.Labels
+		covered[labelsField] = true
+	}
+
+	var notCovered [][]string
+	for key := range issueType.Fields {
+		if covered[key] {
+			continue
+		}
+		required, _ := issueType.Fields.Bool(key + "/required")
+		hasDefault, _ := issueType.Fields.Bool(key + "/hasDefaultValue")
+		if !required || hasDefault {
+			continue
+		}
+		name, _ := issueType.Fields.String(key + "/name")
+		if name == "" {
+			name = key
+		}
+		notCovered = append(notCovered, []string{key, name})
+	}
+	sort.Slice(notCovered, func(i, j int) bool {
+		return notCovered[i][0] < notCovered[j][0]
+	})
+
+	return fields, notCovered, nil
+}
+
+// findAllowedValue returns the id and name of the allowed value of the field
+// that matches the name or the id.
+func findAllowedValue(issueType *jira.MetaIssueType, field, value string) (map[string]string, error) {
+	allowed, err := issueType.Fields.Array(field + "/allowedValues")
+	if err != nil {
+		return nil, errors.Errorf("The %s can not be set on `%s` issues.", field, issueType.Name)
+	}
+
+	names := []string{}
+	for _, v := range allowed {
+		var m map[string]interface{}
+		switch v := v.(type) {
+		case map[string]interface{}:
+			m = v
+		case tcontainer.MarshalMap:
+			m = v
+		default:
+			continue
+		}
+		id, _ := m["id"].(string)
+		name, _ := m["name"].(string)
+		if id == value || strings.EqualFold(name, value) {
+			return map[string]string{"id": id, "name": name}, nil
+		}
+		names = append(names, "`"+name+"`")
+	}
+	return nil, errors.Errorf("`%s` is not a valid %s. Allowed values: %s.", value, field, strings.Join(names, ", "))
+}
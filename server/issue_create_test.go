@@ -0,0 +1,163 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseIssueCreateArgs(t *testing.T) {
+	for name, tc := range map[string]struct {
+		args        string
+		expected    *issueCreateArgs
+		expectedErr string
+	}{
+		"all flags": {
+			args: `--project=ops --type=Bug --priority=High --labels=a,b "Disk is full" on the build server`,
+			expected: &issueCreateArgs{
+				ProjectKey:  "OPS",
+				IssueType:   "Bug",
+				Priority:    "High",
+				Labels:      []string{"a", "b"},
+				Summary:     "Disk is full",
+				Description: "on the build server",
+			},
+		},
+		"flags after the summary": {
+			args:     `"Disk is full" --type=Task`,
+			expected: &issueCreateArgs{IssueType: "Task", Summary: "Disk is full"},
+		},
+		"unquoted summary": {
+			args:     `Outage`,
+			expected: &issueCreateArgs{Summary: "Outage"},
+		},
+		"smart quotes": {
+			args:     `“Disk is full” details`,
+			expected: &issueCreateArgs{Summary: "Disk is full", Description: "details"},
+		},
+		"no summary": {
+			args:     `--project=OPS`,
+			expected: &issueCreateArgs{ProjectKey: "OPS"},
+		},
+		"unknown flag": {
+			args:        `--assignee=me "Disk is full"`,
+			expectedErr: "`--assignee` is not a supported flag",
+		},
+		"flag without value": {
+			args:        `--project "Disk is full"`,
+			expectedErr: "`--project` is not valid, flags must be in the form `--name=value`",
+		},
+		"unterminated quote": {
+			args:        `"Disk is full`,
+			expectedErr: "unterminated quote",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			in, err := parseIssueCreateArgs(strings.Fields(tc.args))
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, in)
+		})
+	}
+}
+
+const testCreateMetaJSON = `{
+	"projects": [{
+		"id": "10000",
+		"key": "OPS",
+		"name": "Operations",
+		"issuetypes": [{
+			"id": "10001",
+			"name": "Bug",
+			"fields": {
+				"summary": {"required": true, "name": "Summary"},
+				"issuetype": {"required": true, "name": "Issue Type"},
+				"project": {"required": true, "name": "Project"},
+				"reporter": {"required": true, "name": "Reporter"},
+				"description": {"required": false, "name": "Description"},
+				"labels": {"required": false, "name": "Labels"},
+				"priority": {"required": false, "name": "Priority", "hasDefaultValue": true, "allowedValues": [
+					{"id": "1", "name": "High"},
+					{"id": "3", "name": "Low"}
+				]},
+				"customfield_10010": {"required": true, "name": "Team"},
+				"customfield_10011": {"required": true, "name": "Severity", "hasDefaultValue": true}
+			}
+		}, {
+			"id": "10002",
+			"name": "Task",
+			"fields": {
+				"summary": {"required": true, "name": "Summary"}
+			}
+		}]
+	}]
+}`
+
+func TestMakeIssueCreateFields(t *testing.T) {
+	meta := &jira.CreateMetaInfo{}
+	require.NoError(t, json.Unmarshal([]byte(testCreateMetaJSON), meta))
+
+	t.Run("valid", func(t *testing.T) {
+		fields, notCovered, err := makeIssueCreateFields(meta, &issueCreateArgs{
+			ProjectKey: "OPS",
+			IssueType:  "bug",
+			Priority:   "high",
+			Labels:     []string{"a"},
+			Summary:    "Disk is full",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "OPS", fields.Project.Key)
+		assert.Equal(t, "10001", fields.Type.ID)
+		assert.Equal(t, &jira.Priority{ID: "1", Name: "High"}, fields.Priority)
+		assert.Equal(t, []string{"a"}, fields.Labels)
+		assert.Equal(t, [][]string{{"customfield_10010", "Team"}, {"reporter", "Reporter"}}, notCovered)
+	})
+
+	t.Run("saved issue type ID", func(t *testing.T) {
+		fields, notCovered, err := makeIssueCreateFields(meta, &issueCreateArgs{ProjectKey: "OPS", IssueType: "10002", Summary: "Cleanup"})
+		require.NoError(t, err)
+		assert.Equal(t, "Task", fields.Type.Name)
+		assert.Empty(t, notCovered)
+	})
+
+	for name, tc := range map[string]struct {
+		in          *issueCreateArgs
+		expectedErr string
+	}{
+		"unknown project": {
+			in:          &issueCreateArgs{ProjectKey: "NOPE", IssueType: "Bug"},
+			expectedErr: "Project `NOPE` was not found, or you do not have permission to create issues in it.",
+		},
+		"unknown issue type": {
+			in:          &issueCreateArgs{ProjectKey: "OPS", IssueType: "Epic"},
+			expectedErr: "Issue type `Epic` is not available in project `OPS`. Available types: `Bug`, `Task`.",
+		},
+		"unknown priority": {
+			in:          &issueCreateArgs{ProjectKey: "OPS", IssueType: "Bug", Priority: "Urgent"},
+			expectedErr: "`Urgent` is not a valid priority. Allowed values: `High`, `Low`.",
+		},
+		"priority not on the screen": {
+			in:          &issueCreateArgs{ProjectKey: "OPS", IssueType: "Task", Priority: "High"},
+			expectedErr: "The priority can not be set on `Task` issues.",
+		},
+		"labels not on the screen": {
+			in:          &issueCreateArgs{ProjectKey: "OPS", IssueType: "Task", Labels: []string{"a"}},
+			expectedErr: "Labels can not be set on `Task` issues in project `OPS`.",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := makeIssueCreateFields(meta, tc.in)
+			require.EqualError(t, err, tc.expectedErr)
+		})
+	}
+}
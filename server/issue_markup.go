@@ -0,0 +1,162 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	jira "github.com/andygrunwald/go-jira"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/markup"
+	"github.com/mattermost/mattermost-plugin-jira/server/utils/types"
+)
+
+// jiraMentions returns the Jira users of the Mattermost users mentioned in a
+// message, if they are connected to the instance.
+func (p *Plugin) jiraMentions(instanceID types.ID) markup.MentionFunc {
+	return func(username string) *markup.JiraUser {
+		user, err := p.client.User.GetByUsername(username)
+		if err != nil {
+			return nil
+		}
+		connection, err := p.userStore.LoadConnection(instanceID, types.ID(user.Id))
+		if err != nil {
+			return nil
+		}
+		return &markup.JiraUser{AccountID: connection.AccountID, Name: connection.Name}
+	}
+}
+
+// markdownToWiki converts a Mattermost message to Jira wiki markup, e.g. for
+// the description of a new issue.
+func (p *Plugin) markdownToWiki(instanceID types.ID, md string) string {
+	return markup.MarkdownToWiki(md, p.jiraMentions(instanceID))
+}
+
+// textAreaCustomFieldType is the schema of the multi-line text custom
+// fields, which Jira Cloud takes as ADF documents.
+const textAreaCustomFieldType = "com.atlassian.jira.plugin.system.customfieldtypes:textarea"
+
+// markdownComment is a comment written in Mattermost Markdown. It is sent to
+// Jira Cloud as an ADF document, and to Jira Server as wiki markup.
+type markdownComment struct {
+	adf  *markup.ADFNode
+	wiki string
+}
+
+func (p *Plugin) newMarkdownComment(client Client, instanceID types.ID, md string) *markdownComment {
+	if _, ok := client.(ADFCommentService); ok {
+		return &markdownComment{adf: markup.MarkdownToADF(md, p.jiraMentions(instanceID))}
+	}
+	return &markdownComment{wiki: p.markdownToWiki(instanceID, md)}
+}
+
+// appendAttachment refers to an attachment of the issue at the end of the
+// comment. The images are embedded, the other files are linked.
+func (c *markdownComment) appendAttachment(attachment *jira.Attachment, embed bool) {
+	switch {
+	case c.adf != nil && embed:
+		c.adf.Content = append(c.adf.Content, &markup.ADFNode{
+			Type:  "mediaSingle",
+			Attrs: map[string]interface{}{"layout": "center"},
+			Content: []*markup.ADFNode{{
+				Type:  "media",
+				Attrs: map[string]interface{}{"type": "external", "url": attachment.Content, "alt": attachment.Filename},
+			}},
+		})
+	case c.adf != nil:
+		c.adf.Content = append(c.adf.Content, &markup.ADFNode{
+			Type: "paragraph",
+			Content: []*markup.ADFNode{
+				{Type: "text", Text: "Attachment: "},
+				{
+					Type:  "text",
+					Text:  attachment.Filename,
+					Marks: []*markup.ADFMark{{Type: "link", Attrs: map[string]interface{}{"href": attachment.Content}}},
+				},
+			},
+		})
+	case embed:
+		c.wiki += "\n\nAttachment: !" + attachment.Filename + "!"
+	default:
+		c.wiki += "\n\nAttachment: [^" + attachment.Filename + "]"
+	}
+}
+
+func (c *markdownComment) add(client Client, issueKey string) (*jira.Comment, error) {
+	if adfClient, ok := client.(ADFCommentService); ok && c.adf != nil {
+		return adfClient.AddADFComment(issueKey, c.adf)
+	}
+	return client.AddComment(issueKey, &jira.Comment{Body: c.wiki})
+}
+
+func (c *markdownComment) update(client Client, issueKey, commentID string) (*jira.Comment, error) {
+	if adfClient, ok := client.(ADFCommentService); ok && c.adf != nil {
+		return adfClient.UpdateADFComment(issueKey, commentID, c.adf)
+	}
+	return client.UpdateComment(issueKey, &jira.Comment{ID: commentID, Body: c.wiki})
+}
+
+// issueRichTextADF converts the rich text fields of a new issue to ADF
+// documents: the description, from the Markdown it was written in, the
+// environment, and the text area custom fields of the issue type.
+func (p *Plugin) issueRichTextADF(client Client, issue *jira.Issue, instanceID types.ID, description string) map[string]*markup.ADFNode {
+	mentions := p.jiraMentions(instanceID)
+	richText := map[string]*markup.ADFNode{}
+	if description != "" {
+		richText["description"] = markup.MarkdownToADF(description, mentions)
+	}
+	if issue.Fields.Environment != "" {
+		richText["environment"] = markup.MarkdownToADF(issue.Fields.Environment, mentions)
+	}
+
+	textFields := []string{}
+	for id, value := range issue.Fields.Unknowns {
+		if s, ok := value.(string); ok && s != "" {
+			textFields = append(textFields, id)
+		}
+	}
+	if len(textFields) == 0 {
+		return richText
+	}
+
+	textAreas, err := p.textAreaFields(client, issue)
+	if err != nil {
+		p.errorf("issueRichTextADF: failed to load the fields of issue type %s: %v", issue.Fields.Type.ID, err)
+		return richText
+	}
+	for _, id := range textFields {
+		if textAreas[id] {
+			richText[id] = markup.MarkdownToADF(issue.Fields.Unknowns[id].(string), mentions)
+		}
+	}
+	return richText
+}
+
+// textAreaFields returns the IDs of the text area custom fields of the
+// project and type of a new issue.
+func (p *Plugin) textAreaFields(client Client, issue *jira.Issue) (StringSet, error) {
+	cimd, err := client.GetCreateMetaInfo(p.API, &jira.GetQueryOptions{
+		Expand:      "projects.issuetypes.fields",
+		ProjectKeys: issue.Fields.Project.Key,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	textAreas := StringSet{}
+	for _, project := range cimd.Projects {
+		for _, issueType := range project.IssueTypes {
+			if issueType.Id != issue.Fields.Type.ID {
+				continue
+			}
+			for id, field := range issueType.Fields {
+				field, _ := field.(map[string]interface{})
+				schema, _ := field["schema"].(map[string]interface{})
+				if schema["custom"] == textAreaCustomFieldType {
+					textAreas[id] = true
+				}
+			}
+		}
+	}
+	return textAreas, nil
+}
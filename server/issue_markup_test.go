@@ -0,0 +1,182 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/utils/kvstore"
+	"github.com/mattermost/mattermost-plugin-jira/server/utils/types"
+)
+
+type mentionsUserStore struct {
+	mockUserStore
+	connections map[types.ID]*Connection
+}
+
+func (store mentionsUserStore) LoadConnection(_, mattermostUserID types.ID) (*Connection, error) {
+	if c, ok := store.connections[mattermostUserID]; ok {
+		return c, nil
+	}
+	return nil, kvstore.ErrNotFound
+}
+
+type commentsTestClient struct {
+	testClient
+	added *jira.Comment
+}
+
+func (client *commentsTestClient) AddComment(issueKey string, comment *jira.Comment) (*jira.Comment, error) {
+	client.added = comment
+	return &jira.Comment{ID: "100", Body: comment.Body}, nil
+}
+
+func setupTestMentions(connection *Connection) *Plugin {
+	api := &plugintest.API{}
+	api.On("GetUserByUsername", "jane").Return(&model.User{Id: "user1", Username: "jane"}, nil)
+	api.On("GetUserByUsername", "john").Return(&model.User{Id: "user2", Username: "john"}, nil)
+	api.On("GetUserByUsername", "here").Return(nil, &model.AppError{Id: "app.user.missing_account.const"})
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.client = pluginapi.NewClient(api, p.Driver)
+	p.userStore = mentionsUserStore{connections: map[types.ID]*Connection{"user1": connection}}
+	return p
+}
+
+func TestMarkdownCommentServer(t *testing.T) {
+	p := setupTestMentions(&Connection{User: jira.User{Name: "jdoe"}})
+	client := &commentsTestClient{}
+
+	comment := p.newMarkdownComment(client, testInstance1.InstanceID, "**Done**, thanks @jane and @john! cc @here")
+	added, err := comment.add(client, "TES-1")
+	require.NoError(t, err)
+	assert.Equal(t, "100", added.ID)
+	assert.Equal(t, "*Done*, thanks [~jdoe] and @john! cc @here", client.added.Body)
+}
+
+func TestMarkdownCommentCloud(t *testing.T) {
+	p := setupTestMentions(&Connection{User: jira.User{AccountID: "5c5f880629be9642ba529340"}})
+
+	var method, path string
+	var body map[string]interface{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path = r.Method, r.URL.Path
+		data, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &body)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"id": "100", "body": {"type": "doc", "version": 1, "content": [
+			{"type": "paragraph", "content": [{"type": "text", "text": "Done", "marks": [{"type": "strong"}]}]}
+		]}}`))
+	}))
+	defer ts.Close()
+	jiraClient, err := jira.NewClient(ts.Client(), ts.URL)
+	require.NoError(t, err)
+	client := newCloudClient(jiraClient)
+
+	comment := p.newMarkdownComment(client, testInstance1.InstanceID, "**Done**, @jane")
+	added, err := comment.add(client, "TES-1")
+	require.NoError(t, err)
+	assert.Equal(t, http.MethodPost, method)
+	assert.Equal(t, "/rest/api/3/issue/TES-1/comment", path)
+	assert.Equal(t, "100", added.ID)
+	assert.Equal(t, "**Done**", added.Body)
+
+	data, err := json.Marshal(body)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"body": {"type": "doc", "version": 1, "content": [
+		{"type": "paragraph", "content": [
+			{"type": "text", "text": "Done", "marks": [{"type": "strong"}]},
+			{"type": "text", "text": ", "},
+			{"type": "mention", "attrs": {"id": "5c5f880629be9642ba529340", "text": "@jane"}}
+		]}
+	]}}`, string(data))
+
+	comment.appendAttachment(&jira.Attachment{Filename: "screenshot.png", Content: "https://jira/attachment/content/1"}, true)
+	comment.appendAttachment(&jira.Attachment{Filename: "logs.txt", Content: "https://jira/attachment/content/2"}, false)
+	_, err = comment.update(client, "TES-1", "100")
+	require.NoError(t, err)
+	assert.Equal(t, http.MethodPut, method)
+	assert.Equal(t, "/rest/api/3/issue/TES-1/comment/100", path)
+
+	content, err := json.Marshal(body["body"].(map[string]interface{})["content"].([]interface{})[1:])
+	require.NoError(t, err)
+	assert.JSONEq(t, `[
+		{"type": "mediaSingle", "attrs": {"layout": "center"}, "content": [
+			{"type": "media", "attrs": {"type": "external", "url": "https://jira/attachment/content/1", "alt": "screenshot.png"}}
+		]},
+		{"type": "paragraph", "content": [
+			{"type": "text", "text": "Attachment: "},
+			{"type": "text", "text": "logs.txt", "marks": [{"type": "link", "attrs": {"href": "https://jira/attachment/content/2"}}]}
+		]}
+	]`, string(content))
+}
+
+func TestCreateADFIssue(t *testing.T) {
+	p := setupTestMentions(&Connection{User: jira.User{AccountID: "5c5f880629be9642ba529340"}})
+
+	var body map[string]interface{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rest/api/2/issue/createmeta":
+			_, _ = w.Write([]byte(`{"projects": [{"key": "TES", "issuetypes": [{"id": "10001", "fields": {
+				"customfield_10100": {"schema": {"type": "string", "custom": "com.atlassian.jira.plugin.system.customfieldtypes:textarea"}},
+				"customfield_10101": {"schema": {"type": "string", "custom": "com.atlassian.jira.plugin.system.customfieldtypes:textfield"}}
+			}}]}]}`))
+		case "/rest/api/3/issue":
+			data, _ := io.ReadAll(r.Body)
+			_ = json.Unmarshal(data, &body)
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"id": "10000", "key": "TES-1"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	jiraClient, err := jira.NewClient(ts.Client(), ts.URL)
+	require.NoError(t, err)
+	client := newCloudClient(jiraClient)
+
+	issue := &jira.Issue{Fields: &jira.IssueFields{
+		Project: jira.Project{Key: "TES"},
+		Type:    jira.IssueType{ID: "10001"},
+		Summary: "Summary",
+		Unknowns: map[string]interface{}{
+			"customfield_10100": "**Steps**",
+			"customfield_10101": "plain",
+		},
+	}}
+	created, err := client.(ADFIssueService).CreateADFIssue(issue, p.issueRichTextADF(client, issue, testInstance1.InstanceID, "**Done**, @jane"))
+	require.NoError(t, err)
+	assert.Equal(t, "TES-1", created.Key)
+
+	fields := body["fields"].(map[string]interface{})
+	assert.Equal(t, "Summary", fields["summary"])
+	assert.Equal(t, "plain", fields["customfield_10101"])
+	data, err := json.Marshal([]interface{}{fields["description"], fields["customfield_10100"]})
+	require.NoError(t, err)
+	assert.JSONEq(t, `[
+		{"type": "doc", "version": 1, "content": [
+			{"type": "paragraph", "content": [
+				{"type": "text", "text": "Done", "marks": [{"type": "strong"}]},
+				{"type": "text", "text": ", "},
+				{"type": "mention", "attrs": {"id": "5c5f880629be9642ba529340", "text": "@jane"}}
+			]}
+		]},
+		{"type": "doc", "version": 1, "content": [
+			{"type": "paragraph", "content": [{"type": "text", "text": "Steps", "marks": [{"type": "strong"}]}]}
+		]}
+	]`, string(data))
+}
@@ -5,21 +5,15 @@ package main
 
 import (
 	"fmt"
-	"regexp"
 
 	jira "github.com/andygrunwald/go-jira"
 
 	"github.com/mattermost/mattermost/server/public/model"
 
+	"github.com/mattermost/mattermost-plugin-jira/server/markup"
 	"github.com/mattermost/mattermost-plugin-jira/server/utils/types"
 )
 
-var jiraLinkWithTextRegex = regexp.MustCompile(`\[([^\[]+)\|([^\]]+)\]`)
-
-func parseJiraLinksToMarkdown(text string) string {
-	return jiraLinkWithTextRegex.ReplaceAllString(text, "[${1}](${2})")
-}
-
 func mdKeySummaryLink(issue *jira.Issue, instance Instance) string {
 	return fmt.Sprintf("[%s: %s (%s)](%s%s)", issue.Key, issue.Fields.Summary, issue.Fields.Status.Name, instance.GetJiraBaseURL(), "/browse/"+issue.Key)
 }
@@ -81,8 +75,7 @@ func getActions(instanceID types.ID, client Client, issue *jira.Issue) ([]*model
 
 func asSlackAttachment(instance Instance, client Client, issue *jira.Issue, showActions bool) ([]*model.SlackAttachment, error) {
 	text := mdKeySummaryLink(issue, instance)
-	desc := truncate(issue.Fields.Description, 3000)
-	desc = parseJiraLinksToMarkdown(desc)
+	desc := markup.TruncateMarkdown(issueDescriptionMarkdown(issue), 3000)
 	if desc != "" {
 		text += "\n\n" + desc + "\n"
 	}
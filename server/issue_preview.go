@@ -0,0 +1,342 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/utils/types"
+)
+
+const (
+	prefixIssuePreview         = "issue_preview_"
+	prefixIssuePreviewProjects = "issue_preview_projects_"
+	prefixIssuePreviewsOptOut  = "issue_previews_off_"
+	issuePreviewTTL            = 2 * time.Minute
+	issuePreviewsCommandSyntax = "`/jira issue previews [on|off]`"
+
+	// issuePreviewProjectsTTL is how long the projects a user can see are
+	// cached, to tell the issue keys from the look-alikes such as UTF-8.
+	issuePreviewProjectsTTL = time.Hour
+
+	// issuePreviewLookupsPerPreview bounds the issues looked up for a post,
+	// as a multiple of the maximum number of previews.
+	issuePreviewLookupsPerPreview = 2
+)
+
+var (
+	// reIssuePreviewURL matches the links to an issue, e.g.
+	// https://jira.example.com/browse/KEY-123.
+	reIssuePreviewURL = regexp.MustCompile(`(https?://[^\s/()<>\[\]]+(?:/[^\s()<>\[\]]*?)?)/browse/([A-Z][A-Z0-9_]+-[1-9][0-9]*)\b`)
+
+	// reIssuePreviewKey matches the issue keys that are not part of a link or
+	// of a longer word.
+	reIssuePreviewKey = regexp.MustCompile(`(?:^|[^\w/.-])([A-Z][A-Z0-9_]+-[1-9][0-9]*)\b`)
+
+	// reIssuePreviewCode matches the code blocks and spans of a message.
+	reIssuePreviewCode = regexp.MustCompile("(?s)```.*?(?:```|$)|`[^`\n]*`")
+)
+
+// issueReference is an issue mentioned in a message, with the Jira URL of
+// its link, or without one for a bare issue key.
+type issueReference struct {
+	baseURL  string
+	issueKey string
+}
+
+// issuePreview is the cached preview of an issue. The attachment is nil if
+// the issue could not be fetched, so that missing issues and mistaken keys
+// are not looked up again until the cache expires.
+type issuePreview struct {
+	Attachment *model.SlackAttachment `json:"attachment,omitempty"`
+}
+
+// findIssueReferences returns the issues linked or mentioned in a message,
+// in order, without the ones in code.
+func findIssueReferences(message string) []issueReference {
+	message = reIssuePreviewCode.ReplaceAllString(message, " ")
+
+	refs := []issueReference{}
+	for _, m := range reIssuePreviewURL.FindAllStringSubmatch(message, -1) {
+		refs = append(refs, issueReference{baseURL: m[1], issueKey: m[2]})
+	}
+	message = reIssuePreviewURL.ReplaceAllString(message, " ")
+	for _, m := range reIssuePreviewKey.FindAllStringSubmatch(message, -1) {
+		refs = append(refs, issueReference{issueKey: m[1]})
+	}
+	return refs
+}
+
+// previewIssues adds the previews of the issues linked or mentioned in a post
+// once it has been posted, so that the issues are not looked up in Jira while
+// the user waits for their post. The previews are dropped if the post was
+// edited or deleted in the meantime.
+func (p *Plugin) previewIssues(post *model.Post) {
+	attachments := p.issuePreviews(post)
+	if len(attachments) == 0 {
+		return
+	}
+
+	latest, err := p.client.Post.GetPost(post.Id)
+	if err != nil {
+		p.errorf("previewIssues: failed to load post %s: %v", post.Id, err)
+		return
+	}
+	if latest.DeleteAt != 0 || latest.Message != post.Message || len(latest.Attachments()) > 0 {
+		return
+	}
+
+	latest = latest.Clone()
+	model.ParseSlackAttachment(latest, attachments)
+	err = p.client.Post.UpdatePost(latest)
+	if err != nil {
+		p.errorf("previewIssues: failed to add the issue previews to post %s: %v", post.Id, err)
+	}
+}
+
+// issuePreviews returns the previews of the issues linked or mentioned in a
+// post. The issues are fetched with the connection of the poster, so that
+// they only preview the issues they can see. The bare issue keys are looked
+// up in their default instance. Only the keys of the projects the user can
+// see are looked up, and only a few more of them than can be previewed.
+func (p *Plugin) issuePreviews(post *model.Post) []*model.SlackAttachment {
+	conf := p.getConfig()
+	maxPreviews := conf.MaxIssuePreviewsPerPost
+	if maxPreviews <= 0 || post.Type != "" || post.UserId == conf.botUserID ||
+		post.GetProp("from_webhook") != nil || post.GetProp("from_bot") != nil || len(post.Attachments()) > 0 {
+		return nil
+	}
+
+	refs := findIssueReferences(post.Message)
+	if len(refs) == 0 {
+		return nil
+	}
+
+	optedOut, err := p.issuePreviewsOptedOut(post.ChannelId)
+	if err != nil {
+		p.errorf("issuePreviews: failed to load the preview setting of channel %s: %v", post.ChannelId, err)
+		return nil
+	}
+	if optedOut {
+		return nil
+	}
+
+	user, err := p.userStore.LoadUser(types.ID(post.UserId))
+	if err != nil || user.ConnectedInstances.IsEmpty() {
+		return nil
+	}
+	defaultInstanceID, _ := p.resolveUserInstanceURL(user, "")
+
+	instances := map[types.ID]Instance{}
+	for _, id := range user.ConnectedInstances.IDs() {
+		instance, loadErr := p.instanceStore.LoadInstance(id)
+		if loadErr != nil {
+			continue
+		}
+		instances[id] = instance
+	}
+
+	attachments := []*model.SlackAttachment{}
+	previewed := NewStringSet()
+	projectsByInstance := map[types.ID]StringSet{}
+	lookups := 0
+	for _, ref := range refs {
+		if len(attachments) >= maxPreviews || lookups >= maxPreviews*issuePreviewLookupsPerPreview {
+			break
+		}
+
+		instance := instances[defaultInstanceID]
+		if ref.baseURL != "" {
+			instance = nil
+			for _, i := range instances {
+				if strings.EqualFold(strings.TrimRight(i.GetJiraBaseURL(), "/"), ref.baseURL) {
+					instance = i
+					break
+				}
+			}
+		}
+		if instance == nil {
+			continue
+		}
+
+		id := instance.GetID().String() + "/" + ref.issueKey
+		if previewed.ContainsAny(id) {
+			continue
+		}
+		previewed = previewed.Add(id)
+
+		projects, ok := projectsByInstance[instance.GetID()]
+		if !ok {
+			projects, err = p.loadIssuePreviewProjects(instance, user.MattermostUserID)
+			if err != nil {
+				p.errorf("issuePreviews: failed to load the projects of instance %s: %v", instance.GetID(), err)
+			}
+			projectsByInstance[instance.GetID()] = projects
+		}
+		if !projects.ContainsAny(ref.issueKey[:strings.LastIndex(ref.issueKey, "-")]) {
+			continue
+		}
+
+		lookups++
+		attachment, err := p.loadIssuePreview(instance, user.MattermostUserID, ref.issueKey)
+		if err != nil {
+			p.errorf("issuePreviews: failed to preview issue %s: %v", ref.issueKey, err)
+			continue
+		}
+		if attachment != nil {
+			attachments = append(attachments, attachment)
+		}
+	}
+	return attachments
+}
+
+// loadIssuePreview returns the preview of an issue for a user from the
+// cache, or from Jira. It returns nil if the issue can not be previewed, e.g.
+// if it does not exist or the user does not have access to it.
+func (p *Plugin) loadIssuePreview(instance Instance, mattermostUserID types.ID, issueKey string) (*model.SlackAttachment, error) {
+	key := hashkey(prefixIssuePreview, instance.GetID().String()+"/"+mattermostUserID.String()+"/"+issueKey)
+	var cached *issuePreview
+	err := p.client.KV.Get(key, &cached)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil {
+		return cached.Attachment, nil
+	}
+
+	connection, err := p.userStore.LoadConnection(instance.GetID(), mattermostUserID)
+	if err != nil {
+		return nil, nil
+	}
+
+	preview := &issuePreview{}
+	attachments, err := p.getIssueAsSlackAttachment(instance, connection, issueKey, false)
+	if err == nil && len(attachments) > 0 {
+		preview.Attachment = compactIssuePreview(attachments[0])
+	}
+
+	_, err = p.client.KV.Set(key, preview, pluginapi.SetExpiry(issuePreviewTTL))
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to cache the preview")
+	}
+	return preview.Attachment, nil
+}
+
+// loadIssuePreviewProjects returns the keys of the projects of an instance
+// that a user can see, from the cache or from Jira.
+func (p *Plugin) loadIssuePreviewProjects(instance Instance, mattermostUserID types.ID) (StringSet, error) {
+	key := hashkey(prefixIssuePreviewProjects, instance.GetID().String()+"/"+mattermostUserID.String())
+	var cached []string
+	err := p.client.KV.Get(key, &cached)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil {
+		return NewStringSet(cached...), nil
+	}
+
+	client, ok := p.getClientForMattermostUser(instance, mattermostUserID)
+	if !ok {
+		return nil, errors.New("the user is not connected")
+	}
+	projects, err := client.ListProjects("", -1, false)
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for _, project := range projects {
+		keys = append(keys, project.Key)
+	}
+
+	_, err = p.client.KV.Set(key, keys, pluginapi.SetExpiry(issuePreviewProjectsTTL))
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to cache the projects")
+	}
+	return NewStringSet(keys...), nil
+}
+
+// compactIssuePreview keeps the link to the issue with its summary and
+// status, and its assignee and priority, out of the attachment of an issue.
+func compactIssuePreview(attachment *model.SlackAttachment) *model.SlackAttachment {
+	compact := &model.SlackAttachment{
+		Color: attachment.Color,
+		Text:  strings.SplitN(attachment.Text, "\n", 2)[0],
+	}
+	for _, field := range attachment.Fields {
+		if field.Title == "Assignee" || field.Title == "Priority" {
+			compact.Fields = append(compact.Fields, field)
+		}
+	}
+	return compact
+}
+
+func (p *Plugin) issuePreviewsOptedOut(channelID string) (bool, error) {
+	var optedOut bool
+	err := p.client.KV.Get(prefixIssuePreviewsOptOut+channelID, &optedOut)
+	return optedOut, err
+}
+
+func (p *Plugin) setIssuePreviewsOptedOut(channelID string, optedOut bool) error {
+	if !optedOut {
+		return p.client.KV.Delete(prefixIssuePreviewsOptOut + channelID)
+	}
+	_, err := p.client.KV.Set(prefixIssuePreviewsOptOut+channelID, true)
+	return err
+}
+
+func executeIssuePreviews(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	if len(args) == 0 {
+		optedOut, err := p.issuePreviewsOptedOut(header.ChannelId)
+		if err != nil {
+			return p.responsef(header, "Failed to load the issue preview setting of this channel. Error: %v.", err)
+		}
+		if optedOut {
+			return p.responsef(header, "Jira issue previews are off in this channel.")
+		}
+		return p.responsef(header, "Jira issue previews are on in this channel.")
+	}
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		return p.responsef(header, "Please use %s.", issuePreviewsCommandSyntax)
+	}
+
+	err := p.hasPermissionToManageChannel(header.UserId, header.ChannelId)
+	if err != nil {
+		return p.responsef(header, "You do not have permission to change the issue preview setting of this channel.")
+	}
+
+	err = p.setIssuePreviewsOptedOut(header.ChannelId, args[0] == "off")
+	if err != nil {
+		return p.responsef(header, "Failed to save the issue preview setting of this channel. Error: %v.", err)
+	}
+	return p.responsef(header, "Jira issue previews are now %s in this channel.", args[0])
+}
+
+// hasPermissionToManageChannel checks that the user can manage the
+// properties of a channel. Any member of a direct or group message can.
+func (p *Plugin) hasPermissionToManageChannel(userID, channelID string) error {
+	channel, err := p.client.Channel.Get(channelID)
+	if err != nil {
+		return errors.Wrap(err, "unable to get channel to check permission")
+	}
+
+	permission := model.PermissionReadChannel
+	switch channel.Type {
+	case model.ChannelTypeOpen:
+		permission = model.PermissionManagePublicChannelProperties
+	case model.ChannelTypePrivate:
+		permission = model.PermissionManagePrivateChannelProperties
+	}
+	if !p.client.User.HasPermissionToChannel(userID, channelID, permission) {
+		return errors.New("is not channel admin")
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/utils/kvstore"
+	"github.com/mattermost/mattermost-plugin-jira/server/utils/types"
+)
+
+type previewTestClient struct {
+	testClient
+	fetched        []string
+	listedProjects int
+}
+
+func (client *previewTestClient) ListProjects(query string, limit int, expandIssueTypes bool) (jira.ProjectList, error) {
+	client.listedProjects++
+	return jira.ProjectList{{Key: "ABC"}, {Key: "XY_Z"}}, nil
+}
+
+func (client *previewTestClient) GetIssue(issueKey string, options *jira.GetQueryOptions) (*jira.Issue, error) {
+	client.fetched = append(client.fetched, issueKey)
+	if strings.HasPrefix(issueKey, "ABC-10") {
+		return nil, kvstore.ErrNotFound
+	}
+	return &jira.Issue{
+		Key: issueKey,
+		Fields: &jira.IssueFields{
+			Summary:     "Summary of " + issueKey,
+			Description: "A long description",
+			Status:      &jira.Status{Name: "In Progress"},
+			Assignee:    &jira.User{DisplayName: "Jane Doe"},
+			Priority:    &jira.Priority{Name: "High"},
+			Reporter:    &jira.User{Name: "john"},
+		},
+	}, nil
+}
+
+type previewTestInstance struct {
+	testInstance
+	client *previewTestClient
+}
+
+func (ti previewTestInstance) GetClient(*Connection) (Client, error) {
+	return ti.client, nil
+}
+
+type previewInstanceStore struct {
+	mockInstanceStore
+	instance Instance
+}
+
+func (store previewInstanceStore) LoadInstance(types.ID) (Instance, error) {
+	return store.instance, nil
+}
+
+type previewUserStore struct {
+	mockUserStore
+}
+
+func (store previewUserStore) LoadUser(mattermostUserID types.ID) (*User, error) {
+	user := NewUser(mattermostUserID)
+	if mattermostUserID == "connected" {
+		user.ConnectedInstances.Set(testInstance1.Common())
+	}
+	return user, nil
+}
+
+func setupTestIssuePreviews() (*Plugin, *previewTestClient, *plugintest.API) {
+	api := &plugintest.API{}
+	makeTestKVStore(api, nil)
+
+	client := &previewTestClient{}
+	p := &Plugin{}
+	p.updateConfig(func(conf *config) {
+		conf.botUserID = "bot"
+		conf.MaxIssuePreviewsPerPost = 2
+	})
+	p.SetAPI(api)
+	p.client = pluginapi.NewClient(api, p.Driver)
+	p.userStore = previewUserStore{}
+	p.instanceStore = previewInstanceStore{instance: &previewTestInstance{testInstance: *testInstance1, client: client}}
+	return p, client, api
+}
+
+// postWithPreviews runs the hook of a new post, with the post loaded back as
+// latest, and returns the post updated with the previews, or nil if the post
+// was not updated.
+func postWithPreviews(p *Plugin, api *plugintest.API, post, latest *model.Post) *model.Post {
+	post = post.Clone()
+	post.Id = model.NewId()
+	latest = latest.Clone()
+	latest.Id = post.Id
+
+	var updated *model.Post
+	api.On("GetPost", post.Id).Return(latest, nil)
+	api.On("UpdatePost", mock.MatchedBy(func(u *model.Post) bool { return u.Id == post.Id })).Return(
+		func(u *model.Post) *model.Post {
+			updated = u.Clone()
+			return u.Clone()
+		},
+		func(*model.Post) *model.AppError { return nil })
+	p.MessageHasBeenPosted(nil, post)
+	return updated
+}
+
+func TestFindIssueReferences(t *testing.T) {
+	for name, tc := range map[string]struct {
+		message  string
+		expected []issueReference
+	}{
+		"no issues": {
+			message:  "Nothing to see here",
+			expected: []issueReference{},
+		},
+		"keys": {
+			message:  "Fixed ABC-12 and XY_Z-3, see (ABC-4).",
+			expected: []issueReference{{issueKey: "ABC-12"}, {issueKey: "XY_Z-3"}, {issueKey: "ABC-4"}},
+		},
+		"links": {
+			message: "See https://jira.example.com/browse/ABC-12 and <https://example.com/jira/browse/DEF-3>",
+			expected: []issueReference{
+				{baseURL: "https://jira.example.com", issueKey: "ABC-12"},
+				{baseURL: "https://example.com/jira", issueKey: "DEF-3"},
+			},
+		},
+		"Markdown link": {
+			message: "[the bug](https://jira.example.com/browse/ABC-12) is ABC-12",
+			expected: []issueReference{
+				{baseURL: "https://jira.example.com", issueKey: "ABC-12"},
+				{issueKey: "ABC-12"},
+			},
+		},
+		"not keys": {
+			message:  "abc-12, ABC-0, ABC-12x, github.com/org/ABC-12 and v.ABC-1",
+			expected: []issueReference{},
+		},
+		"code": {
+			message:  "`ABC-1` and\n```\nABC-2\n```\nbut ABC-3",
+			expected: []issueReference{{issueKey: "ABC-3"}},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, findIssueReferences(tc.message))
+		})
+	}
+}
+
+func TestMessageHasBeenPosted(t *testing.T) {
+	t.Run("issues are previewed", func(t *testing.T) {
+		p, client, api := setupTestIssuePreviews()
+
+		original := &model.Post{
+			UserId:    "connected",
+			ChannelId: "channel1",
+			Message:   "Is ABC-1 a duplicate of " + mockInstance1URL + "/browse/ABC-2?",
+		}
+		post := postWithPreviews(p, api, original, original)
+		require.NotNil(t, post)
+		assert.Equal(t, "Is ABC-1 a duplicate of "+mockInstance1URL+"/browse/ABC-2?", post.Message)
+		attachments := post.Attachments()
+		require.Len(t, attachments, 2)
+		assert.Equal(t, "[ABC-2: Summary of ABC-2 (In Progress)]("+mockInstance1URL+"/browse/ABC-2)", attachments[0].Text)
+		assert.Equal(t, "[ABC-1: Summary of ABC-1 (In Progress)]("+mockInstance1URL+"/browse/ABC-1)", attachments[1].Text)
+		require.Len(t, attachments[0].Fields, 2)
+		assert.Equal(t, "Assignee", attachments[0].Fields[0].Title)
+		assert.Equal(t, "Priority", attachments[0].Fields[1].Title)
+		assert.Empty(t, attachments[0].Actions)
+		assert.Equal(t, []string{"ABC-2", "ABC-1"}, client.fetched)
+	})
+
+	t.Run("previews are limited and cached", func(t *testing.T) {
+		p, client, api := setupTestIssuePreviews()
+		post := &model.Post{UserId: "connected", ChannelId: "channel1", Message: "UTF-8, SHA-256: ABC-100, ABC-1, ABC-1, ABC-2 and ABC-3"}
+
+		previewed := postWithPreviews(p, api, post, post)
+		require.NotNil(t, previewed)
+		assert.Len(t, previewed.Attachments(), 2)
+		assert.Equal(t, []string{"ABC-100", "ABC-1", "ABC-2"}, client.fetched, "the keys of unknown projects are not looked up")
+
+		previewed = postWithPreviews(p, api, post, post)
+		require.NotNil(t, previewed)
+		assert.Len(t, previewed.Attachments(), 2)
+		assert.Equal(t, []string{"ABC-100", "ABC-1", "ABC-2"}, client.fetched)
+		assert.Equal(t, 1, client.listedProjects)
+	})
+
+	t.Run("lookups are limited", func(t *testing.T) {
+		p, client, api := setupTestIssuePreviews()
+		post := &model.Post{UserId: "connected", ChannelId: "channel1", Message: "ABC-101 ABC-102 ABC-103 ABC-104 ABC-105 ABC-1"}
+
+		previewed := postWithPreviews(p, api, post, post)
+		assert.Nil(t, previewed)
+		assert.Equal(t, []string{"ABC-101", "ABC-102", "ABC-103", "ABC-104"}, client.fetched)
+	})
+
+	t.Run("posts are not changed", func(t *testing.T) {
+		for name, post := range map[string]*model.Post{
+			"no issues":         {UserId: "connected", ChannelId: "channel1", Message: "Hello"},
+			"not connected":     {UserId: "other", ChannelId: "channel1", Message: "ABC-1"},
+			"bot post":          {UserId: "bot", ChannelId: "channel1", Message: "ABC-1"},
+			"system post":       {UserId: "connected", ChannelId: "channel1", Message: "ABC-1", Type: model.PostTypeHeaderChange},
+			"other instance":    {UserId: "connected", ChannelId: "channel1", Message: "https://other.example.com/browse/ABC-1"},
+			"opted out channel": {UserId: "connected", ChannelId: "quiet", Message: "ABC-1"},
+		} {
+			t.Run(name, func(t *testing.T) {
+				p, client, api := setupTestIssuePreviews()
+				require.NoError(t, p.setIssuePreviewsOptedOut("quiet", true))
+
+				previewed := postWithPreviews(p, api, post, post)
+				assert.Nil(t, previewed)
+				assert.Empty(t, client.fetched)
+			})
+		}
+	})
+
+	t.Run("previews can be turned back on", func(t *testing.T) {
+		p, _, api := setupTestIssuePreviews()
+		require.NoError(t, p.setIssuePreviewsOptedOut("channel1", true))
+		require.NoError(t, p.setIssuePreviewsOptedOut("channel1", false))
+
+		post := &model.Post{UserId: "connected", ChannelId: "channel1", Message: "ABC-1"}
+		previewed := postWithPreviews(p, api, post, post)
+		require.NotNil(t, previewed)
+		assert.Len(t, previewed.Attachments(), 1)
+	})
+
+	t.Run("previews can be disabled", func(t *testing.T) {
+		p, client, api := setupTestIssuePreviews()
+		p.updateConfig(func(conf *config) {
+			conf.MaxIssuePreviewsPerPost = 0
+		})
+
+		post := &model.Post{UserId: "connected", ChannelId: "channel1", Message: "ABC-1"}
+		previewed := postWithPreviews(p, api, post, post)
+		assert.Nil(t, previewed)
+		assert.Empty(t, client.fetched)
+	})
+
+	t.Run("edited posts are not changed", func(t *testing.T) {
+		p, client, api := setupTestIssuePreviews()
+		post := &model.Post{UserId: "connected", ChannelId: "channel1", Message: "ABC-1"}
+		edited := &model.Post{UserId: "connected", ChannelId: "channel1", Message: "ABC-2"}
+
+		previewed := postWithPreviews(p, api, post, edited)
+		assert.Nil(t, previewed)
+		assert.Equal(t, []string{"ABC-1"}, client.fetched)
+	})
+}
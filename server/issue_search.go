@@ -0,0 +1,264 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/utils/types"
+)
+
+const (
+	searchDefaultLimit = 10
+	searchMaxLimit     = 50
+)
+
+// reLooksLikeJQL detects the queries of `/jira search` that are JQL rather than text.
+var reLooksLikeJQL = regexp.MustCompile(`(?i)(!?=|!?~|[<>]|\s(not\s+)?in\s*\(|\sis\s+(not\s+)?(empty|null)\b|\border\s+by\b)`)
+
+// issueSearch is a page of the results of `/jira search`. It is passed in the
+// context of the paging buttons.
+type issueSearch struct {
+	InstanceID types.ID
+	Query      string
+	JQL        string
+	StartAt    int
+	Limit      int
+}
+
+func executeSearch(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	user, instance, args, err := p.loadFlagUserInstance(header.UserId, args)
+	if err != nil {
+		return p.responsef(header, "Failed to load your connection to Jira. Error: %v.", err)
+	}
+	limit, args, err := parseCommandFlagLimit(args)
+	if err != nil {
+		return p.responsef(header, "%v", err)
+	}
+	if len(args) == 0 {
+		return p.responsef(header, "Please specify a JQL query or a text to search for in the form `/jira search <JQL or text> [--limit=N]`.")
+	}
+
+	query := strings.Join(args, " ")
+	search := &issueSearch{
+		InstanceID: instance.GetID(),
+		Query:      query,
+		JQL:        searchQueryToJQL(query),
+		Limit:      limit,
+	}
+
+	post, err := p.searchIssuesPost(user.MattermostUserID, search)
+	if err != nil {
+		return p.responsef(header, "Failed to search issues. Error: %v.", err)
+	}
+	post.UserId = p.getUserID()
+	post.ChannelId = header.ChannelId
+	post.RootId = header.RootId
+	p.client.Post.SendEphemeralPost(header.UserId, post)
+
+	return &model.CommandResponse{}
+}
+
+// parseCommandFlagLimit extracts the --limit=N flag from the command arguments.
+func parseCommandFlagLimit(args []string) (int, []string, error) {
+	limit := searchDefaultLimit
+	remaining := []string{}
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "--limit=") {
+			remaining = append(remaining, arg)
+			continue
+		}
+		n, err := strconv.Atoi(arg[len("--limit="):])
+		if err != nil || n < 1 || n > searchMaxLimit {
+			return 0, nil, errors.Errorf("`%s` is not valid, the limit must be between 1 and %d", arg, searchMaxLimit)
+		}
+		limit = n
+	}
+	return limit, remaining, nil
+}
+
+// searchQueryToJQL returns the query as is if it is JQL, or a text search.
+func searchQueryToJQL(query string) string {
+	if reLooksLikeJQL.MatchString(query) {
+		return query
+	}
+	if reJiraIssueKey.MatchString(query) {
+		return fmt.Sprintf(`key = "%s"`, strings.ToUpper(query))
+	}
+	escaped := strings.ReplaceAll(query, `"`, `\"`)
+	return fmt.Sprintf(`text ~ "%s" ORDER BY updated DESC`, escaped)
+}
+
+// searchIssuesPost runs the search as the user, and returns the ephemeral post
+// of the page of results.
+func (p *Plugin) searchIssuesPost(mattermostUserID types.ID, search *issueSearch) (*model.Post, error) {
+	client, instance, _, err := p.getClient(search.InstanceID, mattermostUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fetch one more issue to know if there is a next page
+	issues, err := client.SearchIssues(search.JQL, &jira.SearchOptions{
+		StartAt:    search.StartAt,
+		MaxResults: search.Limit + 1,
+		Fields:     []string{"summary", "status", "assignee", "priority"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hasNext := len(issues) > search.Limit
+	if hasNext {
+		issues = issues[:search.Limit]
+	}
+
+	return makeSearchResultsPost(instance.GetJiraBaseURL(), search, issues, hasNext), nil
+}
+
+func makeSearchResultsPost(baseURL string, search *issueSearch, issues []jira.Issue, hasNext bool) *model.Post {
+	post := &model.Post{}
+
+	if len(issues) == 0 {
+		if search.StartAt == 0 {
+			post.Message = fmt.Sprintf("No issues found for `%s`.", search.Query)
+			return post
+		}
+		post.Message = fmt.Sprintf("No more issues found for `%s`.", search.Query)
+	} else {
+		sb := &strings.Builder{}
+		fmt.Fprintf(sb, "#### Search results for `%s` (%d-%d)\n", search.Query, search.StartAt+1, search.StartAt+len(issues))
+		sb.WriteString("| Key | Summary | Status | Assignee | Priority |\n")
+		sb.WriteString("|:----|:--------|:-------|:---------|:---------|\n")
+		for _, issue := range issues {
+			status, assignee, priority := "", "_Unassigned_", ""
+			summary := ""
+			if issue.Fields != nil {
+				summary = issue.Fields.Summary
+				if issue.Fields.Status != nil {
+					status = issue.Fields.Status.Name
+				}
+				if issue.Fields.Assignee != nil {
+					assignee = issue.Fields.Assignee.DisplayName
+				}
+				if issue.Fields.Priority != nil {
+					priority = issue.Fields.Priority.Name
+				}
+			}
+			fmt.Fprintf(sb, "| [%s](%s/browse/%s) | %s | %s | %s | %s |\n",
+				issue.Key, baseURL, issue.Key,
+				escapeTableCell(summary), escapeTableCell(status), escapeTableCell(assignee), escapeTableCell(priority))
+		}
+		post.Message = sb.String()
+	}
+
+	var actions []*model.PostAction
+	if search.StartAt > 0 {
+		previous := *search
+		previous.StartAt -= search.Limit
+		if previous.StartAt < 0 {
+			previous.StartAt = 0
+		}
+		actions = append(actions, makeSearchPageAction("Previous page", &previous))
+	}
+	if hasNext {
+		next := *search
+		next.StartAt += search.Limit
+		actions = append(actions, makeSearchPageAction("Next page", &next))
+	}
+	if len(actions) > 0 {
+		model.ParseSlackAttachment(post, []*model.SlackAttachment{
+			{
+				Actions: actions,
+			},
+		})
+	}
+
+	return post
+}
+
+func makeSearchPageAction(name string, search *issueSearch) *model.PostAction {
+	return &model.PostAction{
+		Name: name,
+		Type: model.PostActionTypeButton,
+		Integration: &model.PostActionIntegration{
+			URL: fmt.Sprintf("/plugins/%s%s%s", manifest.Id, routeAPI, routeSearchIssuesPage),
+			Context: map[string]interface{}{
+				"instance_id": search.InstanceID.String(),
+				"query":       search.Query,
+				"jql":         search.JQL,
+				"start_at":    search.StartAt,
+				"limit":       search.Limit,
+			},
+		},
+	}
+}
+
+func escapeTableCell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func (p *Plugin) httpSearchIssuesPage(w http.ResponseWriter, r *http.Request) (int, error) {
+	var requestData model.PostActionIntegrationRequest
+	err := json.NewDecoder(r.Body).Decode(&requestData)
+	if err != nil {
+		return respondErr(w, http.StatusBadRequest,
+			errors.Wrap(err, "unmarshall the body"))
+	}
+
+	jiraBotID := p.getUserID()
+	channelID := requestData.ChannelId
+	mattermostUserID := r.Header.Get("Mattermost-User-Id")
+
+	search, err := issueSearchFromContext(requestData.Context)
+	if err != nil {
+		return p.respondErrWithFeedback(mattermostUserID, makePost(jiraBotID, channelID,
+			"Invalid search context: "+err.Error()), w, http.StatusBadRequest)
+	}
+
+	post, err := p.searchIssuesPost(types.ID(mattermostUserID), search)
+	if err != nil {
+		return p.respondErrWithFeedback(mattermostUserID, makePost(jiraBotID, channelID,
+			"Failed to search issues. Error: "+err.Error()), w, http.StatusInternalServerError)
+	}
+	post.Id = requestData.PostId
+	post.ChannelId = channelID
+	post.UserId = jiraBotID
+	p.client.Post.UpdateEphemeralPost(mattermostUserID, post)
+
+	return respondJSON(w, &model.PostActionIntegrationResponse{})
+}
+
+func issueSearchFromContext(context map[string]interface{}) (*issueSearch, error) {
+	instanceID, _ := context["instance_id"].(string)
+	query, _ := context["query"].(string)
+	jql, _ := context["jql"].(string)
+	startAt, _ := context["start_at"].(float64)
+	limit, _ := context["limit"].(float64)
+	if instanceID == "" || jql == "" {
+		return nil, errors.New("missing instance or query")
+	}
+	if limit < 1 || limit > searchMaxLimit || startAt < 0 {
+		return nil, errors.New("invalid page")
+	}
+
+	return &issueSearch{
+		InstanceID: types.ID(instanceID),
+		Query:      query,
+		JQL:        jql,
+		StartAt:    int(startAt),
+		Limit:      int(limit),
+	}, nil
+}
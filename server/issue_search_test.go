@@ -0,0 +1,119 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchQueryToJQL(t *testing.T) {
+	for query, expected := range map[string]string{
+		`project = OPS AND status != Done`:  `project = OPS AND status != Done`,
+		`summary ~ outage`:                  `summary ~ outage`,
+		`priority in (P1, P2)`:              `priority in (P1, P2)`,
+		`assignee is EMPTY`:                 `assignee is EMPTY`,
+		`tes-41`:                            `key = "TES-41"`,
+		`disk full`:                         `text ~ "disk full" ORDER BY updated DESC`,
+		`the "quoted" word`:                 `text ~ "the \"quoted\" word" ORDER BY updated DESC`,
+		`project in(OPS) order by created`:  `project in(OPS) order by created`,
+		`login broken after upgrade`:        `text ~ "login broken after upgrade" ORDER BY updated DESC`,
+		`created >= -1w ORDER BY priority`:  `created >= -1w ORDER BY priority`,
+		`labels not in (noise) AND key=X-1`: `labels not in (noise) AND key=X-1`,
+	} {
+		assert.Equal(t, expected, searchQueryToJQL(query), query)
+	}
+}
+
+func TestParseCommandFlagLimit(t *testing.T) {
+	limit, args, err := parseCommandFlagLimit([]string{"disk", "--limit=25", "full"})
+	require.NoError(t, err)
+	assert.Equal(t, 25, limit)
+	assert.Equal(t, []string{"disk", "full"}, args)
+
+	limit, args, err = parseCommandFlagLimit([]string{"disk"})
+	require.NoError(t, err)
+	assert.Equal(t, searchDefaultLimit, limit)
+	assert.Equal(t, []string{"disk"}, args)
+
+	for _, arg := range []string{"--limit=0", "--limit=51", "--limit=ten"} {
+		_, _, err = parseCommandFlagLimit([]string{"disk", arg})
+		assert.Error(t, err, arg)
+	}
+}
+
+func TestMakeSearchResultsPost(t *testing.T) {
+	issues := []jira.Issue{
+		{
+			Key: "TES-41",
+			Fields: &jira.IssueFields{
+				Summary:  "Disk | full",
+				Status:   &jira.Status{Name: "To Do"},
+				Assignee: &jira.User{DisplayName: "Test User"},
+				Priority: &jira.Priority{Name: "High"},
+			},
+		},
+		{
+			Key:    "TES-42",
+			Fields: &jira.IssueFields{Summary: "Second"},
+		},
+	}
+
+	t.Run("first page", func(t *testing.T) {
+		search := &issueSearch{InstanceID: testInstance1.InstanceID, Query: "disk", JQL: "text ~ disk", Limit: 2}
+		post := makeSearchResultsPost("https://jira.example.com", search, issues, true)
+
+		assert.Contains(t, post.Message, "#### Search results for `disk` (1-2)\n")
+		assert.Contains(t, post.Message, "| [TES-41](https://jira.example.com/browse/TES-41) | Disk \\| full | To Do | Test User | High |\n")
+		assert.Contains(t, post.Message, "| [TES-42](https://jira.example.com/browse/TES-42) | Second |  | _Unassigned_ |  |\n")
+
+		actions := post.Attachments()[0].Actions
+		require.Len(t, actions, 1)
+		assert.Equal(t, "Next page", actions[0].Name)
+
+		// The context goes through JSON on its way back to the plugin
+		bb, err := json.Marshal(actions[0].Integration.Context)
+		require.NoError(t, err)
+		var context map[string]interface{}
+		require.NoError(t, json.Unmarshal(bb, &context))
+		next, err := issueSearchFromContext(context)
+		require.NoError(t, err)
+		assert.Equal(t, &issueSearch{InstanceID: testInstance1.InstanceID, Query: "disk", JQL: "text ~ disk", StartAt: 2, Limit: 2}, next)
+	})
+
+	t.Run("last page", func(t *testing.T) {
+		search := &issueSearch{InstanceID: testInstance1.InstanceID, Query: "disk", JQL: "text ~ disk", StartAt: 2, Limit: 2}
+		post := makeSearchResultsPost("https://jira.example.com", search, issues[:1], false)
+
+		assert.Contains(t, post.Message, "(3-3)")
+		actions := post.Attachments()[0].Actions
+		require.Len(t, actions, 1)
+		assert.Equal(t, "Previous page", actions[0].Name)
+		assert.Equal(t, 0, actions[0].Integration.Context["start_at"])
+	})
+
+	t.Run("no results", func(t *testing.T) {
+		search := &issueSearch{InstanceID: testInstance1.InstanceID, Query: "disk", JQL: "text ~ disk", Limit: 2}
+		post := makeSearchResultsPost("https://jira.example.com", search, nil, false)
+
+		assert.Equal(t, "No issues found for `disk`.", post.Message)
+		assert.Empty(t, post.Attachments())
+	})
+}
+
+func TestIssueSearchFromContext(t *testing.T) {
+	for name, context := range map[string]map[string]interface{}{
+		"missing instance": {"jql": "text ~ disk", "start_at": 0.0, "limit": 10.0},
+		"missing jql":      {"instance_id": "jiraurl1", "start_at": 0.0, "limit": 10.0},
+		"invalid limit":    {"instance_id": "jiraurl1", "jql": "text ~ disk", "start_at": 0.0, "limit": 1000.0},
+		"negative start":   {"instance_id": "jiraurl1", "jql": "text ~ disk", "start_at": -10.0, "limit": 10.0},
+	} {
+		_, err := issueSearchFromContext(context)
+		assert.Error(t, err, name)
+	}
+}
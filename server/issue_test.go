@@ -60,6 +60,21 @@ func (client testClient) GetTransitions(issueKey string) ([]jira.Transition, err
 	}, nil
 }
 
+func (client testClient) GetWatchers(issueKey string) ([]jira.User, error) {
+	return nil, nil
+}
+
+func (client testClient) GetUserGroups(connection *Connection) ([]*jira.UserGroup, error) {
+	return []*jira.UserGroup{{Name: "jira-developers"}}, nil
+}
+
+func (client testClient) GetGroupMembers(groupName string) ([]jira.GroupMember, error) {
+	if groupName != "jira-developers" {
+		return []jira.GroupMember{}, nil
+	}
+	return []jira.GroupMember{{Name: "admin", Key: "admin", AccountID: "5c5f880629be9642ba529340"}}, nil
+}
+
 func (client testClient) DoTransition(issueKey string, transitionID string) error {
 	return nil
 }
@@ -100,6 +115,15 @@ func (client testClient) GetCreateMetaInfo(api plugin.API, options *jira.GetQuer
 									},
 								},
 							},
+							"summary": tcontainer.MarshalMap{
+								"schema": tcontainer.MarshalMap{"type": "string"},
+							},
+							"duedate": tcontainer.MarshalMap{
+								"schema": tcontainer.MarshalMap{"type": "date"},
+							},
+							"customfield_10002": tcontainer.MarshalMap{
+								"schema": tcontainer.MarshalMap{"type": "number"},
+							},
 						},
 					},
 				},
@@ -323,6 +347,7 @@ func TestRouteAttachCommentToIssue(t *testing.T) {
 
 	api.On("GetPost", "1").Return(&model.Post{UserId: "1"}, (*model.AppError)(nil))
 	api.On("GetUser", "1").Return(&model.User{Username: "username"}, (*model.AppError)(nil))
+	api.On("GetUserByUsername", "username").Return(nil, &model.AppError{Id: "1"})
 	api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{}, (*model.AppError)(nil))
 
 	api.On("PublishWebSocketEvent", "update_defaults", mock.AnythingOfType("map[string]interface {}"), mock.AnythingOfType("*model.WebsocketBroadcast"))
@@ -0,0 +1,874 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/pkg/errors"
+)
+
+// A JQL expression of a subscription is evaluated locally, against the issue
+// of the webhook event. Only the subset of JQL that can be answered from the
+// issue alone is supported: AND/OR/NOT, parentheses, =, !=, <, <=, >, >=,
+// IN, NOT IN, IS [NOT] EMPTY, ~ and !~. History operators (WAS, CHANGED),
+// functions and ORDER BY are rejected.
+//
+// Unlike Jira, != and NOT IN match issues where the field is empty, so that
+// `labels != noise` does not drop the issues without labels.
+
+type jqlFieldKind int
+
+const (
+	// jqlValueField has a set of values, each known by its ID, name or key.
+	jqlValueField jqlFieldKind = iota
+	// jqlTextField supports only the ~ and !~ operators.
+	jqlTextField
+	// jqlDateField is compared with absolute or relative dates.
+	jqlDateField
+	// jqlCustomField is a custom field of an unknown type, it supports all the operators.
+	jqlCustomField
+)
+
+type jqlField struct {
+	name   string
+	kind   jqlFieldKind
+	values func(t *jqlTarget) [][]string
+}
+
+// jqlTarget is what a JQL expression is evaluated against.
+type jqlTarget struct {
+	issue   *jira.Issue
+	comment string
+	now     time.Time
+}
+
+type jqlNode interface {
+	eval(t *jqlTarget) bool
+}
+
+type jqlAnd struct{ left, right jqlNode }
+type jqlOr struct{ left, right jqlNode }
+type jqlNot struct{ node jqlNode }
+
+func (n *jqlAnd) eval(t *jqlTarget) bool { return n.left.eval(t) && n.right.eval(t) }
+func (n *jqlOr) eval(t *jqlTarget) bool  { return n.left.eval(t) || n.right.eval(t) }
+func (n *jqlNot) eval(t *jqlTarget) bool { return !n.node.eval(t) }
+
+type jqlClause struct {
+	field    *jqlField
+	operator string
+	operands []string
+}
+
+const (
+	jqlOpEquals      = "="
+	jqlOpNotEquals   = "!="
+	jqlOpLess        = "<"
+	jqlOpLessOrEq    = "<="
+	jqlOpGreater     = ">"
+	jqlOpGreaterOrEq = ">="
+	jqlOpContains    = "~"
+	jqlOpNotContains = "!~"
+	jqlOpIn          = "in"
+	jqlOpNotIn       = "not in"
+	jqlOpIsEmpty     = "is empty"
+	jqlOpIsNotEmpty  = "is not empty"
+)
+
+// JQLExpression is a parsed JQL expression that can be matched against issues.
+type JQLExpression struct {
+	root jqlNode
+}
+
+// ParseJQL parses a JQL expression, and returns an error describing the first
+// clause that cannot be evaluated locally.
+func ParseJQL(jql string) (*JQLExpression, error) {
+	tokens, err := tokenizeJQL(jql)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("JQL expression is empty")
+	}
+
+	parser := &jqlParser{tokens: tokens}
+	root, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !parser.done() {
+		tok := parser.next()
+		if tok.is("order") {
+			return nil, errors.New("ORDER BY is not supported in subscription JQL")
+		}
+		return nil, errors.Errorf("unexpected %q in JQL", tok.text)
+	}
+
+	return &JQLExpression{root: root}, nil
+}
+
+// parsedSubscriptionJQL is the parsed JQL expression of a subscription.
+type parsedSubscriptionJQL struct {
+	jql  string
+	expr *JQLExpression
+}
+
+// subscriptionJQL returns the parsed JQL expression of a subscription, from
+// the cache if it did not change. The expressions of the subscriptions that
+// are not saved yet, as the ones previewed, are not cached.
+func (p *Plugin) subscriptionJQL(sub ChannelSubscription) (*JQLExpression, error) {
+	if sub.ID == "" {
+		return ParseJQL(sub.Filters.JQL)
+	}
+	if cached, ok := p.subscriptionJQLs.Load(sub.ID); ok {
+		if parsed := cached.(*parsedSubscriptionJQL); parsed.jql == sub.Filters.JQL {
+			return parsed.expr, nil
+		}
+	}
+
+	expr, err := ParseJQL(sub.Filters.JQL)
+	if err != nil {
+		return nil, err
+	}
+	p.subscriptionJQLs.Store(sub.ID, &parsedSubscriptionJQL{jql: sub.Filters.JQL, expr: expr})
+	return expr, nil
+}
+
+// Projects returns the projects the expression restricts the issues to, as
+// written in the expression. It returns false if the expression may match the
+// issues of any project.
+func (e *JQLExpression) Projects() (StringSet, bool) {
+	return jqlProjects(e.root)
+}
+
+func jqlProjects(node jqlNode) (StringSet, bool) {
+	switch n := node.(type) {
+	case *jqlAnd:
+		left, leftOK := jqlProjects(n.left)
+		right, rightOK := jqlProjects(n.right)
+		switch {
+		case leftOK && rightOK:
+			return left.Intersection(right), true
+		case leftOK:
+			return left, true
+		case rightOK:
+			return right, true
+		}
+	case *jqlOr:
+		left, leftOK := jqlProjects(n.left)
+		right, rightOK := jqlProjects(n.right)
+		if leftOK && rightOK {
+			return left.Union(right), true
+		}
+	case *jqlClause:
+		if strings.EqualFold(n.field.name, "project") && (n.operator == jqlOpEquals || n.operator == jqlOpIn) {
+			return NewStringSet(n.operands...), true
+		}
+	}
+	return nil, false
+}
+
+// Matches returns true if the issue, and the comment of the event if any,
+// satisfies the expression.
+func (e *JQLExpression) Matches(issue *jira.Issue, comment string) bool {
+	return e.root.eval(&jqlTarget{
+		issue:   issue,
+		comment: comment,
+		now:     time.Now(),
+	})
+}
+
+type jqlToken struct {
+	text   string
+	quoted bool
+}
+
+// is compares an unquoted token with a keyword or a symbol, ignoring case.
+func (t jqlToken) is(keyword string) bool {
+	return !t.quoted && strings.EqualFold(t.text, keyword)
+}
+
+const jqlSpecialChars = "=!<>~(),\"'"
+
+func tokenizeJQL(jql string) ([]jqlToken, error) {
+	var tokens []jqlToken
+	runes := []rune(jql)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			i++
+
+		case r == '"' || r == '\'':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(runes) && runes[j] != r; j++ {
+				if runes[j] == '\\' && j+1 < len(runes) {
+					j++
+				}
+				b.WriteRune(runes[j])
+			}
+			if j == len(runes) {
+				return nil, errors.New("unterminated quoted string in JQL")
+			}
+			tokens = append(tokens, jqlToken{text: b.String(), quoted: true})
+			i = j + 1
+
+		case r == '(' || r == ')' || r == ',' || r == '=' || r == '~':
+			tokens = append(tokens, jqlToken{text: string(r)})
+			i++
+
+		case r == '!' || r == '<' || r == '>':
+			if i+1 < len(runes) && (runes[i+1] == '=' || (r == '!' && runes[i+1] == '~')) {
+				tokens = append(tokens, jqlToken{text: string(runes[i : i+2])})
+				i += 2
+				continue
+			}
+			tokens = append(tokens, jqlToken{text: string(r)})
+			i++
+
+		case r == '&' || r == '|':
+			if i+1 < len(runes) && runes[i+1] == r {
+				tokens = append(tokens, jqlToken{text: string(runes[i : i+2])})
+				i += 2
+				continue
+			}
+			return nil, errors.Errorf("unexpected %q in JQL", string(r))
+
+		default:
+			j := i
+			for ; j < len(runes); j++ {
+				c := runes[j]
+				if c == ' ' || c == '\t' || c == '\n' || c == '\r' || strings.ContainsRune(jqlSpecialChars, c) {
+					break
+				}
+				if (c == '&' || c == '|') && j+1 < len(runes) && runes[j+1] == c {
+					break
+				}
+			}
+			tokens = append(tokens, jqlToken{text: string(runes[i:j])})
+			i = j
+		}
+	}
+
+	return tokens, nil
+}
+
+type jqlParser struct {
+	tokens []jqlToken
+	pos    int
+}
+
+func (p *jqlParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *jqlParser) peek() jqlToken {
+	if p.done() {
+		return jqlToken{}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *jqlParser) next() jqlToken {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+func (p *jqlParser) parseOr() (jqlNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for !p.done() && (p.peek().is("or") || p.peek().is("||")) {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &jqlOr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *jqlParser) parseAnd() (jqlNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for !p.done() && (p.peek().is("and") || p.peek().is("&&")) {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &jqlAnd{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *jqlParser) parseNot() (jqlNode, error) {
+	if p.peek().is("not") || p.peek().is("!") {
+		p.next()
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &jqlNot{node: node}, nil
+	}
+
+	if p.peek().is("(") {
+		p.next()
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.next().is(")") {
+			return nil, errors.New("missing closing parenthesis in JQL")
+		}
+		return node, nil
+	}
+
+	return p.parseClause()
+}
+
+func (p *jqlParser) parseClause() (jqlNode, error) {
+	if p.done() {
+		return nil, errors.New("unexpected end of JQL, expected a field name")
+	}
+	fieldName := p.next()
+	field, err := lookupJQLField(fieldName.text)
+	if err != nil {
+		return nil, err
+	}
+
+	operator, err := p.parseOperator(field)
+	if err != nil {
+		return nil, err
+	}
+
+	clause := &jqlClause{
+		field:    field,
+		operator: operator,
+	}
+
+	switch operator {
+	case jqlOpIsEmpty, jqlOpIsNotEmpty:
+		return clause, nil
+
+	case jqlOpIn, jqlOpNotIn:
+		if !p.next().is("(") {
+			return nil, errors.Errorf("expected a list of values after %s %s", field.name, strings.ToUpper(operator))
+		}
+		for {
+			value, err := p.parseValue(field, operator)
+			if err != nil {
+				return nil, err
+			}
+			clause.operands = append(clause.operands, value)
+
+			tok := p.next()
+			if tok.is(")") {
+				break
+			}
+			if !tok.is(",") {
+				return nil, errors.Errorf("expected ',' or ')' in the list of values of %s", field.name)
+			}
+		}
+
+	default:
+		value, err := p.parseValue(field, operator)
+		if err != nil {
+			return nil, err
+		}
+		clause.operands = []string{value}
+	}
+
+	return clause, nil
+}
+
+func (p *jqlParser) parseOperator(field *jqlField) (string, error) {
+	if p.done() {
+		return "", errors.Errorf("missing operator after %s", field.name)
+	}
+
+	tok := p.next()
+	operator := strings.ToLower(tok.text)
+	switch {
+	case tok.quoted:
+		return "", errors.Errorf("expected an operator after %s, found %q", field.name, tok.text)
+
+	case operator == "is":
+		if p.peek().is("not") {
+			p.next()
+			operator = jqlOpIsNotEmpty
+		} else {
+			operator = jqlOpIsEmpty
+		}
+		value := p.next()
+		if !value.is("empty") && !value.is("null") {
+			return "", errors.Errorf("only EMPTY is supported after IS, found %q", value.text)
+		}
+		return operator, nil
+
+	case operator == "not":
+		if !p.next().is("in") {
+			return "", errors.Errorf("expected IN after %s NOT", field.name)
+		}
+		operator = jqlOpNotIn
+
+	case operator == "was", operator == "changed":
+		return "", errors.Errorf("the %s operator is not supported in subscription JQL", strings.ToUpper(operator))
+
+	case operator != jqlOpEquals && operator != jqlOpNotEquals && operator != jqlOpIn &&
+		operator != jqlOpContains && operator != jqlOpNotContains &&
+		operator != jqlOpLess && operator != jqlOpLessOrEq && operator != jqlOpGreater && operator != jqlOpGreaterOrEq:
+		return "", errors.Errorf("unknown operator %q after %s", tok.text, field.name)
+	}
+
+	switch field.kind {
+	case jqlTextField:
+		if operator != jqlOpContains && operator != jqlOpNotContains {
+			return "", errors.Errorf("the %s field only supports the ~ and !~ operators", field.name)
+		}
+	case jqlDateField:
+		switch operator {
+		case jqlOpEquals, jqlOpNotEquals, jqlOpLess, jqlOpLessOrEq, jqlOpGreater, jqlOpGreaterOrEq:
+		default:
+			return "", errors.Errorf("the %s operator is not supported for the %s field", strings.ToUpper(operator), field.name)
+		}
+	case jqlValueField:
+		switch operator {
+		case jqlOpEquals, jqlOpNotEquals, jqlOpIn, jqlOpNotIn:
+		default:
+			return "", errors.Errorf("the %s operator is not supported for the %s field", strings.ToUpper(operator), field.name)
+		}
+	}
+
+	return operator, nil
+}
+
+func (p *jqlParser) parseValue(field *jqlField, operator string) (string, error) {
+	if p.done() {
+		return "", errors.Errorf("missing value after %s %s", field.name, strings.ToUpper(operator))
+	}
+
+	tok := p.next()
+	if !tok.quoted {
+		if strings.ContainsAny(tok.text, jqlSpecialChars) || tok.is("&&") || tok.is("||") {
+			return "", errors.Errorf("expected a value after %s %s, found %q", field.name, strings.ToUpper(operator), tok.text)
+		}
+		if tok.is("empty") || tok.is("null") {
+			return "", errors.Errorf("use IS EMPTY or IS NOT EMPTY to match an empty %s", field.name)
+		}
+		if p.peek().is("(") {
+			return "", errors.Errorf("JQL functions such as %s() are not supported in subscriptions", tok.text)
+		}
+	}
+
+	switch operator {
+	case jqlOpLess, jqlOpLessOrEq, jqlOpGreater, jqlOpGreaterOrEq:
+		if field.kind == jqlDateField {
+			if _, ok := parseJQLDate(tok.text, time.Now()); !ok {
+				return "", errors.Errorf("invalid date %q for the %s field", tok.text, field.name)
+			}
+			break
+		}
+		if _, err := strconv.ParseFloat(tok.text, 64); err != nil {
+			return "", errors.Errorf("invalid number %q for the %s field", tok.text, field.name)
+		}
+	default:
+		if field.kind == jqlDateField {
+			if _, ok := parseJQLDate(tok.text, time.Now()); !ok {
+				return "", errors.Errorf("invalid date %q for the %s field", tok.text, field.name)
+			}
+		}
+	}
+
+	return tok.text, nil
+}
+
+func (c *jqlClause) eval(t *jqlTarget) bool {
+	values := c.field.values(t)
+
+	switch c.operator {
+	case jqlOpIsEmpty:
+		return len(values) == 0
+	case jqlOpIsNotEmpty:
+		return len(values) > 0
+	case jqlOpEquals, jqlOpIn:
+		if c.field.kind == jqlDateField {
+			return compareJQLDates(values, c.operands[0], t.now, func(cmp int) bool { return cmp == 0 })
+		}
+		return jqlValuesContainAny(values, c.operands)
+	case jqlOpNotEquals, jqlOpNotIn:
+		if c.field.kind == jqlDateField {
+			return !compareJQLDates(values, c.operands[0], t.now, func(cmp int) bool { return cmp == 0 })
+		}
+		return !jqlValuesContainAny(values, c.operands)
+	case jqlOpContains:
+		return jqlTextContains(values, c.operands[0])
+	case jqlOpNotContains:
+		return !jqlTextContains(values, c.operands[0])
+	}
+
+	var test func(cmp int) bool
+	switch c.operator {
+	case jqlOpLess:
+		test = func(cmp int) bool { return cmp < 0 }
+	case jqlOpLessOrEq:
+		test = func(cmp int) bool { return cmp <= 0 }
+	case jqlOpGreater:
+		test = func(cmp int) bool { return cmp > 0 }
+	case jqlOpGreaterOrEq:
+		test = func(cmp int) bool { return cmp >= 0 }
+	default:
+		return false
+	}
+	if c.field.kind == jqlDateField {
+		return compareJQLDates(values, c.operands[0], t.now, test)
+	}
+	return compareJQLNumbers(values, c.operands[0], test)
+}
+
+func jqlValuesContainAny(values [][]string, operands []string) bool {
+	for _, aliases := range values {
+		for _, alias := range aliases {
+			for _, operand := range operands {
+				if strings.EqualFold(alias, operand) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// jqlTextContains returns true if every word of the search term is found in
+// the text. Wildcards are ignored.
+func jqlTextContains(values [][]string, term string) bool {
+	var text strings.Builder
+	for _, aliases := range values {
+		for _, alias := range aliases {
+			text.WriteString(strings.ToLower(alias))
+			text.WriteString(" ")
+		}
+	}
+
+	words := strings.Fields(strings.ToLower(strings.NewReplacer("*", " ", "?", " ").Replace(term)))
+	if len(words) == 0 {
+		return false
+	}
+	for _, word := range words {
+		if !strings.Contains(text.String(), word) {
+			return false
+		}
+	}
+	return true
+}
+
+func compareJQLNumbers(values [][]string, operand string, test func(cmp int) bool) bool {
+	expected, err := strconv.ParseFloat(operand, 64)
+	if err != nil {
+		return false
+	}
+	for _, aliases := range values {
+		for _, alias := range aliases {
+			actual, err := strconv.ParseFloat(alias, 64)
+			if err != nil {
+				continue
+			}
+			switch {
+			case actual < expected:
+				if test(-1) {
+					return true
+				}
+			case actual > expected:
+				if test(1) {
+					return true
+				}
+			default:
+				if test(0) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func compareJQLDates(values [][]string, operand string, now time.Time, test func(cmp int) bool) bool {
+	expected, ok := parseJQLDate(operand, now)
+	if !ok {
+		return false
+	}
+	for _, aliases := range values {
+		if len(aliases) == 0 {
+			continue
+		}
+		actual, err := time.Parse(time.RFC3339, aliases[0])
+		if err != nil {
+			continue
+		}
+		if test(actual.Compare(expected)) {
+			return true
+		}
+	}
+	return false
+}
+
+var jqlRelativeDateRegex = regexp.MustCompile(`^([-+]?)(\d+)([wdhm])$`)
+
+var jqlDateLayouts = []string{
+	"2006-01-02 15:04",
+	"2006/01/02 15:04",
+	"2006-01-02",
+	"2006/01/02",
+}
+
+// parseJQLDate parses the absolute (2006-01-02 [15:04]) and relative (-1d, 2w)
+// date formats of JQL.
+func parseJQLDate(value string, now time.Time) (time.Time, bool) {
+	if match := jqlRelativeDateRegex.FindStringSubmatch(strings.ToLower(value)); match != nil {
+		n, _ := strconv.Atoi(match[2])
+		unit := map[string]time.Duration{
+			"w": 7 * 24 * time.Hour,
+			"d": 24 * time.Hour,
+			"h": time.Hour,
+			"m": time.Minute,
+		}[match[3]]
+		d := time.Duration(n) * unit
+		if match[1] == "-" {
+			d = -d
+		}
+		return now.Add(d), true
+	}
+
+	for _, layout := range jqlDateLayouts {
+		t, err := time.ParseInLocation(layout, value, now.Location())
+		if err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+var jqlCustomFieldRegex = regexp.MustCompile(`^cf\[(\d+)\]$`)
+
+func lookupJQLField(name string) (*jqlField, error) {
+	key := strings.ToLower(name)
+	if match := jqlCustomFieldRegex.FindStringSubmatch(key); match != nil {
+		key = "customfield_" + match[1]
+	}
+
+	if strings.HasPrefix(key, "customfield_") {
+		return &jqlField{
+			name: name,
+			kind: jqlCustomField,
+			values: func(t *jqlTarget) [][]string {
+				return jqlUnknownFieldValues(t.issue, key)
+			},
+		}, nil
+	}
+
+	field, ok := jqlFields[key]
+	if !ok {
+		return nil, errors.Errorf("the %q field is not supported in subscription JQL", name)
+	}
+	return &jqlField{
+		name:   name,
+		kind:   field.kind,
+		values: field.values,
+	}, nil
+}
+
+var jqlFields = map[string]jqlField{}
+
+func init() {
+	add := func(kind jqlFieldKind, values func(t *jqlTarget) [][]string, names ...string) {
+		for _, name := range names {
+			jqlFields[name] = jqlField{name: name, kind: kind, values: values}
+		}
+	}
+
+	add(jqlValueField, func(t *jqlTarget) [][]string {
+		project := t.issue.Fields.Project
+		return [][]string{{project.Key, project.ID, project.Name}}
+	}, "project")
+	add(jqlValueField, func(t *jqlTarget) [][]string {
+		return [][]string{{t.issue.Key, t.issue.ID}}
+	}, "key", "issuekey", "issue", "id")
+	add(jqlValueField, func(t *jqlTarget) [][]string {
+		issueType := t.issue.Fields.Type
+		return [][]string{{issueType.ID, issueType.Name}}
+	}, "issuetype", "type")
+	add(jqlValueField, func(t *jqlTarget) [][]string {
+		if t.issue.Fields.Status == nil {
+			return nil
+		}
+		return [][]string{{t.issue.Fields.Status.ID, t.issue.Fields.Status.Name}}
+	}, statusField)
+	add(jqlValueField, func(t *jqlTarget) [][]string {
+		if t.issue.Fields.Status == nil || t.issue.Fields.Status.StatusCategory.Key == "" {
+			return nil
+		}
+		category := t.issue.Fields.Status.StatusCategory
+		return [][]string{{strconv.Itoa(category.ID), category.Key, category.Name}}
+	}, "statuscategory", "category")
+	add(jqlValueField, func(t *jqlTarget) [][]string {
+		if t.issue.Fields.Priority == nil {
+			return nil
+		}
+		return [][]string{{t.issue.Fields.Priority.ID, t.issue.Fields.Priority.Name}}
+	}, priorityField)
+	add(jqlValueField, func(t *jqlTarget) [][]string {
+		if t.issue.Fields.Resolution == nil {
+			return nil
+		}
+		return [][]string{{t.issue.Fields.Resolution.ID, t.issue.Fields.Resolution.Name}}
+	}, resolutionField)
+	add(jqlValueField, func(t *jqlTarget) [][]string {
+		var values [][]string
+		for _, label := range t.issue.Fields.Labels {
+			values = append(values, []string{label})
+		}
+		return values
+	}, labelsField, "label")
+	add(jqlValueField, func(t *jqlTarget) [][]string {
+		var values [][]string
+		for _, c := range t.issue.Fields.Components {
+			values = append(values, []string{c.ID, c.Name})
+		}
+		return values
+	}, "component", "components")
+	add(jqlValueField, func(t *jqlTarget) [][]string {
+		var values [][]string
+		for _, v := range t.issue.Fields.FixVersions {
+			values = append(values, []string{v.ID, v.Name})
+		}
+		return values
+	}, "fixversion", "fixversions")
+	add(jqlValueField, func(t *jqlTarget) [][]string {
+		var values [][]string
+		for _, v := range t.issue.Fields.AffectsVersions {
+			values = append(values, []string{v.ID, v.Name})
+		}
+		return values
+	}, "affectedversion", "versions")
+	add(jqlValueField, func(t *jqlTarget) [][]string {
+		return jqlUserValues(t.issue.Fields.Assignee)
+	}, "assignee")
+	add(jqlValueField, func(t *jqlTarget) [][]string {
+		return jqlUserValues(t.issue.Fields.Reporter)
+	}, reporterField)
+	add(jqlValueField, func(t *jqlTarget) [][]string {
+		return jqlUserValues(t.issue.Fields.Creator)
+	}, "creator")
+	add(jqlValueField, func(t *jqlTarget) [][]string {
+		return jqlUnknownFieldValues(t.issue, securityLevelField)
+	}, securityLevelField, "level")
+
+	add(jqlTextField, func(t *jqlTarget) [][]string {
+		return jqlTextValues(t.issue.Fields.Summary)
+	}, "summary")
+	add(jqlTextField, func(t *jqlTarget) [][]string {
+		return jqlTextValues(t.issue.Fields.Description)
+	}, descriptionField)
+	add(jqlTextField, func(t *jqlTarget) [][]string {
+		return jqlTextValues(t.issue.Fields.Environment)
+	}, "environment")
+	add(jqlTextField, func(t *jqlTarget) [][]string {
+		return jqlTextValues(t.comment)
+	}, "comment")
+	add(jqlTextField, func(t *jqlTarget) [][]string {
+		return jqlTextValues(t.issue.Fields.Summary, t.issue.Fields.Description, t.issue.Fields.Environment, t.comment)
+	}, "text")
+
+	add(jqlDateField, func(t *jqlTarget) [][]string {
+		return jqlDateValues(time.Time(t.issue.Fields.Created))
+	}, "created", "createddate")
+	add(jqlDateField, func(t *jqlTarget) [][]string {
+		return jqlDateValues(time.Time(t.issue.Fields.Updated))
+	}, "updated", "updateddate")
+	add(jqlDateField, func(t *jqlTarget) [][]string {
+		return jqlDateValues(time.Time(t.issue.Fields.Duedate))
+	}, "duedate", "due")
+	add(jqlDateField, func(t *jqlTarget) [][]string {
+		return jqlDateValues(time.Time(t.issue.Fields.Resolutiondate))
+	}, "resolutiondate", "resolved")
+}
+
+func jqlUserValues(user *jira.User) [][]string {
+	if user == nil {
+		return nil
+	}
+	return [][]string{{user.AccountID, user.Name, user.Key, user.DisplayName, user.EmailAddress}}
+}
+
+func jqlTextValues(texts ...string) [][]string {
+	var values [][]string
+	for _, text := range texts {
+		if text != "" {
+			values = append(values, []string{text})
+		}
+	}
+	return values
+}
+
+func jqlDateValues(t time.Time) [][]string {
+	if t.IsZero() {
+		return nil
+	}
+	return [][]string{{t.Format(time.RFC3339)}}
+}
+
+// jqlUnknownFieldValues returns the values of a custom field, an option
+// being known by its ID, value or name.
+func jqlUnknownFieldValues(issue *jira.Issue, key string) [][]string {
+	m, exists := issue.Fields.Unknowns.Value(key)
+	if !exists || m == nil {
+		return nil
+	}
+
+	var values [][]string
+	var add func(v interface{})
+	add = func(v interface{}) {
+		switch value := v.(type) {
+		case string:
+			if value != "" {
+				values = append(values, []string{value})
+			}
+		case float64:
+			values = append(values, []string{strconv.FormatFloat(value, 'f', -1, 64)})
+		case bool:
+			values = append(values, []string{strconv.FormatBool(value)})
+		case []interface{}:
+			for _, elem := range value {
+				add(elem)
+			}
+		case map[string]interface{}:
+			var aliases []string
+			for _, k := range []string{"id", "value", "name", "key", "accountId", "displayName"} {
+				if s, ok := value[k].(string); ok && s != "" {
+					aliases = append(aliases, s)
+				}
+			}
+			if len(aliases) > 0 {
+				values = append(values, aliases)
+			}
+		default:
+			values = append(values, []string{fmt.Sprint(value)})
+		}
+	}
+	add(m)
+
+	return values
+}
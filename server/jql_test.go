@@ -0,0 +1,155 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseJQL(t *testing.T) {
+	for jql, expectedErr := range map[string]string{
+		`project = OPS AND priority in (P1, P2) AND labels != noise`:                 "",
+		`(status = "In Progress" OR NOT assignee is EMPTY) && summary ~ "disk full"`: "",
+		`cf[10001] >= 3 AND customfield_10002 is not empty`:                          "",
+		`created > -1d AND duedate <= "2024/01/31"`:                                  "",
+		``:                               "JQL expression is empty",
+		`project = OPS ORDER BY created`: "ORDER BY is not supported in subscription JQL",
+		`status WAS "Done"`:              "the WAS operator is not supported in subscription JQL",
+		`assignee = currentUser()`:       "JQL functions such as currentUser() are not supported in subscriptions",
+		`sprint = 12`:                    `the "sprint" field is not supported in subscription JQL`,
+		`summary = outage`:               "the summary field only supports the ~ and !~ operators",
+		`priority > P2`:                  "the > operator is not supported for the priority field",
+		`labels = EMPTY`:                 "use IS EMPTY or IS NOT EMPTY to match an empty labels",
+		`created > yesterday`:            `invalid date "yesterday" for the created field`,
+		`project in (OPS, TES`:           "expected ',' or ')' in the list of values of project",
+		`(project = OPS`:                 "missing closing parenthesis in JQL",
+		`project = "OPS`:                 "unterminated quoted string in JQL",
+		`project = OPS AND`:              "unexpected end of JQL, expected a field name",
+		`project OPS`:                    `unknown operator "OPS" after project`,
+		`project = OPS labels = noise`:   `unexpected "labels" in JQL`,
+		`cf[10001] > high`:               `invalid number "high" for the cf[10001] field`,
+		`status is not "Done"`:           `only EMPTY is supported after IS, found "Done"`,
+		`project = OPS & labels = noise`: `unexpected "&" in JQL`,
+		`project = OPS AND (labels = noise OR labels = spam)`: "",
+	} {
+		t.Run(jql, func(t *testing.T) {
+			_, err := ParseJQL(jql)
+			if expectedErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, expectedErr)
+			}
+		})
+	}
+}
+
+func TestJQLMatches(t *testing.T) {
+	data, err := getJiraTestData("webhook-issue-created.json")
+	require.NoError(t, err)
+	wh, err := ParseWebhook(data)
+	require.NoError(t, err)
+	issue := &wh.(*webhook).JiraWebhook.Issue
+
+	for jql, expected := range map[string]bool{
+		`project = TES`:                                        true,
+		`project = tes`:                                        true,
+		`project = test1 OR project = 10000`:                   true,
+		`project = OPS`:                                        false,
+		`project != OPS`:                                       true,
+		`key = TES-41`:                                         true,
+		`issuetype = Story AND status = "To Do"`:               true,
+		`priority in (High, Highest)`:                          true,
+		`priority in (2)`:                                      true,
+		`priority not in (High, Highest)`:                      false,
+		`labels = test-label`:                                  true,
+		`labels != noise`:                                      true,
+		`labels in (noise, spam)`:                              false,
+		`labels is EMPTY`:                                      false,
+		`assignee is EMPTY`:                                    true,
+		`assignee is not EMPTY`:                                false,
+		`resolution is EMPTY`:                                  true,
+		`summary ~ "unit test"`:                                true,
+		`summary ~ "unit outage"`:                              false,
+		`summary !~ outage`:                                    true,
+		`text ~ "summ*"`:                                       true,
+		`created >= "2019-02-15" AND created < 2019/02/17`:     true,
+		`created > -1d`:                                        false,
+		`NOT project = TES`:                                    false,
+		`project = OPS OR labels = test-label`:                 true,
+		`project = TES AND (labels = noise OR priority = Low)`: false,
+		`project = TES AND NOT (labels = noise OR priority = Low)`: true,
+	} {
+		t.Run(jql, func(t *testing.T) {
+			expr, err := ParseJQL(jql)
+			require.NoError(t, err)
+			assert.Equal(t, expected, expr.Matches(issue, ""))
+		})
+	}
+
+	t.Run("comment", func(t *testing.T) {
+		expr, err := ParseJQL(`comment ~ deploy`)
+		require.NoError(t, err)
+		assert.True(t, expr.Matches(issue, "Ready to deploy"))
+		assert.False(t, expr.Matches(issue, ""))
+	})
+}
+
+func TestJQLProjects(t *testing.T) {
+	for jql, expected := range map[string][]string{
+		"project = OPS": {"OPS"},
+		"project in (OPS, DEV) AND labels = noise":           {"DEV", "OPS"},
+		"labels = noise AND project = OPS":                   {"OPS"},
+		"project in (OPS, DEV) AND project = DEV":            {"DEV"},
+		"project = OPS OR (project = DEV AND priority = P1)": {"DEV", "OPS"},
+		"project = OPS OR priority = P1":                     nil,
+		"project != OPS":                                     nil,
+		"NOT project = OPS":                                  nil,
+		"labels = noise":                                     nil,
+	} {
+		expr, err := ParseJQL(jql)
+		require.NoError(t, err, jql)
+		projects, ok := expr.Projects()
+		if expected == nil {
+			assert.False(t, ok, jql)
+			continue
+		}
+		require.True(t, ok, jql)
+		elems := projects.Elems()
+		sort.Strings(elems)
+		assert.Equal(t, expected, elems, jql)
+	}
+}
+
+func TestSubscriptionJQL(t *testing.T) {
+	p := &Plugin{}
+	sub := ChannelSubscription{ID: "sub1", Filters: SubscriptionFilters{JQL: "project = TES"}}
+
+	expr, err := p.subscriptionJQL(sub)
+	require.NoError(t, err)
+	cached, err := p.subscriptionJQL(sub)
+	require.NoError(t, err)
+	assert.Same(t, expr, cached)
+
+	// The expression is replaced when it changes
+	sub.Filters.JQL = "project = OPS"
+	changed, err := p.subscriptionJQL(sub)
+	require.NoError(t, err)
+	assert.NotSame(t, expr, changed)
+	projects, _ := changed.Projects()
+	assert.Equal(t, NewStringSet("OPS"), projects)
+
+	// The expressions of unsaved subscriptions are not cached
+	_, err = p.subscriptionJQL(ChannelSubscription{Filters: SubscriptionFilters{JQL: "labels = noise"}})
+	require.NoError(t, err)
+	_, ok := p.subscriptionJQLs.Load("")
+	assert.False(t, ok)
+
+	p.updateCachedSubscription(testInstance1.InstanceID, "sub1", nil)
+	_, ok = p.subscriptionJQLs.Load("sub1")
+	assert.False(t, ok, "the expression of a removed subscription is forgotten")
+}
@@ -39,6 +39,8 @@ type Store interface {
 	UserStore
 	SecretsStore
 	OTSStore
+	WebhookQueueStore
+	DigestStore
 }
 
 type SecretsStore interface {
@@ -4,11 +4,17 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"testing"
 
 	jira "github.com/andygrunwald/go-jira"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest/mock"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
 	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
 
 	"github.com/mattermost/mattermost-plugin-jira/server/utils/types"
 )
@@ -121,3 +127,28 @@ func (store mockInstanceStore) StoreInstance(instance Instance) error {
 func (store mockInstanceStore) StoreInstances(*Instances) error {
 	return nil
 }
+
+// mockSubscriptionStore mocks a KV store with the subscriptions of
+// testInstance1 in the single document they are migrated from.
+func mockSubscriptionStore(t *testing.T, api *plugintest.API, subs *Subscriptions) testKVStore {
+	store := testKVStore{}
+	if subs != nil {
+		data, err := json.Marshal(subs)
+		require.NoError(t, err)
+		store[testSubKey] = data
+	}
+	api.On("PublishPluginClusterEvent", mock.Anything, mock.Anything).Return(nil).Maybe()
+	api.On("LogInfo", mock.AnythingOfType("string")).Return(nil).Maybe()
+	return makeTestKVStore(api, store)
+}
+
+// loadTestSubscriptions loads the subscriptions of testInstance1 from the KV
+// store.
+func loadTestSubscriptions(t *testing.T, api *plugintest.API) *Subscriptions {
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.client = pluginapi.NewClient(api, p.Driver)
+	subs, err := p.loadSubscriptions(testInstance1.InstanceID)
+	require.NoError(t, err)
+	return subs
+}
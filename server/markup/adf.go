@@ -0,0 +1,413 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package markup
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ADFNode is a node of an Atlassian Document Format document, the rich text
+// format of the v3 REST API of Jira Cloud. The root node of a document has
+// the "doc" type, and a version.
+type ADFNode struct {
+	Type    string                 `json:"type"`
+	Version int                    `json:"version,omitempty"`
+	Text    string                 `json:"text,omitempty"`
+	Attrs   map[string]interface{} `json:"attrs,omitempty"`
+	Content []*ADFNode             `json:"content,omitempty"`
+	Marks   []*ADFMark             `json:"marks,omitempty"`
+}
+
+// ADFMark is a text formatting of an ADF text node, e.g. strong or link.
+type ADFMark struct {
+	Type  string                 `json:"type"`
+	Attrs map[string]interface{} `json:"attrs,omitempty"`
+}
+
+var adfPanelIcons = map[string]string{
+	"info":    ":information_source:",
+	"note":    ":memo:",
+	"warning": ":warning:",
+	"success": ":white_check_mark:",
+	"error":   ":no_entry:",
+}
+
+// ParseADF parses an ADF document. It returns nil if the JSON value is not
+// an ADF document, e.g. if it is null or a string.
+func ParseADF(data []byte) *ADFNode {
+	doc := &ADFNode{}
+	if err := json.Unmarshal(data, doc); err != nil || doc.Type != "doc" {
+		return nil
+	}
+	return doc
+}
+
+// ADFToMarkdown renders an ADF document as Markdown.
+//
+// User mentions are rendered as [~accountid:id], like in Jira wiki markup,
+// so that they can be replaced with the matching Mattermost users.
+func ADFToMarkdown(doc *ADFNode) string {
+	if doc == nil {
+		return ""
+	}
+	return strings.TrimSpace(renderADFBlocks(doc.Content, "\n\n"))
+}
+
+// ADFToText returns the text of an ADF document, without formatting. Blocks
+// are separated by new lines.
+func ADFToText(doc *ADFNode) string {
+	if doc == nil {
+		return ""
+	}
+	var out strings.Builder
+	var walk func(n *ADFNode)
+	walk = func(n *ADFNode) {
+		switch n.Type {
+		case "text":
+			out.WriteString(n.Text)
+		case "hardBreak":
+			out.WriteString("\n")
+		case "mention", "emoji", "status":
+			out.WriteString(adfAttr(n, "text"))
+		case "inlineCard":
+			out.WriteString(adfAttr(n, "url"))
+		}
+		for _, child := range n.Content {
+			walk(child)
+		}
+		if isADFBlock(n) && out.Len() > 0 && !strings.HasSuffix(out.String(), "\n") {
+			out.WriteString("\n")
+		}
+	}
+	walk(doc)
+	return strings.TrimSpace(out.String())
+}
+
+func isADFBlock(n *ADFNode) bool {
+	switch n.Type {
+	case "paragraph", "heading", "codeBlock", "listItem", "taskItem", "decisionItem", "tableCell", "tableHeader", "rule":
+		return true
+	}
+	return false
+}
+
+func adfAttr(n *ADFNode, name string) string {
+	switch v := n.Attrs[name].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+// adfInt returns a number attribute, decoded from JSON or set by the
+// Markdown parser.
+func adfInt(n *ADFNode, name string) int {
+	switch v := n.Attrs[name].(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
+
+func renderADFBlocks(nodes []*ADFNode, separator string) string {
+	blocks := []string{}
+	for _, n := range nodes {
+		if md := renderADFBlock(n); md != "" {
+			blocks = append(blocks, md)
+		}
+	}
+	return strings.Join(blocks, separator)
+}
+
+func renderADFBlock(n *ADFNode) string {
+	switch n.Type {
+	case "paragraph":
+		md := renderADFInline(n.Content)
+		if strings.HasPrefix(md, "#") || strings.HasPrefix(md, ">") {
+			md = `\` + md
+		}
+		return md
+	case "heading":
+		level := adfInt(n, "level")
+		if level < 1 || level > 6 {
+			level = 1
+		}
+		return strings.Repeat("#", level) + " " + renderADFInline(n.Content)
+	case "bulletList", "orderedList", "taskList", "decisionList":
+		return renderADFList(n)
+	case "codeBlock":
+		code := adfPlainText(n.Content)
+		fence := codeFence(code)
+		return fence + adfAttr(n, "language") + "\n" + code + "\n" + fence
+	case "blockquote":
+		return quoteLines(renderADFBlocks(n.Content, "\n\n"))
+	case "panel":
+		content := renderADFBlocks(n.Content, "\n\n")
+		if icon := adfPanelIcons[adfAttr(n, "panelType")]; icon != "" {
+			content = icon + " " + content
+		}
+		return quoteLines(content)
+	case "expand", "nestedExpand":
+		content := renderADFBlocks(n.Content, "\n\n")
+		if title := adfAttr(n, "title"); title != "" {
+			content = bold(escapeMarkdown(title, false)) + "\n" + content
+		}
+		return content
+	case "rule":
+		return "---"
+	case "table":
+		return renderADFTable(n)
+	case "mediaSingle", "mediaGroup":
+		media := []string{}
+		for _, child := range n.Content {
+			if md := renderADFMedia(child); md != "" {
+				media = append(media, md)
+			}
+		}
+		return strings.Join(media, "\n")
+	case "blockCard", "embedCard":
+		return adfLink(adfAttr(n, "url"))
+	}
+
+	// Unknown blocks are rendered as their content
+	if len(n.Content) > 0 {
+		if isADFInline(n.Content[0]) {
+			return renderADFInline(n.Content)
+		}
+		return renderADFBlocks(n.Content, "\n\n")
+	}
+	return escapeMarkdown(n.Text, false)
+}
+
+func isADFInline(n *ADFNode) bool {
+	switch n.Type {
+	case "text", "hardBreak", "mention", "emoji", "inlineCard", "status", "date", "mediaInline", "placeholder":
+		return true
+	}
+	return false
+}
+
+// renderADFList renders a list, with the content of its items indented
+// under the item marker.
+func renderADFList(n *ADFNode) string {
+	number := 1
+	if order := adfInt(n, "order"); order > 0 {
+		number = order
+	}
+
+	items := []string{}
+	for _, item := range n.Content {
+		var marker string
+		switch {
+		case n.Type == "orderedList":
+			marker = fmt.Sprintf("%d. ", number)
+			number++
+		case item.Type == "taskItem" && adfAttr(item, "state") == "DONE":
+			marker = "- [x] "
+		case item.Type == "taskItem":
+			marker = "- [ ] "
+		default:
+			marker = "* "
+		}
+
+		var content string
+		if len(item.Content) > 0 && isADFInline(item.Content[0]) {
+			content = renderADFInline(item.Content)
+		} else {
+			content = renderADFBlocks(item.Content, "\n")
+		}
+		indent := strings.Repeat(" ", len(marker))
+		items = append(items, marker+strings.ReplaceAll(content, "\n", "\n"+indent))
+	}
+	return strings.Join(items, "\n")
+}
+
+func renderADFTable(n *ADFNode) string {
+	rows := [][]string{}
+	headerRow := false
+	columns := 0
+	for i, row := range n.Content {
+		cells := []string{}
+		allHeaders := len(row.Content) > 0
+		for _, cell := range row.Content {
+			if cell.Type != "tableHeader" {
+				allHeaders = false
+			}
+			text := renderADFBlocks(cell.Content, " ")
+			text = strings.ReplaceAll(text, "\n", " ")
+			text = escapeTablePipes(text)
+			cells = append(cells, text)
+		}
+		if i == 0 && allHeaders {
+			headerRow = true
+		}
+		if len(cells) > columns {
+			columns = len(cells)
+		}
+		rows = append(rows, cells)
+	}
+
+	header := make([]string, columns)
+	if headerRow {
+		header = rows[0]
+		rows = rows[1:]
+	}
+	separator := make([]string, columns)
+	for i := range separator {
+		separator[i] = "---"
+	}
+	lines := []string{tableRow(header, columns), tableRow(separator, columns)}
+	for _, row := range rows {
+		lines = append(lines, tableRow(row, columns))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// escapeTablePipes escapes the pipes of a table cell, except the ones that
+// are already escaped.
+func escapeTablePipes(text string) string {
+	var out strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\\' && i+1 < len(text) {
+			out.WriteString(text[i : i+2])
+			i++
+			continue
+		}
+		if text[i] == '|' {
+			out.WriteString(`\|`)
+			continue
+		}
+		out.WriteByte(text[i])
+	}
+	return out.String()
+}
+
+func renderADFMedia(n *ADFNode) string {
+	alt := adfAttr(n, "alt")
+	if adfAttr(n, "type") == "external" {
+		url := adfAttr(n, "url")
+		if alt == "" {
+			alt = "image"
+		}
+		return "![" + escapeMarkdown(alt, false) + "](" + markdownURL(url) + ")"
+	}
+	if alt == "" {
+		alt = "attachment"
+	}
+	return ":paperclip: " + escapeMarkdown(alt, false)
+}
+
+func adfLink(url string) string {
+	if url == "" {
+		return ""
+	}
+	return "[" + escapeMarkdown(url, false) + "](" + markdownURL(url) + ")"
+}
+
+func adfPlainText(nodes []*ADFNode) string {
+	text := ""
+	for _, n := range nodes {
+		if n.Type == "hardBreak" {
+			text += "\n"
+		}
+		text += n.Text
+	}
+	return text
+}
+
+func renderADFInline(nodes []*ADFNode) string {
+	var out strings.Builder
+	for _, n := range nodes {
+		switch n.Type {
+		case "text":
+			out.WriteString(renderADFText(n))
+		case "hardBreak":
+			out.WriteString("\n")
+		case "mention":
+			if id := adfAttr(n, "id"); id != "" {
+				out.WriteString("[~accountid:" + id + "]")
+			} else {
+				out.WriteString(escapeMarkdown(adfAttr(n, "text"), false))
+			}
+		case "emoji":
+			out.WriteString(renderADFEmoji(n))
+		case "inlineCard":
+			out.WriteString(adfLink(adfAttr(n, "url")))
+		case "status":
+			out.WriteString(codeSpan(strings.ToUpper(adfAttr(n, "text")), false))
+		case "date":
+			ms, err := strconv.ParseInt(adfAttr(n, "timestamp"), 10, 64)
+			if err == nil {
+				out.WriteString(time.UnixMilli(ms).UTC().Format("2006-01-02"))
+			}
+		case "mediaInline":
+			out.WriteString(renderADFMedia(n))
+		default:
+			out.WriteString(renderADFInline(n.Content))
+		}
+	}
+	return out.String()
+}
+
+// renderADFEmoji returns the Mattermost emoji of an ADF emoji. The Atlassian
+// emojis that Mattermost does not know, such as :atlassian:, are rendered as
+// their text.
+func renderADFEmoji(n *ADFNode) string {
+	shortName := adfAttr(n, "shortName")
+	if id := adfAttr(n, "id"); shortName != "" && !strings.HasPrefix(id, "atlassian-") {
+		return shortName
+	}
+	if text := adfAttr(n, "text"); text != "" {
+		return text
+	}
+	return shortName
+}
+
+// renderADFText renders a text node with its marks. Markdown emphasis can
+// not start or end with a space, so the spaces are kept out of it.
+func renderADFText(n *ADFNode) string {
+	text := n.Text
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return text
+	}
+	leading := text[:strings.Index(text, trimmed)]
+	trailing := text[len(leading)+len(trimmed):]
+
+	md := ""
+	var link string
+	for _, mark := range n.Marks {
+		if mark.Type == "code" {
+			md = codeSpan(trimmed, false)
+		}
+		if mark.Type == "link" {
+			link, _ = mark.Attrs["href"].(string)
+		}
+	}
+	if md == "" {
+		md = escapeLiteral(trimmed)
+		for _, mark := range n.Marks {
+			switch mark.Type {
+			case "strong":
+				md = "**" + md + "**"
+			case "em":
+				md = "_" + md + "_"
+			case "strike":
+				md = "~~" + md + "~~"
+			}
+		}
+	}
+	if link != "" {
+		md = "[" + md + "](" + markdownURL(link) + ")"
+	}
+	return leading + md + trailing
+}
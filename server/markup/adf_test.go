@@ -0,0 +1,101 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package markup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestADFToMarkdownGolden(t *testing.T) {
+	testGolden(t, "testdata/*.json", ".md", func(in string) string {
+		doc := ParseADF([]byte(in))
+		require.NotNil(t, doc)
+		return ADFToMarkdown(doc)
+	})
+}
+
+func TestParseADF(t *testing.T) {
+	assert.Nil(t, ParseADF([]byte(`null`)))
+	assert.Nil(t, ParseADF([]byte(`"plain text"`)))
+	assert.Nil(t, ParseADF([]byte(`{"type": "paragraph"}`)))
+	assert.NotNil(t, ParseADF([]byte(`{"type": "doc", "version": 1, "content": []}`)))
+}
+
+func TestADFToMarkdown(t *testing.T) {
+	text := func(s string, marks ...string) *ADFNode {
+		n := &ADFNode{Type: "text", Text: s}
+		for _, m := range marks {
+			n.Marks = append(n.Marks, &ADFMark{Type: m})
+		}
+		return n
+	}
+	paragraph := func(content ...*ADFNode) *ADFNode {
+		return &ADFNode{Type: "paragraph", Content: content}
+	}
+
+	for name, tc := range map[string]struct {
+		content  []*ADFNode
+		expected string
+	}{
+		"paragraphs": {
+			content:  []*ADFNode{paragraph(text("one")), paragraph(text("two"))},
+			expected: "one\n\ntwo",
+		},
+		"marks": {
+			content:  []*ADFNode{paragraph(text("bold ", "strong"), text("and "), text("both", "strong", "em"))},
+			expected: "**bold** and _**both**_",
+		},
+		"literal text": {
+			content:  []*ADFNode{paragraph(text("a *b* snake_case [c]"))},
+			expected: `a \*b\* snake_case \[c\]`,
+		},
+		"code mark": {
+			content:  []*ADFNode{paragraph(text("a `b`", "code", "strong"))},
+			expected: "`` a `b` ``",
+		},
+		"ordered list start": {
+			content: []*ADFNode{{Type: "orderedList", Attrs: map[string]interface{}{"order": float64(3)}, Content: []*ADFNode{
+				{Type: "listItem", Content: []*ADFNode{paragraph(text("three"))}},
+				{Type: "listItem", Content: []*ADFNode{paragraph(text("four"))}},
+			}}},
+			expected: "3. three\n4. four",
+		},
+		"mention without id": {
+			content:  []*ADFNode{paragraph(&ADFNode{Type: "mention", Attrs: map[string]interface{}{"text": "@all"}})},
+			expected: "@all",
+		},
+		"unknown node": {
+			content:  []*ADFNode{{Type: "layoutSection", Content: []*ADFNode{{Type: "layoutColumn", Content: []*ADFNode{paragraph(text("column"))}}}}},
+			expected: "column",
+		},
+		"panel": {
+			content:  []*ADFNode{{Type: "panel", Attrs: map[string]interface{}{"panelType": "info"}, Content: []*ADFNode{paragraph(text("one")), paragraph(text("two"))}}},
+			expected: "> :information_source: one\n>\n> two",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, ADFToMarkdown(&ADFNode{Type: "doc", Version: 1, Content: tc.content}))
+		})
+	}
+}
+
+func TestADFToText(t *testing.T) {
+	doc := ParseADF([]byte(`{"type": "doc", "version": 1, "content": [
+		{"type": "paragraph", "content": [
+			{"type": "text", "text": "Hello ", "marks": [{"type": "strong"}]},
+			{"type": "mention", "attrs": {"id": "123", "text": "@Jane"}},
+			{"type": "hardBreak"},
+			{"type": "text", "text": "bye"}
+		]},
+		{"type": "bulletList", "content": [
+			{"type": "listItem", "content": [{"type": "paragraph", "content": [{"type": "text", "text": "one"}]}]},
+			{"type": "listItem", "content": [{"type": "paragraph", "content": [{"type": "text", "text": "two"}]}]}
+		]}
+	]}`))
+	require.NotNil(t, doc)
+	assert.Equal(t, "Hello @Jane\nbye\none\ntwo", ADFToText(doc))
+}
@@ -0,0 +1,732 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package markup
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// JiraUser is the Jira user of a Mattermost user mentioned in Markdown. Jira
+// Cloud identifies users by their account ID, Jira Server by their name.
+type JiraUser struct {
+	AccountID string
+	Name      string
+}
+
+// MentionFunc returns the Jira user of a Mattermost username, or nil if the
+// user is not connected to Jira. Unknown mentions are kept as text.
+type MentionFunc func(username string) *JiraUser
+
+var (
+	mdFenceRegex     = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})\\s*([^`\\s]*)")
+	mdHeadingRegex   = regexp.MustCompile(`^ {0,3}(#{1,6})(?:\s+(.*?))?(?:\s+#+)?\s*$`)
+	mdRuleRegex      = regexp.MustCompile(`^ {0,3}(?:(?:-\s*){3,}|(?:\*\s*){3,}|(?:_\s*){3,})$`)
+	mdSetextRegex    = regexp.MustCompile(`^ {0,3}(=+|-+)\s*$`)
+	mdQuoteRegex     = regexp.MustCompile(`^ {0,3}> ?`)
+	mdListItemRegex  = regexp.MustCompile(`^( *)([-*+]|\d{1,9}[.)])(?:( +)(.*))?$`)
+	mdTaskRegex      = regexp.MustCompile(`^\[([ xX])\]\s+`)
+	mdTableSepRegex  = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+	mdImageRegex     = regexp.MustCompile(`^!\[([^\]]*)\]\(<?([^\s)>]+)>?(?:\s+"[^"]*")?\)$`)
+	mdUsernameRegex  = regexp.MustCompile(`^@([a-zA-Z0-9][a-zA-Z0-9._-]*)`)
+	mdEmojiRegex     = regexp.MustCompile(`^:([a-z0-9_+-]+):`)
+	mdURLRegex       = regexp.MustCompile(`^(?:(?:https?|ftp)://|mailto:|www\.)[^\s<]+`)
+	mdAutolinkRegex  = regexp.MustCompile(`^<((?:https?|ftp)://[^\s>]+|mailto:[^\s>]+)>`)
+	mdPunctuationSet = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+)
+
+// MarkdownToADF converts a Mattermost message to an ADF document. The
+// mentions of connected users become Jira mentions.
+func MarkdownToADF(md string, mention MentionFunc) *ADFNode {
+	return parseMarkdown(md, func(username string) string {
+		if user := resolveMention(mention, username); user != nil {
+			return user.AccountID
+		}
+		return ""
+	})
+}
+
+func resolveMention(mention MentionFunc, username string) *JiraUser {
+	if mention == nil {
+		return nil
+	}
+	return mention(username)
+}
+
+// parseMarkdown parses Markdown as an ADF document. mentionID returns the
+// ID of the mention node of a username, or "" to keep the username as text.
+func parseMarkdown(md string, mentionID func(string) string) *ADFNode {
+	md = strings.ReplaceAll(md, "\r\n", "\n")
+	md = strings.ReplaceAll(md, "\t", "    ")
+	p := &markdownParser{mentionID: mentionID}
+	return &ADFNode{
+		Type:    "doc",
+		Version: 1,
+		Content: p.parseBlocks(strings.Split(md, "\n")),
+	}
+}
+
+type markdownParser struct {
+	mentionID func(string) string
+}
+
+func (p *markdownParser) parseBlocks(lines []string) []*ADFNode {
+	blocks := []*ADFNode{}
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case strings.TrimSpace(line) == "":
+			i++
+
+		case mdFenceRegex.MatchString(line):
+			var block *ADFNode
+			block, i = parseFencedCode(lines, i)
+			blocks = append(blocks, block)
+
+		case mdHeadingRegex.MatchString(line):
+			m := mdHeadingRegex.FindStringSubmatch(line)
+			blocks = append(blocks, p.heading(len(m[1]), m[2]))
+			i++
+
+		case mdRuleRegex.MatchString(line):
+			blocks = append(blocks, &ADFNode{Type: "rule"})
+			i++
+
+		case mdQuoteRegex.MatchString(line):
+			quoted := []string{}
+			for ; i < len(lines) && mdQuoteRegex.MatchString(lines[i]); i++ {
+				quoted = append(quoted, mdQuoteRegex.ReplaceAllString(lines[i], ""))
+			}
+			blocks = append(blocks, &ADFNode{Type: "blockquote", Content: p.parseBlocks(quoted)})
+
+		case isTableStart(lines, i):
+			var block *ADFNode
+			block, i = p.parseTable(lines, i)
+			blocks = append(blocks, block)
+
+		case mdListItemRegex.MatchString(line):
+			var block *ADFNode
+			block, i = p.parseList(lines, i)
+			blocks = append(blocks, block)
+
+		default:
+			var block *ADFNode
+			block, i = p.parseParagraph(lines, i)
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+func (p *markdownParser) heading(level int, text string) *ADFNode {
+	return &ADFNode{
+		Type:    "heading",
+		Attrs:   map[string]interface{}{"level": level},
+		Content: p.parseInline(strings.TrimSpace(text), nil),
+	}
+}
+
+func parseFencedCode(lines []string, i int) (*ADFNode, int) {
+	m := mdFenceRegex.FindStringSubmatch(lines[i])
+	fence := m[1]
+	indent := len(lines[i]) - len(strings.TrimLeft(lines[i], " "))
+
+	code := []string{}
+	for i++; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+			i++
+			break
+		}
+		line := lines[i]
+		for j := 0; j < indent && strings.HasPrefix(line, " "); j++ {
+			line = line[1:]
+		}
+		code = append(code, line)
+	}
+
+	block := &ADFNode{Type: "codeBlock"}
+	if m[2] != "" {
+		block.Attrs = map[string]interface{}{"language": m[2]}
+	}
+	if text := strings.Join(code, "\n"); text != "" {
+		block.Content = []*ADFNode{{Type: "text", Text: text}}
+	}
+	return block, i
+}
+
+// startsBlock returns true if a line interrupts a paragraph.
+func startsBlock(lines []string, i int) bool {
+	line := lines[i]
+	return strings.TrimSpace(line) == "" ||
+		mdFenceRegex.MatchString(line) ||
+		mdHeadingRegex.MatchString(line) ||
+		mdRuleRegex.MatchString(line) ||
+		mdQuoteRegex.MatchString(line) ||
+		mdListItemRegex.MatchString(line) ||
+		isTableStart(lines, i)
+}
+
+func (p *markdownParser) parseParagraph(lines []string, i int) (*ADFNode, int) {
+	text := []string{strings.TrimSpace(lines[i])}
+	for i++; i < len(lines); i++ {
+		if mdSetextRegex.MatchString(lines[i]) {
+			level := 2
+			if strings.TrimSpace(lines[i])[0] == '=' {
+				level = 1
+			}
+			return p.heading(level, strings.Join(text, " ")), i + 1
+		}
+		if startsBlock(lines, i) {
+			break
+		}
+		text = append(text, strings.TrimSpace(lines[i]))
+	}
+
+	joined := strings.Join(text, "\n")
+	if m := mdImageRegex.FindStringSubmatch(joined); m != nil {
+		return &ADFNode{
+			Type:  "mediaSingle",
+			Attrs: map[string]interface{}{"layout": "center"},
+			Content: []*ADFNode{{
+				Type:  "media",
+				Attrs: map[string]interface{}{"type": "external", "url": m[2], "alt": m[1]},
+			}},
+		}, i
+	}
+	return &ADFNode{Type: "paragraph", Content: p.parseInline(joined, nil)}, i
+}
+
+func isTableStart(lines []string, i int) bool {
+	return i+1 < len(lines) &&
+		strings.Contains(lines[i], "|") &&
+		strings.Contains(lines[i+1], "|") &&
+		mdTableSepRegex.MatchString(lines[i+1])
+}
+
+func (p *markdownParser) parseTable(lines []string, i int) (*ADFNode, int) {
+	header := splitTableRow(lines[i])
+	rows := []*ADFNode{p.tableRow(header, len(header), "tableHeader")}
+	for i += 2; i < len(lines) && strings.TrimSpace(lines[i]) != "" && strings.Contains(lines[i], "|"); i++ {
+		rows = append(rows, p.tableRow(splitTableRow(lines[i]), len(header), "tableCell"))
+	}
+	return &ADFNode{Type: "table", Content: rows}, i
+}
+
+func (p *markdownParser) tableRow(cells []string, columns int, cellType string) *ADFNode {
+	row := &ADFNode{Type: "tableRow"}
+	for i := 0; i < columns; i++ {
+		paragraph := &ADFNode{Type: "paragraph"}
+		if i < len(cells) {
+			paragraph.Content = p.parseInline(cells[i], nil)
+		}
+		row.Content = append(row.Content, &ADFNode{Type: cellType, Content: []*ADFNode{paragraph}})
+	}
+	return row
+}
+
+// splitTableRow splits a Markdown table row on the pipes that are not
+// escaped, nor in a code span.
+func splitTableRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+
+	cells := []string{}
+	var cell strings.Builder
+	inCode := false
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell.WriteString(`\|`)
+			i++
+			continue
+		case line[i] == '`':
+			inCode = !inCode
+		case line[i] == '|' && !inCode:
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+			continue
+		}
+		cell.WriteByte(line[i])
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+type mdListItem struct {
+	lines []string
+	task  string
+}
+
+// parseList parses the items of a list, and the lines indented under them.
+// A list ends at a blank line that is not followed by an indented line or
+// by another item of the same kind.
+func (p *markdownParser) parseList(lines []string, i int) (*ADFNode, int) {
+	m := mdListItemRegex.FindStringSubmatch(lines[i])
+	ordered := isOrderedMarker(m[2])
+
+	list := &ADFNode{Type: "bulletList"}
+	if ordered {
+		list.Type = "orderedList"
+		if start, err := strconv.Atoi(strings.TrimRight(m[2], ".)")); err == nil && start != 1 {
+			list.Attrs = map[string]interface{}{"order": start}
+		}
+	}
+
+	items := []*mdListItem{}
+	var item *mdListItem
+	contentIndent := 0
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if m := mdListItemRegex.FindStringSubmatch(line); m != nil && (item == nil || len(m[1]) < contentIndent) {
+			if isOrderedMarker(m[2]) != ordered {
+				break
+			}
+			item = &mdListItem{}
+			items = append(items, item)
+			contentIndent = len(m[1]) + len(m[2]) + len(m[3])
+			if m[3] == "" || len(m[3]) > 4 {
+				contentIndent = len(m[1]) + len(m[2]) + 1
+			}
+			text := m[4]
+			if len(m[3]) > 4 {
+				text = strings.Repeat(" ", len(m[3])-1) + text
+			}
+			if t := mdTaskRegex.FindStringSubmatch(text); t != nil && !ordered {
+				item.task = strings.ToUpper(t[1])
+				text = text[len(t[0]):]
+			}
+			item.lines = append(item.lines, text)
+			continue
+		}
+
+		if strings.TrimSpace(line) == "" {
+			// The list goes on if the next line is indented, or is an item
+			next := i + 1
+			if next >= len(lines) || strings.TrimSpace(lines[next]) == "" {
+				break
+			}
+			nextIndent := len(lines[next]) - len(strings.TrimLeft(lines[next], " "))
+			if nextIndent < contentIndent && !mdListItemRegex.MatchString(lines[next]) {
+				break
+			}
+			item.lines = append(item.lines, "")
+			continue
+		}
+
+		lineIndent := len(line) - len(strings.TrimLeft(line, " "))
+		if lineIndent >= contentIndent {
+			item.lines = append(item.lines, line[contentIndent:])
+			continue
+		}
+
+		// Lazy continuation of the paragraph of the item
+		last := item.lines[len(item.lines)-1]
+		if strings.TrimSpace(last) == "" || startsBlock(lines, i) {
+			break
+		}
+		item.lines = append(item.lines, strings.TrimSpace(line))
+	}
+
+	isTaskList := !ordered
+	for _, item := range items {
+		if item.task == "" {
+			isTaskList = false
+		}
+	}
+	if isTaskList {
+		list.Type = "taskList"
+	}
+
+	for n, item := range items {
+		if isTaskList {
+			list.Content = append(list.Content, p.taskItem(item, n))
+			continue
+		}
+		if item.task != "" {
+			item.lines[0] = `\[` + strings.ToLower(item.task) + `\] ` + item.lines[0]
+		}
+		list.Content = append(list.Content, &ADFNode{Type: "listItem", Content: listItemContent(p.parseBlocks(item.lines))})
+	}
+	return list, i
+}
+
+func isOrderedMarker(marker string) bool {
+	return marker[0] >= '0' && marker[0] <= '9'
+}
+
+// taskItem returns a task of a task list. Tasks only have inline content,
+// so the blocks of the item are joined with line breaks.
+func (p *markdownParser) taskItem(item *mdListItem, n int) *ADFNode {
+	state := "TODO"
+	if item.task == "X" {
+		state = "DONE"
+	}
+	task := &ADFNode{
+		Type:  "taskItem",
+		Attrs: map[string]interface{}{"localId": strconv.Itoa(n + 1), "state": state},
+	}
+	for _, block := range p.parseBlocks(item.lines) {
+		if len(task.Content) > 0 {
+			task.Content = append(task.Content, &ADFNode{Type: "hardBreak"})
+		}
+		task.Content = append(task.Content, inlineContent(block)...)
+	}
+	return task
+}
+
+// listItemContent converts the blocks that ADF does not allow in a list item.
+// An item must start with a paragraph.
+func listItemContent(blocks []*ADFNode) []*ADFNode {
+	content := []*ADFNode{}
+	for _, block := range blocks {
+		switch block.Type {
+		case "paragraph", "bulletList", "orderedList", "codeBlock", "mediaSingle":
+			content = append(content, block)
+		case "heading":
+			content = append(content, &ADFNode{Type: "paragraph", Content: block.Content})
+		case "rule":
+		default:
+			content = append(content, &ADFNode{Type: "paragraph", Content: inlineContent(block)})
+		}
+	}
+	if len(content) == 0 || content[0].Type == "bulletList" || content[0].Type == "orderedList" {
+		content = append([]*ADFNode{{Type: "paragraph"}}, content...)
+	}
+	return content
+}
+
+// inlineContent returns the inline nodes of a block and of its children, with
+// line breaks between the blocks.
+func inlineContent(block *ADFNode) []*ADFNode {
+	if len(block.Content) == 0 || isADFInline(block.Content[0]) {
+		return block.Content
+	}
+	content := []*ADFNode{}
+	for _, child := range block.Content {
+		if len(content) > 0 {
+			content = append(content, &ADFNode{Type: "hardBreak"})
+		}
+		content = append(content, inlineContent(child)...)
+	}
+	return content
+}
+
+// parseInline parses the inline Markdown of a block. New lines are line
+// breaks, like in Mattermost.
+func (p *markdownParser) parseInline(text string, marks []*ADFMark) []*ADFNode {
+	nodes := []*ADFNode{}
+	var plain strings.Builder
+	flush := func() {
+		if plain.Len() > 0 {
+			nodes = append(nodes, &ADFNode{Type: "text", Text: plain.String(), Marks: marks})
+			plain.Reset()
+		}
+	}
+
+	for i := 0; i < len(text); {
+		rest := text[i:]
+		prev, _ := utf8.DecodeLastRuneInString(text[:i])
+		atWordStart := i == 0 || !isWordRune(prev)
+
+		switch {
+		case rest[0] == '\\' && len(rest) > 1 && strings.IndexByte(mdPunctuationSet, rest[1]) >= 0:
+			plain.WriteByte(rest[1])
+			i += 2
+			continue
+
+		case rest[0] == '\n' || (rest[0] == '\\' && len(rest) > 1 && rest[1] == '\n'):
+			flush()
+			nodes = append(nodes, &ADFNode{Type: "hardBreak"})
+			i = skipSpaces(text, i+strings.IndexByte(rest, '\n')+1)
+			continue
+
+		case rest[0] == '`':
+			if code, n := matchCodeSpan(rest); n > 0 {
+				flush()
+				nodes = append(nodes, &ADFNode{Type: "text", Text: code, Marks: codeMarks(marks)})
+				i += n
+				continue
+			}
+
+		case strings.HasPrefix(rest, "!["):
+			if alt, url, n := matchLink(rest[1:]); n > 0 {
+				flush()
+				if alt == "" {
+					alt = url
+				}
+				nodes = append(nodes, p.parseInline(alt, withMark(marks, &ADFMark{Type: "link", Attrs: map[string]interface{}{"href": url}}))...)
+				i += n + 1
+				continue
+			}
+
+		case rest[0] == '[':
+			if content, url, n := matchLink(rest); n > 0 {
+				flush()
+				nodes = append(nodes, p.parseInline(content, withMark(marks, &ADFMark{Type: "link", Attrs: map[string]interface{}{"href": url}}))...)
+				i += n
+				continue
+			}
+
+		case rest[0] == '<':
+			if m := mdAutolinkRegex.FindStringSubmatch(rest); m != nil {
+				flush()
+				nodes = append(nodes, linkText(m[1], m[1], marks))
+				i += len(m[0])
+				continue
+			}
+
+		case rest[0] == '@' && atWordStart && prev != '@':
+			if m := mdUsernameRegex.FindStringSubmatch(rest); m != nil {
+				username := strings.TrimRight(m[1], ".-_")
+				if id := p.mentionID(strings.ToLower(username)); id != "" {
+					flush()
+					nodes = append(nodes, &ADFNode{Type: "mention", Attrs: map[string]interface{}{"id": id, "text": "@" + username}})
+					i += len(username) + 1
+					continue
+				}
+			}
+
+		case rest[0] == ':' && atWordStart && prev != ':':
+			if m := mdEmojiRegex.FindString(rest); m != "" {
+				flush()
+				nodes = append(nodes, &ADFNode{Type: "emoji", Attrs: map[string]interface{}{"shortName": m, "text": m}})
+				i += len(m)
+				continue
+			}
+		}
+
+		if atWordStart && !hasMark(marks, "link") {
+			if url := matchURL(rest); url != "" {
+				flush()
+				href := url
+				if strings.HasPrefix(url, "www.") {
+					href = "http://" + url
+				}
+				nodes = append(nodes, linkText(url, href, marks))
+				i += len(url)
+				continue
+			}
+		}
+
+		if mark, content, n := matchMarkdownEmphasis(rest, prev, i == 0); n > 0 {
+			flush()
+			nodes = append(nodes, p.parseInline(content, withMark(marks, &ADFMark{Type: mark}))...)
+			i += n
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(rest)
+		if r == ' ' && strings.HasPrefix(strings.TrimLeft(rest, " "), "\n") {
+			// Trailing spaces before a line break
+			i += len(rest) - len(strings.TrimLeft(rest, " "))
+			continue
+		}
+		plain.WriteString(rest[:size])
+		i += size
+	}
+	flush()
+	return nodes
+}
+
+func skipSpaces(text string, i int) int {
+	for i < len(text) && text[i] == ' ' {
+		i++
+	}
+	return i
+}
+
+func linkText(text, href string, marks []*ADFMark) *ADFNode {
+	return &ADFNode{Type: "text", Text: text, Marks: withMark(marks, &ADFMark{Type: "link", Attrs: map[string]interface{}{"href": href}})}
+}
+
+// withMark returns a copy of the marks, with a mark added if the marks do
+// not have one of its type yet.
+func withMark(marks []*ADFMark, mark *ADFMark) []*ADFMark {
+	if hasMark(marks, mark.Type) {
+		return marks
+	}
+	added := make([]*ADFMark, 0, len(marks)+1)
+	added = append(added, marks...)
+	return append(added, mark)
+}
+
+func hasMark(marks []*ADFMark, markType string) bool {
+	for _, m := range marks {
+		if m.Type == markType {
+			return true
+		}
+	}
+	return false
+}
+
+// codeMarks returns the marks of a code span. ADF only allows a link mark
+// with the code mark.
+func codeMarks(marks []*ADFMark) []*ADFMark {
+	code := []*ADFMark{}
+	for _, m := range marks {
+		if m.Type == "link" {
+			code = append(code, m)
+		}
+	}
+	return append(code, &ADFMark{Type: "code"})
+}
+
+// matchCodeSpan matches a code span at the start of the text. It returns the
+// code and the length of the span.
+func matchCodeSpan(text string) (string, int) {
+	n := len(text) - len(strings.TrimLeft(text, "`"))
+	fence := text[:n]
+	for j := n; j < len(text); {
+		k := strings.Index(text[j:], fence)
+		if k < 0 {
+			return "", 0
+		}
+		end := j + k
+		if end+n < len(text) && text[end+n] == '`' {
+			j = end + n + len(text[end+n:]) - len(strings.TrimLeft(text[end+n:], "`"))
+			continue
+		}
+		code := strings.ReplaceAll(text[n:end], "\n", " ")
+		if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.TrimSpace(code) != "" {
+			code = code[1 : len(code)-1]
+		}
+		if code == "" {
+			return "", 0
+		}
+		return code, end + n
+	}
+	return "", 0
+}
+
+// matchLink matches a [text](url) link at the start of the text. It returns
+// the text, the URL and the length of the link.
+func matchLink(text string) (string, string, int) {
+	depth := 0
+	end := -1
+	for j := 0; j < len(text) && end < 0; j++ {
+		switch text[j] {
+		case '\\':
+			j++
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				end = j
+			}
+		case '\n':
+			return "", "", 0
+		}
+	}
+	if end < 0 || end+1 >= len(text) || text[end+1] != '(' {
+		return "", "", 0
+	}
+
+	dest := text[end+2:]
+	closing := strings.IndexByte(dest, ')')
+	if closing < 0 {
+		return "", "", 0
+	}
+	// URLs may have balanced parentheses, e.g. the ones of Wikipedia
+	for strings.Count(dest[:closing], "(") > strings.Count(dest[:closing], ")") {
+		next := strings.IndexByte(dest[closing+1:], ')')
+		if next < 0 {
+			break
+		}
+		closing += next + 1
+	}
+
+	target := strings.TrimSpace(dest[:closing])
+	if space := strings.IndexAny(target, " \n"); space >= 0 {
+		target = target[:space]
+	}
+	target = strings.TrimSuffix(strings.TrimPrefix(target, "<"), ">")
+	if target == "" {
+		return "", "", 0
+	}
+	return text[1:end], target, end + 2 + closing + 1
+}
+
+// matchURL matches a bare URL at the start of the text, without the
+// punctuation that ends a sentence.
+func matchURL(text string) string {
+	url := mdURLRegex.FindString(text)
+	for url != "" {
+		last := url[len(url)-1]
+		switch {
+		case strings.IndexByte(".,:;!?\"'*_~", last) >= 0:
+			url = url[:len(url)-1]
+		case last == ')' && strings.Count(url, "(") < strings.Count(url, ")"):
+			url = url[:len(url)-1]
+		default:
+			return url
+		}
+	}
+	return ""
+}
+
+// matchMarkdownEmphasis matches **strong**, __strong__, *em*, _em_ or
+// ~~strike~~ at the start of the text. It returns the ADF mark, the content
+// and the length of the match.
+func matchMarkdownEmphasis(text string, prev rune, atStart bool) (string, string, int) {
+	var delim, mark string
+	switch {
+	case strings.HasPrefix(text, "**"), strings.HasPrefix(text, "__"):
+		delim, mark = text[:2], "strong"
+	case strings.HasPrefix(text, "~~"):
+		delim, mark = "~~", "strike"
+	case text[0] == '*' || text[0] == '_':
+		delim, mark = text[:1], "em"
+	default:
+		return "", "", 0
+	}
+	if delim[0] == '_' && !atStart && isWordRune(prev) {
+		return "", "", 0
+	}
+
+	body := text[len(delim):]
+	first, _ := utf8.DecodeRuneInString(body)
+	if body == "" || unicode.IsSpace(first) {
+		return "", "", 0
+	}
+
+	for j := 1; j < len(body); j++ {
+		if body[j] == '\\' {
+			j++
+			continue
+		}
+		if body[j] == '`' {
+			if _, n := matchCodeSpan(body[j:]); n > 0 {
+				j += n - 1
+				continue
+			}
+		}
+		if !strings.HasPrefix(body[j:], delim) {
+			continue
+		}
+		// A single delimiter does not close on a double one, e.g. in
+		// *a **b** c*
+		run := len(body[j:]) - len(strings.TrimLeft(body[j:], delim[:1]))
+		if len(delim) == 1 && run != 1 {
+			j += run - 1
+			continue
+		}
+		// A double delimiter closes on the end of a longer run, e.g. in
+		// ***a***
+		j += run - len(delim)
+		last, _ := utf8.DecodeLastRuneInString(body[:j])
+		next, _ := utf8.DecodeRuneInString(body[j+len(delim):])
+		if unicode.IsSpace(last) || (delim[0] == '_' && isWordRune(next)) {
+			continue
+		}
+		return mark, body[:j], len(delim) + j + len(delim)
+	}
+	return "", "", 0
+}
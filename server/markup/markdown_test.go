@@ -0,0 +1,169 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package markup
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testMentions(username string) *JiraUser {
+	switch username {
+	case "jane.doe":
+		return &JiraUser{AccountID: "5c5f880629be9642ba529340", Name: "jdoe"}
+	case "john":
+		return &JiraUser{Name: "jsmith"}
+	}
+	return nil
+}
+
+func TestMarkdownToWikiGolden(t *testing.T) {
+	testGolden(t, "testdata/markdown/*.md", ".wiki", func(md string) string {
+		return MarkdownToWiki(md, testMentions)
+	})
+}
+
+func TestMarkdownToADFGolden(t *testing.T) {
+	testGolden(t, "testdata/markdown/*.md", ".json", func(md string) string {
+		data, err := json.MarshalIndent(MarkdownToADF(md, testMentions), "", "  ")
+		require.NoError(t, err)
+		return string(data)
+	})
+}
+
+func TestMarkdownToWiki(t *testing.T) {
+	for name, tc := range map[string]struct {
+		md       string
+		expected string
+	}{
+		"empty": {
+			md:       "",
+			expected: "",
+		},
+		"line breaks": {
+			md:       "one\ntwo  \nthree",
+			expected: "one\ntwo\nthree",
+		},
+		"emphasis": {
+			md:       "**bold** __bold__ *em* _em_ ~~strike~~ ***both***",
+			expected: "*bold* *bold* _em_ _em_ -strike- *_both_*",
+		},
+		"nested emphasis": {
+			md:       "*a **b** c*",
+			expected: "_a *b* c_",
+		},
+		"spaces in emphasis": {
+			md:       "** not bold ** and * not em *",
+			expected: `\** not bold ** and * not em *`,
+		},
+		"intraword underscores": {
+			md:       "snake_case_name and __init__",
+			expected: "snake_case_name and *init*",
+		},
+		"escaped": {
+			md:       `\*not em\* and \_not em\_`,
+			expected: `\*not em* and \_not em_`,
+		},
+		"code span": {
+			md:       "run ``a `b` c`` now",
+			expected: "run {{a `b` c}} now",
+		},
+		"link with pipe": {
+			md:       "[a | b](https://example.com)",
+			expected: `[a \| b|https://example.com]`,
+		},
+		"link to itself": {
+			md:       "<https://example.com> and www.example.com.",
+			expected: "[https://example.com] and [www.example.com|http://www.example.com].",
+		},
+		"image in text": {
+			md:       "see ![diagram](https://example.com/d.png)",
+			expected: "see [diagram|https://example.com/d.png]",
+		},
+		"server mention": {
+			md:       "cc @john.",
+			expected: "cc [~jsmith].",
+		},
+		"cloud mention": {
+			md:       "cc @Jane.Doe",
+			expected: "cc [~accountid:5c5f880629be9642ba529340]",
+		},
+		"email": {
+			md:       "mail john@example.com",
+			expected: "mail john@example.com",
+		},
+		"emoticons": {
+			md:       ":thumbsup: :star: :smile: 10:30:00",
+			expected: "(y) (*) :smile: 10:30:00",
+		},
+		"emoticon in text": {
+			md:       "option (i) or (x)",
+			expected: `option \(i) or \(x)`,
+		},
+		"numbered list start": {
+			md:       "3. three\n4. four",
+			expected: "# three\n# four",
+		},
+		"list with paragraphs": {
+			md:       "- one\n\n  more\n- two",
+			expected: "* one \\\\ more\n* two",
+		},
+		"nested numbered list": {
+			md:       "- a\n  1. b\n     - c",
+			expected: "* a\n*# b\n*#* c",
+		},
+		"code in list": {
+			md:       "1. run\n   ```\n   make\n   ```",
+			expected: "# run\n{noformat}\nmake\n{noformat}",
+		},
+		"setext heading": {
+			md:       "Title\n=====\nSub\n---",
+			expected: "h1. Title\n\nh2. Sub",
+		},
+		"unclosed fence": {
+			md:       "```go\nfunc main() {}",
+			expected: "{code:go}\nfunc main() {}\n{code}",
+		},
+		"quote": {
+			md:       "> one\n>\n> two",
+			expected: "{quote}\none\n\ntwo\n{quote}",
+		},
+		"table without leading pipes": {
+			md:       "a | b\n--|--\n1 | 2",
+			expected: "||a||b||\n|1|2|",
+		},
+		"line starting like a list": {
+			md:       "5\\. not a list\n\\- nor this",
+			expected: "5. not a list\n\\- nor this",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, MarkdownToWiki(tc.md, testMentions))
+		})
+	}
+}
+
+func TestMarkdownToADF(t *testing.T) {
+	doc := MarkdownToADF("Hi @jane.doe and @john, **see** [this `code`](https://example.com)", testMentions)
+	data, err := json.Marshal(doc)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"type": "doc", "version": 1, "content": [
+		{"type": "paragraph", "content": [
+			{"type": "text", "text": "Hi "},
+			{"type": "mention", "attrs": {"id": "5c5f880629be9642ba529340", "text": "@jane.doe"}},
+			{"type": "text", "text": " and @john, "},
+			{"type": "text", "text": "see", "marks": [{"type": "strong"}]},
+			{"type": "text", "text": " "},
+			{"type": "text", "text": "this ", "marks": [{"type": "link", "attrs": {"href": "https://example.com"}}]},
+			{"type": "text", "text": "code", "marks": [{"type": "link", "attrs": {"href": "https://example.com"}}, {"type": "code"}]}
+		]}
+	]}`, string(data))
+
+	// The rendered Markdown of the ADF is the same as the original
+	md := "## Title\n\n1. **one**\n2. two\n   * three\n\n```go\nfunc main() {}\n```"
+	assert.Equal(t, "## Title\n\n1. **one**\n2. two\n   * three\n\n```go\nfunc main() {}\n```", ADFToMarkdown(MarkdownToADF(md, nil)))
+}
@@ -0,0 +1,373 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package markup
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// wikiCodeLanguages maps the languages of Markdown code blocks to the ones
+// of the Jira code macro. The code of other languages is not highlighted.
+var wikiCodeLanguages = map[string]string{
+	"actionscript": "actionscript",
+	"bash":         "bash",
+	"c":            "c",
+	"c#":           "c#",
+	"cpp":          "cpp",
+	"c++":          "cpp",
+	"cs":           "c#",
+	"csharp":       "c#",
+	"css":          "css",
+	"erlang":       "erlang",
+	"go":           "go",
+	"golang":       "go",
+	"groovy":       "groovy",
+	"haskell":      "haskell",
+	"html":         "html",
+	"java":         "java",
+	"javascript":   "javascript",
+	"js":           "javascript",
+	"json":         "json",
+	"jsx":          "javascript",
+	"lua":          "lua",
+	"objc":         "objc",
+	"perl":         "perl",
+	"php":          "php",
+	"py":           "python",
+	"python":       "python",
+	"r":            "r",
+	"rb":           "ruby",
+	"ruby":         "ruby",
+	"scala":        "scala",
+	"sh":           "bash",
+	"shell":        "bash",
+	"sql":          "sql",
+	"swift":        "swift",
+	"vb":           "visualbasic",
+	"xml":          "xml",
+	"yaml":         "yaml",
+	"yml":          "yaml",
+}
+
+// wikiEmojiEmoticons maps Mattermost emojis back to Jira emoticons. When
+// several emoticons have the same emoji, the last one wins, e.g. (*) rather
+// than (*y).
+var wikiEmojiEmoticons = func() map[string]string {
+	emoticons := map[string]string{}
+	for _, e := range wikiEmoticons {
+		emoticons[e.emoji] = e.emoticon
+	}
+	return emoticons
+}()
+
+// MarkdownToWiki converts a Mattermost message to Jira wiki markup. The
+// mentions of connected users become Jira mentions.
+func MarkdownToWiki(md string, mention MentionFunc) string {
+	doc := parseMarkdown(md, func(username string) string {
+		user := resolveMention(mention, username)
+		switch {
+		case user == nil:
+			return ""
+		case user.AccountID != "":
+			return "accountid:" + user.AccountID
+		default:
+			return user.Name
+		}
+	})
+	return strings.TrimSpace(renderWikiBlocks(doc.Content))
+}
+
+func renderWikiBlocks(nodes []*ADFNode) string {
+	blocks := []string{}
+	for _, n := range nodes {
+		if wiki := renderWikiBlock(n); wiki != "" {
+			blocks = append(blocks, wiki)
+		}
+	}
+	return strings.Join(blocks, "\n\n")
+}
+
+func renderWikiBlock(n *ADFNode) string {
+	switch n.Type {
+	case "paragraph":
+		return escapeWikiLineStarts(renderWikiInline(n.Content, "\n"))
+	case "heading":
+		level := adfInt(n, "level")
+		if level < 1 || level > 6 {
+			level = 1
+		}
+		return "h" + strconv.Itoa(level) + ". " + renderWikiInline(n.Content, " ")
+	case "bulletList", "orderedList", "taskList":
+		return renderWikiList(n, "")
+	case "codeBlock":
+		code := adfPlainText(n.Content)
+		if language, ok := wikiCodeLanguages[strings.ToLower(adfAttr(n, "language"))]; ok {
+			return "{code:" + language + "}\n" + code + "\n{code}"
+		}
+		return "{noformat}\n" + code + "\n{noformat}"
+	case "blockquote":
+		return "{quote}\n" + renderWikiBlocks(n.Content) + "\n{quote}"
+	case "rule":
+		return "----"
+	case "table":
+		return renderWikiTable(n)
+	case "mediaSingle":
+		media := []string{}
+		for _, child := range n.Content {
+			if url := adfAttr(child, "url"); url != "" {
+				media = append(media, "!"+url+"!")
+			}
+		}
+		return strings.Join(media, "\n")
+	}
+	return renderWikiInline(n.Content, "\n")
+}
+
+// renderWikiList renders the items of a list, prefixed with the markers of
+// the lists they are nested in, e.g. #* for a bullet in a numbered list.
+// Wiki list items are a single line, so their paragraphs are joined with line
+// breaks.
+func renderWikiList(n *ADFNode, prefix string) string {
+	marker := prefix + "*"
+	if n.Type == "orderedList" {
+		marker = prefix + "#"
+	}
+
+	lines := []string{}
+	for _, item := range n.Content {
+		if item.Type == "taskItem" {
+			checkbox := `\[ \] `
+			if adfAttr(item, "state") == "DONE" {
+				checkbox = `\[x\] `
+			}
+			lines = append(lines, marker+" "+checkbox+renderWikiInline(item.Content, ` \\ `))
+			continue
+		}
+
+		text := []string{}
+		after := []string{}
+		for _, block := range item.Content {
+			switch block.Type {
+			case "paragraph":
+				text = append(text, renderWikiInline(block.Content, ` \\ `))
+			case "bulletList", "orderedList", "taskList":
+				after = append(after, renderWikiList(block, marker))
+			default:
+				after = append(after, renderWikiBlock(block))
+			}
+		}
+		lines = append(lines, strings.TrimRight(marker+" "+strings.Join(text, ` \\ `), " "))
+		lines = append(lines, after...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func renderWikiTable(n *ADFNode) string {
+	rows := []string{}
+	for _, row := range n.Content {
+		var line strings.Builder
+		delim := "|"
+		for _, cell := range row.Content {
+			delim = "|"
+			if cell.Type == "tableHeader" {
+				delim = "||"
+			}
+			text := []string{}
+			for _, block := range cell.Content {
+				text = append(text, renderWikiInline(inlineContent(block), ` \\ `))
+			}
+			content := strings.Join(text, ` \\ `)
+			if content == "" {
+				content = " "
+			}
+			line.WriteString(delim + escapeWikiPipes(content))
+		}
+		line.WriteString(delim)
+		rows = append(rows, line.String())
+	}
+	return strings.Join(rows, "\n")
+}
+
+// escapeWikiPipes escapes the pipes of a table cell, except the ones of the
+// links, e.g. [text|url], and the ones already escaped.
+func escapeWikiPipes(text string) string {
+	var out strings.Builder
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		switch {
+		case text[i] == '\\' && i+1 < len(text):
+			out.WriteString(text[i : i+2])
+			i++
+			continue
+		case text[i] == '[':
+			depth++
+		case text[i] == ']' && depth > 0:
+			depth--
+		case text[i] == '|' && depth == 0:
+			out.WriteString(`\|`)
+			continue
+		}
+		out.WriteByte(text[i])
+	}
+	return out.String()
+}
+
+// escapeWikiLineStarts escapes the characters that would start a list at the
+// start of the lines of a paragraph.
+func escapeWikiLineStarts(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if len(line) > 1 && strings.ContainsRune("*#-", rune(line[0])) && (line[1] == ' ' || line[1] == line[0]) {
+			lines[i] = `\` + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// renderWikiInline renders inline nodes as wiki markup, with the line breaks
+// rendered as lineBreak. The consecutive nodes with the same mark are
+// rendered in one text effect, e.g. _a *b* c_.
+func renderWikiInline(nodes []*ADFNode, lineBreak string) string {
+	var out strings.Builder
+	for i := 0; i < len(nodes); {
+		n := nodes[i]
+		if mark := outerWikiMark(n); mark != nil {
+			j := i + 1
+			for j < len(nodes) && sameMark(outerWikiMark(nodes[j]), mark) {
+				j++
+			}
+			out.WriteString(renderWikiMark(mark, withoutMark(nodes[i:j], mark), lineBreak))
+			i = j
+			continue
+		}
+		i++
+
+		switch n.Type {
+		case "text":
+			if hasMark(n.Marks, "code") {
+				out.WriteString(trimmedEffect(escapeWiki(n.Text), "{{", "}}"))
+			} else {
+				out.WriteString(escapeWiki(n.Text))
+			}
+		case "hardBreak":
+			out.WriteString(lineBreak)
+		case "mention":
+			if id := adfAttr(n, "id"); id != "" {
+				out.WriteString("[~" + id + "]")
+			} else {
+				out.WriteString(escapeWiki(adfAttr(n, "text")))
+			}
+		case "emoji":
+			shortName := adfAttr(n, "shortName")
+			if emoticon, ok := wikiEmojiEmoticons[shortName]; ok {
+				out.WriteString(emoticon)
+			} else {
+				out.WriteString(shortName)
+			}
+		default:
+			out.WriteString(renderWikiInline(n.Content, lineBreak))
+		}
+	}
+	return out.String()
+}
+
+// outerWikiMark returns the outermost mark of a node, except the code mark
+// that is always the innermost one.
+func outerWikiMark(n *ADFNode) *ADFMark {
+	for _, mark := range n.Marks {
+		if mark.Type != "code" {
+			return mark
+		}
+	}
+	return nil
+}
+
+func sameMark(a, b *ADFMark) bool {
+	if a == nil || b == nil || a.Type != b.Type {
+		return false
+	}
+	return a.Type != "link" || a.Attrs["href"] == b.Attrs["href"]
+}
+
+// withoutMark returns copies of the nodes without a mark.
+func withoutMark(nodes []*ADFNode, mark *ADFMark) []*ADFNode {
+	copies := make([]*ADFNode, 0, len(nodes))
+	for _, n := range nodes {
+		c := *n
+		c.Marks = nil
+		for _, m := range n.Marks {
+			if !sameMark(m, mark) {
+				c.Marks = append(c.Marks, m)
+			}
+		}
+		copies = append(copies, &c)
+	}
+	return copies
+}
+
+func renderWikiMark(mark *ADFMark, nodes []*ADFNode, lineBreak string) string {
+	wiki := renderWikiInline(nodes, lineBreak)
+	switch mark.Type {
+	case "strong":
+		return trimmedEffect(wiki, "*", "*")
+	case "em":
+		return trimmedEffect(wiki, "_", "_")
+	case "strike":
+		return trimmedEffect(wiki, "-", "-")
+	case "link":
+		link, _ := mark.Attrs["href"].(string)
+		if strings.TrimSpace(wiki) == link {
+			return trimmedEffect(wiki, "[", "]")
+		}
+		return trimmedEffect(strings.ReplaceAll(wiki, "|", `\|`), "[", "|"+link+"]")
+	}
+	return wiki
+}
+
+// trimmedEffect surrounds the text with the delimiters of a text effect.
+// Wiki text effects can not start or end with a space, so the spaces are
+// kept out of them.
+func trimmedEffect(text, start, end string) string {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return text
+	}
+	leading := text[:strings.Index(text, trimmed)]
+	trailing := text[len(leading)+len(trimmed):]
+	return leading + start + trimmed + end + trailing
+}
+
+// escapeWiki escapes the text so that Jira renders it as is: macros, links,
+// images, text effects and emoticons.
+func escapeWiki(text string) string {
+	var out strings.Builder
+	for i := 0; i < len(text); {
+		rest := text[i:]
+		prev, _ := utf8.DecodeLastRuneInString(text[:i])
+		atWordStart := i == 0 || !isWordRune(prev)
+		r, size := utf8.DecodeRuneInString(rest)
+		next, _ := utf8.DecodeRuneInString(rest[size:])
+
+		switch {
+		case r == '{' || r == '}' || r == '[' || r == ']':
+			out.WriteString(`\`)
+		case r == '!' && atWordStart && next != utf8.RuneError && !unicode.IsSpace(next):
+			out.WriteString(`\`)
+		case r == '(' && atWordStart:
+			if _, n := matchEmoticon(rest); n > 0 {
+				out.WriteString(`\`)
+			}
+		case atWordStart && strings.ContainsRune("*_-+^~?", r):
+			if _, _, n := matchEmphasis(rest); n > 0 {
+				out.WriteString(`\`)
+			}
+		}
+		out.WriteString(rest[:size])
+		i += size
+	}
+	return out.String()
+}
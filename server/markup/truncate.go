@@ -0,0 +1,108 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package markup
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+const ellipsis = "..."
+
+// TruncateMarkdown shortens Markdown to at most max bytes. It cuts after the
+// last block that fits if that keeps at least half of the text, and otherwise
+// at a word, before a link or code span that would be cut in two. A code
+// block that is cut is closed, so that the rest of the post is not rendered
+// as code.
+func TruncateMarkdown(md string, max int) string {
+	if len(md) <= max {
+		return md
+	}
+	if max <= len(ellipsis) {
+		return cutRunes(md, max)
+	}
+	limit := max - len("\n\n"+ellipsis)
+
+	// Find the end of the last block that fits, outside of code blocks, and
+	// the fence of the code block open at the limit.
+	blockEnd := 0
+	fence := ""
+	for offset := 0; offset < limit; {
+		lineEnd := strings.IndexByte(md[offset:], '\n')
+		if lineEnd < 0 {
+			lineEnd = len(md)
+		} else {
+			lineEnd += offset
+		}
+		line := strings.TrimSpace(md[offset:lineEnd])
+
+		switch {
+		case fence != "":
+			if lineEnd <= limit && strings.HasPrefix(line, fence) && strings.Trim(line, fence[:1]) == "" {
+				fence = ""
+				blockEnd = lineEnd
+			}
+		case line == "":
+			blockEnd = offset
+		default:
+			fence = openingFence(line)
+		}
+		offset = lineEnd + 1
+	}
+
+	if blockEnd > limit/2 {
+		return strings.TrimRight(md[:blockEnd], " \n") + "\n\n" + ellipsis
+	}
+
+	if fence != "" {
+		text := cutRunes(md, limit-len("\n"+fence))
+		if i := strings.LastIndexByte(text, '\n'); i > 0 {
+			text = text[:i]
+		}
+		return text + "\n" + fence + "\n\n" + ellipsis
+	}
+
+	text := cutRunes(md, limit)
+	if i := strings.LastIndexAny(text, " \n\t"); i > len(text)/2 {
+		text = text[:i]
+	}
+	return strings.TrimRight(trimOpenInline(text), " \n\t") + ellipsis
+}
+
+// openingFence returns the fence of a line that opens a fenced code block.
+func openingFence(line string) string {
+	for _, c := range []string{"`", "~"} {
+		n := len(line) - len(strings.TrimLeft(line, c))
+		if n >= 3 {
+			return strings.Repeat(c, n)
+		}
+	}
+	return ""
+}
+
+// trimOpenInline removes from the end of the text a code span or link that
+// is not closed on its last line.
+func trimOpenInline(text string) string {
+	start := strings.LastIndexByte(text, '\n') + 1
+	line := text[start:]
+	if strings.Count(line, "`")%2 == 1 {
+		line = line[:strings.LastIndexByte(line, '`')]
+	}
+	if i := strings.LastIndexByte(line, '['); i >= 0 && !strings.Contains(line[i:], ")") {
+		line = line[:i]
+	}
+	return text[:start] + line
+}
+
+// cutRunes returns the longest prefix of s of at most n bytes that does not
+// end in the middle of a rune.
+func cutRunes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
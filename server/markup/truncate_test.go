@@ -0,0 +1,72 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package markup
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncateMarkdown(t *testing.T) {
+	words := strings.Repeat("word ", 10)
+	for name, tc := range map[string]struct {
+		md       string
+		max      int
+		expected string
+	}{
+		"short": {
+			md:       "some **text**",
+			max:      20,
+			expected: "some **text**",
+		},
+		"block boundary": {
+			md:       words + "\n\n" + words + "\n\n" + words,
+			max:      120,
+			expected: words + "\n\n" + words[:len(words)-1] + "\n\n...",
+		},
+		"word boundary": {
+			md:       words + words,
+			max:      32,
+			expected: "word word word word word...",
+		},
+		"link": {
+			md:       "see the [documentation](https://example.com/documentation/page)",
+			max:      50,
+			expected: "see the...",
+		},
+		"code span": {
+			md:       "run `make dist-all-platforms` to build",
+			max:      28,
+			expected: "run...",
+		},
+		"code block": {
+			md:       "Logs:\n```\nline one\nline two\nline three\nline four\n```\n",
+			max:      40,
+			expected: "Logs:\n```\nline one\nline two\n```\n\n...",
+		},
+		"code block with backticks": {
+			md:       "````\nuse ``` to fence\n" + words + "\n````",
+			max:      50,
+			expected: "````\nuse ``` to fence\n````\n\n...",
+		},
+		"closed code block": {
+			md:       "```\ncode\n```\n\n" + words + words,
+			max:      30,
+			expected: "```\ncode\n```\n\n...",
+		},
+		"runes": {
+			md:       strings.Repeat("é", 20),
+			max:      20,
+			expected: strings.Repeat("é", 7) + "...",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			truncated := TruncateMarkdown(tc.md, tc.max)
+			assert.Equal(t, tc.expected, truncated)
+			assert.LessOrEqual(t, len(truncated), tc.max)
+		})
+	}
+}
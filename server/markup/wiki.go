@@ -0,0 +1,497 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+// Package markup converts between the text formats of Jira and the Markdown
+// of Mattermost.
+package markup
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type blockKind int
+
+const (
+	blockParagraph blockKind = iota
+	blockHeading
+	blockQuote
+	blockPanel
+	blockCode
+	blockList
+	blockTable
+	blockRule
+)
+
+// block is a block of Jira wiki markup. The fields used depend on the kind.
+type block struct {
+	kind blockKind
+	// gap is true if the block follows a blank line
+	gap bool
+
+	level    int
+	text     string
+	lang     string
+	title    string
+	icon     string
+	children []block
+	items    []listItem
+	rows     [][]tableCell
+}
+
+type listItem struct {
+	// marker is the Jira list marker, e.g. "#" or "#*"
+	marker string
+	text   string
+}
+
+type tableCell struct {
+	header bool
+	text   string
+}
+
+var (
+	headingRegex  = regexp.MustCompile(`^h([1-6])\.\s+`)
+	listItemRegex = regexp.MustCompile(`^([*#]+|-)\s+`)
+	ruleRegex     = regexp.MustCompile(`^-{4,}$`)
+	macroRegex    = regexp.MustCompile(`\{(code|noformat|quote|panel|info|note|warning|tip)(:[^}]*)?\}`)
+)
+
+var panelIcons = map[string]string{
+	"info":    ":information_source:",
+	"note":    ":memo:",
+	"warning": ":warning:",
+	"tip":     ":white_check_mark:",
+}
+
+// WikiToMarkdown converts Jira wiki markup, as used in the descriptions and
+// comments of Jira Server and of the v2 REST API of Jira Cloud, to Markdown.
+//
+// User mentions are kept as [~name] or [~accountid:id], so that they can be
+// replaced with the matching Mattermost users.
+func WikiToMarkdown(text string) string {
+	return renderBlocks(parseWikiBlocks(text))
+}
+
+type wikiParser struct {
+	blocks []block
+	para   []string
+	gap    bool
+}
+
+func parseWikiBlocks(text string) []block {
+	p := &wikiParser{}
+	src := strings.ReplaceAll(text, "\r\n", "\n")
+	for src != "" {
+		line, rest, _ := strings.Cut(src, "\n")
+		src = rest
+
+		loc := macroRegex.FindStringSubmatchIndex(line)
+		if loc == nil {
+			p.parseLine(line)
+			continue
+		}
+
+		name := line[loc[2]:loc[3]]
+		params := ""
+		if loc[4] >= 0 {
+			params = line[loc[4]+1 : loc[5]]
+		}
+		body := line[loc[1]:] + "\n" + src
+		closeTag := "{" + name + "}"
+		end := strings.Index(body, closeTag)
+		if end < 0 {
+			// An unterminated macro is not one
+			p.parseLine(line)
+			continue
+		}
+
+		if before := line[:loc[0]]; strings.TrimSpace(before) != "" {
+			p.parseLine(before)
+		}
+		p.addMacro(name, params, body[:end])
+
+		// The rest of the line after the closing tag is a new line
+		src = strings.TrimPrefix(body[end+len(closeTag):], "\n")
+		if strings.TrimSpace(src) == "" {
+			src = ""
+		}
+	}
+	p.flushParagraph()
+	return p.blocks
+}
+
+func (p *wikiParser) add(b block) {
+	p.flushParagraph()
+	b.gap = p.gap
+	p.gap = false
+	p.blocks = append(p.blocks, b)
+}
+
+func (p *wikiParser) flushParagraph() {
+	if len(p.para) == 0 {
+		return
+	}
+	p.blocks = append(p.blocks, block{
+		kind: blockParagraph,
+		gap:  p.gap,
+		text: strings.Join(p.para, "\n"),
+	})
+	p.para = nil
+	p.gap = false
+}
+
+// last returns the previous block if it is of the given kind and the line
+// continues it.
+func (p *wikiParser) last(kind blockKind) *block {
+	if len(p.para) > 0 || p.gap || len(p.blocks) == 0 {
+		return nil
+	}
+	b := &p.blocks[len(p.blocks)-1]
+	if b.kind != kind {
+		return nil
+	}
+	return b
+}
+
+func (p *wikiParser) parseLine(line string) {
+	trimmed := strings.TrimSpace(line)
+	switch {
+	case trimmed == "":
+		p.flushParagraph()
+		if len(p.blocks) > 0 {
+			p.gap = true
+		}
+
+	case headingRegex.MatchString(trimmed):
+		m := headingRegex.FindStringSubmatch(trimmed)
+		p.add(block{kind: blockHeading, level: int(m[1][0] - '0'), text: trimmed[len(m[0]):]})
+
+	case strings.HasPrefix(trimmed, "bq. "):
+		p.add(block{kind: blockQuote, children: []block{{kind: blockParagraph, text: strings.TrimSpace(trimmed[4:])}}})
+
+	case ruleRegex.MatchString(trimmed):
+		p.add(block{kind: blockRule})
+
+	case strings.HasPrefix(trimmed, "|"):
+		row := parseTableRow(trimmed)
+		if b := p.last(blockTable); b != nil {
+			b.rows = append(b.rows, row)
+			return
+		}
+		p.add(block{kind: blockTable, rows: [][]tableCell{row}})
+
+	case listItemRegex.MatchString(trimmed):
+		m := listItemRegex.FindStringSubmatch(trimmed)
+		item := listItem{marker: m[1], text: trimmed[len(m[0]):]}
+		if b := p.last(blockList); b != nil {
+			b.items = append(b.items, item)
+			return
+		}
+		p.add(block{kind: blockList, items: []listItem{item}})
+
+	default:
+		p.para = append(p.para, trimmed)
+	}
+}
+
+func (p *wikiParser) addMacro(name, params, content string) {
+	content = strings.Trim(content, "\n")
+	switch name {
+	case "code", "noformat":
+		b := block{kind: blockCode, text: content}
+		if name == "code" {
+			b.lang, b.title = parseCodeParams(params)
+		}
+		p.add(b)
+	case "quote":
+		p.add(block{kind: blockQuote, children: parseWikiBlocks(content)})
+	default:
+		p.add(block{
+			kind:     blockPanel,
+			title:    macroParam(params, "title"),
+			icon:     panelIcons[name],
+			children: parseWikiBlocks(content),
+		})
+	}
+}
+
+// parseCodeParams returns the language and title of a code macro, e.g.
+// {code:java}, {code:title=Foo.java|borderStyle=solid} or
+// {code:language=go|title=main.go}.
+func parseCodeParams(params string) (string, string) {
+	lang := macroParam(params, "language")
+	for _, param := range strings.Split(params, "|") {
+		if param != "" && !strings.Contains(param, "=") && lang == "" {
+			lang = strings.TrimSpace(param)
+		}
+	}
+	return lang, macroParam(params, "title")
+}
+
+func macroParam(params, name string) string {
+	for _, param := range strings.Split(params, "|") {
+		key, value, ok := strings.Cut(param, "=")
+		if ok && strings.TrimSpace(key) == name {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// parseTableRow splits a table row into cells. Cells starting with || are
+// header cells. Pipes inside links, images and monospace text do not end a
+// cell.
+func parseTableRow(line string) []tableCell {
+	cells := []tableCell{}
+	var current *tableCell
+	depth := 0
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && i+1 < len(line):
+			if current != nil {
+				current.text += line[i : i+2]
+			}
+			i++
+			continue
+		case c == '[' || strings.HasPrefix(line[i:], "{{"):
+			depth++
+		case (c == ']' || strings.HasPrefix(line[i:], "}}")) && depth > 0:
+			depth--
+		case c == '|' && depth == 0:
+			if current != nil {
+				cells = append(cells, *current)
+			}
+			current = &tableCell{}
+			if i+1 < len(line) && line[i+1] == '|' {
+				current.header = true
+				i++
+			}
+			continue
+		}
+		if current != nil {
+			current.text += string(c)
+		}
+	}
+	if current != nil && strings.TrimSpace(current.text) != "" {
+		cells = append(cells, *current)
+	}
+	return cells
+}
+
+func renderBlocks(blocks []block) string {
+	out := ""
+	for i, b := range blocks {
+		if i > 0 {
+			out += "\n"
+			if b.gap || needsBlankLine(blocks[i-1], b) {
+				out += "\n"
+			}
+		}
+		out += renderBlock(b)
+	}
+	return strings.TrimSpace(out)
+}
+
+// needsBlankLine returns true if the blocks must be separated by a blank line
+// in Markdown, e.g. so that a paragraph is not read as the continuation of a
+// list item before it.
+func needsBlankLine(prev, next block) bool {
+	switch prev.kind {
+	case blockList, blockQuote, blockPanel, blockTable:
+		return true
+	}
+	return next.kind == blockTable
+}
+
+func renderBlock(b block) string {
+	switch b.kind {
+	case blockHeading:
+		return strings.Repeat("#", b.level) + " " + renderInline(b.text, false)
+	case blockQuote:
+		return quoteLines(renderBlocks(b.children))
+	case blockPanel:
+		content := renderBlocks(b.children)
+		switch {
+		case b.title != "":
+			content = strings.TrimSpace(b.icon+" "+bold(b.title)) + "\n" + content
+		case b.icon != "":
+			content = b.icon + " " + content
+		}
+		return quoteLines(strings.TrimSpace(content))
+	case blockCode:
+		return renderCodeBlock(b)
+	case blockList:
+		return renderList(b.items)
+	case blockTable:
+		return renderTable(b.rows)
+	case blockRule:
+		return "---"
+	default:
+		lines := strings.Split(renderInline(b.text, false), "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight(line, " ")
+		}
+		return strings.Join(lines, "\n")
+	}
+}
+
+func bold(text string) string {
+	if text == "" {
+		return ""
+	}
+	return "**" + text + "**"
+}
+
+func quoteLines(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if line == "" {
+			lines[i] = ">"
+		} else {
+			lines[i] = "> " + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func renderCodeBlock(b block) string {
+	fence := codeFence(b.text)
+	out := ""
+	if b.title != "" {
+		out = bold(b.title) + "\n"
+	}
+	return out + fence + b.lang + "\n" + b.text + "\n" + fence
+}
+
+// codeFence returns a fence longer than any run of backticks in the code.
+func codeFence(code string) string {
+	longest, run := 0, 0
+	for _, c := range code {
+		if c == '`' {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	if longest < 3 {
+		return "```"
+	}
+	return strings.Repeat("`", longest+1)
+}
+
+type listLevel struct {
+	ordered bool
+	count   int
+	width   int
+}
+
+// renderList renders nested Jira lists, where the marker of an item holds
+// the type of all its parent lists, e.g. "#*" for a bullet item in a
+// numbered list.
+func renderList(items []listItem) string {
+	lines := []string{}
+	stack := []listLevel{}
+	for _, item := range items {
+		marker := item.marker
+		if marker == "-" {
+			marker = "*"
+		}
+		depth := len(marker)
+		ordered := marker[depth-1] == '#'
+
+		if len(stack) > depth {
+			stack = stack[:depth]
+		}
+		for len(stack) < depth-1 {
+			stack = append(stack, listLevel{ordered: marker[len(stack)] == '#', width: 2})
+		}
+		if len(stack) == depth && stack[depth-1].ordered != ordered {
+			stack = stack[:depth-1]
+		}
+		if len(stack) < depth {
+			stack = append(stack, listLevel{ordered: ordered})
+		}
+
+		level := &stack[depth-1]
+		level.count++
+		bullet := "* "
+		if ordered {
+			bullet = fmt.Sprintf("%d. ", level.count)
+		}
+		level.width = len(bullet)
+
+		indent := 0
+		for _, parent := range stack[:depth-1] {
+			indent += parent.width
+		}
+		lines = append(lines, strings.Repeat(" ", indent)+bullet+renderInline(item.text, false))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func renderTable(rows [][]tableCell) string {
+	columns := 0
+	for _, row := range rows {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+
+	header := make([]string, columns)
+	if len(rows) > 0 && isHeaderRow(rows[0]) {
+		for i, cell := range rows[0] {
+			header[i] = renderInline(strings.TrimSpace(cell.text), true)
+		}
+		rows = rows[1:]
+	}
+
+	lines := []string{tableRow(header, columns)}
+	separator := make([]string, columns)
+	for i := range separator {
+		separator[i] = "---"
+	}
+	lines = append(lines, tableRow(separator, columns))
+
+	for _, row := range rows {
+		cells := []string{}
+		for _, cell := range row {
+			text := renderInline(strings.TrimSpace(cell.text), true)
+			if cell.header && text != "" {
+				text = "**" + text + "**"
+			}
+			cells = append(cells, text)
+		}
+		lines = append(lines, tableRow(cells, columns))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func isHeaderRow(row []tableCell) bool {
+	for _, cell := range row {
+		if !cell.header {
+			return false
+		}
+	}
+	return len(row) > 0
+}
+
+func tableRow(cells []string, columns int) string {
+	for len(cells) < columns {
+		cells = append(cells, "")
+	}
+	out := "|"
+	for _, cell := range cells {
+		if cell == "" {
+			out += " |"
+		} else {
+			out += " " + cell + " |"
+		}
+	}
+	return out
+}
@@ -0,0 +1,354 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package markup
+
+import (
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// wikiEmphasis maps the Jira text effects to their Markdown equivalent.
+// Markdown has no underline, superscript nor subscript, so their text is
+// kept as is.
+var wikiEmphasis = map[string]string{
+	"*":  "**",
+	"_":  "_",
+	"-":  "~~",
+	"+":  "",
+	"^":  "",
+	"~":  "",
+	"??": "_",
+}
+
+// wikiEmoticons maps the Jira emoticons to Mattermost emojis. Longer
+// emoticons come first, so that they are matched before their prefixes.
+var wikiEmoticons = []struct {
+	emoticon string
+	emoji    string
+}{
+	{"(flagoff)", ":triangular_flag_on_post:"},
+	{"(flag)", ":triangular_flag_on_post:"},
+	{"(off)", ":bulb:"},
+	{"(on)", ":bulb:"},
+	{"(*r)", ":star:"},
+	{"(*g)", ":star:"},
+	{"(*b)", ":star:"},
+	{"(*y)", ":star:"},
+	{"(*)", ":star:"},
+	{"(y)", ":thumbsup:"},
+	{"(n)", ":thumbsdown:"},
+	{"(i)", ":information_source:"},
+	{"(/)", ":white_check_mark:"},
+	{"(x)", ":x:"},
+	{"(!)", ":warning:"},
+	{"(+)", ":heavy_plus_sign:"},
+	{"(-)", ":heavy_minus_sign:"},
+	{"(?)", ":question:"},
+	{"</3", ":broken_heart:"},
+	{"<3", ":heart:"},
+	{":)", ":slightly_smiling_face:"},
+	{":(", ":slightly_frowning_face:"},
+	{":P", ":stuck_out_tongue:"},
+	{":D", ":smiley:"},
+	{";)", ":wink:"},
+}
+
+var (
+	wikiImageRegex = regexp.MustCompile(`^!([^\s!|][^!\n]*?)(\|[^!\n]*)?!`)
+	urlRegex       = regexp.MustCompile(`^(?:https?|ftp)://[^\s]+`)
+	colorRegex     = regexp.MustCompile(`^\{color(:[^}]*)?\}`)
+	anchorRegex    = regexp.MustCompile(`^\{anchor:[^}]*\}`)
+)
+
+// wikiEscapable are the characters that can be escaped with a backslash in
+// Jira wiki markup.
+const wikiEscapable = `*_-+^~?{}[]!|\#()`
+
+// markdownSpecial are the characters escaped in the text rendered as
+// Markdown.
+const markdownSpecial = "\\`*_~[]"
+
+// renderInline converts the text effects, links, images and emoticons of a
+// line of Jira wiki markup. In tables, pipes are escaped and line breaks are
+// replaced with spaces.
+func renderInline(text string, inTable bool) string {
+	var out strings.Builder
+	for i := 0; i < len(text); {
+		rest := text[i:]
+		prev, _ := utf8.DecodeLastRuneInString(text[:i])
+		atWordStart := i == 0 || !isWordRune(prev)
+
+		switch {
+		case strings.HasPrefix(rest, `\\`):
+			if inTable {
+				out.WriteString(" ")
+			} else {
+				out.WriteString("\n")
+			}
+			i += 2
+			if !inTable && i < len(text) && text[i] == '\n' {
+				i++
+			}
+			continue
+
+		case rest[0] == '\\' && len(rest) > 1 && strings.ContainsRune(wikiEscapable, rune(rest[1])):
+			out.WriteString(escapeMarkdown(rest[1:2], inTable))
+			i += 2
+			continue
+
+		case strings.HasPrefix(rest, "{{"):
+			if end := strings.Index(rest[2:], "}}"); end > 0 {
+				out.WriteString(codeSpan(rest[2:2+end], inTable))
+				i += end + 4
+				continue
+			}
+
+		case rest[0] == '{':
+			if m := colorRegex.FindString(rest); m != "" {
+				i += len(m)
+				continue
+			}
+			if m := anchorRegex.FindString(rest); m != "" {
+				i += len(m)
+				continue
+			}
+
+		case rest[0] == '[':
+			if end := strings.IndexAny(rest, "]\n"); end > 0 && rest[end] == ']' {
+				out.WriteString(renderLink(rest[1:end], inTable))
+				i += end + 1
+				continue
+			}
+
+		case rest[0] == '!' && atWordStart:
+			if m := wikiImageRegex.FindStringSubmatch(rest); m != nil {
+				out.WriteString(renderImage(m[1]))
+				i += len(m[0])
+				continue
+			}
+
+		case atWordStart && urlRegex.MatchString(rest):
+			url := urlRegex.FindString(rest)
+			if inTable {
+				url = strings.TrimRight(url, "|")
+			}
+			out.WriteString(url)
+			i += len(url)
+			continue
+		}
+
+		if atWordStart {
+			if emoji, n := matchEmoticon(rest); n > 0 {
+				out.WriteString(emoji)
+				i += n
+				continue
+			}
+			if md, content, n := matchEmphasis(rest); n > 0 && (i == 0 || opensEmphasis(prev)) {
+				inner := renderInline(content, inTable)
+				out.WriteString(md + inner + md)
+				i += n
+				continue
+			}
+		}
+
+		if rest[0] == '>' && (i == 0 || text[i-1] == '\n') {
+			out.WriteString(`\>`)
+			i++
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(rest)
+		next, _ := utf8.DecodeRuneInString(rest[size:])
+		if isLiteralDelimiter(r, prev, next, i == 0) {
+			out.WriteRune(r)
+		} else {
+			out.WriteString(escapeMarkdown(rest[:size], inTable))
+		}
+		i += size
+	}
+	return out.String()
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// opensEmphasis returns true if a text effect can start after the rune, as a
+// space or an opening punctuation, but not the slash of a path.
+func opensEmphasis(prev rune) bool {
+	return unicode.IsSpace(prev) || strings.ContainsRune(`([{"'`, prev)
+}
+
+// isLiteralDelimiter returns true if an emphasis delimiter can not be read
+// as one in Markdown, e.g. the underscores of snake_case or the asterisk of
+// 2 * 3, so that it does not need to be escaped.
+func isLiteralDelimiter(r, prev, next rune, atStart bool) bool {
+	switch r {
+	case '_':
+		return !atStart && isWordRune(prev) && isWordRune(next)
+	case '*', '~':
+		return (atStart || unicode.IsSpace(prev)) && (next == utf8.RuneError || unicode.IsSpace(next))
+	}
+	return false
+}
+
+// escapeLiteral escapes the text so that Markdown renders it as is.
+func escapeLiteral(text string) string {
+	var out strings.Builder
+	var prev rune
+	for i, r := range text {
+		next, _ := utf8.DecodeRuneInString(text[i+utf8.RuneLen(r):])
+		if isLiteralDelimiter(r, prev, next, i == 0) {
+			out.WriteRune(r)
+		} else {
+			out.WriteString(escapeMarkdown(string(r), false))
+		}
+		prev = r
+	}
+	return out.String()
+}
+
+func escapeMarkdown(s string, inTable bool) string {
+	var out strings.Builder
+	for _, r := range s {
+		switch {
+		case strings.ContainsRune(markdownSpecial, r) || (inTable && r == '|'):
+			out.WriteString(`\` + string(r))
+		case inTable && r == '\n':
+			out.WriteString(" ")
+		default:
+			out.WriteRune(r)
+		}
+	}
+	return out.String()
+}
+
+func codeSpan(code string, inTable bool) string {
+	if inTable {
+		code = strings.ReplaceAll(code, "|", `\|`)
+	}
+	if strings.Contains(code, "`") {
+		return "`` " + code + " ``"
+	}
+	return "`" + code + "`"
+}
+
+// matchEmoticon returns the emoji of the emoticon the text starts with, and
+// the length of the emoticon.
+func matchEmoticon(text string) (string, int) {
+	for _, e := range wikiEmoticons {
+		if !strings.HasPrefix(text, e.emoticon) {
+			continue
+		}
+		next, _ := utf8.DecodeRuneInString(text[len(e.emoticon):])
+		if isWordRune(next) {
+			return "", 0
+		}
+		return e.emoji, len(e.emoticon)
+	}
+	return "", 0
+}
+
+// matchEmphasis matches a Jira text effect such as *bold*, at the start of
+// the text. The effect must not start or end with a space, must end before
+// the end of the line, and must not be followed by a letter or digit. It
+// returns the Markdown delimiter, the content and the length of the match.
+func matchEmphasis(text string) (string, string, int) {
+	delim := text[:1]
+	if strings.HasPrefix(text, "??") {
+		delim = "??"
+	}
+	md, ok := wikiEmphasis[delim]
+	if !ok {
+		return "", "", 0
+	}
+
+	body := text[len(delim):]
+	first, _ := utf8.DecodeRuneInString(body)
+	if body == "" || unicode.IsSpace(first) || strings.HasPrefix(body, delim[:1]) {
+		return "", "", 0
+	}
+
+	for j := 1; j < len(body); j++ {
+		if body[j] == '\n' {
+			return "", "", 0
+		}
+		if !strings.HasPrefix(body[j:], delim) {
+			continue
+		}
+		last, _ := utf8.DecodeLastRuneInString(body[:j])
+		next, _ := utf8.DecodeRuneInString(body[j+len(delim):])
+		if unicode.IsSpace(last) || isWordRune(next) {
+			continue
+		}
+		return md, body[:j], len(delim) + j + len(delim)
+	}
+	return "", "", 0
+}
+
+// renderLink converts the content of a Jira link: [url], [text|url],
+// [text|url|tooltip], [^attachment], [#anchor] or a user mention. Links that
+// Markdown cannot express, such as issue keys, are kept as is.
+func renderLink(content string, inTable bool) string {
+	switch {
+	case strings.HasPrefix(content, "~"):
+		return "[" + content + "]"
+	case strings.HasPrefix(content, "^"):
+		return ":paperclip: " + escapeMarkdown(content[1:], inTable)
+	case strings.HasPrefix(content, "#"):
+		return renderInline(content[1:], inTable)
+	}
+
+	parts := strings.Split(content, "|")
+	text, target := "", parts[0]
+	if len(parts) > 1 {
+		text, target = parts[0], parts[1]
+	}
+	target = strings.TrimSpace(target)
+
+	switch {
+	case strings.HasPrefix(target, "mailto:"):
+		if text == "" {
+			text = strings.TrimPrefix(target, "mailto:")
+		}
+	case strings.HasPrefix(target, "^"):
+		return ":paperclip: " + renderInline(text, inTable)
+	case !isURL(target):
+		if text != "" {
+			return renderInline(text, inTable)
+		}
+		return "[" + content + "]"
+	}
+
+	if strings.TrimSpace(text) == "" {
+		text = target
+	}
+	return "[" + renderInline(strings.TrimSpace(text), inTable) + "](" + markdownURL(target) + ")"
+}
+
+func renderImage(target string) string {
+	if !isURL(target) {
+		return ":paperclip: " + escapeMarkdown(target, false)
+	}
+	return "![" + path.Base(target) + "](" + markdownURL(target) + ")"
+}
+
+func isURL(s string) bool {
+	for _, scheme := range []string{"http://", "https://", "ftp://", "mailto:", "file:"} {
+		if strings.HasPrefix(s, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+func markdownURL(url string) string {
+	if strings.ContainsAny(url, " ()") {
+		return "<" + url + ">"
+	}
+	return url
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package markup
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestWikiToMarkdown(t *testing.T) {
+	for name, tc := range map[string]struct {
+		wiki     string
+		expected string
+	}{
+		"bold":                  {wiki: "a *bold text* b", expected: "a **bold text** b"},
+		"italic":                {wiki: "_italic_", expected: "_italic_"},
+		"strikethrough":         {wiki: "-gone-", expected: "~~gone~~"},
+		"underline":             {wiki: "+under+", expected: "under"},
+		"citation":              {wiki: "??cite??", expected: "_cite_"},
+		"nested effects":        {wiki: "*bold _italic_*", expected: "**bold _italic_**"},
+		"not an effect":         {wiki: "2 * 3 * 4 and well-known-thing", expected: "2 * 3 * 4 and well-known-thing"},
+		"unterminated effect":   {wiki: "*bold", expected: `\*bold`},
+		"snake case":            {wiki: "run make_build_all", expected: "run make_build_all"},
+		"path":                  {wiki: "open path/to/-file-name- and a/*b*", expected: "open path/to/-file-name- and a/\\*b\\*"},
+		"effect in parentheses": {wiki: "(*bold*) \"_quoted_\"", expected: "(**bold**) \"_quoted_\""},
+		"escaped":               {wiki: `\*not bold\*`, expected: `\*not bold\*`},
+		"monospace":             {wiki: "run {{go test ./...}}", expected: "run `go test ./...`"},
+		"monospace backtick":    {wiki: "{{a`b}}", expected: "`` a`b ``"},
+		"line break":            {wiki: `one\\two`, expected: "one\ntwo"},
+		"color":                 {wiki: "{color:#ff5630}RED{color} {color:blue}BLUE{color}", expected: "RED BLUE"},
+		"link":                  {wiki: "[google|http://www.google.com]", expected: "[google](http://www.google.com)"},
+		"link with tooltip":     {wiki: "[google|http://www.google.com|Search]", expected: "[google](http://www.google.com)"},
+		"smart link":            {wiki: "[http://www.google.com|http://www.google.com|smart-link]", expected: "[http://www.google.com](http://www.google.com)"},
+		"bare link":             {wiki: "[http://www.google.com]", expected: "[http://www.google.com](http://www.google.com)"},
+		"mail link":             {wiki: "[mailto:a@b.com]", expected: "[a@b.com](mailto:a@b.com)"},
+		"link with spaces":      {wiki: "[doc|http://a.com/x (1)]", expected: "[doc](<http://a.com/x (1)>)"},
+		"issue link":            {wiki: "[MM-123]", expected: "[MM-123]"},
+		"anchor link":           {wiki: "[#summary]", expected: "summary"},
+		"attachment link":       {wiki: "[^logs.txt]", expected: ":paperclip: logs.txt"},
+		"mention":               {wiki: "hi [~accountid:5c5f88] and [~jdoe]", expected: "hi [~accountid:5c5f88] and [~jdoe]"},
+		"url":                   {wiki: "see https://a.com/b_c_d", expected: "see https://a.com/b_c_d"},
+		"attached image":        {wiki: "!screen shot.png|thumbnail!", expected: ":paperclip: screen shot.png"},
+		"remote image":          {wiki: "!https://a.com/i/logo.png!", expected: "![logo.png](https://a.com/i/logo.png)"},
+		"exclamation marks":     {wiki: "Wow! Great!", expected: "Wow! Great!"},
+		"emoticons":             {wiki: ":) :( (y) (n) (i) (/) (x) (!) <3", expected: ":slightly_smiling_face: :slightly_frowning_face: :thumbsup: :thumbsdown: :information_source: :white_check_mark: :x: :warning: :heart:"},
+		"not an emoticon":       {wiki: "Note:Pending f(x)", expected: "Note:Pending f(x)"},
+		"heading":               {wiki: "h3. Title", expected: "### Title"},
+		"headings":              {wiki: "h1. 1\nh2. 2\nh3. 3\nh4. 4\nh5. 5\nh6. 6", expected: "# 1\n## 2\n### 3\n#### 4\n##### 5\n###### 6"},
+		"paragraphs":            {wiki: "one\ntwo\n\n\nthree", expected: "one\ntwo\n\nthree"},
+		"quote line":            {wiki: "> not a quote", expected: `\> not a quote`},
+		"bq":                    {wiki: "bq. quoted", expected: "> quoted"},
+		"quote":                 {wiki: "{quote}This is a quote{quote}", expected: "> This is a quote"},
+		"multiline quote":       {wiki: "{quote}\none\n\ntwo\n{quote}", expected: "> one\n>\n> two"},
+		"code":                  {wiki: "{code:go}fruit := \"APPLE\"{code}", expected: "```go\nfruit := \"APPLE\"\n```"},
+		"code with title":       {wiki: "{code:title=Main.java|borderStyle=solid}\nclass Main {}\n{code}", expected: "**Main.java**\n```\nclass Main {}\n```"},
+		"code with language":    {wiki: "{code:language=sql|title=q}\nSELECT 1\n{code}", expected: "**q**\n```sql\nSELECT 1\n```"},
+		"code with fence":       {wiki: "{code}\n```\n{code}", expected: "````\n```\n````"},
+		"code in a paragraph":   {wiki: "run {code}make{code} now", expected: "run\n```\nmake\n```\nnow"},
+		"unterminated code":     {wiki: "{code}x", expected: "{code}x"},
+		"noformat":              {wiki: "{noformat}\n*raw*\n{noformat}", expected: "```\n*raw*\n```"},
+		"panel":                 {wiki: "{panel:title=Note}\ntext\n{panel}", expected: "> **Note**\n> text"},
+		"warning":               {wiki: "{warning}careful{warning}", expected: "> :warning: careful"},
+		"rule":                  {wiki: "a\n----\nb", expected: "a\n---\nb"},
+		"bullet list":           {wiki: "* one\n* two", expected: "* one\n* two"},
+		"dash list":             {wiki: "- one\n- two", expected: "* one\n* two"},
+		"nested bullet list":    {wiki: "* one\n** two\n*** three\n* four", expected: "* one\n  * two\n    * three\n* four"},
+		"numbered list":         {wiki: "# one\n# two\n## a\n## b\n# three", expected: "1. one\n2. two\n   1. a\n   2. b\n3. three"},
+		"mixed list":            {wiki: "# one\n#* a\n#* b\n# two", expected: "1. one\n   * a\n   * b\n2. two"},
+		"list after a gap":      {wiki: "# one\n\n# one again", expected: "1. one\n\n1. one again"},
+		"long numbered list":    {wiki: strings.Repeat("# item\n", 10) + "## sub", expected: "1. item\n2. item\n3. item\n4. item\n5. item\n6. item\n7. item\n8. item\n9. item\n10. item\n    1. sub"},
+		"list then paragraph":   {wiki: "# one\n# two\nnon-numbered list text\n# one", expected: "1. one\n2. two\n\nnon-numbered list text\n1. one"},
+		"table":                 {wiki: "||a||b||\n|1|2|", expected: "| a | b |\n| --- | --- |\n| 1 | 2 |"},
+		"table without header":  {wiki: "|1|2|\n|3|", expected: "| | |\n| --- | --- |\n| 1 | 2 |\n| 3 | |"},
+		"table with header col": {wiki: "||a|1|", expected: "| | |\n| --- | --- |\n| **a** | 1 |"},
+		"table with pipes":      {wiki: "||a||\n|[x|http://y.com] {{p|q}} \\| r|", expected: "| a |\n| --- |\n| [x](http://y.com) `p\\|q` \\| r |"},
+		"paragraph then table":  {wiki: "text\n||a||", expected: "text\n\n| a |\n| --- |"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, WikiToMarkdown(tc.wiki))
+		})
+	}
+}
+
+func TestWikiToMarkdownGolden(t *testing.T) {
+	testGolden(t, "testdata/*.wiki", ".md", WikiToMarkdown)
+}
+
+// testGolden converts the files matching the pattern, and compares the
+// result with the file of the same name with the golden extension. Run the
+// tests with -update to write the golden files.
+func testGolden(t *testing.T, pattern, goldenExt string, convert func(string) string) {
+	files, err := filepath.Glob(pattern)
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			in, err := os.ReadFile(file)
+			require.NoError(t, err)
+			actual := convert(string(in)) + "\n"
+
+			golden := strings.TrimSuffix(file, filepath.Ext(file)) + goldenExt
+			if *update {
+				require.NoError(t, os.WriteFile(golden, []byte(actual), 0600))
+			}
+			expected, err := os.ReadFile(golden)
+			require.NoError(t, err)
+			assert.Equal(t, string(expected), actual)
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"bytes"
+
+	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
 	"github.com/stretchr/testify/mock"
 )
@@ -13,15 +16,8 @@ func makeTestKVStore(api *plugintest.API, initialValues testKVStore) testKVStore
 		testStore = testKVStore{}
 	}
 
-	kvCall := api.On("KVGet", mock.Anything).Maybe().Return(nil, nil)
-	kvCall.Run(func(args mock.Arguments) {
-		key := args.Get(0).(string)
-		val, ok := testStore[key]
-		if ok {
-			kvCall.Return(val, nil)
-		} else {
-			kvCall.Return(nil, nil)
-		}
+	api.On("KVGet", mock.Anything).Maybe().Return(func(key string) ([]byte, *model.AppError) {
+		return testStore[key], nil
 	})
 
 	api.On("KVSet", mock.Anything, mock.Anything).Maybe().Return(nil).Run(func(args mock.Arguments) {
@@ -30,11 +26,14 @@ func makeTestKVStore(api *plugintest.API, initialValues testKVStore) testKVStore
 		testStore[key] = value
 	})
 
-	api.On("KVSetWithOptions", mock.AnythingOfType("string"), mock.Anything, mock.AnythingOfType("model.PluginKVSetOptions")).Return(true, nil).Run(func(args mock.Arguments) {
-		key := args.Get(0).(string)
-		value := args.Get(1).([]byte)
-		testStore[key] = value
-	})
+	api.On("KVSetWithOptions", mock.AnythingOfType("string"), mock.Anything, mock.AnythingOfType("model.PluginKVSetOptions")).Return(
+		func(key string, value []byte, options model.PluginKVSetOptions) (bool, *model.AppError) {
+			if options.Atomic && !bytes.Equal(testStore[key], options.OldValue) {
+				return false, nil
+			}
+			testStore[key] = value
+			return true, nil
+		})
 
 	return testStore
 }
@@ -0,0 +1,473 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/utils/kvstore"
+	"github.com/mattermost/mattermost-plugin-jira/server/utils/types"
+)
+
+const (
+	DeliveryModeInstant = "instant"
+	DeliveryModeHourly  = "hourly"
+	DeliveryModeDaily   = "daily"
+	DeliveryModeWeekly  = "weekly"
+
+	defaultDeliveryTime = "09:00"
+	defaultDeliveryDay  = "monday"
+
+	keyDigestIndex = "digest_index"
+	prefixDigest   = "digest_"
+	digestJobKey   = "digest_job"
+
+	// DigestJobInterval is how often the buffered notifications are checked
+	// for digests that are due.
+	DigestJobInterval = 5 * time.Minute
+
+	// digestMaxNotifications bounds the size of a digest in the KV store. The
+	// oldest notifications are dropped past that.
+	digestMaxNotifications = 200
+
+	// digestMaxMessageSize keeps the posts of a digest under the maximum size
+	// of a Mattermost post.
+	digestMaxMessageSize = 16000
+
+	// digestMaxAttempts is how many runs of the digest job try to deliver a
+	// digest before it is given up.
+	digestMaxAttempts = 12
+)
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+type DigestStore interface {
+	AddToDigest(key string, n digestNotification, newDigest func() *notificationDigest) error
+	ClaimDigest(key string, isDue func(*notificationDigest) bool) (*notificationDigest, error)
+	RequeueDigest(key string, digest *notificationDigest) error
+	ListDigestKeys() ([]string, error)
+	PruneDigestIndex(key string) error
+}
+
+// notificationDigest holds the notifications of a user waiting to be
+// delivered together.
+type notificationDigest struct {
+	InstanceID       types.ID             `json:"instance_id"`
+	MattermostUserID types.ID             `json:"mattermost_user_id"`
+	Mode             string               `json:"mode"`
+	NextDelivery     int64                `json:"next_delivery"`
+	Dropped          int                  `json:"dropped,omitempty"`
+	Attempts         int                  `json:"attempts,omitempty"`
+	Notifications    []digestNotification `json:"notifications"`
+}
+
+type digestNotification struct {
+	IssueKey  string `json:"issue_key"`
+	IssueLink string `json:"issue_link"`
+	Message   string `json:"message"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// digestMessage is a post of a digest, with the notifications it delivers.
+type digestMessage struct {
+	Text          string
+	Notifications []digestNotification
+}
+
+func digestKey(instanceID, mattermostUserID types.ID) string {
+	return hashkey(prefixDigest, instanceID.String()+"/"+mattermostUserID.String())
+}
+
+func (s *ConnectionSettings) isDigest() bool {
+	if s == nil {
+		return false
+	}
+	switch s.DeliveryMode {
+	case DeliveryModeHourly, DeliveryModeDaily, DeliveryModeWeekly:
+		return true
+	}
+	return false
+}
+
+func (s *ConnectionSettings) deliveryString() string {
+	switch {
+	case !s.isDigest():
+		return DeliveryModeInstant
+	case s.DeliveryMode == DeliveryModeHourly:
+		return "hourly digest"
+	case s.DeliveryMode == DeliveryModeDaily:
+		return fmt.Sprintf("daily digest at %s", s.deliveryTime())
+	default:
+		return fmt.Sprintf("weekly digest on %s at %s", s.deliveryDay(), s.deliveryTime())
+	}
+}
+
+func (s *ConnectionSettings) deliveryTime() string {
+	if s.DeliveryTime == "" {
+		return defaultDeliveryTime
+	}
+	return s.DeliveryTime
+}
+
+func (s *ConnectionSettings) deliveryDay() string {
+	if s.DeliveryDay == "" {
+		return defaultDeliveryDay
+	}
+	return s.DeliveryDay
+}
+
+// nextDigestDelivery returns when the digest that starts now is due, in the
+// timezone of the user.
+func (s *ConnectionSettings) nextDigestDelivery(now time.Time, loc *time.Location) time.Time {
+	now = now.In(loc)
+	if s.DeliveryMode == DeliveryModeHourly {
+		return time.Date(now.Year(), now.Month(), now.Day(), now.Hour()+1, 0, 0, 0, loc)
+	}
+
+	hour, minute, _ := parseDeliveryTime(s.deliveryTime())
+	next := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, loc)
+	if s.DeliveryMode == DeliveryModeWeekly {
+		weekday := weekdays[s.deliveryDay()]
+		for next.Weekday() != weekday || !next.After(now) {
+			next = next.AddDate(0, 0, 1)
+		}
+		return next
+	}
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+func parseDeliveryTime(value string) (int, int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, 0, errors.Errorf("`%s` is not a valid time, use HH:MM", value)
+	}
+	return t.Hour(), t.Minute(), nil
+}
+
+// addToDigest buffers a notification for the next digest of the user.
+func (p *Plugin) addToDigest(instanceID, mattermostUserID types.ID, settings *ConnectionSettings, wh *webhook, message string) error {
+	return p.digestStore.AddToDigest(digestKey(instanceID, mattermostUserID), newDigestNotification(wh, message), func() *notificationDigest {
+		return &notificationDigest{
+			InstanceID:       instanceID,
+			MattermostUserID: mattermostUserID,
+			Mode:             settings.DeliveryMode,
+			NextDelivery:     settings.nextDigestDelivery(time.Now(), p.userLocation(mattermostUserID)).UnixMilli(),
+		}
+	})
+}
+
+func newDigestNotification(wh *webhook, message string) digestNotification {
+	return digestNotification{
+		IssueKey:  wh.Issue.Key,
+		IssueLink: wh.mdKeySummaryLink(),
+		Message:   message,
+		CreatedAt: time.Now().UnixMilli(),
+	}
+}
+
+// userLocation returns the timezone of the Mattermost user, or UTC.
+func (p *Plugin) userLocation(mattermostUserID types.ID) *time.Location {
+	user, err := p.client.User.Get(mattermostUserID.String())
+	if err != nil {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(user.GetPreferredTimezone())
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// deliverDigests is run periodically by a cluster job. Each digest that is
+// due is claimed atomically, so that it is delivered once even if the job
+// runs on several servers. The notifications that could not be posted are
+// queued again for the next run. Digests and held notifications are not
+// delivered while the user is in their quiet hours, or does not want to be
+// disturbed.
+func (p *Plugin) deliverDigests() {
+	keys, err := p.digestStore.ListDigestKeys()
+	if err != nil {
+		p.errorf("deliverDigests: failed to list digests: %v", err)
+		return
+	}
+
+	now := time.Now()
+	isDue := func(digest *notificationDigest) bool {
+		if digest.NextDelivery > now.UnixMilli() {
+			return false
+		}
+		c, err := p.userStore.LoadConnection(digest.InstanceID, digest.MattermostUserID)
+		if err != nil {
+			return true
+		}
+		_, quiet := p.quietUntil(digest.MattermostUserID, c.Settings, now)
+		return !quiet
+	}
+
+	for _, key := range keys {
+		digest, err := p.digestStore.ClaimDigest(key, isDue)
+		if errors.Cause(err) == kvstore.ErrNotFound {
+			err = p.digestStore.PruneDigestIndex(key)
+		}
+		if err != nil {
+			p.errorf("deliverDigests: failed to claim digest %s: %v", key, err)
+			continue
+		}
+		if digest == nil {
+			continue
+		}
+
+		err = p.digestStore.PruneDigestIndex(key)
+		if err != nil {
+			p.errorf("deliverDigests: failed to update the digest index: %v", err)
+		}
+
+		var messages []digestMessage
+		if digest.Mode == deliveryModeHeld {
+			messages = makeHeldMessages(digest)
+		} else {
+			messages = makeDigestMessages(digest)
+		}
+		p.postDigestMessages(key, digest, messages)
+	}
+}
+
+// postDigestMessages posts the messages of a claimed digest. If a post
+// fails, the notifications of the messages that were not posted are queued
+// again.
+func (p *Plugin) postDigestMessages(key string, digest *notificationDigest, messages []digestMessage) {
+	for i, message := range messages {
+		_, err := p.CreateBotDMPost(digest.InstanceID, digest.MattermostUserID, message.Text, PostTypeDigest)
+		if err == nil {
+			continue
+		}
+
+		p.errorf("deliverDigests: failed to post the digest of user %s: %v", digest.MattermostUserID, err)
+		if digest.Attempts+1 >= digestMaxAttempts {
+			p.errorf("deliverDigests: giving up the digest of user %s after %d attempts", digest.MattermostUserID, digestMaxAttempts)
+			return
+		}
+
+		requeued := *digest
+		requeued.Attempts++
+		requeued.Notifications = nil
+		for _, m := range messages[i:] {
+			requeued.Notifications = append(requeued.Notifications, m.Notifications...)
+		}
+		if i > 0 {
+			// The first message, which reports the dropped notifications, was posted.
+			requeued.Dropped = 0
+		}
+		err = p.digestStore.RequeueDigest(key, &requeued)
+		if err != nil {
+			p.errorf("deliverDigests: failed to queue the digest of user %s again: %v", digest.MattermostUserID, err)
+		}
+		return
+	}
+}
+
+// groupDigestNotifications groups the notifications of the digest by issue,
+// in the order the issues were first notified.
+func groupDigestNotifications(digest *notificationDigest) [][]digestNotification {
+	keys := []string{}
+	byIssue := map[string][]digestNotification{}
+	for _, n := range digest.Notifications {
+		if _, ok := byIssue[n.IssueKey]; !ok {
+			keys = append(keys, n.IssueKey)
+		}
+		byIssue[n.IssueKey] = append(byIssue[n.IssueKey], n)
+	}
+
+	groups := [][]digestNotification{}
+	for _, key := range keys {
+		groups = append(groups, byIssue[key])
+	}
+	return groups
+}
+
+func updatesString(n int) string {
+	if n == 1 {
+		return "1 update"
+	}
+	return fmt.Sprintf("%d updates", n)
+}
+
+// makeDigestMessages returns a message per issue of the digest. The
+// notifications of an issue are split in several messages if they do not fit
+// in one post.
+func makeDigestMessages(digest *notificationDigest) []digestMessage {
+	title := "Jira digest"
+	if len(digest.Mode) > 0 {
+		title = strings.ToUpper(digest.Mode[:1]) + digest.Mode[1:] + " Jira digest"
+	}
+
+	messages := []digestMessage{}
+	for i, notifications := range groupDigestNotifications(digest) {
+		header := fmt.Sprintf("##### %s: %s (%s)\n", title, notifications[0].IssueLink, updatesString(len(notifications)))
+		if i == 0 && digest.Dropped > 0 {
+			header += fmt.Sprintf("_%d older notifications were dropped from this digest._\n", digest.Dropped)
+		}
+		messages = appendDigestMessages(messages, header, notifications)
+	}
+	return messages
+}
+
+// appendDigestMessages appends the notifications to messages starting with
+// header, as few as fit in digestMaxMessageSize. A notification too long for
+// a post of its own is truncated.
+func appendDigestMessages(messages []digestMessage, header string, notifications []digestNotification) []digestMessage {
+	message := digestMessage{Text: header}
+	for _, n := range notifications {
+		text := "\n" + truncate(n.Message, digestMaxMessageSize-len(header)-2) + "\n"
+		if len(message.Notifications) > 0 && len(message.Text)+len(text) > digestMaxMessageSize {
+			messages = append(messages, message)
+			message = digestMessage{Text: header}
+		}
+		message.Text += text
+		message.Notifications = append(message.Notifications, n)
+	}
+	return append(messages, message)
+}
+
+func (store store) AddToDigest(key string, n digestNotification, newDigest func() *notificationDigest) error {
+	err := store.plugin.client.KV.SetAtomicWithRetries(key, func(initialBytes []byte) (interface{}, error) {
+		var digest *notificationDigest
+		if len(initialBytes) == 0 {
+			digest = newDigest()
+		} else {
+			digest = &notificationDigest{}
+			err := json.Unmarshal(initialBytes, digest)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		digest.Notifications = append(digest.Notifications, n)
+		if extra := len(digest.Notifications) - digestMaxNotifications; extra > 0 {
+			digest.Notifications = digest.Notifications[extra:]
+			digest.Dropped += extra
+		}
+		return digest, nil
+	})
+	if err != nil {
+		return errors.WithMessage(err, "failed to store the notification in the digest")
+	}
+
+	return store.updateIDIndex(keyDigestIndex, func(keys StringSet) StringSet {
+		return keys.Add(key)
+	})
+}
+
+// ClaimDigest atomically removes a digest that is due for delivery, and
+// returns it. It returns kvstore.ErrNotFound if the digest does not exist, or
+// nil if it is not due or was changed concurrently.
+func (store store) ClaimDigest(key string, isDue func(*notificationDigest) bool) (*notificationDigest, error) {
+	var data []byte
+	err := store.plugin.client.KV.Get(key, &data)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errors.Wrap(kvstore.ErrNotFound, key)
+	}
+
+	digest := &notificationDigest{}
+	err = json.Unmarshal(data, digest)
+	if err != nil {
+		return nil, err
+	}
+	if !isDue(digest) {
+		return nil, nil
+	}
+
+	deleted, err := store.plugin.client.KV.Set(key, nil, pluginapi.SetAtomic(data))
+	if err != nil {
+		return nil, err
+	}
+	if !deleted {
+		return nil, nil
+	}
+	return digest, nil
+}
+
+// RequeueDigest stores the notifications of a digest that could not be
+// delivered, before the notifications received since it was claimed.
+func (store store) RequeueDigest(key string, requeued *notificationDigest) error {
+	err := store.plugin.client.KV.SetAtomicWithRetries(key, func(initialBytes []byte) (interface{}, error) {
+		digest := *requeued
+		if len(initialBytes) != 0 {
+			current := &notificationDigest{}
+			err := json.Unmarshal(initialBytes, current)
+			if err != nil {
+				return nil, err
+			}
+			digest.Notifications = append(append([]digestNotification{}, requeued.Notifications...), current.Notifications...)
+			digest.Dropped += current.Dropped
+		}
+
+		if extra := len(digest.Notifications) - digestMaxNotifications; extra > 0 {
+			digest.Notifications = digest.Notifications[extra:]
+			digest.Dropped += extra
+		}
+		return &digest, nil
+	})
+	if err != nil {
+		return errors.WithMessage(err, "failed to queue the digest again")
+	}
+
+	return store.updateIDIndex(keyDigestIndex, func(keys StringSet) StringSet {
+		return keys.Add(key)
+	})
+}
+
+func (store store) ListDigestKeys() ([]string, error) {
+	keys := NewStringSet()
+	err := store.plugin.client.KV.Get(keyDigestIndex, &keys)
+	if err != nil {
+		return nil, err
+	}
+	return keys.Elems(), nil
+}
+
+// PruneDigestIndex removes the key from the digest index, unless a new
+// digest was started for it in the meantime.
+func (store store) PruneDigestIndex(key string) error {
+	var data []byte
+	return store.plugin.client.KV.SetAtomicWithRetries(keyDigestIndex, func(initialBytes []byte) (interface{}, error) {
+		keys := NewStringSet()
+		if len(initialBytes) != 0 {
+			err := json.Unmarshal(initialBytes, &keys)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err := store.plugin.client.KV.Get(key, &data)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) != 0 {
+			return keys, nil
+		}
+		return keys.Subtract(key), nil
+	})
+}
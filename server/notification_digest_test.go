@@ -0,0 +1,257 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest/mock"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNextDigestDelivery(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	// Wednesday
+	now := time.Date(2024, 3, 13, 10, 30, 0, 0, time.UTC)
+
+	for name, tc := range map[string]struct {
+		settings ConnectionSettings
+		loc      *time.Location
+		expected time.Time
+	}{
+		"hourly": {
+			settings: ConnectionSettings{DeliveryMode: DeliveryModeHourly},
+			loc:      time.UTC,
+			expected: time.Date(2024, 3, 13, 11, 0, 0, 0, time.UTC),
+		},
+		"daily, later today": {
+			settings: ConnectionSettings{DeliveryMode: DeliveryModeDaily, DeliveryTime: "17:00"},
+			loc:      time.UTC,
+			expected: time.Date(2024, 3, 13, 17, 0, 0, 0, time.UTC),
+		},
+		"daily, tomorrow": {
+			settings: ConnectionSettings{DeliveryMode: DeliveryModeDaily},
+			loc:      time.UTC,
+			expected: time.Date(2024, 3, 14, 9, 0, 0, 0, time.UTC),
+		},
+		"daily, in the timezone of the user": {
+			settings: ConnectionSettings{DeliveryMode: DeliveryModeDaily, DeliveryTime: "12:00"},
+			loc:      berlin,
+			expected: time.Date(2024, 3, 13, 11, 0, 0, 0, time.UTC),
+		},
+		"weekly, next week": {
+			settings: ConnectionSettings{DeliveryMode: DeliveryModeWeekly},
+			loc:      time.UTC,
+			expected: time.Date(2024, 3, 18, 9, 0, 0, 0, time.UTC),
+		},
+		"weekly, later today": {
+			settings: ConnectionSettings{DeliveryMode: DeliveryModeWeekly, DeliveryTime: "18:30", DeliveryDay: "wednesday"},
+			loc:      time.UTC,
+			expected: time.Date(2024, 3, 13, 18, 30, 0, 0, time.UTC),
+		},
+		"weekly, earlier today": {
+			settings: ConnectionSettings{DeliveryMode: DeliveryModeWeekly, DeliveryTime: "08:00", DeliveryDay: "wednesday"},
+			loc:      time.UTC,
+			expected: time.Date(2024, 3, 20, 8, 0, 0, 0, time.UTC),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			next := tc.settings.nextDigestDelivery(now, tc.loc)
+			assert.True(t, tc.expected.Equal(next), "expected %v, got %v", tc.expected, next)
+		})
+	}
+}
+
+func dueAt(now time.Time) func(*notificationDigest) bool {
+	return func(digest *notificationDigest) bool {
+		return digest.NextDelivery <= now.UnixMilli()
+	}
+}
+
+func TestDigestStore(t *testing.T) {
+	p := &Plugin{}
+	api := &plugintest.API{}
+	makeTestKVStore(api, nil)
+	p.SetAPI(api)
+	p.client = pluginapi.NewClient(api, p.Driver)
+	store := NewStore(p)
+
+	now := time.Now()
+	key := digestKey(testInstance1.InstanceID, "user1")
+	newDigest := func() *notificationDigest {
+		return &notificationDigest{
+			InstanceID:       testInstance1.InstanceID,
+			MattermostUserID: "user1",
+			Mode:             DeliveryModeDaily,
+			NextDelivery:     now.Add(time.Hour).UnixMilli(),
+		}
+	}
+	for _, issueKey := range []string{"TES-1", "TES-2", "TES-1"} {
+		require.NoError(t, store.AddToDigest(key, digestNotification{IssueKey: issueKey, IssueLink: "[" + issueKey + "]", Message: "update of " + issueKey}, newDigest))
+	}
+
+	keys, err := store.ListDigestKeys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{key}, keys)
+
+	// Not due yet
+	digest, err := store.ClaimDigest(key, dueAt(now))
+	require.NoError(t, err)
+	assert.Nil(t, digest)
+	require.NoError(t, store.PruneDigestIndex(key))
+	keys, err = store.ListDigestKeys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{key}, keys, "the index must be kept while the digest exists")
+
+	digest, err = store.ClaimDigest(key, dueAt(now.Add(2*time.Hour)))
+	require.NoError(t, err)
+	require.NotNil(t, digest)
+	assert.Len(t, digest.Notifications, 3)
+
+	// Claimed only once
+	_, err = store.ClaimDigest(key, dueAt(now.Add(2*time.Hour)))
+	assert.Error(t, err)
+
+	require.NoError(t, store.PruneDigestIndex(key))
+	keys, err = store.ListDigestKeys()
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	messages := makeDigestMessages(digest)
+	require.Len(t, messages, 2)
+	assert.Equal(t, "##### Daily Jira digest: [TES-1] (2 updates)\n\nupdate of TES-1\n\nupdate of TES-1\n", messages[0].Text)
+	assert.Equal(t, "##### Daily Jira digest: [TES-2] (1 update)\n\nupdate of TES-2\n", messages[1].Text)
+}
+
+func TestDigestMaxNotifications(t *testing.T) {
+	p := &Plugin{}
+	api := &plugintest.API{}
+	makeTestKVStore(api, nil)
+	p.SetAPI(api)
+	p.client = pluginapi.NewClient(api, p.Driver)
+	store := NewStore(p)
+
+	key := digestKey(testInstance1.InstanceID, "user1")
+	for i := 0; i < digestMaxNotifications+3; i++ {
+		require.NoError(t, store.AddToDigest(key, digestNotification{IssueKey: "TES-1"}, func() *notificationDigest {
+			return &notificationDigest{Mode: DeliveryModeHourly}
+		}))
+	}
+
+	digest, err := store.ClaimDigest(key, dueAt(time.Now()))
+	require.NoError(t, err)
+	assert.Len(t, digest.Notifications, digestMaxNotifications)
+	assert.Equal(t, 3, digest.Dropped)
+	assert.Contains(t, makeDigestMessages(digest)[0].Text, "_3 older notifications were dropped from this digest._")
+}
+
+func TestMakeDigestMessagesSize(t *testing.T) {
+	long := strings.Repeat("a", digestMaxMessageSize/3)
+	digest := &notificationDigest{Mode: DeliveryModeDaily}
+	for i := 0; i < 5; i++ {
+		digest.Notifications = append(digest.Notifications, digestNotification{IssueKey: "TES-1", IssueLink: "[TES-1]", Message: long})
+	}
+	digest.Notifications = append(digest.Notifications, digestNotification{IssueKey: "TES-2", IssueLink: "[TES-2]", Message: strings.Repeat("b", 2*digestMaxMessageSize)})
+
+	messages := makeDigestMessages(digest)
+	require.Len(t, messages, 4)
+	count := 0
+	for _, m := range messages {
+		assert.LessOrEqual(t, len(m.Text), digestMaxMessageSize)
+		count += len(m.Notifications)
+	}
+	assert.Equal(t, len(digest.Notifications), count)
+	assert.Len(t, messages[0].Notifications, 2)
+	assert.Len(t, messages[1].Notifications, 2)
+	assert.Len(t, messages[2].Notifications, 1)
+	assert.True(t, strings.HasPrefix(messages[3].Text, "##### Daily Jira digest: [TES-2] (1 update)\n"))
+}
+
+func TestPostDigestMessages(t *testing.T) {
+	for name, tc := range map[string]struct {
+		failAt   int
+		attempts int
+		requeued []string
+	}{
+		"all posted":                   {failAt: -1},
+		"first post fails":             {failAt: 0, requeued: []string{"TES-1", "TES-1", "TES-2", "TES-3"}},
+		"second post fails":            {failAt: 1, requeued: []string{"TES-2", "TES-3"}},
+		"given up after many attempts": {failAt: 0, attempts: digestMaxAttempts - 1},
+		"new notifications are kept":   {failAt: 2, requeued: []string{"TES-3"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := &Plugin{}
+			p.updateConfig(func(conf *config) {
+				conf.botUserID = "bot"
+			})
+			api := &plugintest.API{}
+			api.On("LogError", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe()
+			makeTestKVStore(api, nil)
+			api.On("GetDirectChannel", mockUserIDWithNotifications, "bot").Return(&model.Channel{Id: "dm"}, nil)
+			posted := 0
+			api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(func(post *model.Post) *model.Post {
+				return post.Clone()
+			}, func(post *model.Post) *model.AppError {
+				defer func() { posted++ }()
+				if posted == tc.failAt {
+					return model.NewAppError("CreatePost", "app.post.save.app_error", nil, "", 500)
+				}
+				return nil
+			})
+			p.SetAPI(api)
+			p.client = pluginapi.NewClient(api, p.Driver)
+			p.userStore = getMockUserStoreKV()
+			store := NewStore(p)
+			p.digestStore = store
+
+			key := digestKey(testInstance1.InstanceID, mockUserIDWithNotifications)
+			digest := &notificationDigest{
+				InstanceID:       testInstance1.InstanceID,
+				MattermostUserID: mockUserIDWithNotifications,
+				Mode:             DeliveryModeDaily,
+				Dropped:          1,
+				Attempts:         tc.attempts,
+			}
+			for _, issueKey := range []string{"TES-1", "TES-2", "TES-1", "TES-3"} {
+				digest.Notifications = append(digest.Notifications, digestNotification{IssueKey: issueKey, IssueLink: "[" + issueKey + "]"})
+			}
+			messages := makeDigestMessages(digest)
+			require.Len(t, messages, 3)
+			if name == "new notifications are kept" {
+				require.NoError(t, store.AddToDigest(key, digestNotification{IssueKey: "TES-3"}, func() *notificationDigest {
+					return &notificationDigest{Mode: DeliveryModeDaily}
+				}))
+				tc.requeued = append(tc.requeued, "TES-3")
+			}
+
+			p.postDigestMessages(key, digest, messages)
+
+			requeued, err := store.ClaimDigest(key, dueAt(time.Now()))
+			if tc.requeued == nil {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			issueKeys := []string{}
+			for _, n := range requeued.Notifications {
+				issueKeys = append(issueKeys, n.IssueKey)
+			}
+			assert.Equal(t, tc.requeued, issueKeys)
+			assert.Equal(t, tc.attempts+1, requeued.Attempts)
+			if tc.failAt == 0 {
+				assert.Equal(t, 1, requeued.Dropped)
+			} else {
+				assert.Equal(t, 0, requeued.Dropped)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/utils/types"
+)
+
+const (
+	prefixHeldNotifications = "held_notifications_"
+
+	// deliveryModeHeld is the mode of the digests holding the notifications
+	// of a user during their quiet hours, or while they do not want to be
+	// disturbed.
+	deliveryModeHeld = "held"
+)
+
+func heldNotificationsKey(instanceID, mattermostUserID types.ID) string {
+	return hashkey(prefixHeldNotifications, instanceID.String()+"/"+mattermostUserID.String())
+}
+
+func (s *ConnectionSettings) hasQuietHours() bool {
+	return s != nil && s.QuietHoursStart != "" && s.QuietHoursEnd != ""
+}
+
+// quietHoursEnd returns the end of the quiet hours the user is in at now, in
+// their timezone. The quiet hours may span midnight, e.g. 22:00 to 07:00.
+func (s *ConnectionSettings) quietHoursEnd(now time.Time, loc *time.Location) (time.Time, bool) {
+	if !s.hasQuietHours() {
+		return time.Time{}, false
+	}
+	startHour, startMinute, err := parseDeliveryTime(s.QuietHoursStart)
+	if err != nil {
+		return time.Time{}, false
+	}
+	endHour, endMinute, err := parseDeliveryTime(s.QuietHoursEnd)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	now = now.In(loc)
+	start := time.Date(now.Year(), now.Month(), now.Day(), startHour, startMinute, 0, 0, loc)
+	end := time.Date(now.Year(), now.Month(), now.Day(), endHour, endMinute, 0, 0, loc)
+	if !end.After(start) {
+		if now.Before(end) {
+			return end, true
+		}
+		end = end.AddDate(0, 0, 1)
+	}
+	if now.Before(start) || !now.Before(end) {
+		return time.Time{}, false
+	}
+	return end, true
+}
+
+// quietUntil returns when the user may be notified again, if they are in
+// their quiet hours or their status is do not disturb. A do not disturb
+// status without an end time lasts until the user changes it, so it is
+// checked again on every run of the digest job.
+func (p *Plugin) quietUntil(mattermostUserID types.ID, settings *ConnectionSettings, now time.Time) (time.Time, bool) {
+	until, quiet := settings.quietHoursEnd(now, p.userLocation(mattermostUserID))
+
+	status, err := p.client.User.GetStatus(mattermostUserID.String())
+	if err != nil || status.Status != model.StatusDnd {
+		return until, quiet
+	}
+	if dndEnd := time.Unix(status.DNDEndTime, 0); status.DNDEndTime > 0 && dndEnd.After(until) {
+		until = dndEnd
+	}
+	if until.Before(now) {
+		until = now
+	}
+	return until, true
+}
+
+// bypassesQuietHours returns true if the issue has one of the priorities
+// that are notified even during quiet hours.
+func (p *Plugin) bypassesQuietHours(wh *webhook) bool {
+	if wh.Issue.Fields == nil || wh.Issue.Fields.Priority == nil {
+		return false
+	}
+	for _, priority := range strings.Split(p.getConfig().QuietHoursBypassPriorities, ",") {
+		priority = strings.TrimSpace(priority)
+		if priority != "" && strings.EqualFold(priority, wh.Issue.Fields.Priority.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// holdNotification keeps a notification until the user may be disturbed
+// again. The held notifications are delivered as one summary by the digest
+// job.
+func (p *Plugin) holdNotification(instanceID, mattermostUserID types.ID, until time.Time, wh *webhook, message string) error {
+	return p.digestStore.AddToDigest(heldNotificationsKey(instanceID, mattermostUserID), newDigestNotification(wh, message), func() *notificationDigest {
+		return &notificationDigest{
+			InstanceID:       instanceID,
+			MattermostUserID: mattermostUserID,
+			Mode:             deliveryModeHeld,
+			NextDelivery:     until.UnixMilli(),
+		}
+	})
+}
+
+// makeHeldMessages returns the summary of the held notifications, grouped by
+// issue. The summary is split in several messages if it does not fit in one
+// post.
+func makeHeldMessages(digest *notificationDigest) []digestMessage {
+	title := "##### Jira notifications received while you were away\n"
+	message := digestMessage{Text: title}
+	if digest.Dropped > 0 {
+		message.Text += fmt.Sprintf("_%d older notifications were dropped from this summary._\n", digest.Dropped)
+	}
+
+	messages := []digestMessage{}
+	for _, notifications := range groupDigestNotifications(digest) {
+		issueHeader := fmt.Sprintf("\n###### %s (%s)\n", notifications[0].IssueLink, updatesString(len(notifications)))
+		for i, n := range notifications {
+			text := "\n" + truncate(n.Message, digestMaxMessageSize-len(title)-len(issueHeader)-2) + "\n"
+			if i == 0 {
+				text = issueHeader + text
+			}
+			if len(message.Notifications) > 0 && len(message.Text)+len(text) > digestMaxMessageSize {
+				messages = append(messages, message)
+				message = digestMessage{Text: title}
+				if i > 0 {
+					message.Text += issueHeader
+				}
+			}
+			message.Text += text
+			message.Notifications = append(message.Notifications, n)
+		}
+	}
+	return append(messages, message)
+}
@@ -0,0 +1,186 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuietHoursEnd(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	overnight := &ConnectionSettings{QuietHoursStart: "22:00", QuietHoursEnd: "07:00"}
+	lunch := &ConnectionSettings{QuietHoursStart: "12:00", QuietHoursEnd: "13:30"}
+
+	for name, tc := range map[string]struct {
+		settings      *ConnectionSettings
+		now           time.Time
+		loc           *time.Location
+		expectedQuiet bool
+		expectedEnd   time.Time
+	}{
+		"no quiet hours": {
+			settings: &ConnectionSettings{},
+			now:      time.Date(2024, 3, 13, 23, 0, 0, 0, time.UTC),
+			loc:      time.UTC,
+		},
+		"nil settings": {
+			now: time.Date(2024, 3, 13, 23, 0, 0, 0, time.UTC),
+			loc: time.UTC,
+		},
+		"overnight, before midnight": {
+			settings:      overnight,
+			now:           time.Date(2024, 3, 13, 23, 0, 0, 0, time.UTC),
+			loc:           time.UTC,
+			expectedQuiet: true,
+			expectedEnd:   time.Date(2024, 3, 14, 7, 0, 0, 0, time.UTC),
+		},
+		"overnight, after midnight": {
+			settings:      overnight,
+			now:           time.Date(2024, 3, 14, 3, 0, 0, 0, time.UTC),
+			loc:           time.UTC,
+			expectedQuiet: true,
+			expectedEnd:   time.Date(2024, 3, 14, 7, 0, 0, 0, time.UTC),
+		},
+		"overnight, during the day": {
+			settings: overnight,
+			now:      time.Date(2024, 3, 13, 7, 0, 0, 0, time.UTC),
+			loc:      time.UTC,
+		},
+		"overnight, in the timezone of the user": {
+			settings:      overnight,
+			now:           time.Date(2024, 3, 13, 21, 30, 0, 0, time.UTC),
+			loc:           berlin,
+			expectedQuiet: true,
+			expectedEnd:   time.Date(2024, 3, 14, 6, 0, 0, 0, time.UTC),
+		},
+		"lunch": {
+			settings:      lunch,
+			now:           time.Date(2024, 3, 13, 12, 0, 0, 0, time.UTC),
+			loc:           time.UTC,
+			expectedQuiet: true,
+			expectedEnd:   time.Date(2024, 3, 13, 13, 30, 0, 0, time.UTC),
+		},
+		"after lunch": {
+			settings: lunch,
+			now:      time.Date(2024, 3, 13, 13, 30, 0, 0, time.UTC),
+			loc:      time.UTC,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			end, quiet := tc.settings.quietHoursEnd(tc.now, tc.loc)
+			assert.Equal(t, tc.expectedQuiet, quiet)
+			assert.True(t, tc.expectedEnd.Equal(end), "expected %v, got %v", tc.expectedEnd, end)
+		})
+	}
+}
+
+func TestQuietUntil(t *testing.T) {
+	now := time.Date(2024, 3, 13, 10, 0, 0, 0, time.UTC)
+	dndEnd := now.Add(2 * time.Hour).Truncate(time.Second)
+
+	for name, tc := range map[string]struct {
+		status        *model.Status
+		expectedQuiet bool
+		expectedUntil time.Time
+	}{
+		"online": {
+			status: &model.Status{Status: model.StatusOnline},
+		},
+		"do not disturb": {
+			status:        &model.Status{Status: model.StatusDnd},
+			expectedQuiet: true,
+			expectedUntil: now,
+		},
+		"do not disturb with an end time": {
+			status:        &model.Status{Status: model.StatusDnd, DNDEndTime: dndEnd.Unix()},
+			expectedQuiet: true,
+			expectedUntil: dndEnd,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &plugintest.API{}
+			api.On("GetUser", "user1").Return(&model.User{Id: "user1"}, nil)
+			api.On("GetUserStatus", "user1").Return(tc.status, nil)
+			p := &Plugin{}
+			p.SetAPI(api)
+			p.client = pluginapi.NewClient(api, p.Driver)
+
+			until, quiet := p.quietUntil("user1", &ConnectionSettings{Notifications: true}, now)
+			assert.Equal(t, tc.expectedQuiet, quiet)
+			assert.True(t, tc.expectedUntil.Equal(until), "expected %v, got %v", tc.expectedUntil, until)
+		})
+	}
+}
+
+func TestBypassesQuietHours(t *testing.T) {
+	p := &Plugin{}
+	p.updateConfig(func(conf *config) {
+		conf.QuietHoursBypassPriorities = "Highest, blocker"
+	})
+
+	for priority, expected := range map[string]bool{
+		"Highest": true,
+		"Blocker": true,
+		"High":    false,
+		"":        false,
+	} {
+		wh := &webhook{JiraWebhook: &JiraWebhook{Issue: jira.Issue{Fields: &jira.IssueFields{}}}}
+		if priority != "" {
+			wh.Issue.Fields.Priority = &jira.Priority{Name: priority}
+		}
+		assert.Equal(t, expected, p.bypassesQuietHours(wh), priority)
+	}
+}
+
+func TestMakeHeldMessages(t *testing.T) {
+	digest := &notificationDigest{
+		Mode: deliveryModeHeld,
+		Notifications: []digestNotification{
+			{IssueKey: "TES-1", IssueLink: "[TES-1]", Message: "assigned"},
+			{IssueKey: "TES-2", IssueLink: "[TES-2]", Message: "commented"},
+			{IssueKey: "TES-1", IssueLink: "[TES-1]", Message: "resolved"},
+		},
+	}
+	assert.Equal(t, "##### Jira notifications received while you were away\n"+
+		"\n###### [TES-1] (2 updates)\n\nassigned\n\nresolved\n"+
+		"\n###### [TES-2] (1 update)\n\ncommented\n", makeHeldMessages(digest)[0].Text)
+}
+
+func TestMakeHeldMessagesSize(t *testing.T) {
+	long := strings.Repeat("a", digestMaxMessageSize/3)
+	digest := &notificationDigest{Mode: deliveryModeHeld, Dropped: 2}
+	for _, issueKey := range []string{"TES-1", "TES-2", "TES-1", "TES-1", "TES-2"} {
+		digest.Notifications = append(digest.Notifications, digestNotification{IssueKey: issueKey, IssueLink: "[" + issueKey + "]", Message: long})
+	}
+	digest.Notifications = append(digest.Notifications, digestNotification{IssueKey: "TES-3", IssueLink: "[TES-3]", Message: strings.Repeat("b", 2*digestMaxMessageSize)})
+
+	messages := makeHeldMessages(digest)
+	require.Len(t, messages, 4)
+	count := 0
+	for _, m := range messages {
+		assert.LessOrEqual(t, len(m.Text), digestMaxMessageSize)
+		assert.True(t, strings.HasPrefix(m.Text, "##### Jira notifications received while you were away\n"))
+		count += len(m.Notifications)
+	}
+	assert.Equal(t, len(digest.Notifications), count)
+	assert.Contains(t, messages[0].Text, "_2 older notifications were dropped from this summary._")
+	assert.NotContains(t, messages[1].Text, "dropped")
+	// The notifications of an issue continue under its header
+	assert.True(t, strings.HasPrefix(messages[1].Text, "##### Jira notifications received while you were away\n\n###### [TES-1] (3 updates)\n"))
+	assert.True(t, strings.HasPrefix(messages[2].Text, "##### Jira notifications received while you were away\n\n###### [TES-2] (2 updates)\n"))
+	assert.Contains(t, messages[3].Text, "\n###### [TES-3] (1 update)\n")
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
 	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
 	"github.com/mattermost/mattermost/server/public/pluginapi/experimental/flow"
 
 	"github.com/mattermost/mattermost-plugin-autolink/server/autolink"
@@ -81,6 +82,15 @@ type externalConfig struct {
 
 	// Display subscription name in notifications
 	DisplaySubscriptionNameInNotifications bool
+
+	// Comma-separated priority names of the issues notified during quiet hours
+	QuietHoursBypassPriorities string
+
+	// Maximum number of issue previews attached to a post. Zero disables the previews
+	MaxIssuePreviewsPerPost int
+
+	// Maximum number of subscription posts per minute to a channel. Zero is unlimited
+	SubscriptionPostsPerMinute int
 }
 
 const defaultMaxAttachmentSize = utils.ByteSize(10 * 1024 * 1024) // 10Mb
@@ -112,6 +122,34 @@ type Plugin struct {
 	otsStore      OTSStore
 	secretsStore  SecretsStore
 
+	webhookQueueStore WebhookQueueStore
+	digestStore       DigestStore
+
+	// subscriptionCache caches the channel subscriptions of each instance
+	subscriptionCache subscriptionCache
+
+	// subscriptionPolicies caches the subscription policy of the channels
+	subscriptionPolicies subscriptionPolicyCache
+
+	// subscriptionTemplates caches the parsed templates of the
+	// subscriptions, by subscription ID
+	subscriptionTemplates sync.Map
+
+	// subscriptionJQLs caches the parsed JQL expressions of the
+	// subscriptions, by subscription ID
+	subscriptionJQLs sync.Map
+
+	// subscriptionRegexps caches the compiled regular expressions of the
+	// field filters of the subscriptions, by subscription ID
+	subscriptionRegexps sync.Map
+
+	// subscriptionLocations caches the timezones of the schedules of the
+	// subscriptions, by subscription ID
+	subscriptionLocations sync.Map
+
+	// channelPostBurstTimers are the pending rewrites of the burst summaries
+	channelPostBurstTimers channelPostBurstTimers
+
 	setupFlow  *flow.Flow
 	oauth2Flow *flow.Flow
 
@@ -127,6 +165,12 @@ type Plugin struct {
 	// channel to distribute work to the webhook processors
 	webhookQueue chan *webhookMessage
 
+	// job that retries failed and orphaned webhook events
+	webhookRetryJob *cluster.Job
+
+	// job that delivers the notification digests that are due
+	digestJob *cluster.Job
+
 	// service that determines if this Mattermost instance has access to
 	// enterprise features
 	enterpriseChecker enterprise.Checker
@@ -205,6 +249,21 @@ func (p *Plugin) OnConfigurationChange() error {
 }
 
 func (p *Plugin) OnDeactivate() error {
+	// Queued webhook events are persisted, the job picks them up again on activation
+	if p.webhookRetryJob != nil {
+		if err := p.webhookRetryJob.Close(); err != nil {
+			p.client.Log.Warn("OnDeactivate: Failed to close webhook retry job", "error", err.Error())
+		}
+	}
+	if p.digestJob != nil {
+		if err := p.digestJob.Close(); err != nil {
+			p.client.Log.Warn("OnDeactivate: Failed to close digest job", "error", err.Error())
+		}
+	}
+
+	// The pending rewrites of the burst summaries are dropped
+	p.channelPostBurstTimers.stop()
+
 	// close the tracker on plugin deactivation
 	if p.telemetryClient != nil {
 		err := p.telemetryClient.Close()
@@ -221,6 +280,8 @@ func (p *Plugin) OnActivate() error {
 	p.userStore = store
 	p.secretsStore = store
 	p.otsStore = store
+	p.webhookQueueStore = store
+	p.digestStore = store
 	p.client = pluginapi.NewClient(p.API, p.Driver)
 
 	p.initializeRouter()
@@ -303,6 +364,18 @@ func (p *Plugin) OnActivate() error {
 		go webhookWorker{i, p, p.webhookQueue}.work()
 	}
 
+	// Retry failed webhook events, and pick up the ones left over by a
+	// previous run of the plugin.
+	p.webhookRetryJob, err = cluster.Schedule(p.API, webhookRetryJobKey, cluster.MakeWaitForInterval(WebhookRetryInterval), p.retryQueuedWebhooks)
+	if err != nil {
+		return errors.Wrap(err, "failed to schedule webhook retry job")
+	}
+
+	p.digestJob, err = cluster.Schedule(p.API, digestJobKey, cluster.MakeWaitForInterval(DigestJobInterval), p.deliverDigests)
+	if err != nil {
+		return errors.Wrap(err, "failed to schedule digest job")
+	}
+
 	p.enterpriseChecker = enterprise.NewEnterpriseChecker(p.API)
 
 	go func() {
@@ -511,3 +584,8 @@ func (p *Plugin) OnInstall(c *plugin.Context, event model.OnInstallEvent) error
 
 	return nil
 }
+
+func (p *Plugin) MessageHasBeenPosted(c *plugin.Context, post *model.Post) {
+	p.syncPostToIssue(post)
+	p.previewIssues(post)
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"strings"
+
 	"github.com/mattermost/mattermost/server/public/model"
 
 	"github.com/mattermost/mattermost-plugin-jira/server/utils/types"
@@ -11,9 +13,112 @@ const (
 	settingOff = "off"
 )
 
+// Categories of the personal notifications, that can be turned off separately.
+const (
+	notificationCategoryAssigned         = "assigned"
+	notificationCategoryMentioned        = "mentioned"
+	notificationCategoryReporterComments = "reporter-comments"
+	notificationCategoryAssigneeComments = "assignee-comments"
+	notificationCategoryStatusChanges    = "status-changes"
+	notificationCategoryWatching         = "watching"
+)
+
+type notificationCategory struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+}
+
+var notificationCategories = []notificationCategory{
+	{notificationCategoryAssigned, "An issue is assigned to me"},
+	{notificationCategoryMentioned, "I am mentioned in a comment"},
+	{notificationCategoryReporterComments, "An issue I reported is commented on"},
+	{notificationCategoryAssigneeComments, "An issue assigned to me is commented on"},
+	{notificationCategoryStatusChanges, "The status of an issue I reported or am assigned to changes"},
+	{notificationCategoryWatching, "An issue I watch is updated"},
+}
+
+// optInNotificationCategories were added after users could connect. They are
+// off for the connections made before, unless turned on, so that existing
+// users do not start receiving them unannounced.
+var optInNotificationCategories = map[string]bool{
+	notificationCategoryReporterComments: true,
+	notificationCategoryStatusChanges:    true,
+	notificationCategoryWatching:         true,
+}
+
+// newConnectionSettings returns the settings of a user who connects for the
+// first time.
+func newConnectionSettings() *ConnectionSettings {
+	categories := map[string]bool{}
+	for category := range optInNotificationCategories {
+		categories[category] = true
+	}
+	return &ConnectionSettings{
+		Notifications:          true,
+		NotificationCategories: categories,
+	}
+}
+
+func isNotificationCategory(category string) bool {
+	for _, c := range notificationCategories {
+		if c.ID == category {
+			return true
+		}
+	}
+	return false
+}
+
+// isNotificationCategoryEnabled returns false if the user opted out of the
+// category. The categories are on unless turned off, except the opt-in ones.
+func (s *ConnectionSettings) isNotificationCategoryEnabled(category string) bool {
+	if category == "" {
+		return true
+	}
+	if s != nil {
+		if enabled, ok := s.NotificationCategories[category]; ok {
+			return enabled
+		}
+	}
+	return !optInNotificationCategories[category]
+}
+
+// setNotificationCategories stores the notification categories of the
+// connection that are turned on or off.
+func (p *Plugin) setNotificationCategories(instanceID, mattermostUserID types.ID, connection *Connection, values map[string]bool) error {
+	if connection.Settings == nil {
+		connection.Settings = &ConnectionSettings{}
+	}
+	categories := map[string]bool{}
+	for k, v := range connection.Settings.NotificationCategories {
+		categories[k] = v
+	}
+	for k, v := range values {
+		categories[k] = v
+	}
+	connection.Settings.NotificationCategories = categories
+	return p.userStore.StoreConnection(instanceID, mattermostUserID, connection)
+}
+
+// disabledNotificationCategories returns the categories the user turned off.
+func (s *ConnectionSettings) disabledNotificationCategories() []string {
+	disabled := []string{}
+	if s == nil {
+		return disabled
+	}
+	for _, c := range notificationCategories {
+		if enabled, ok := s.NotificationCategories[c.ID]; ok && !enabled {
+			disabled = append(disabled, c.ID)
+		}
+	}
+	return disabled
+}
+
 func (p *Plugin) settingsNotifications(header *model.CommandArgs, instanceID, mattermostUserID types.ID, connection *Connection, args []string) *model.CommandResponse {
 	const helpText = "`/jira settings notifications [value]`\n* Invalid value. Accepted values are: `on` or `off`."
 
+	if len(args) == 3 {
+		return p.settingsNotificationCategory(header, instanceID, mattermostUserID, connection, args[1], args[2])
+	}
 	if len(args) != 2 {
 		return p.responsef(header, helpText)
 	}
@@ -49,3 +154,117 @@ func (p *Plugin) settingsNotifications(header *model.CommandArgs, instanceID, ma
 
 	return p.responsef(header, "Settings updated. Notifications %s.", notifications)
 }
+
+func (p *Plugin) settingsDelivery(header *model.CommandArgs, instanceID, mattermostUserID types.ID, connection *Connection, args []string) *model.CommandResponse {
+	const helpText = "`/jira settings delivery [mode] [time] [day]`\n" +
+		"* [mode] can be `instant`, `hourly`, `daily` or `weekly`\n" +
+		"* [time] is the time of the daily and weekly digests in your timezone, e.g. `09:00`\n" +
+		"* [day] is the day of the weekly digest, e.g. `monday`"
+
+	if len(args) < 2 || len(args) > 4 {
+		return p.responsef(header, helpText)
+	}
+
+	settings := ConnectionSettings{}
+	if connection.Settings != nil {
+		settings = *connection.Settings
+	}
+	settings.DeliveryMode = strings.ToLower(args[1])
+	settings.DeliveryTime = ""
+	settings.DeliveryDay = ""
+
+	switch settings.DeliveryMode {
+	case DeliveryModeInstant, DeliveryModeHourly:
+		if len(args) > 2 {
+			return p.responsef(header, helpText)
+		}
+	case DeliveryModeDaily, DeliveryModeWeekly:
+		if len(args) > 2 {
+			if _, _, err := parseDeliveryTime(args[2]); err != nil {
+				return p.responsef(header, "%v.\n%s", err, helpText)
+			}
+			settings.DeliveryTime = args[2]
+		}
+		if len(args) > 3 {
+			day := strings.ToLower(args[3])
+			if _, ok := weekdays[day]; !ok || settings.DeliveryMode != DeliveryModeWeekly {
+				return p.responsef(header, helpText)
+			}
+			settings.DeliveryDay = day
+		}
+	default:
+		return p.responsef(header, helpText)
+	}
+	if settings.DeliveryMode == DeliveryModeInstant {
+		settings.DeliveryMode = ""
+	}
+
+	connection.Settings = &settings
+	if err := p.userStore.StoreConnection(instanceID, mattermostUserID, connection); err != nil {
+		p.errorf("settingsDelivery, err: %v", err)
+		return p.responsef(header, "Could not store new settings. Please contact your system administrator. error: %v", err)
+	}
+
+	return p.responsef(header, "Settings updated. Notifications delivery: %s.", settings.deliveryString())
+}
+
+func (p *Plugin) settingsQuietHours(header *model.CommandArgs, instanceID, mattermostUserID types.ID, connection *Connection, args []string) *model.CommandResponse {
+	const helpText = "`/jira settings quiet-hours [start] [end]` or `/jira settings quiet-hours off`\n" +
+		"* [start] and [end] are times in your timezone, e.g. `22:00` and `07:00`\n" +
+		"* Notifications received during your quiet hours, or while your status is Do Not Disturb, are delivered as a summary afterwards"
+
+	settings := ConnectionSettings{}
+	if connection.Settings != nil {
+		settings = *connection.Settings
+	}
+
+	switch {
+	case len(args) == 2 && args[1] == settingOff:
+		settings.QuietHoursStart = ""
+		settings.QuietHoursEnd = ""
+	case len(args) == 3:
+		for _, value := range args[1:] {
+			if _, _, err := parseDeliveryTime(value); err != nil {
+				return p.responsef(header, "%v.\n%s", err, helpText)
+			}
+		}
+		if args[1] == args[2] {
+			return p.responsef(header, "The quiet hours must start and end at different times.\n%s", helpText)
+		}
+		settings.QuietHoursStart = args[1]
+		settings.QuietHoursEnd = args[2]
+	default:
+		return p.responsef(header, helpText)
+	}
+
+	connection.Settings = &settings
+	if err := p.userStore.StoreConnection(instanceID, mattermostUserID, connection); err != nil {
+		p.errorf("settingsQuietHours, err: %v", err)
+		return p.responsef(header, "Could not store new settings. Please contact your system administrator. error: %v", err)
+	}
+
+	if !settings.hasQuietHours() {
+		return p.responsef(header, "Settings updated. Quiet hours off.")
+	}
+	return p.responsef(header, "Settings updated. Quiet hours: %s to %s.", settings.QuietHoursStart, settings.QuietHoursEnd)
+}
+
+func (p *Plugin) settingsNotificationCategory(header *model.CommandArgs, instanceID, mattermostUserID types.ID, connection *Connection, category, value string) *model.CommandResponse {
+	ids := []string{}
+	for _, c := range notificationCategories {
+		ids = append(ids, "`"+c.ID+"`")
+	}
+	helpText := "`/jira settings notifications [category] [value]`\n* Accepted categories are: " + strings.Join(ids, ", ") +
+		".\n* Accepted values are: `on` or `off`."
+
+	if !isNotificationCategory(category) || (value != settingOn && value != settingOff) {
+		return p.responsef(header, helpText)
+	}
+
+	if err := p.setNotificationCategories(instanceID, mattermostUserID, connection, map[string]bool{category: value == settingOn}); err != nil {
+		p.errorf("settingsNotificationCategory, err: %v", err)
+		return p.responsef(header, "Could not store new settings. Please contact your system administrator. error: %v", err)
+	}
+
+	return p.responsef(header, "Settings updated. Notifications for `%s` %s.", category, value)
+}
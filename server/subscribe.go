@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"sort"
 	"strings"
+	"time"
 
 	jira "github.com/andygrunwald/go-jira"
 	"github.com/gorilla/mux"
@@ -31,6 +32,7 @@ const (
 	FilterExcludeAny     = "exclude_any"
 	FilterEmpty          = "empty"
 	FilterIncludeOrEmpty = "include_or_empty"
+	FilterChanged        = "changed"
 
 	MaxSubscriptionNameLength = 100
 )
@@ -39,6 +41,14 @@ type FieldFilter struct {
 	Key       string    `json:"key"`
 	Inclusion string    `json:"inclusion"`
 	Values    StringSet `json:"values"`
+
+	// From, To and ToGroups are the conditions of a FilterChanged filter on
+	// the values of the field before and after the change, by ID or name.
+	// ToGroups are the Jira groups the user the field is changed to must be
+	// a member of. An empty condition matches any value.
+	From     StringSet `json:"from,omitempty"`
+	To       StringSet `json:"to,omitempty"`
+	ToGroups StringSet `json:"to_groups,omitempty"`
 }
 
 type SubscriptionFilters struct {
@@ -46,6 +56,7 @@ type SubscriptionFilters struct {
 	Projects   StringSet     `json:"projects"`
 	IssueTypes StringSet     `json:"issue_types"`
 	Fields     []FieldFilter `json:"fields"`
+	JQL        string        `json:"jql,omitempty"`
 }
 
 type ChannelSubscription struct {
@@ -54,12 +65,44 @@ type ChannelSubscription struct {
 	Filters    SubscriptionFilters `json:"filters"`
 	Name       string              `json:"name"`
 	InstanceID types.ID            `json:"instance_id"`
+
+	// ThreadByIssue posts the events of an issue as replies to the thread of
+	// the first event of that issue in the channel.
+	ThreadByIssue bool `json:"thread_by_issue,omitempty"`
+
+	// Template customizes the posts of the subscription.
+	Template *SubscriptionTemplate `json:"template,omitempty"`
+
+	// Priority orders the subscriptions of a channel that match the same
+	// event, the highest first. See the subscription policy of the channel.
+	Priority int `json:"priority,omitempty"`
+
+	// Enabled is false if the subscription is muted, a subscription is
+	// enabled unless it is set.
+	Enabled *bool `json:"enabled,omitempty"`
+	// SnoozedUntil is the time, in milliseconds, until which the subscription
+	// is muted.
+	SnoozedUntil int64 `json:"snoozed_until,omitempty"`
+	// Schedule restricts the subscription to its weekly active periods.
+	Schedule *SubscriptionSchedule `json:"schedule,omitempty"`
+
+	// RateLimit is the number of posts per minute of the subscription to its
+	// channel, instead of the limit of the channel. The limit of the channel
+	// applies if it is zero, and there is no limit if it is
+	// SubscriptionRateLimitNone.
+	RateLimit int `json:"rate_limit,omitempty"`
 }
 
 type ChannelSubscriptions struct {
 	ByID          map[string]ChannelSubscription `json:"by_id"`
 	IDByChannelID map[string]StringSet           `json:"id_by_channel_id"`
 	IDByEvent     map[string]StringSet           `json:"id_by_event"`
+
+	// IDByProject and IDByIssueType index the subscriptions by the projects
+	// and issue types they filter on. The subscriptions that do not filter on
+	// them match any, and are indexed under an empty key.
+	IDByProject   map[string]StringSet `json:"id_by_project,omitempty"`
+	IDByIssueType map[string]StringSet `json:"id_by_issue_type,omitempty"`
 }
 
 func NewChannelSubscriptions() *ChannelSubscriptions {
@@ -67,7 +110,18 @@ func NewChannelSubscriptions() *ChannelSubscriptions {
 		ByID:          map[string]ChannelSubscription{},
 		IDByChannelID: map[string]StringSet{},
 		IDByEvent:     map[string]StringSet{},
+		IDByProject:   map[string]StringSet{},
+		IDByIssueType: map[string]StringSet{},
+	}
+}
+
+// filterIndexKeys returns the keys a subscription is indexed under for the
+// values of a filter, or the empty key if it does not filter on any.
+func filterIndexKeys(values StringSet) []string {
+	if values.Len() == 0 {
+		return []string{""}
 	}
+	return values.Elems()
 }
 
 func (s *ChannelSubscriptions) remove(sub *ChannelSubscription) {
@@ -78,6 +132,12 @@ func (s *ChannelSubscriptions) remove(sub *ChannelSubscription) {
 	for _, event := range sub.Filters.Events.Elems() {
 		s.IDByEvent[event] = s.IDByEvent[event].Subtract(sub.ID)
 	}
+	for _, project := range filterIndexKeys(sub.Filters.Projects) {
+		s.IDByProject[project] = s.IDByProject[project].Subtract(sub.ID)
+	}
+	for _, issueType := range filterIndexKeys(sub.Filters.IssueTypes) {
+		s.IDByIssueType[issueType] = s.IDByIssueType[issueType].Subtract(sub.ID)
+	}
 }
 
 func (s *ChannelSubscriptions) add(newSubscription *ChannelSubscription) {
@@ -86,6 +146,69 @@ func (s *ChannelSubscriptions) add(newSubscription *ChannelSubscription) {
 	for _, event := range newSubscription.Filters.Events.Elems() {
 		s.IDByEvent[event] = s.IDByEvent[event].Add(newSubscription.ID)
 	}
+	for _, project := range filterIndexKeys(newSubscription.Filters.Projects) {
+		s.IDByProject[project] = s.IDByProject[project].Add(newSubscription.ID)
+	}
+	for _, issueType := range filterIndexKeys(newSubscription.Filters.IssueTypes) {
+		s.IDByIssueType[issueType] = s.IDByIssueType[issueType].Add(newSubscription.ID)
+	}
+}
+
+// addAll adds subscriptions, updating the indexes in place rather than adding
+// the subscriptions one by one, which copies the sets.
+func (s *ChannelSubscriptions) addAll(subs []ChannelSubscription) {
+	index := func(m map[string]StringSet, key, id string) {
+		if m[key] == nil {
+			m[key] = NewStringSet()
+		}
+		m[key][id] = true
+	}
+	for _, sub := range subs {
+		s.ByID[sub.ID] = sub
+		index(s.IDByChannelID, sub.ChannelID, sub.ID)
+		for event := range sub.Filters.Events {
+			index(s.IDByEvent, event, sub.ID)
+		}
+		for _, project := range filterIndexKeys(sub.Filters.Projects) {
+			index(s.IDByProject, project, sub.ID)
+		}
+		for _, issueType := range filterIndexKeys(sub.Filters.IssueTypes) {
+			index(s.IDByIssueType, issueType, sub.ID)
+		}
+	}
+}
+
+// candidateIDs returns the sorted IDs of the subscriptions that may match a
+// webhook event, by its events, project and issue type, so that only their
+// filters are evaluated.
+func (s *ChannelSubscriptions) candidateIDs(wh *webhook) []string {
+	ids := map[string]bool{}
+	for event := range wh.Events() {
+		for id := range s.IDByEvent[event] {
+			ids[id] = true
+		}
+		if isEventUpdatedAny(event) {
+			for id := range s.IDByEvent[eventUpdatedAny] {
+				ids[id] = true
+			}
+		}
+	}
+
+	projectKey, issueTypeID := "", ""
+	if fields := wh.JiraWebhook.Issue.Fields; fields != nil {
+		projectKey = fields.Project.Key
+		issueTypeID = fields.Type.ID
+	}
+
+	candidates := []string{}
+	for id := range ids {
+		if (s.IDByProject[projectKey][id] || s.IDByProject[""][id]) &&
+			(s.IDByIssueType[issueTypeID][id] || s.IDByIssueType[""][id]) {
+			candidates = append(candidates, id)
+		}
+	}
+	sort.Strings(candidates)
+	return candidates
 }
 
 type Subscriptions struct {
@@ -125,7 +248,14 @@ func (p *Plugin) getUserID() string {
 	return p.getConfig().botUserID
 }
 
-func (p *Plugin) matchesSubsciptionFilters(wh *webhook, filters SubscriptionFilters) bool {
+func (p *Plugin) matchesSubsciptionFilters(instanceID types.ID, wh *webhook, sub ChannelSubscription) bool {
+	return p.subscriptionMismatch(instanceID, wh, sub) == ""
+}
+
+// subscriptionMismatch returns why a webhook event does not match the filters
+// of a subscription, or an empty string if it matches.
+func (p *Plugin) subscriptionMismatch(instanceID types.ID, wh *webhook, sub ChannelSubscription) string {
+	filters := sub.Filters
 	webhookEvents := wh.Events()
 	foundEvent := false
 	eventTypes := filters.Events
@@ -133,34 +263,46 @@ func (p *Plugin) matchesSubsciptionFilters(wh *webhook, filters SubscriptionFilt
 		foundEvent = true
 	} else if eventTypes.ContainsAny(eventUpdatedAny) {
 		for _, eventType := range webhookEvents.Elems() {
-			if strings.HasPrefix(eventType, "event_updated") || strings.HasSuffix(eventType, "comment") {
+			if isEventUpdatedAny(eventType) {
 				foundEvent = true
 			}
 		}
 	}
 
 	if !foundEvent {
-		return false
+		return fmt.Sprintf("the event %s is not selected", joinSorted(webhookEvents))
 	}
 
 	issue := &wh.JiraWebhook.Issue
 
 	if filters.IssueTypes.Len() != 0 && !filters.IssueTypes.ContainsAny(issue.Fields.Type.ID) {
-		return false
+		return fmt.Sprintf("the issue type %s (%s) is not selected", issue.Fields.Type.Name, issue.Fields.Type.ID)
 	}
 
 	if filters.Projects.Len() != 0 && !filters.Projects.ContainsAny(issue.Fields.Project.Key) {
-		return false
+		return fmt.Sprintf("the project %s is not selected", issue.Fields.Project.Key)
 	}
 
 	containsSecurityLevelFilter := false
 	useEmptySecurityLevel := p.getConfig().SecurityLevelEmptyForJiraSubscriptions
 	for _, field := range filters.Fields {
 		inclusion := field.Inclusion
+		if inclusion == FilterChanged {
+			if reason := p.fieldChangeMismatch(instanceID, wh, field); reason != "" {
+				return reason
+			}
+			continue
+		}
+		if isComparisonInclusion(inclusion) {
+			if reason := p.comparisonFieldMismatch(sub, issue, field, time.Now()); reason != "" {
+				return reason
+			}
+			continue
+		}
 
 		// Broken filter, values must be provided
 		if inclusion == "" || (field.Values.Len() == 0 && inclusion != FilterEmpty) {
-			return false
+			return fmt.Sprintf("the filter on %s is incomplete", field.Key)
 		}
 
 		if field.Key == securityLevelField {
@@ -172,18 +314,65 @@ func (p *Plugin) matchesSubsciptionFilters(wh *webhook, filters SubscriptionFilt
 
 		value := getIssueFieldValue(issue, field.Key)
 		if !isValidFieldInclusion(field, value, inclusion) {
-			return false
+			return fieldInclusionMismatch(field, value, inclusion)
 		}
 	}
 
 	if !containsSecurityLevelFilter && useEmptySecurityLevel {
 		securityLevel := getIssueFieldValue(issue, securityLevelField)
 		if securityLevel.Len() > 0 {
-			return false
+			return "the issue has a security level"
 		}
 	}
 
-	return true
+	if filters.JQL != "" {
+		jql, err := p.subscriptionJQL(sub)
+		if err != nil {
+			p.debugf("matchesSubsciptionFilters: invalid JQL %q: %v", filters.JQL, err)
+			return fmt.Sprintf("the JQL is invalid: %v", err)
+		}
+		if !jql.Matches(issue, wh.JiraWebhook.Comment.Body) {
+			return "the issue does not match the JQL"
+		}
+	}
+
+	return ""
+}
+
+// isEventUpdatedAny tells if an event matches the subscriptions to any update
+// of an issue.
+func isEventUpdatedAny(eventType string) bool {
+	return strings.HasPrefix(eventType, "event_updated") || strings.HasSuffix(eventType, "comment")
+}
+
+// fieldInclusionMismatch describes why the value of an issue field does not
+// match a field filter.
+func fieldInclusionMismatch(field FieldFilter, value StringSet, inclusion string) string {
+	values := joinSorted(field.Values)
+	actual := "empty"
+	if value.Len() > 0 {
+		actual = joinSorted(value)
+	}
+
+	switch inclusion {
+	case FilterIncludeAny:
+		return fmt.Sprintf("%s is %s, not any of %s", field.Key, actual, values)
+	case FilterIncludeAll:
+		return fmt.Sprintf("%s is %s, not all of %s", field.Key, actual, values)
+	case FilterExcludeAny:
+		return fmt.Sprintf("%s is %s, one of the excluded %s", field.Key, actual, values)
+	case FilterEmpty:
+		return fmt.Sprintf("%s is %s, not empty", field.Key, actual)
+	case FilterIncludeOrEmpty:
+		return fmt.Sprintf("%s is %s, neither empty nor any of %s", field.Key, actual, values)
+	}
+	return fmt.Sprintf("the filter on %s is invalid", field.Key)
+}
+
+func joinSorted(set StringSet) string {
+	elems := set.Elems()
+	sort.Strings(elems)
+	return strings.Join(elems, ", ")
 }
 
 func isValidFieldInclusion(field FieldFilter, value StringSet, inclusion string) bool {
@@ -201,35 +390,38 @@ func isValidFieldInclusion(field FieldFilter, value StringSet, inclusion string)
 	return true
 }
 
+// getChannelsSubscribed returns the subscriptions to post a webhook event with,
+// ordered by channel. A channel may have several, depending on its
+// subscription policy.
 func (p *Plugin) getChannelsSubscribed(wh *webhook, instanceID types.ID) ([]ChannelSubscription, error) {
 	subs, err := p.getSubscriptions(instanceID)
 	if err != nil {
 		return nil, err
 	}
 
-	var channelSubscriptions []ChannelSubscription
-	subscriptionMap := make(map[string]bool)
-	subIds := subs.Channel.ByID
-	for _, sub := range subIds {
-		if p.matchesSubsciptionFilters(wh, sub.Filters) {
-			if !subscriptionMap[sub.ChannelID] {
-				subscriptionMap[sub.ChannelID] = true
-				channelSubscriptions = append(channelSubscriptions, sub)
+	matchedByChannelID := map[string][]ChannelSubscription{}
+	channelIDs := []string{}
+	now := time.Now()
+	for _, id := range subs.Channel.candidateIDs(wh) {
+		sub := subs.Channel.ByID[id]
+		if !p.isSubscriptionActive(sub, now) {
+			continue
+		}
+		if p.matchesSubsciptionFilters(instanceID, wh, sub) {
+			if matchedByChannelID[sub.ChannelID] == nil {
+				channelIDs = append(channelIDs, sub.ChannelID)
 			}
+			matchedByChannelID[sub.ChannelID] = append(matchedByChannelID[sub.ChannelID], sub)
 		}
 	}
+	sort.Strings(channelIDs)
 
-	return channelSubscriptions, nil
-}
-
-func (p *Plugin) getSubscriptions(instanceID types.ID) (*Subscriptions, error) {
-	subKey := keyWithInstanceID(instanceID, JiraSubscriptionsKey)
-	var data []byte
-	err := p.client.KV.Get(subKey, &data)
-	if err != nil {
-		return nil, err
+	var channelSubscriptions []ChannelSubscription
+	for _, channelID := range channelIDs {
+		channelSubscriptions = append(channelSubscriptions, p.applySubscriptionPolicy(channelID, matchedByChannelID[channelID])...)
 	}
-	return SubscriptionsFromJSON(data, instanceID)
+
+	return channelSubscriptions, nil
 }
 
 func (p *Plugin) getSubscriptionsForChannel(instanceID types.ID, channelID string) ([]ChannelSubscription, error) {
@@ -250,6 +442,25 @@ func (p *Plugin) getSubscriptionsForChannel(instanceID types.ID, channelID strin
 	return channelSubscriptions, nil
 }
 
+// findSubscriptionByName returns the subscription with a name, nil if there is
+// none. The names are unique per channel but case sensitive, so a name that
+// differs in case only matches if no other subscription does.
+func findSubscriptionByName(subs []ChannelSubscription, name string) *ChannelSubscription {
+	var folded []*ChannelSubscription
+	for i := range subs {
+		if subs[i].Name == name {
+			return &subs[i]
+		}
+		if strings.EqualFold(subs[i].Name, name) {
+			folded = append(folded, &subs[i])
+		}
+	}
+	if len(folded) != 1 {
+		return nil
+	}
+	return folded[0]
+}
+
 func (p *Plugin) getChannelSubscription(instanceID types.ID, subscriptionID string) (*ChannelSubscription, error) {
 	subs, err := p.getSubscriptions(instanceID)
 	if err != nil {
@@ -265,52 +476,36 @@ func (p *Plugin) getChannelSubscription(instanceID types.ID, subscriptionID stri
 }
 
 func (p *Plugin) removeChannelSubscription(instanceID types.ID, subscriptionID string) error {
-	subKey := keyWithInstanceID(instanceID, JiraSubscriptionsKey)
-	return p.client.KV.SetAtomicWithRetries(subKey, func(initialBytes []byte) (interface{}, error) {
-		subs, err := SubscriptionsFromJSON(initialBytes, instanceID)
-		if err != nil {
-			return nil, err
-		}
-
-		subscription, ok := subs.Channel.ByID[subscriptionID]
-		if !ok {
-			return nil, errors.New("could not find subscription")
-		}
-
-		subs.Channel.remove(&subscription)
+	err := p.ensureSubscriptionStore(instanceID)
+	if err != nil {
+		return err
+	}
 
-		modifiedBytes, marshalErr := json.Marshal(&subs)
-		if marshalErr != nil {
-			return nil, marshalErr
-		}
+	subscription, err := p.loadSubscription(instanceID, subscriptionID)
+	if err != nil {
+		return err
+	}
+	if subscription == nil {
+		return errors.New("could not find subscription")
+	}
 
-		return modifiedBytes, nil
-	})
+	return p.deleteSubscription(instanceID, subscription)
 }
 
 func (p *Plugin) addChannelSubscription(instanceID types.ID, newSubscription *ChannelSubscription, client Client) error {
-	subKey := keyWithInstanceID(instanceID, JiraSubscriptionsKey)
-	return p.client.KV.SetAtomicWithRetries(subKey, func(initialBytes []byte) (interface{}, error) {
-		subs, err := SubscriptionsFromJSON(initialBytes, instanceID)
-		if err != nil {
-			return nil, err
-		}
-
-		err = p.validateSubscription(instanceID, newSubscription, client)
-		if err != nil {
-			return nil, err
-		}
-
-		newSubscription.ID = model.NewId()
-		subs.Channel.add(newSubscription)
+	err := p.ensureSubscriptionStore(instanceID)
+	if err != nil {
+		return err
+	}
 
-		modifiedBytes, marshalErr := json.Marshal(&subs)
-		if marshalErr != nil {
-			return nil, marshalErr
-		}
+	err = p.validateSubscription(instanceID, newSubscription, client)
+	if err != nil {
+		return err
+	}
 
-		return modifiedBytes, nil
-	})
+	newSubscription.ID = model.NewId()
+	newSubscription.InstanceID = instanceID
+	return p.storeNewSubscription(instanceID, newSubscription)
 }
 
 func (p *Plugin) validateSubscription(instanceID types.ID, subscription *ChannelSubscription, client Client) error {
@@ -326,20 +521,55 @@ func (p *Plugin) validateSubscription(instanceID types.ID, subscription *Channel
 		return errors.New("please provide at least one event type")
 	}
 
-	if len(subscription.Filters.IssueTypes) == 0 {
+	if subscription.RateLimit < SubscriptionRateLimitNone {
+		return errors.Errorf("please provide a rate limit of at least 1 post per minute, 0 for the limit of the channel, or %d for no limit", SubscriptionRateLimitNone)
+	}
+
+	// A JQL expression may select the projects and the issue types itself
+	hasJQL := strings.TrimSpace(subscription.Filters.JQL) != ""
+	var jqlProjectKeys []string
+	if hasJQL {
+		jql, err := ParseJQL(subscription.Filters.JQL)
+		if err != nil {
+			return errors.WithMessage(err, "invalid JQL")
+		}
+		if len(subscription.Filters.Projects) == 0 {
+			// The user must be able to read the projects the JQL selects
+			projects, ok := jql.Projects()
+			if !ok {
+				return errors.New("please provide a project identifier, or restrict the JQL to projects, as project in (KEY1, KEY2)")
+			}
+			jqlProjectKeys = projects.Elems()
+			sort.Strings(jqlProjectKeys)
+		}
+	}
+
+	if len(subscription.Filters.IssueTypes) == 0 && !hasJQL {
 		return errors.New("please provide at least one issue type")
 	}
 
-	if (len(subscription.Filters.Projects)) == 0 {
+	if (len(subscription.Filters.Projects)) == 0 && !hasJQL {
 		return errors.New("please provide a project identifier")
 	}
 
-	projectKey := subscription.Filters.Projects.Elems()[0]
+	projectKey := ""
+	if len(subscription.Filters.Projects) > 0 {
+		projectKey = subscription.Filters.Projects.Elems()[0]
+	}
+
+	for _, field := range subscription.Filters.Fields {
+		if err := validateFieldChangeFilter(field, subscription.Filters.Events); err != nil {
+			return err
+		}
+	}
+	if err := p.validateComparisonFilters(client, subscription.Filters); err != nil {
+		return err
+	}
 
 	var securityLevels StringSet
 	useEmptySecurityLevel := p.getConfig().SecurityLevelEmptyForJiraSubscriptions
 	for _, field := range subscription.Filters.Fields {
-		if field.Key != securityLevelField {
+		if field.Key != securityLevelField || field.Inclusion == FilterChanged {
 			continue
 		}
 
@@ -351,6 +581,10 @@ func (p *Plugin) validateSubscription(instanceID types.ID, subscription *Channel
 			return errors.New("security level does not allow for an \"Exclude\" clause")
 		}
 
+		if projectKey == "" {
+			return errors.New("please provide a project identifier to filter on security level")
+		}
+
 		if securityLevels == nil {
 			securityLevelsArray, err := p.getSecurityLevelsForProject(client, projectKey)
 			if err != nil {
@@ -365,6 +599,20 @@ func (p *Plugin) validateSubscription(instanceID types.ID, subscription *Channel
 		}
 	}
 
+	if subscription.Template != nil {
+		sample, err := subscription.Template.validate(subscription)
+		if err != nil {
+			return err
+		}
+		subscription.Template.sample = sample
+	}
+
+	if subscription.Schedule != nil {
+		if err := subscription.Schedule.validate(); err != nil {
+			return err
+		}
+	}
+
 	channelID := subscription.ChannelID
 	subs, err := p.getSubscriptionsForChannel(instanceID, channelID)
 	if err != nil {
@@ -377,9 +625,15 @@ func (p *Plugin) validateSubscription(instanceID types.ID, subscription *Channel
 		}
 	}
 
-	_, err = client.GetProject(projectKey)
-	if err != nil {
-		return errors.WithMessagef(err, "failed to get project %q", projectKey)
+	projectKeys := jqlProjectKeys
+	if projectKey != "" {
+		projectKeys = []string{projectKey}
+	}
+	for _, key := range projectKeys {
+		_, err = client.GetProject(key)
+		if err != nil {
+			return errors.WithMessagef(err, "failed to get project %q", key)
+		}
 	}
 
 	return nil
@@ -427,32 +681,27 @@ func (p *Plugin) getSecurityLevelsForProject(client Client, projectKey string) (
 }
 
 func (p *Plugin) editChannelSubscription(instanceID types.ID, modifiedSubscription *ChannelSubscription, client Client) error {
-	subKey := keyWithInstanceID(instanceID, JiraSubscriptionsKey)
-	return p.client.KV.SetAtomicWithRetries(subKey, func(initialBytes []byte) (interface{}, error) {
-		subs, err := SubscriptionsFromJSON(initialBytes, instanceID)
-		if err != nil {
-			return nil, err
-		}
-
-		oldSub, ok := subs.Channel.ByID[modifiedSubscription.ID]
-		if !ok {
-			return nil, errors.New("existing subscription does not exist")
-		}
-
-		err = p.validateSubscription(instanceID, modifiedSubscription, client)
-		if err != nil {
-			return nil, err
-		}
+	err := p.ensureSubscriptionStore(instanceID)
+	if err != nil {
+		return err
+	}
 
-		subs.Channel.remove(&oldSub)
-		subs.Channel.add(modifiedSubscription)
+	oldSub, err := p.loadSubscription(instanceID, modifiedSubscription.ID)
+	if err != nil {
+		return err
+	}
+	if oldSub == nil {
+		return errors.New("existing subscription does not exist")
+	}
 
-		modifiedBytes, marshalErr := json.Marshal(&subs)
-		if marshalErr != nil {
-			return nil, marshalErr
-		}
+	err = p.validateSubscription(instanceID, modifiedSubscription, client)
+	if err != nil {
+		return err
+	}
 
-		return modifiedBytes, nil
+	modifiedSubscription.InstanceID = instanceID
+	return p.updateStoredSubscription(instanceID, modifiedSubscription.ID, func(*ChannelSubscription) (*ChannelSubscription, error) {
+		return modifiedSubscription, nil
 	})
 }
 
@@ -525,8 +774,18 @@ func (p *Plugin) listChannelSubscriptions(instanceID types.ID, teamID string) (s
 					return channelSubscriptions[i].Name < channelSubscriptions[j].Name
 				})
 
+				now := time.Now()
 				for _, channelSubscription := range channelSubscriptions {
-					rows = append(rows, fmt.Sprintf("\t\t* %s - %s", channelSubscription.Filters.Projects.Elems()[0], channelSubscription.Name))
+					// A subscription may select its projects with JQL only
+					projects := joinSorted(channelSubscription.Filters.Projects)
+					if projects == "" {
+						projects = "JQL"
+					}
+					row := fmt.Sprintf("\t\t* %s - %s", projects, channelSubscription.Name)
+					if state := p.subscriptionStateDescription(channelSubscription, now); state != "" {
+						row += fmt.Sprintf(" (%s)", state)
+					}
+					rows = append(rows, row)
 				}
 			}
 		}
@@ -784,17 +1043,28 @@ func (p *Plugin) httpSubscribeWebhook(w http.ResponseWriter, r *http.Request, in
 		p.client.Log.Debug("Webhook Event Log", "event", string(bb))
 	}
 
-	// If there is space in the queue, immediately return a 200; we will process the webhook event async.
-	// If the queue is full, return a 503; we will not process that webhook event.
-	select {
-	case p.webhookQueue <- &webhookMessage{
-		InstanceID: instanceID,
-		Data:       bb,
-	}:
-		return http.StatusOK, nil
-	default:
-		return respondErr(w, http.StatusServiceUnavailable, nil)
+	// Once the event is persisted, immediately return a 200; we will process the webhook event async.
+	// If it could not be persisted, return a 503 so that Jira retries the delivery.
+	err = p.queueWebhook(instanceID, bb)
+	if err != nil {
+		return respondErr(w, http.StatusServiceUnavailable, err)
+	}
+	return http.StatusOK, nil
+}
+
+// subscriptionResponse is a subscription that was created or edited, with its
+// template rendered with a sample event, for the user to check it.
+type subscriptionResponse struct {
+	*ChannelSubscription
+	TemplateSample string `json:"template_sample,omitempty"`
+}
+
+func newSubscriptionResponse(sub *ChannelSubscription) *subscriptionResponse {
+	response := &subscriptionResponse{ChannelSubscription: sub}
+	if sub.Template != nil {
+		response.TemplateSample = sub.Template.sample
 	}
+	return response
 }
 
 func (p *Plugin) httpChannelCreateSubscription(w http.ResponseWriter, r *http.Request) (int, error) {
@@ -842,7 +1112,7 @@ func (p *Plugin) httpChannelCreateSubscription(w http.ResponseWriter, r *http.Re
 		ProjectKey: projectKey,
 	})
 
-	code, err := respondJSON(w, &subscription)
+	code, err := respondJSON(w, newSubscriptionResponse(&subscription))
 	if err != nil {
 		return code, err
 	}
@@ -904,7 +1174,7 @@ func (p *Plugin) httpChannelEditSubscription(w http.ResponseWriter, r *http.Requ
 		ProjectKey: projectKey,
 	})
 
-	code, err := respondJSON(w, &subscription)
+	code, err := respondJSON(w, newSubscriptionResponse(&subscription))
 	if err != nil {
 		return code, err
 	}
@@ -0,0 +1,670 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/utils/types"
+)
+
+const (
+	subscriptionsExportVersion = 1
+
+	exportFormatJSON = "json"
+	exportFormatYAML = "yaml"
+
+	importConflictSkip      = "skip"
+	importConflictOverwrite = "overwrite"
+	importConflictRename    = "rename"
+
+	importActionAdd       = "add"
+	importActionUpdate    = "update"
+	importActionUnchanged = "unchanged"
+	importActionSkip      = "skip"
+	importActionError     = "error"
+
+	// maxSubscriptionsImportSize is the maximum size of an imported file.
+	maxSubscriptionsImportSize = 10 * 1024 * 1024
+)
+
+// subscriptionsExport is the file the channel subscriptions are exported to,
+// in JSON or YAML. The channels are identified by their team and channel
+// names, so that the subscriptions can be imported in another Mattermost
+// server.
+type subscriptionsExport struct {
+	Version       int                    `json:"version"`
+	InstanceID    types.ID               `json:"instance_id"`
+	Subscriptions []exportedSubscription `json:"subscriptions"`
+}
+
+type exportedSubscription struct {
+	TeamName    string `json:"team_name"`
+	ChannelName string `json:"channel_name"`
+	ChannelSubscription
+}
+
+// subscriptionsExportScope selects the subscriptions of a channel, of the
+// channels of a team, or all of them.
+type subscriptionsExportScope struct {
+	ChannelID string
+	TeamID    string
+}
+
+// subscriptionsImport is the result of an import, or of its dry run.
+type subscriptionsImport struct {
+	DryRun  bool                       `json:"dry_run"`
+	Results []subscriptionImportResult `json:"results"`
+}
+
+// subscriptionImportResult tells what is, or would be in a dry run, done with
+// an imported subscription: added, updated, left unchanged, skipped because a
+// subscription of the channel has the same name, or not imported because of
+// an error.
+type subscriptionImportResult struct {
+	Name        string   `json:"name"`
+	TeamName    string   `json:"team_name"`
+	ChannelName string   `json:"channel_name"`
+	ChannelID   string   `json:"channel_id,omitempty"`
+	Action      string   `json:"action"`
+	Changes     []string `json:"changes,omitempty"`
+	Reason      string   `json:"reason,omitempty"`
+}
+
+// exportSubscriptions exports the subscriptions of a scope. The team admins
+// only export the subscriptions of the channels they can manage the
+// subscriptions of.
+func (p *Plugin) exportSubscriptions(instanceID types.ID, mattermostUserID string, scope subscriptionsExportScope) (*subscriptionsExport, error) {
+	subs, err := p.getSubscriptions(instanceID)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to load the subscriptions")
+	}
+
+	checkChannels := false
+	if scope.ChannelID == "" && scope.TeamID != "" {
+		sysAdmin, sysAdminErr := authorizedSysAdmin(p, mattermostUserID)
+		if sysAdminErr != nil {
+			return nil, errors.WithMessage(sysAdminErr, "failed to check the permissions")
+		}
+		checkChannels = !sysAdmin
+	}
+	permitted := map[string]bool{}
+
+	channels := map[string]*model.Channel{}
+	teams := map[string]*model.Team{}
+	export := &subscriptionsExport{
+		Version:       subscriptionsExportVersion,
+		InstanceID:    instanceID,
+		Subscriptions: []exportedSubscription{},
+	}
+	for _, sub := range subs.Channel.ByID {
+		if scope.ChannelID != "" && sub.ChannelID != scope.ChannelID {
+			continue
+		}
+
+		channel, ok := channels[sub.ChannelID]
+		if !ok {
+			channel, err = p.client.Channel.Get(sub.ChannelID)
+			if err != nil {
+				p.errorf("exportSubscriptions: failed to get channel %s: %v", sub.ChannelID, err)
+			}
+			channels[sub.ChannelID] = channel
+		}
+		if channel == nil || (scope.TeamID != "" && channel.TeamId != scope.TeamID) {
+			continue
+		}
+		if checkChannels {
+			allowed, ok := permitted[sub.ChannelID]
+			if !ok {
+				allowed = p.hasPermissionToManageSubscription(instanceID, mattermostUserID, sub.ChannelID) == nil
+				permitted[sub.ChannelID] = allowed
+			}
+			if !allowed {
+				continue
+			}
+		}
+
+		teamName := ""
+		if channel.TeamId != "" {
+			team, ok := teams[channel.TeamId]
+			if !ok {
+				team, err = p.client.Team.Get(channel.TeamId)
+				if err != nil {
+					return nil, errors.WithMessagef(err, "failed to get team %s", channel.TeamId)
+				}
+				teams[channel.TeamId] = team
+			}
+			teamName = team.Name
+		}
+
+		export.Subscriptions = append(export.Subscriptions, exportedSubscription{
+			TeamName:            teamName,
+			ChannelName:         channel.Name,
+			ChannelSubscription: sub,
+		})
+	}
+
+	sort.Slice(export.Subscriptions, func(i, j int) bool {
+		a, b := export.Subscriptions[i], export.Subscriptions[j]
+		if a.TeamName != b.TeamName {
+			return a.TeamName < b.TeamName
+		}
+		if a.ChannelName != b.ChannelName {
+			return a.ChannelName < b.ChannelName
+		}
+		return a.Name < b.Name
+	})
+	return export, nil
+}
+
+func encodeSubscriptionsExport(export *subscriptionsExport, format string) ([]byte, error) {
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil || format != exportFormatYAML {
+		return data, err
+	}
+
+	// The subscriptions are converted through JSON to keep their field names
+	// and the encoding of their sets.
+	var value interface{}
+	err = json.Unmarshal(data, &value)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(value)
+}
+
+// decodeSubscriptionsExport decodes an export in JSON or YAML, as JSON is
+// YAML.
+func decodeSubscriptionsExport(data []byte) (*subscriptionsExport, error) {
+	var value interface{}
+	err := yaml.Unmarshal(data, &value)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to parse the file")
+	}
+	data, err = json.Marshal(value)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to parse the file")
+	}
+
+	export := &subscriptionsExport{}
+	err = json.Unmarshal(data, export)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to parse the subscriptions")
+	}
+	if export.Version < 1 || export.Version > subscriptionsExportVersion {
+		return nil, errors.Errorf("unsupported version %d of the subscriptions file", export.Version)
+	}
+	return export, nil
+}
+
+// importSubscriptions imports the subscriptions of an export in the channels
+// with the same team and channel names. The subscriptions named like an
+// existing subscription of the channel are skipped, overwrite it, or are
+// renamed, depending on onConflict. In a dry run, the subscriptions are
+// validated, but not saved.
+func (p *Plugin) importSubscriptions(instanceID types.ID, mattermostUserID string, client Client, export *subscriptionsExport, onConflict string, dryRun bool) (*subscriptionsImport, error) {
+	switch onConflict {
+	case importConflictSkip, importConflictOverwrite, importConflictRename:
+	default:
+		return nil, errors.Errorf("invalid conflict handling %q, please use skip, overwrite or rename", onConflict)
+	}
+
+	result := &subscriptionsImport{
+		DryRun:  dryRun,
+		Results: []subscriptionImportResult{},
+	}
+	// The names of the subscriptions of each channel, including the ones
+	// imported so far
+	names := map[string][]ChannelSubscription{}
+	imported := map[string]StringSet{}
+	for _, exported := range export.Subscriptions {
+		r := subscriptionImportResult{
+			Name:        exported.Name,
+			TeamName:    exported.TeamName,
+			ChannelName: exported.ChannelName,
+		}
+		p.importSubscription(instanceID, mattermostUserID, client, exported, onConflict, dryRun, names, imported, &r)
+		result.Results = append(result.Results, r)
+	}
+	return result, nil
+}
+
+func (p *Plugin) importSubscription(instanceID types.ID, mattermostUserID string, client Client, exported exportedSubscription,
+	onConflict string, dryRun bool, names map[string][]ChannelSubscription, imported map[string]StringSet, r *subscriptionImportResult) {
+	r.Action = importActionError
+	if exported.TeamName == "" || exported.ChannelName == "" {
+		r.Reason = "only the subscriptions of team channels can be imported"
+		return
+	}
+	channel, err := p.client.Channel.GetByNameForTeamName(exported.TeamName, exported.ChannelName, false)
+	if err != nil {
+		r.Reason = fmt.Sprintf("channel %s was not found in team %s", exported.ChannelName, exported.TeamName)
+		return
+	}
+	r.ChannelID = channel.Id
+
+	err = p.hasPermissionToManageSubscription(instanceID, mattermostUserID, channel.Id)
+	if err != nil {
+		r.Reason = fmt.Sprintf("you don't have permission to manage the subscriptions of this channel: %v", err)
+		return
+	}
+
+	existing, ok := names[channel.Id]
+	if !ok {
+		subs, loadErr := p.getSubscriptionsForChannel(instanceID, channel.Id)
+		if loadErr != nil {
+			r.Reason = fmt.Sprintf("failed to load the subscriptions of the channel: %v", loadErr)
+			return
+		}
+		existing = subs
+		names[channel.Id] = existing
+		imported[channel.Id] = NewStringSet()
+	}
+
+	sub := exported.ChannelSubscription
+	sub.ID = ""
+	sub.ChannelID = channel.Id
+	sub.InstanceID = instanceID
+
+	if imported[channel.Id].ContainsAny(sub.Name) {
+		r.Reason = "the file has another subscription with this name for this channel"
+		return
+	}
+
+	action := importActionAdd
+	var changes []string
+	if found := findSubscriptionByName(existing, sub.Name); found != nil {
+		old := *found
+		switch onConflict {
+		case importConflictSkip:
+			r.Action = importActionSkip
+			r.Reason = "the channel already has a subscription with this name"
+			return
+		case importConflictOverwrite:
+			sub.ID = old.ID
+			sub.Name = old.Name
+			changes = subscriptionChanges(&old, &sub)
+			action = importActionUpdate
+			if len(changes) == 0 {
+				action = importActionUnchanged
+			}
+		case importConflictRename:
+			sub.Name = uniqueSubscriptionName(sub.Name, existing)
+		}
+	}
+
+	err = p.validateSubscription(instanceID, &sub, client)
+	if err != nil {
+		r.Reason = err.Error()
+		return
+	}
+
+	if !dryRun {
+		switch action {
+		case importActionAdd:
+			err = p.addChannelSubscription(instanceID, &sub, client)
+		case importActionUpdate:
+			err = p.editChannelSubscription(instanceID, &sub, client)
+		}
+		if err != nil {
+			r.Reason = err.Error()
+			return
+		}
+	}
+
+	if action == importActionAdd {
+		names[channel.Id] = append(existing, sub)
+	}
+	imported[channel.Id] = imported[channel.Id].Add(exported.Name, sub.Name)
+	r.Name = sub.Name
+	r.Action = action
+	r.Changes = changes
+}
+
+// uniqueSubscriptionName appends a number to a subscription name, so that it
+// is not the name of another subscription of the channel.
+func uniqueSubscriptionName(name string, existing []ChannelSubscription) string {
+	for i := 2; ; i++ {
+		suffix := fmt.Sprintf(" (%d)", i)
+		candidate := name
+		if len(candidate)+len(suffix) > MaxSubscriptionNameLength {
+			candidate = candidate[:MaxSubscriptionNameLength-len(suffix)]
+		}
+		candidate += suffix
+		if findSubscriptionByName(existing, candidate) == nil {
+			return candidate
+		}
+	}
+}
+
+// subscriptionChanges returns the names of the settings that differ between
+// two subscriptions.
+func subscriptionChanges(old, updated *ChannelSubscription) []string {
+	changes := []string{}
+	if !reflect.DeepEqual(old.Filters.Events, updated.Filters.Events) {
+		changes = append(changes, "events")
+	}
+	if !reflect.DeepEqual(old.Filters.Projects, updated.Filters.Projects) {
+		changes = append(changes, "projects")
+	}
+	if !reflect.DeepEqual(old.Filters.IssueTypes, updated.Filters.IssueTypes) {
+		changes = append(changes, "issue types")
+	}
+	if (len(old.Filters.Fields) > 0 || len(updated.Filters.Fields) > 0) && !jsonEqual(old.Filters.Fields, updated.Filters.Fields) {
+		changes = append(changes, "fields")
+	}
+	if old.Filters.JQL != updated.Filters.JQL {
+		changes = append(changes, "JQL")
+	}
+	if old.ThreadByIssue != updated.ThreadByIssue {
+		changes = append(changes, "threading")
+	}
+	if !jsonEqual(old.Template, updated.Template) {
+		changes = append(changes, "template")
+	}
+	if old.Priority != updated.Priority {
+		changes = append(changes, "priority")
+	}
+	if old.isEnabled() != updated.isEnabled() || old.SnoozedUntil != updated.SnoozedUntil {
+		changes = append(changes, "mute")
+	}
+	if !jsonEqual(old.Schedule, updated.Schedule) {
+		changes = append(changes, "schedule")
+	}
+	if old.RateLimit != updated.RateLimit {
+		changes = append(changes, "rate limit")
+	}
+	return changes
+}
+
+func jsonEqual(a, b interface{}) bool {
+	dataA, errA := json.Marshal(a)
+	dataB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(dataA, dataB)
+}
+
+// hasPermissionToExportSubscriptions checks that the user can manage the
+// subscriptions of the channel, or is a team admin to export the ones of a
+// team, or a system admin to export all of them. The team admins still only
+// export the channels they can manage the subscriptions of.
+func (p *Plugin) hasPermissionToExportSubscriptions(instanceID types.ID, userID string, scope subscriptionsExportScope) error {
+	switch {
+	case scope.ChannelID != "":
+		return p.hasPermissionToManageSubscription(instanceID, userID, scope.ChannelID)
+	case scope.TeamID != "" && p.client.User.HasPermissionToTeam(userID, scope.TeamID, model.PermissionManageTeam):
+		return nil
+	}
+
+	authorized, err := authorizedSysAdmin(p, userID)
+	if err != nil {
+		return err
+	}
+	if !authorized {
+		return errors.New("is not a system admin")
+	}
+	return nil
+}
+
+func (p *Plugin) httpExportSubscriptions(w http.ResponseWriter, r *http.Request) (int, error) {
+	mattermostUserID := r.Header.Get(HeaderMattermostUserID)
+	instanceID := types.ID(r.FormValue("instance_id"))
+	scope := subscriptionsExportScope{
+		ChannelID: r.FormValue("channel_id"),
+		TeamID:    r.FormValue("team_id"),
+	}
+	format := r.FormValue("format")
+	if format == "" {
+		format = exportFormatJSON
+	}
+	if format != exportFormatJSON && format != exportFormatYAML {
+		return respondErr(w, http.StatusBadRequest, errors.Errorf("invalid format %q, please use json or yaml", format))
+	}
+
+	err := p.hasPermissionToExportSubscriptions(instanceID, mattermostUserID, scope)
+	if err != nil {
+		return respondErr(w, http.StatusForbidden,
+			errors.Wrap(err, "you don't have permission to export these subscriptions"))
+	}
+
+	export, err := p.exportSubscriptions(instanceID, mattermostUserID, scope)
+	if err != nil {
+		return respondErr(w, http.StatusInternalServerError, err)
+	}
+	data, err := encodeSubscriptionsExport(export, format)
+	if err != nil {
+		return respondErr(w, http.StatusInternalServerError, err)
+	}
+
+	contentType := "application/json"
+	if format == exportFormatYAML {
+		contentType = "application/yaml"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "jira-subscriptions."+format))
+	_, err = w.Write(data)
+	if err != nil {
+		return http.StatusInternalServerError, errors.WithMessage(err, "failed to write the response")
+	}
+	return http.StatusOK, nil
+}
+
+func (p *Plugin) httpImportSubscriptions(w http.ResponseWriter, r *http.Request) (int, error) {
+	mattermostUserID := r.Header.Get(HeaderMattermostUserID)
+	instanceID := types.ID(r.FormValue("instance_id"))
+	dryRun := r.FormValue("dry_run") == "true"
+	onConflict := r.FormValue("on_conflict")
+	if onConflict == "" {
+		onConflict = importConflictSkip
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxSubscriptionsImportSize))
+	if err != nil {
+		return respondErr(w, http.StatusBadRequest, errors.WithMessage(err, "failed to read the request"))
+	}
+	export, err := decodeSubscriptionsExport(data)
+	if err != nil {
+		return respondErr(w, http.StatusBadRequest, err)
+	}
+
+	client, _, _, err := p.getClient(instanceID, types.ID(mattermostUserID))
+	if err != nil {
+		return respondErr(w, http.StatusInternalServerError, err)
+	}
+
+	result, err := p.importSubscriptions(instanceID, mattermostUserID, client, export, onConflict, dryRun)
+	if err != nil {
+		return respondErr(w, http.StatusBadRequest, err)
+	}
+	return respondJSON(w, result)
+}
+
+func executeSubscribeExport(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	const helpText = "Please use `/jira subscribe export [--channel|--team|--all] [--format=json|yaml]`."
+	_, instance, args, err := p.loadFlagUserInstance(header.UserId, args)
+	if err != nil {
+		return p.responsef(header, "Failed to identify the Jira instance. Error: %v.", err)
+	}
+
+	scope := subscriptionsExportScope{ChannelID: header.ChannelId}
+	scopeName := "this channel"
+	format := exportFormatJSON
+	for _, arg := range args {
+		switch {
+		case arg == "--channel":
+			scope, scopeName = subscriptionsExportScope{ChannelID: header.ChannelId}, "this channel"
+		case arg == "--team":
+			scope, scopeName = subscriptionsExportScope{TeamID: header.TeamId}, "this team"
+		case arg == "--all":
+			scope, scopeName = subscriptionsExportScope{}, "all the channels"
+		case strings.HasPrefix(arg, "--format="):
+			format = strings.ToLower(strings.TrimPrefix(arg, "--format="))
+			if format != exportFormatJSON && format != exportFormatYAML {
+				return p.responsef(header, helpText)
+			}
+		default:
+			return p.responsef(header, helpText)
+		}
+	}
+
+	err = p.hasPermissionToExportSubscriptions(instance.GetID(), header.UserId, scope)
+	if err != nil {
+		return p.responsef(header, "You don't have permission to export the subscriptions of %s.", scopeName)
+	}
+
+	export, err := p.exportSubscriptions(instance.GetID(), header.UserId, scope)
+	if err != nil {
+		return p.responsef(header, "Failed to export the subscriptions. Error: %v.", err)
+	}
+	data, err := encodeSubscriptionsExport(export, format)
+	if err != nil {
+		return p.responsef(header, "Failed to export the subscriptions. Error: %v.", err)
+	}
+
+	// The file is sent in a direct message, as ephemeral posts can not have
+	// attachments.
+	botUserID := p.getConfig().botUserID
+	channel, err := p.client.Channel.GetDirect(header.UserId, botUserID)
+	if err != nil {
+		return p.responsef(header, "Failed to send the export. Error: %v.", err)
+	}
+	fileInfo, err := p.client.File.Upload(bytes.NewReader(data), "jira-subscriptions."+format, channel.Id)
+	if err != nil {
+		return p.responsef(header, "Failed to send the export. Error: %v.", err)
+	}
+	err = p.client.Post.CreatePost(&model.Post{
+		UserId:    botUserID,
+		ChannelId: channel.Id,
+		Message:   fmt.Sprintf("The %d Jira subscriptions of %s for %s.", len(export.Subscriptions), scopeName, instance.GetURL()),
+		FileIds:   []string{fileInfo.Id},
+	})
+	if err != nil {
+		return p.responsef(header, "Failed to send the export. Error: %v.", err)
+	}
+
+	return p.responsef(header, "The %d Jira subscriptions of %s were sent to you in a direct message.", len(export.Subscriptions), scopeName)
+}
+
+// executeSubscribeImport imports the subscriptions of a file attached to a
+// post, given by its permalink, or pasted on the lines after the command.
+func executeSubscribeImport(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	const helpText = "Please use `/jira subscribe import [--dry-run] [--on-conflict=skip|overwrite|rename] [permalink]`, " +
+		"with the permalink of a post with the exported file attached, or with the exported subscriptions pasted on the next lines."
+
+	// The pasted subscriptions are on the lines after the command
+	lines := strings.SplitN(header.Command, "\n", 2)
+	if len(lines) == 2 {
+		args = strings.Fields(lines[0])
+		for len(args) > 0 && args[0] != "import" {
+			args = args[1:]
+		}
+		if len(args) > 0 {
+			args = args[1:]
+		}
+	}
+
+	user, instance, args, err := p.loadFlagUserInstance(header.UserId, args)
+	if err != nil {
+		return p.responsef(header, "Failed to load your connection to Jira. Error: %v.", err)
+	}
+
+	dryRun := false
+	onConflict := importConflictSkip
+	permalink := ""
+	for _, arg := range args {
+		switch {
+		case arg == "--dry-run":
+			dryRun = true
+		case strings.HasPrefix(arg, "--on-conflict="):
+			onConflict = strings.TrimPrefix(arg, "--on-conflict=")
+		case !strings.HasPrefix(arg, "--") && permalink == "":
+			permalink = arg
+		default:
+			return p.responsef(header, helpText)
+		}
+	}
+
+	var data []byte
+	switch {
+	case len(lines) == 2:
+		data = []byte(lines[1])
+	case permalink != "":
+		data, err = p.loadPermalinkFile(header.UserId, permalink)
+		if err != nil {
+			return p.responsef(header, "Failed to load the file to import. Error: %v.", err)
+		}
+	default:
+		return p.responsef(header, helpText)
+	}
+
+	export, err := decodeSubscriptionsExport(data)
+	if err != nil {
+		return p.responsef(header, "Failed to import the subscriptions. Error: %v.", err)
+	}
+
+	client, _, _, err := p.getClient(instance.GetID(), user.MattermostUserID)
+	if err != nil {
+		return p.responsef(header, "Failed to get a Jira client. Error: %v.", err)
+	}
+
+	result, err := p.importSubscriptions(instance.GetID(), header.UserId, client, export, onConflict, dryRun)
+	if err != nil {
+		return p.responsef(header, "Failed to import the subscriptions. Error: %v.", err)
+	}
+	if len(result.Results) == 0 {
+		return p.responsef(header, "The file has no subscriptions.")
+	}
+
+	text := "Imported the subscriptions:\n\n"
+	if dryRun {
+		text = "Dry run, no subscriptions were changed. The import would:\n\n"
+	}
+	text += "|Team|Channel|Subscription|Action|Details|\n|--|--|--|--|--|\n"
+	for _, r := range result.Results {
+		details := r.Reason
+		if len(r.Changes) > 0 {
+			details = "Changes: " + strings.Join(r.Changes, ", ")
+		}
+		text += fmt.Sprintf("|%s|%s|%s|%s|%s|\n",
+			escapeTableCell(r.TeamName), escapeTableCell(r.ChannelName), escapeTableCell(r.Name), r.Action, escapeTableCell(details))
+	}
+	return p.responsef(header, "%s", text)
+}
+
+// loadPermalinkFile returns the first file attached to the post of a
+// permalink, if the user can read it.
+func (p *Plugin) loadPermalinkFile(userID, permalink string) ([]byte, error) {
+	match := permalinkPostIDRegex.FindStringSubmatch(permalink)
+	if match == nil {
+		return nil, errors.Errorf("%q is not a permalink", permalink)
+	}
+	post, err := p.client.Post.GetPost(match[1])
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to get the post")
+	}
+	if !p.client.User.HasPermissionToChannel(userID, post.ChannelId, model.PermissionReadChannel) {
+		return nil, errors.New("you can not read the post")
+	}
+	if len(post.FileIds) == 0 {
+		return nil, errors.New("the post has no file attached")
+	}
+
+	reader, err := p.client.File.Get(post.FileIds[0])
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to get the file")
+	}
+	return io.ReadAll(io.LimitReader(reader, maxSubscriptionsImportSize))
+}
@@ -0,0 +1,310 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest/mock"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func exportTestSubscription(id, channelID, name string, events ...string) ChannelSubscription {
+	return ChannelSubscription{
+		ID:         id,
+		ChannelID:  channelID,
+		Name:       name,
+		InstanceID: testInstance1.InstanceID,
+		Filters: SubscriptionFilters{
+			Events:     NewStringSet(events...),
+			Projects:   NewStringSet("TES"),
+			IssueTypes: NewStringSet("10001"),
+			Fields:     []FieldFilter{},
+		},
+	}
+}
+
+// setupExportTest returns a plugin with the subscriptions stored in a KV
+// store, channel1 and channel2 in team1, and channel3 in team2.
+func setupExportTest(t *testing.T, subs []ChannelSubscription) (*Plugin, *plugintest.API) {
+	p := &Plugin{}
+	p.updateConfig(func(conf *config) {
+		conf.RolesAllowedToEditJiraSubscriptions = "users"
+	})
+	api := &plugintest.API{}
+	p.SetAPI(api)
+	p.client = pluginapi.NewClient(api, p.Driver)
+	p.instanceStore = mockInstanceStore{}
+	p.userStore = mockUserStore{}
+
+	mockSubscriptionStore(t, api, withExistingChannelSubscriptions(subs))
+
+	channels := []*model.Channel{
+		{Id: "channel1", Name: "town-square", TeamId: "team1", Type: model.ChannelTypeOpen},
+		{Id: "channel2", Name: "bugs", TeamId: "team1", Type: model.ChannelTypeOpen},
+		{Id: "channel3", Name: "town-square", TeamId: "team2", Type: model.ChannelTypeOpen},
+	}
+	teams := map[string]*model.Team{
+		"team1": {Id: "team1", Name: "core"},
+		"team2": {Id: "team2", Name: "ops"},
+	}
+	for _, channel := range channels {
+		api.On("GetChannel", channel.Id).Return(channel, nil)
+		api.On("GetChannelByNameForTeamName", teams[channel.TeamId].Name, channel.Name, false).Return(channel, nil)
+	}
+	for id, team := range teams {
+		api.On("GetTeam", id).Return(team, nil)
+	}
+	api.On("GetChannelByNameForTeamName", mock.AnythingOfType("string"), mock.AnythingOfType("string"), false).Return(
+		nil, &model.AppError{Message: "not found"})
+	api.On("GetUser", "user1").Return(&model.User{Id: "user1", Roles: model.SystemUserRoleId}, nil).Maybe()
+	api.On("GetUser", "admin").Return(&model.User{Id: "admin", Roles: model.SystemAdminRoleId}, nil).Maybe()
+	api.On("LogError", mock.AnythingOfType("string")).Maybe()
+	return p, api
+}
+
+func TestExportSubscriptions(t *testing.T) {
+	subs := []ChannelSubscription{
+		exportTestSubscription("sub1", "channel1", "zeta", eventCreated),
+		exportTestSubscription("sub2", "channel1", "alpha", eventCreated),
+		exportTestSubscription("sub3", "channel2", "bugs", eventCreated),
+		exportTestSubscription("sub4", "channel3", "ops", eventCreated),
+	}
+
+	for name, tc := range map[string]struct {
+		userID       string
+		channelAdmin bool
+		scope        subscriptionsExportScope
+		expected     []string
+	}{
+		"channel": {
+			userID:   "user1",
+			scope:    subscriptionsExportScope{ChannelID: "channel1"},
+			expected: []string{"core/town-square/alpha", "core/town-square/zeta"},
+		},
+		"team": {
+			userID:   "user1",
+			scope:    subscriptionsExportScope{TeamID: "team1"},
+			expected: []string{"core/bugs/bugs", "core/town-square/alpha", "core/town-square/zeta"},
+		},
+		"team, only the channels the user manages the subscriptions of": {
+			userID:       "user1",
+			channelAdmin: true,
+			scope:        subscriptionsExportScope{TeamID: "team1"},
+			expected:     []string{"core/town-square/alpha", "core/town-square/zeta"},
+		},
+		"team, as a system admin": {
+			userID:       "admin",
+			channelAdmin: true,
+			scope:        subscriptionsExportScope{TeamID: "team1"},
+			expected:     []string{"core/bugs/bugs", "core/town-square/alpha", "core/town-square/zeta"},
+		},
+		"all": {
+			userID:   "admin",
+			scope:    subscriptionsExportScope{},
+			expected: []string{"core/bugs/bugs", "core/town-square/alpha", "core/town-square/zeta", "ops/town-square/ops"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p, api := setupExportTest(t, subs)
+			if tc.channelAdmin {
+				p.updateConfig(func(conf *config) {
+					conf.RolesAllowedToEditJiraSubscriptions = "channel_admin"
+				})
+				api.On("HasPermissionToChannel", "user1", "channel1", model.PermissionManagePublicChannelProperties).Return(true)
+				api.On("HasPermissionToChannel", "user1", "channel2", model.PermissionManagePublicChannelProperties).Return(false)
+			}
+			export, err := p.exportSubscriptions(testInstance1.InstanceID, tc.userID, tc.scope)
+			require.NoError(t, err)
+			assert.Equal(t, subscriptionsExportVersion, export.Version)
+			assert.Equal(t, testInstance1.InstanceID, export.InstanceID)
+
+			actual := []string{}
+			for _, sub := range export.Subscriptions {
+				actual = append(actual, sub.TeamName+"/"+sub.ChannelName+"/"+sub.Name)
+			}
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestSubscriptionsExportEncoding(t *testing.T) {
+	sub := exportTestSubscription("sub1", "channel1", "bugs", eventCreated, eventUpdatedStatus)
+	sub.Filters.Fields = []FieldFilter{{Key: "priority", Inclusion: FilterIncludeAny, Values: NewStringSet("1", "2")}}
+	sub.ThreadByIssue = true
+	sub.Template = &SubscriptionTemplate{
+		Message: "{{.Issue.Key}}: {{.Issue.Summary}}",
+		Colors:  AttachmentColors{ByPriority: map[string]string{"High": "#ff0000"}},
+	}
+	export := &subscriptionsExport{
+		Version:    subscriptionsExportVersion,
+		InstanceID: testInstance1.InstanceID,
+		Subscriptions: []exportedSubscription{
+			{TeamName: "core", ChannelName: "town-square", ChannelSubscription: sub},
+		},
+	}
+
+	for _, format := range []string{exportFormatJSON, exportFormatYAML} {
+		t.Run(format, func(t *testing.T) {
+			data, err := encodeSubscriptionsExport(export, format)
+			require.NoError(t, err)
+			if format == exportFormatYAML {
+				assert.Contains(t, string(data), "team_name: core\n")
+			}
+
+			decoded, err := decodeSubscriptionsExport(data)
+			require.NoError(t, err)
+			assert.Equal(t, export, decoded)
+		})
+	}
+
+	t.Run("unsupported version", func(t *testing.T) {
+		_, err := decodeSubscriptionsExport([]byte(`{"version": 2, "subscriptions": []}`))
+		require.Error(t, err)
+		assert.Equal(t, "unsupported version 2 of the subscriptions file", err.Error())
+	})
+
+	t.Run("invalid file", func(t *testing.T) {
+		_, err := decodeSubscriptionsExport([]byte(`{"version": 1, "subscriptions": {`))
+		require.Error(t, err)
+	})
+}
+
+func TestImportSubscriptions(t *testing.T) {
+	existing := []ChannelSubscription{
+		exportTestSubscription("sub1", "channel1", "Bugs", eventCreated),
+		exportTestSubscription("sub2", "channel1", "unchanged", eventCreated),
+		exportTestSubscription("sub3", "channel2", "Ops", eventCreated),
+		exportTestSubscription("sub4", "channel2", "OPS", eventCreated),
+	}
+	exported := func(teamName, channelName string, sub ChannelSubscription) exportedSubscription {
+		sub.ID = "exported"
+		sub.ChannelID = "otherchannel"
+		sub.InstanceID = "otherinstance"
+		return exportedSubscription{TeamName: teamName, ChannelName: channelName, ChannelSubscription: sub}
+	}
+	export := &subscriptionsExport{
+		Version: subscriptionsExportVersion,
+		Subscriptions: []exportedSubscription{
+			exported("core", "town-square", exportTestSubscription("", "", "bugs", eventCreated, eventDeleted)),
+			exported("core", "town-square", exportTestSubscription("", "", "unchanged", eventCreated)),
+			exported("core", "town-square", exportTestSubscription("", "", "new", eventCreated)),
+			exported("core", "town-square", exportTestSubscription("", "", "new", eventCreated)),
+			exported("core", "bugs", exportTestSubscription("", "", "bugs", eventCreated)),
+			exported("core", "bugs", exportTestSubscription("", "", "ops", eventCreated)),
+			exported("core", "missing", exportTestSubscription("", "", "missing", eventCreated)),
+			exported("", "dm", exportTestSubscription("", "", "dm", eventCreated)),
+			exported("ops", "town-square", exportTestSubscription("", "", "invalid")),
+		},
+	}
+	common := []subscriptionImportResult{
+		{Name: "new", TeamName: "core", ChannelName: "town-square", ChannelID: "channel1", Action: importActionAdd},
+		{Name: "new", TeamName: "core", ChannelName: "town-square", ChannelID: "channel1", Action: importActionError,
+			Reason: "the file has another subscription with this name for this channel"},
+		{Name: "bugs", TeamName: "core", ChannelName: "bugs", ChannelID: "channel2", Action: importActionAdd},
+		// The name differs in case only from several subscriptions of the
+		// channel, so it is not the name of any of them
+		{Name: "ops", TeamName: "core", ChannelName: "bugs", ChannelID: "channel2", Action: importActionAdd},
+		{Name: "missing", TeamName: "core", ChannelName: "missing", Action: importActionError,
+			Reason: "channel missing was not found in team core"},
+		{Name: "dm", ChannelName: "dm", Action: importActionError,
+			Reason: "only the subscriptions of team channels can be imported"},
+		{Name: "invalid", TeamName: "ops", ChannelName: "town-square", ChannelID: "channel3", Action: importActionError,
+			Reason: "please provide at least one event type"},
+	}
+
+	for name, tc := range map[string]struct {
+		onConflict    string
+		dryRun        bool
+		expected      []subscriptionImportResult
+		expectedNames []string
+	}{
+		"skip": {
+			onConflict: importConflictSkip,
+			expected: []subscriptionImportResult{
+				{Name: "bugs", TeamName: "core", ChannelName: "town-square", ChannelID: "channel1", Action: importActionSkip,
+					Reason: "the channel already has a subscription with this name"},
+				{Name: "unchanged", TeamName: "core", ChannelName: "town-square", ChannelID: "channel1", Action: importActionSkip,
+					Reason: "the channel already has a subscription with this name"},
+			},
+			expectedNames: []string{"Bugs", "new", "unchanged"},
+		},
+		"overwrite": {
+			onConflict: importConflictOverwrite,
+			expected: []subscriptionImportResult{
+				{Name: "Bugs", TeamName: "core", ChannelName: "town-square", ChannelID: "channel1", Action: importActionUpdate,
+					Changes: []string{"events"}},
+				{Name: "unchanged", TeamName: "core", ChannelName: "town-square", ChannelID: "channel1", Action: importActionUnchanged,
+					Changes: []string{}},
+			},
+			expectedNames: []string{"Bugs", "new", "unchanged"},
+		},
+		"rename": {
+			onConflict: importConflictRename,
+			expected: []subscriptionImportResult{
+				{Name: "bugs (2)", TeamName: "core", ChannelName: "town-square", ChannelID: "channel1", Action: importActionAdd},
+				{Name: "unchanged (2)", TeamName: "core", ChannelName: "town-square", ChannelID: "channel1", Action: importActionAdd},
+			},
+			expectedNames: []string{"Bugs", "bugs (2)", "new", "unchanged", "unchanged (2)"},
+		},
+		"dry run": {
+			onConflict: importConflictRename,
+			dryRun:     true,
+			expected: []subscriptionImportResult{
+				{Name: "bugs (2)", TeamName: "core", ChannelName: "town-square", ChannelID: "channel1", Action: importActionAdd},
+				{Name: "unchanged (2)", TeamName: "core", ChannelName: "town-square", ChannelID: "channel1", Action: importActionAdd},
+			},
+			expectedNames: []string{"Bugs", "unchanged"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p, api := setupExportTest(t, existing)
+
+			result, err := p.importSubscriptions(testInstance1.InstanceID, "user1", testClient{}, export, tc.onConflict, tc.dryRun)
+			require.NoError(t, err)
+			assert.Equal(t, tc.dryRun, result.DryRun)
+			assert.Equal(t, append(tc.expected, common...), result.Results)
+
+			subs := loadTestSubscriptions(t, api)
+			names := []string{}
+			for _, id := range subs.Channel.IDByChannelID["channel1"].Elems() {
+				sub := subs.Channel.ByID[id]
+				names = append(names, sub.Name)
+				assert.Equal(t, "channel1", sub.ChannelID)
+				assert.Equal(t, testInstance1.InstanceID, sub.InstanceID)
+				if sub.Name == "Bugs" && tc.onConflict == importConflictOverwrite {
+					assert.Equal(t, "sub1", sub.ID)
+					assert.True(t, sub.Filters.Events.ContainsAll(eventCreated, eventDeleted))
+				}
+			}
+			sort.Strings(names)
+			assert.Equal(t, tc.expectedNames, names)
+		})
+	}
+
+	t.Run("invalid conflict handling", func(t *testing.T) {
+		p, _ := setupExportTest(t, existing)
+		_, err := p.importSubscriptions(testInstance1.InstanceID, "user1", testClient{}, export, "merge", false)
+		require.Error(t, err)
+	})
+}
+
+func TestUniqueSubscriptionName(t *testing.T) {
+	existing := []ChannelSubscription{{Name: "bugs"}, {Name: "bugs (2)"}}
+	assert.Equal(t, "Bugs (3)", uniqueSubscriptionName("Bugs", existing))
+	assert.Equal(t, "other (2)", uniqueSubscriptionName("other", existing))
+
+	long := ""
+	for i := 0; i < MaxSubscriptionNameLength; i++ {
+		long += "a"
+	}
+	renamed := uniqueSubscriptionName(long, existing)
+	assert.Len(t, renamed, MaxSubscriptionNameLength)
+	assert.Contains(t, renamed, " (2)")
+}
@@ -0,0 +1,210 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/utils/types"
+)
+
+const (
+	prefixJiraGroupMembers = "jira_group_members_"
+
+	// jiraGroupMembersTTL is how long the members of a Jira group are cached,
+	// so that the subscriptions filtering on groups do not query Jira on each
+	// event.
+	jiraGroupMembersTTL = 10 * time.Minute
+)
+
+// userFields are the fields whose changes can be filtered on the groups of
+// the user the field is changed to.
+var userFields = NewStringSet("assignee", "reporter")
+
+// fieldChange is a change of an issue field by a webhook event, with the IDs
+// of the values as well as their names.
+type fieldChange struct {
+	webhookField
+	fromID string
+	toID   string
+}
+
+// webhookFieldChanges returns the changes of the changelog of a webhook
+// event, or of its field if it has no changelog. The creation of an issue
+// changes no field.
+func webhookFieldChanges(wh *webhook) []fieldChange {
+	changes := []fieldChange{}
+	if wh.Events().ContainsAny(eventCreated) {
+		// The changelog of a created issue lists its initial values
+		return changes
+	}
+	for _, item := range wh.JiraWebhook.ChangeLog.Items {
+		fieldID := item.FieldID
+		if fieldID == "" {
+			fieldID = item.Field
+		}
+		changes = append(changes, fieldChange{
+			webhookField: webhookField{name: item.Field, id: fieldID, from: item.FromString, to: item.ToString},
+			fromID:       item.From,
+			toID:         item.To,
+		})
+	}
+	if len(changes) == 0 && wh.fieldInfo.id != "" {
+		changes = append(changes, fieldChange{webhookField: wh.fieldInfo})
+	}
+	return changes
+}
+
+func (c fieldChange) isField(key string) bool {
+	return strings.EqualFold(c.id, key) || strings.EqualFold(c.name, key)
+}
+
+// changeValueMatches tells if the ID or the name of a value of a change is
+// one of the values of a condition. An empty condition matches any value.
+func changeValueMatches(values StringSet, id, name string) bool {
+	if values.Len() == 0 {
+		return true
+	}
+	for value := range values {
+		if (id != "" && value == id) || (name != "" && strings.EqualFold(value, name)) {
+			return true
+		}
+	}
+	return false
+}
+
+func changeValueName(id, name string) string {
+	switch {
+	case name != "":
+		return name
+	case id != "":
+		return id
+	default:
+		return "empty"
+	}
+}
+
+// fieldChangeMismatch returns why the changes of a webhook event do not match
+// a FilterChanged filter, or an empty string if one of them does.
+func (p *Plugin) fieldChangeMismatch(instanceID types.ID, wh *webhook, field FieldFilter) string {
+	reason := fmt.Sprintf("%s did not change", field.Key)
+	for _, change := range webhookFieldChanges(wh) {
+		if !change.isField(field.Key) {
+			continue
+		}
+
+		from := changeValueName(change.fromID, change.from)
+		to := changeValueName(change.toID, change.to)
+		switch {
+		case !changeValueMatches(field.From, change.fromID, change.from):
+			reason = fmt.Sprintf("%s changed from %s, not from any of %s", field.Key, from, joinSorted(field.From))
+		case !changeValueMatches(field.To, change.toID, change.to):
+			reason = fmt.Sprintf("%s changed to %s, not to any of %s", field.Key, to, joinSorted(field.To))
+		case field.ToGroups.Len() > 0 && change.toID == "":
+			reason = fmt.Sprintf("%s changed to nobody, not to a member of any of %s", field.Key, joinSorted(field.ToGroups))
+		case field.ToGroups.Len() > 0:
+			member, err := p.isJiraGroupsMember(instanceID, change.toID, field.ToGroups)
+			if err != nil {
+				p.debugf("fieldChangeMismatch: failed to load the members of %s: %v", joinSorted(field.ToGroups), err)
+				return fmt.Sprintf("the members of %s could not be loaded", joinSorted(field.ToGroups))
+			}
+			if !member {
+				reason = fmt.Sprintf("%s changed to %s, who is not a member of any of %s", field.Key, to, joinSorted(field.ToGroups))
+				continue
+			}
+			return ""
+		default:
+			return ""
+		}
+	}
+	return reason
+}
+
+// isJiraGroupsMember tells if a Jira user, identified by the ID of a change,
+// is a member of any of the groups.
+func (p *Plugin) isJiraGroupsMember(instanceID types.ID, jiraUserID string, groups StringSet) (bool, error) {
+	for _, group := range groups.Elems() {
+		members, err := p.loadJiraGroupMembers(instanceID, group)
+		if err != nil {
+			return false, err
+		}
+		if members.ContainsAny(jiraUserID) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// loadJiraGroupMembers returns the IDs of the members of a Jira group, from
+// the cache or from Jira. The members are fetched with the client of any
+// connected user, and are identified by their account ID on Jira Cloud, or
+// their name and key on Jira Server.
+func (p *Plugin) loadJiraGroupMembers(instanceID types.ID, group string) (StringSet, error) {
+	key := hashkey(prefixJiraGroupMembers, instanceID.String()+"/"+group)
+	var cached []string
+	err := p.client.KV.Get(key, &cached)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil {
+		return NewStringSet(cached...), nil
+	}
+
+	instance, err := p.instanceStore.LoadInstance(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	client, ok := p.getClientForAnyConnectedUser(instance)
+	if !ok {
+		return nil, errors.New("no user is connected to load the members of the group")
+	}
+	members, err := client.GetGroupMembers(group)
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for _, member := range members {
+		for _, id := range []string{member.AccountID, member.Name, member.Key} {
+			if id != "" {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	_, err = p.client.KV.Set(key, ids, pluginapi.SetExpiry(jiraGroupMembersTTL))
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to cache the members of the group")
+	}
+	return NewStringSet(ids...), nil
+}
+
+// validateFieldChangeFilter checks the conditions on the changes of a field
+// filter.
+func validateFieldChangeFilter(field FieldFilter, events StringSet) error {
+	if field.Inclusion != FilterChanged {
+		if field.From.Len() > 0 || field.To.Len() > 0 || field.ToGroups.Len() > 0 {
+			return errors.Errorf("the filter on %s has conditions on the changes of the field, but does not filter on its changes", field.Key)
+		}
+		return nil
+	}
+
+	if field.Values.Len() > 0 {
+		return errors.Errorf("please provide the values of the changes of %s as from and to conditions", field.Key)
+	}
+	if field.ToGroups.Len() > 0 && !userFields.ContainsAny(strings.ToLower(field.Key)) {
+		return errors.Errorf("only the changes of the assignee or the reporter can be filtered on groups, not the changes of %s", field.Key)
+	}
+	for event := range events {
+		if strings.HasPrefix(event, "event_updated") {
+			return nil
+		}
+	}
+	return errors.Errorf("please select an update event to filter on the changes of %s", field.Key)
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFieldChangeMismatch(t *testing.T) {
+	for name, tc := range map[string]struct {
+		data     string
+		field    FieldFilter
+		expected string
+	}{
+		"changed": {
+			data:  "webhook-issue-updated-raised-priority.json",
+			field: FieldFilter{Key: "priority", Inclusion: FilterChanged},
+		},
+		"changed from and to names": {
+			data:  "webhook-issue-updated-raised-priority.json",
+			field: FieldFilter{Key: "priority", Inclusion: FilterChanged, From: NewStringSet("low"), To: NewStringSet("Highest", "High")},
+		},
+		"changed to ID": {
+			data:  "webhook-issue-updated-raised-priority.json",
+			field: FieldFilter{Key: "Priority", Inclusion: FilterChanged, To: NewStringSet("2")},
+		},
+		"changed from another value": {
+			data:     "webhook-issue-updated-raised-priority.json",
+			field:    FieldFilter{Key: "priority", Inclusion: FilterChanged, From: NewStringSet("Medium")},
+			expected: "priority changed from Low, not from any of Medium",
+		},
+		"changed to another value": {
+			data:     "webhook-issue-updated-lowered-priority.json",
+			field:    FieldFilter{Key: "priority", Inclusion: FilterChanged, To: NewStringSet("High", "Highest")},
+			expected: "priority changed to Low, not to any of High, Highest",
+		},
+		"another field changed": {
+			data:     "webhook-issue-updated-raised-priority.json",
+			field:    FieldFilter{Key: "status", Inclusion: FilterChanged},
+			expected: "status did not change",
+		},
+		"not changed": {
+			data:     "webhook-issue-created.json",
+			field:    FieldFilter{Key: "priority", Inclusion: FilterChanged},
+			expected: "priority did not change",
+		},
+		"assigned to a member of the group": {
+			data:  "webhook-issue-updated-assigned.json",
+			field: FieldFilter{Key: "assignee", Inclusion: FilterChanged, ToGroups: NewStringSet("jira-developers", "jira-administrators")},
+		},
+		"assigned to a member of another group": {
+			data:     "webhook-issue-updated-assigned.json",
+			field:    FieldFilter{Key: "assignee", Inclusion: FilterChanged, ToGroups: NewStringSet("jira-administrators")},
+			expected: "assignee changed to Test User, who is not a member of any of jira-administrators",
+		},
+		"assigned to nobody": {
+			data:     "webhook-issue-updated-assigned-nobody.json",
+			field:    FieldFilter{Key: "assignee", Inclusion: FilterChanged, ToGroups: NewStringSet("jira-developers")},
+			expected: "assignee changed to nobody, not to a member of any of jira-developers",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &plugintest.API{}
+			mapped := 0
+			p := &Plugin{
+				instanceStore: previewInstanceStore{instance: testInstance1},
+				userStore:     unconnectedAuthorsUserStore{mapped: &mapped},
+			}
+			p.updateConfig(func(conf *config) {})
+			p.SetAPI(api)
+			p.client = pluginapi.NewClient(api, p.Driver)
+			makeTestKVStore(api, nil)
+
+			data, err := getJiraTestData(tc.data)
+			require.NoError(t, err)
+			wh, err := ParseWebhook(data)
+			require.NoError(t, err)
+
+			filters := SubscriptionFilters{Events: NewStringSet(eventUpdatedAny, eventCreated), Fields: []FieldFilter{tc.field}}
+			assert.Equal(t, tc.expected, p.subscriptionMismatch(testInstance1.InstanceID, wh.(*webhook), ChannelSubscription{Filters: filters}))
+		})
+	}
+}
+
+func TestLoadJiraGroupMembers(t *testing.T) {
+	api := &plugintest.API{}
+	mapped := 0
+	p := &Plugin{
+		instanceStore: previewInstanceStore{instance: testInstance1},
+		userStore:     unconnectedAuthorsUserStore{mapped: &mapped},
+	}
+	p.SetAPI(api)
+	p.client = pluginapi.NewClient(api, p.Driver)
+	store := makeTestKVStore(api, nil)
+
+	members, err := p.loadJiraGroupMembers(testInstance1.InstanceID, "jira-developers")
+	require.NoError(t, err)
+	assert.Equal(t, NewStringSet("admin", "5c5f880629be9642ba529340"), members)
+
+	// The members are cached per group
+	key := hashkey(prefixJiraGroupMembers, testInstance1.InstanceID.String()+"/jira-developers")
+	require.NotNil(t, store[key])
+	store[key] = []byte(`["cached"]`)
+	member, err := p.isJiraGroupsMember(testInstance1.InstanceID, "cached", NewStringSet("jira-developers", "jira-administrators"))
+	require.NoError(t, err)
+	assert.True(t, member)
+	member, err = p.isJiraGroupsMember(testInstance1.InstanceID, "admin", NewStringSet("jira-developers", "jira-administrators"))
+	require.NoError(t, err)
+	assert.False(t, member)
+
+	// The members cannot be loaded without a connected user
+	api = &plugintest.API{}
+	p = &Plugin{
+		instanceStore: previewInstanceStore{instance: testInstance1},
+		userStore:     mockUserStore{},
+	}
+	p.SetAPI(api)
+	p.client = pluginapi.NewClient(api, p.Driver)
+	makeTestKVStore(api, nil)
+	_, err = p.loadJiraGroupMembers(testInstance1.InstanceID, "jira-developers")
+	assert.EqualError(t, err, "no user is connected to load the members of the group")
+}
+
+func TestValidateFieldChangeFilter(t *testing.T) {
+	updated := NewStringSet(eventUpdatedStatus)
+	for name, tc := range map[string]struct {
+		field    FieldFilter
+		events   StringSet
+		expected string
+	}{
+		"changed": {
+			field:  FieldFilter{Key: "status", Inclusion: FilterChanged, From: NewStringSet("In Review"), To: NewStringSet("Done")},
+			events: updated,
+		},
+		"value filter": {
+			field:  FieldFilter{Key: "status", Inclusion: FilterIncludeAny, Values: NewStringSet("10001")},
+			events: NewStringSet(eventCreated),
+		},
+		"groups": {
+			field:  FieldFilter{Key: "Assignee", Inclusion: FilterChanged, ToGroups: NewStringSet("jira-developers")},
+			events: NewStringSet(eventUpdatedAny),
+		},
+		"conditions without changed": {
+			field:    FieldFilter{Key: "status", Inclusion: FilterIncludeAny, Values: NewStringSet("10001"), To: NewStringSet("Done")},
+			events:   updated,
+			expected: "the filter on status has conditions on the changes of the field, but does not filter on its changes",
+		},
+		"values": {
+			field:    FieldFilter{Key: "status", Inclusion: FilterChanged, Values: NewStringSet("Done")},
+			events:   updated,
+			expected: "please provide the values of the changes of status as from and to conditions",
+		},
+		"groups of another field": {
+			field:    FieldFilter{Key: "priority", Inclusion: FilterChanged, ToGroups: NewStringSet("jira-developers")},
+			events:   updated,
+			expected: "only the changes of the assignee or the reporter can be filtered on groups, not the changes of priority",
+		},
+		"no update event": {
+			field:    FieldFilter{Key: "status", Inclusion: FilterChanged},
+			events:   NewStringSet(eventCreated, eventCreatedComment),
+			expected: "please select an update event to filter on the changes of status",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := validateFieldChangeFilter(tc.field, tc.events)
+			if tc.expected == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tc.expected)
+		})
+	}
+}
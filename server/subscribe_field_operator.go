@@ -0,0 +1,384 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/pkg/errors"
+)
+
+// The operators of the field filters that compare the value of a field to an
+// operand, rather than to a set of values:
+//   - FilterGreaterThan and FilterLessThan compare a number or a date field to
+//     a number or a date, as 2006-01-02.
+//   - FilterBetween compares a number or a date field to the range of its two
+//     values, inclusive.
+//   - FilterWithinDays matches a date field within the next number of days,
+//     today included.
+//   - FilterMatches matches a text field with a regular expression.
+const (
+	FilterGreaterThan = "greater_than"
+	FilterLessThan    = "less_than"
+	FilterBetween     = "between"
+	FilterWithinDays  = "within_days"
+	FilterMatches     = "matches"
+
+	filterDateFormat = "2006-01-02"
+
+	fieldSchemaNumber   = "number"
+	fieldSchemaDate     = "date"
+	fieldSchemaDatetime = "datetime"
+	fieldSchemaString   = "string"
+)
+
+var comparisonInclusions = NewStringSet(FilterGreaterThan, FilterLessThan, FilterBetween, FilterWithinDays, FilterMatches)
+
+func isComparisonInclusion(inclusion string) bool {
+	return comparisonInclusions.ContainsAny(inclusion)
+}
+
+// filterOperand is a value of a comparison filter, a number or a date.
+type filterOperand struct {
+	text   string
+	number float64
+	date   time.Time
+	isDate bool
+}
+
+func parseFilterOperand(value string) (filterOperand, error) {
+	if number, err := strconv.ParseFloat(value, 64); err == nil {
+		return filterOperand{text: value, number: number}, nil
+	}
+	if date, err := time.Parse(filterDateFormat, value); err == nil {
+		return filterOperand{text: value, date: date, isDate: true}, nil
+	}
+	return filterOperand{}, errors.Errorf("%q is neither a number nor a date, as 2006-01-02", value)
+}
+
+// compare returns -1, 0 or 1 as the value of a field is lower than, equal to
+// or greater than the operand. Dates are compared by day.
+func (o filterOperand) compare(value string) (int, error) {
+	if o.isDate {
+		date, err := parseIssueDate(value)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case date.Before(o.date):
+			return -1, nil
+		case date.After(o.date):
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, errors.Errorf("%q is not a number", value)
+	}
+	switch {
+	case number < o.number:
+		return -1, nil
+	case number > o.number:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// parseIssueDate parses the day of a date or datetime field value.
+func parseIssueDate(value string) (time.Time, error) {
+	if len(value) < len(filterDateFormat) {
+		return time.Time{}, errors.Errorf("%q is not a date", value)
+	}
+	date, err := time.Parse(filterDateFormat, value[:len(filterDateFormat)])
+	if err != nil {
+		return time.Time{}, errors.Errorf("%q is not a date", value)
+	}
+	return date, nil
+}
+
+// filterRegexps are the compiled regular expressions of the FilterMatches
+// filters of a subscription, by expression.
+type filterRegexps map[string]*regexp.Regexp
+
+// subscriptionRegexp returns the compiled regular expression of a
+// FilterMatches filter of a subscription. The expressions of a subscription
+// are compiled once and cached, until they change. The ones of the
+// subscriptions that are not saved yet, as the ones previewed, are not cached.
+func (p *Plugin) subscriptionRegexp(sub ChannelSubscription, expr string) (*regexp.Regexp, error) {
+	if sub.ID == "" {
+		return regexp.Compile(expr)
+	}
+	if cached, ok := p.subscriptionRegexps.Load(sub.ID); ok {
+		if re, ok := cached.(filterRegexps)[expr]; ok {
+			return re, nil
+		}
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	// The cached expressions are replaced by the ones of the filters of the
+	// subscription, so that the expressions of the changed filters are not
+	// kept.
+	regexps := filterRegexps{expr: re}
+	for _, field := range sub.Filters.Fields {
+		if field.Inclusion != FilterMatches || field.Values.Len() == 0 {
+			continue
+		}
+		other := field.Values.Elems()[0]
+		if _, ok := regexps[other]; ok {
+			continue
+		}
+		if otherRe, otherErr := regexp.Compile(other); otherErr == nil {
+			regexps[other] = otherRe
+		}
+	}
+	p.subscriptionRegexps.Store(sub.ID, regexps)
+	return re, nil
+}
+
+// sortedOperands returns the values of a comparison filter, the range of a
+// FilterBetween filter in order.
+func sortedOperands(field FieldFilter) ([]filterOperand, error) {
+	operands := []filterOperand{}
+	for _, value := range field.Values.Elems() {
+		operand, err := parseFilterOperand(value)
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+	}
+	sort.Slice(operands, func(i, j int) bool {
+		if operands[i].isDate {
+			return operands[i].date.Before(operands[j].date)
+		}
+		return operands[i].number < operands[j].number
+	})
+	return operands, nil
+}
+
+// getIssueFieldText returns the value of a single valued issue field, as
+// text, or false if the field is empty.
+func getIssueFieldText(issue *jira.Issue, key string) (string, bool) {
+	switch strings.ToLower(key) {
+	case "summary":
+		return issue.Fields.Summary, issue.Fields.Summary != ""
+	case descriptionField:
+		return issue.Fields.Description, issue.Fields.Description != ""
+	case "environment":
+		return issue.Fields.Environment, issue.Fields.Environment != ""
+	case "duedate":
+		due := time.Time(issue.Fields.Duedate)
+		return due.Format(filterDateFormat), !due.IsZero()
+	}
+
+	value, ok := issue.Fields.Unknowns.Value(key)
+	if !ok || value == nil {
+		return "", false
+	}
+	switch value := value.(type) {
+	case string:
+		return value, value != ""
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), true
+	case map[string]interface{}:
+		// single-select value
+		text, ok := value["value"].(string)
+		return text, ok && text != ""
+	}
+	return "", false
+}
+
+// comparisonFieldMismatch returns why the value of an issue field does not
+// match a comparison filter of a subscription, or an empty string if it does.
+func (p *Plugin) comparisonFieldMismatch(sub ChannelSubscription, issue *jira.Issue, field FieldFilter, now time.Time) string {
+	value, ok := getIssueFieldText(issue, field.Key)
+	if !ok {
+		return fmt.Sprintf("%s is empty", field.Key)
+	}
+	values := field.Values.Elems()
+	if len(values) == 0 {
+		return fmt.Sprintf("the filter on %s is incomplete", field.Key)
+	}
+
+	switch field.Inclusion {
+	case FilterMatches:
+		re, err := p.subscriptionRegexp(sub, values[0])
+		if err != nil {
+			return fmt.Sprintf("the regular expression of the filter on %s is invalid: %v", field.Key, err)
+		}
+		if !re.MatchString(value) {
+			return fmt.Sprintf("%s does not match %s", field.Key, values[0])
+		}
+		return ""
+
+	case FilterWithinDays:
+		days, err := strconv.Atoi(values[0])
+		if err != nil {
+			return fmt.Sprintf("the number of days of the filter on %s is invalid", field.Key)
+		}
+		date, err := parseIssueDate(value)
+		if err != nil {
+			return fmt.Sprintf("%s is not a date", field.Key)
+		}
+		today, _ := time.Parse(filterDateFormat, now.Format(filterDateFormat))
+		if date.Before(today) || date.After(today.AddDate(0, 0, days)) {
+			return fmt.Sprintf("%s is %s, not within the next %d days", field.Key, value, days)
+		}
+		return ""
+	}
+
+	operands, err := sortedOperands(field)
+	if err != nil {
+		return fmt.Sprintf("the filter on %s is invalid: %v", field.Key, err)
+	}
+	cmp := []int{}
+	for _, operand := range operands {
+		c, err := operand.compare(value)
+		if err != nil {
+			return fmt.Sprintf("%s can not be compared: %v", field.Key, err)
+		}
+		cmp = append(cmp, c)
+	}
+
+	switch field.Inclusion {
+	case FilterGreaterThan:
+		if cmp[0] <= 0 {
+			return fmt.Sprintf("%s is %s, not greater than %s", field.Key, value, values[0])
+		}
+	case FilterLessThan:
+		if cmp[0] >= 0 {
+			return fmt.Sprintf("%s is %s, not less than %s", field.Key, value, values[0])
+		}
+	case FilterBetween:
+		if len(cmp) != 2 {
+			return fmt.Sprintf("the filter on %s is incomplete", field.Key)
+		}
+		if cmp[0] < 0 || cmp[1] > 0 {
+			return fmt.Sprintf("%s is %s, not between %s and %s", field.Key, value, operands[0].text, operands[1].text)
+		}
+	}
+	return ""
+}
+
+// validateComparisonFilter checks the values of a comparison filter, and that
+// they can be compared to the type of the field, from its schema.
+func validateComparisonFilter(field FieldFilter, schemaType string) error {
+	values := field.Values.Elems()
+	expected := 1
+	if field.Inclusion == FilterBetween {
+		expected = 2
+	}
+	if len(values) != expected {
+		return errors.Errorf("please provide %d value(s) to filter %s with %s", expected, field.Key, field.Inclusion)
+	}
+
+	isDateField := schemaType == fieldSchemaDate || schemaType == fieldSchemaDatetime
+	switch field.Inclusion {
+	case FilterMatches:
+		if schemaType != fieldSchemaString {
+			return errors.Errorf("%s is not a text field, and can not be matched with a regular expression", field.Key)
+		}
+		if _, err := regexp.Compile(values[0]); err != nil {
+			return errors.WithMessagef(err, "invalid regular expression to filter %s", field.Key)
+		}
+		return nil
+
+	case FilterWithinDays:
+		if !isDateField {
+			return errors.Errorf("%s is not a date field", field.Key)
+		}
+		if days, err := strconv.Atoi(values[0]); err != nil || days < 0 {
+			return errors.Errorf("please provide a number of days to filter %s, not %q", field.Key, values[0])
+		}
+		return nil
+	}
+
+	for _, value := range values {
+		operand, err := parseFilterOperand(value)
+		if err != nil {
+			return errors.WithMessagef(err, "invalid value to filter %s", field.Key)
+		}
+		switch {
+		case operand.isDate && !isDateField:
+			return errors.Errorf("%s is not a date field, and can not be compared to %s", field.Key, value)
+		case !operand.isDate && schemaType != fieldSchemaNumber:
+			return errors.Errorf("%s is not a number field, and can not be compared to %s", field.Key, value)
+		}
+	}
+	return nil
+}
+
+// getFieldSchemaTypes returns the schema types of the fields of the issues of
+// the projects, from their create metadata.
+func (p *Plugin) getFieldSchemaTypes(client Client, projectKeys []string) (map[string]string, error) {
+	createMeta, err := client.GetCreateMetaInfo(p.API, &jira.GetQueryOptions{
+		Expand:      "projects.issuetypes.fields",
+		ProjectKeys: strings.Join(projectKeys, ","),
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to get the fields of the projects")
+	}
+
+	schemaTypes := map[string]string{}
+	for _, project := range createMeta.Projects {
+		for _, issueType := range project.IssueTypes {
+			for key := range issueType.Fields {
+				field, err := issueType.Fields.MarshalMap(key)
+				if err != nil {
+					continue
+				}
+				schema, err := field.MarshalMap("schema")
+				if err != nil {
+					continue
+				}
+				schemaType, err := schema.String("type")
+				if err != nil {
+					continue
+				}
+				schemaTypes[key] = schemaType
+			}
+		}
+	}
+	return schemaTypes, nil
+}
+
+// validateComparisonFilters checks the comparison filters of a subscription
+// with the schema of the fields of its projects.
+func (p *Plugin) validateComparisonFilters(client Client, filters SubscriptionFilters) error {
+	var schemaTypes map[string]string
+	for _, field := range filters.Fields {
+		if !isComparisonInclusion(field.Inclusion) {
+			continue
+		}
+		if filters.Projects.Len() == 0 {
+			return errors.Errorf("please provide a project identifier to filter %s with %s", field.Key, field.Inclusion)
+		}
+
+		if schemaTypes == nil {
+			var err error
+			schemaTypes, err = p.getFieldSchemaTypes(client, filters.Projects.Elems())
+			if err != nil {
+				return err
+			}
+		}
+		schemaType, ok := schemaTypes[field.Key]
+		if !ok {
+			return errors.Errorf("the type of %s is unknown, it is not a field of the issues of the selected projects", field.Key)
+		}
+		if err := validateComparisonFilter(field, schemaType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trivago/tgo/tcontainer"
+)
+
+func TestComparisonFieldMismatch(t *testing.T) {
+	now := time.Date(2026, 10, 18, 15, 0, 0, 0, time.UTC)
+	issue := &jira.Issue{Fields: &jira.IssueFields{
+		Summary: "[Bug] Login fails",
+		Duedate: jira.Date(time.Date(2026, 10, 21, 0, 0, 0, 0, time.UTC)),
+		Unknowns: tcontainer.MarshalMap{
+			"customfield_10002": 8.0,
+			"customfield_10003": "2026-10-01T10:00:00.000+0000",
+		},
+	}}
+
+	for name, tc := range map[string]struct {
+		field    FieldFilter
+		expected string
+	}{
+		"greater than": {
+			field: FieldFilter{Key: "customfield_10002", Inclusion: FilterGreaterThan, Values: NewStringSet("5")},
+		},
+		"not greater than": {
+			field:    FieldFilter{Key: "customfield_10002", Inclusion: FilterGreaterThan, Values: NewStringSet("8")},
+			expected: "customfield_10002 is 8, not greater than 8",
+		},
+		"less than": {
+			field: FieldFilter{Key: "customfield_10002", Inclusion: FilterLessThan, Values: NewStringSet("13.5")},
+		},
+		"not less than": {
+			field:    FieldFilter{Key: "customfield_10002", Inclusion: FilterLessThan, Values: NewStringSet("3")},
+			expected: "customfield_10002 is 8, not less than 3",
+		},
+		"between": {
+			field: FieldFilter{Key: "customfield_10002", Inclusion: FilterBetween, Values: NewStringSet("8", "13")},
+		},
+		"not between": {
+			field:    FieldFilter{Key: "customfield_10002", Inclusion: FilterBetween, Values: NewStringSet("13", "10.5")},
+			expected: "customfield_10002 is 8, not between 10.5 and 13",
+		},
+		"datetime less than a date": {
+			field: FieldFilter{Key: "customfield_10003", Inclusion: FilterLessThan, Values: NewStringSet("2026-10-02")},
+		},
+		"date between": {
+			field: FieldFilter{Key: "duedate", Inclusion: FilterBetween, Values: NewStringSet("2026-10-21", "2026-10-01")},
+		},
+		"within days": {
+			field: FieldFilter{Key: "duedate", Inclusion: FilterWithinDays, Values: NewStringSet("3")},
+		},
+		"not within days": {
+			field:    FieldFilter{Key: "duedate", Inclusion: FilterWithinDays, Values: NewStringSet("2")},
+			expected: "duedate is 2026-10-21, not within the next 2 days",
+		},
+		"past date not within days": {
+			field:    FieldFilter{Key: "customfield_10003", Inclusion: FilterWithinDays, Values: NewStringSet("30")},
+			expected: "customfield_10003 is 2026-10-01T10:00:00.000+0000, not within the next 30 days",
+		},
+		"matches": {
+			field: FieldFilter{Key: "summary", Inclusion: FilterMatches, Values: NewStringSet(`^\[Bug\]`)},
+		},
+		"does not match": {
+			field:    FieldFilter{Key: "summary", Inclusion: FilterMatches, Values: NewStringSet(`(?i)^\[feature\]`)},
+			expected: `summary does not match (?i)^\[feature\]`,
+		},
+		"empty": {
+			field:    FieldFilter{Key: "customfield_10004", Inclusion: FilterGreaterThan, Values: NewStringSet("1")},
+			expected: "customfield_10004 is empty",
+		},
+		"not a number": {
+			field:    FieldFilter{Key: "summary", Inclusion: FilterGreaterThan, Values: NewStringSet("1")},
+			expected: `summary can not be compared: "[Bug] Login fails" is not a number`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := &Plugin{}
+			sub := ChannelSubscription{ID: "sub1", Filters: SubscriptionFilters{Fields: []FieldFilter{tc.field}}}
+			assert.Equal(t, tc.expected, p.comparisonFieldMismatch(sub, issue, tc.field, now))
+		})
+	}
+}
+
+func TestSubscriptionRegexp(t *testing.T) {
+	p := &Plugin{}
+	bugs := FieldFilter{Key: "summary", Inclusion: FilterMatches, Values: NewStringSet("^Bug")}
+	features := FieldFilter{Key: "description", Inclusion: FilterMatches, Values: NewStringSet("feature")}
+	sub := ChannelSubscription{ID: "sub1", Filters: SubscriptionFilters{Fields: []FieldFilter{bugs, features}}}
+
+	re, err := p.subscriptionRegexp(sub, "^Bug")
+	require.NoError(t, err)
+	cached, err := p.subscriptionRegexp(sub, "^Bug")
+	require.NoError(t, err)
+	assert.Same(t, re, cached)
+	regexps, ok := p.subscriptionRegexps.Load("sub1")
+	require.True(t, ok)
+	assert.Len(t, regexps, 2, "the expressions of the subscription are compiled together")
+
+	// The expressions of the changed filters are not kept
+	sub.Filters.Fields = []FieldFilter{{Key: "summary", Inclusion: FilterMatches, Values: NewStringSet("^Task")}}
+	_, err = p.subscriptionRegexp(sub, "^Task")
+	require.NoError(t, err)
+	regexps, _ = p.subscriptionRegexps.Load("sub1")
+	assert.Len(t, regexps, 1)
+	assert.Contains(t, regexps, "^Task")
+
+	_, err = p.subscriptionRegexp(sub, "[Bug")
+	assert.Error(t, err)
+
+	// The expressions of unsaved subscriptions are not cached
+	_, err = p.subscriptionRegexp(ChannelSubscription{}, "^Story")
+	require.NoError(t, err)
+	_, ok = p.subscriptionRegexps.Load("")
+	assert.False(t, ok)
+
+	p.updateCachedSubscription(testInstance1.InstanceID, "sub1", nil)
+	_, ok = p.subscriptionRegexps.Load("sub1")
+	assert.False(t, ok, "the expressions of a removed subscription are forgotten")
+}
@@ -0,0 +1,327 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/utils/types"
+)
+
+const (
+	scheduleTimeFormat  = "15:04"
+	snoozedUntilFormat  = "2006-01-02 15:04 MST"
+	maxSubscriptionMute = 365 * 24 * time.Hour
+)
+
+var scheduleWeekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// subscriptionLocation is the cached timezone of the schedule of a
+// subscription, with the name it was loaded from.
+type subscriptionLocation struct {
+	timezone string
+	loc      *time.Location
+}
+
+// subscriptionLocation returns the timezone of the schedule of a
+// subscription, UTC if it has none. It is loaded once and cached, until the
+// timezone of the subscription changes, so that it is not loaded for each
+// event. The timezones of the subscriptions that are not saved yet are not
+// cached.
+func (p *Plugin) subscriptionLocation(sub ChannelSubscription) (*time.Location, error) {
+	timezone := ""
+	if sub.Schedule != nil {
+		timezone = sub.Schedule.Timezone
+	}
+	if sub.ID == "" {
+		return time.LoadLocation(timezone)
+	}
+	if cached, ok := p.subscriptionLocations.Load(sub.ID); ok {
+		if location := cached.(*subscriptionLocation); location.timezone == timezone {
+			return location.loc, nil
+		}
+	}
+
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, err
+	}
+	p.subscriptionLocations.Store(sub.ID, &subscriptionLocation{timezone: timezone, loc: loc})
+	return loc, nil
+}
+
+// SubscriptionSchedule is the weekly active period of a subscription, from
+// Start to End on each of the Days, in the Timezone. A period that ends before
+// it starts continues over midnight, into the next day.
+type SubscriptionSchedule struct {
+	// Days are the weekdays, as mon, tue... All days if empty.
+	Days []string `json:"days,omitempty"`
+	// Start and End are the times of the day, as 15:04.
+	Start string `json:"start"`
+	End   string `json:"end"`
+	// Timezone is the IANA name of the timezone, UTC if empty.
+	Timezone string `json:"timezone,omitempty"`
+}
+
+func (s *SubscriptionSchedule) validate() error {
+	for _, day := range s.Days {
+		if _, ok := scheduleWeekdays[strings.ToLower(day)]; !ok {
+			return errors.Errorf("invalid day %q in the schedule, please use one of mon, tue, wed, thu, fri, sat or sun", day)
+		}
+	}
+	start, err := time.Parse(scheduleTimeFormat, s.Start)
+	if err != nil {
+		return errors.Errorf("invalid start time %q in the schedule, please use the 24-hour format, as 09:00", s.Start)
+	}
+	end, err := time.Parse(scheduleTimeFormat, s.End)
+	if err != nil {
+		return errors.Errorf("invalid end time %q in the schedule, please use the 24-hour format, as 18:00", s.End)
+	}
+	if start.Equal(end) {
+		return errors.New("the schedule must start and end at different times")
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return errors.Errorf("unknown timezone %q in the schedule", s.Timezone)
+	}
+	return nil
+}
+
+func (s *SubscriptionSchedule) hasDay(day time.Weekday) bool {
+	if len(s.Days) == 0 {
+		return true
+	}
+	for _, d := range s.Days {
+		if scheduleWeekdays[strings.ToLower(d)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// isActive tells if the time is within an active period of the schedule, in
+// its timezone loc. An invalid schedule is never active.
+func (s *SubscriptionSchedule) isActive(now time.Time, loc *time.Location) bool {
+	start, errStart := time.Parse(scheduleTimeFormat, s.Start)
+	end, errEnd := time.Parse(scheduleTimeFormat, s.End)
+	if errStart != nil || errEnd != nil {
+		return false
+	}
+
+	now = now.In(loc)
+	minutes := now.Hour()*60 + now.Minute()
+	startMinutes := start.Hour()*60 + start.Minute()
+	endMinutes := end.Hour()*60 + end.Minute()
+	if startMinutes < endMinutes {
+		return s.hasDay(now.Weekday()) && minutes >= startMinutes && minutes < endMinutes
+	}
+	// The period of the previous day continues after midnight
+	return (s.hasDay(now.Weekday()) && minutes >= startMinutes) ||
+		(s.hasDay(now.AddDate(0, 0, -1).Weekday()) && minutes < endMinutes)
+}
+
+func (s *SubscriptionSchedule) String() string {
+	days := "every day"
+	if len(s.Days) > 0 {
+		days = strings.Join(s.Days, ", ")
+	}
+	timezone := s.Timezone
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	return fmt.Sprintf("active %s %s-%s %s", days, s.Start, s.End, timezone)
+}
+
+func (sub *ChannelSubscription) isEnabled() bool {
+	return sub.Enabled == nil || *sub.Enabled
+}
+
+func (sub *ChannelSubscription) isSnoozed(now time.Time) bool {
+	return sub.SnoozedUntil > model.GetMillisForTime(now)
+}
+
+// isScheduleActive tells if the time is within the schedule of the
+// subscription. A schedule with an unknown timezone is never active.
+func (p *Plugin) isScheduleActive(sub ChannelSubscription, now time.Time) bool {
+	if sub.Schedule == nil {
+		return true
+	}
+	loc, err := p.subscriptionLocation(sub)
+	if err != nil {
+		return false
+	}
+	return sub.Schedule.isActive(now, loc)
+}
+
+// isSubscriptionActive tells if the events matching the subscription are
+// posted, i.e. it is neither muted nor snoozed, and within its schedule.
+func (p *Plugin) isSubscriptionActive(sub ChannelSubscription, now time.Time) bool {
+	return sub.isEnabled() && !sub.isSnoozed(now) && p.isScheduleActive(sub, now)
+}
+
+// subscriptionStateDescription describes why the subscription is inactive, or
+// its schedule. It is empty for an active subscription without a schedule.
+func (p *Plugin) subscriptionStateDescription(sub ChannelSubscription, now time.Time) string {
+	states := []string{}
+	if !sub.isEnabled() {
+		states = append(states, "muted")
+	}
+	if sub.isSnoozed(now) {
+		states = append(states, "snoozed until "+model.GetTimeForMillis(sub.SnoozedUntil).UTC().Format(snoozedUntilFormat))
+	}
+	if sub.Schedule != nil {
+		states = append(states, sub.Schedule.String())
+		if !p.isScheduleActive(sub, now) {
+			states = append(states, "inactive now")
+		}
+	}
+	return strings.Join(states, ", ")
+}
+
+// parseMuteDuration parses a duration as time.ParseDuration, or as a number
+// of days or weeks, as 3d or 2w.
+func parseMuteDuration(s string) (time.Duration, error) {
+	var d time.Duration
+	var err error
+	switch {
+	case strings.HasSuffix(s, "d") || strings.HasSuffix(s, "w"):
+		n, convErr := strconv.Atoi(s[:len(s)-1])
+		if convErr != nil {
+			return 0, errors.Errorf("invalid duration %q", s)
+		}
+		d = time.Duration(n) * 24 * time.Hour
+		if strings.HasSuffix(s, "w") {
+			d *= 7
+		}
+	default:
+		d, err = time.ParseDuration(s)
+		if err != nil {
+			return 0, errors.Errorf("invalid duration %q", s)
+		}
+	}
+	if d <= 0 || d > maxSubscriptionMute {
+		return 0, errors.Errorf("invalid duration %q, please use a duration of up to a year", s)
+	}
+	return d, nil
+}
+
+// updateChannelSubscriptionState stores the changes of the state of a
+// subscription, that are not validated like the changes of its filters.
+func (p *Plugin) updateChannelSubscriptionState(instanceID types.ID, subscriptionID string, update func(sub *ChannelSubscription)) error {
+	err := p.ensureSubscriptionStore(instanceID)
+	if err != nil {
+		return err
+	}
+
+	return p.updateStoredSubscription(instanceID, subscriptionID, func(sub *ChannelSubscription) (*ChannelSubscription, error) {
+		update(sub)
+		return sub, nil
+	})
+}
+
+// findChannelSubscriptionForCommand finds the subscription of the channel
+// named by the arguments, nil if there is none. The last argument is returned
+// as a duration, if the arguments do not name a subscription but the
+// arguments before do.
+func (p *Plugin) findChannelSubscriptionForCommand(instanceID types.ID, channelID string, args []string) (*ChannelSubscription, string, error) {
+	subs, err := p.getSubscriptionsForChannel(instanceID, channelID)
+	if err != nil {
+		return nil, "", err
+	}
+	name := strings.Join(args, " ")
+	if sub := findSubscriptionByName(subs, name); sub != nil {
+		return sub, "", nil
+	}
+	if len(args) > 1 {
+		if sub := findSubscriptionByName(subs, strings.Join(args[:len(args)-1], " ")); sub != nil {
+			return sub, args[len(args)-1], nil
+		}
+	}
+	return nil, "", nil
+}
+
+func executeSubscribeMute(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	return p.executeSubscribeMuteCommand(header, true, args)
+}
+
+func executeSubscribeUnmute(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	return p.executeSubscribeMuteCommand(header, false, args)
+}
+
+func (p *Plugin) executeSubscribeMuteCommand(header *model.CommandArgs, mute bool, args []string) *model.CommandResponse {
+	_, instance, args, err := p.loadFlagUserInstance(header.UserId, args)
+	if err != nil {
+		return p.responsef(header, "Failed to load your connection to Jira. Error: %v.", err)
+	}
+	if len(args) == 0 {
+		if mute {
+			return p.responsef(header, "Please specify a subscription name in the form `/jira subscribe mute <subscription-name> [duration]`.")
+		}
+		return p.responsef(header, "Please specify a subscription name in the form `/jira subscribe unmute <subscription-name>`.")
+	}
+
+	err = p.hasPermissionToManageSubscription(instance.GetID(), header.UserId, header.ChannelId)
+	if err != nil {
+		return p.responsef(header, "You don't have permission to manage subscriptions. Error: %v.", err)
+	}
+
+	sub, durationArg, err := p.findChannelSubscriptionForCommand(instance.GetID(), header.ChannelId, args)
+	if err != nil {
+		return p.responsef(header, "Failed to load the subscriptions of this channel. Error: %v.", err)
+	}
+	if sub == nil || (!mute && durationArg != "") {
+		return p.responsef(header, "This channel has no subscription named %q.", strings.Join(args, " "))
+	}
+
+	if !mute {
+		err = p.updateChannelSubscriptionState(instance.GetID(), sub.ID, func(sub *ChannelSubscription) {
+			sub.Enabled = nil
+			sub.SnoozedUntil = 0
+		})
+		if err != nil {
+			return p.responsef(header, "Failed to unmute the subscription. Error: %v.", err)
+		}
+		return p.responsef(header, "Subscription %q is unmuted.", sub.Name)
+	}
+
+	if durationArg == "" {
+		err = p.updateChannelSubscriptionState(instance.GetID(), sub.ID, func(sub *ChannelSubscription) {
+			sub.Enabled = model.NewBool(false)
+			sub.SnoozedUntil = 0
+		})
+		if err != nil {
+			return p.responsef(header, "Failed to mute the subscription. Error: %v.", err)
+		}
+		return p.responsef(header, "Subscription %q is muted until you unmute it with `/jira subscribe unmute %s`.", sub.Name, sub.Name)
+	}
+
+	d, err := parseMuteDuration(durationArg)
+	if err != nil {
+		return p.responsef(header, "Failed to mute the subscription: %v. Please specify a duration as 30m, 4h, 3d or 2w.", err)
+	}
+	until := time.Now().Add(d)
+	err = p.updateChannelSubscriptionState(instance.GetID(), sub.ID, func(sub *ChannelSubscription) {
+		sub.Enabled = nil
+		sub.SnoozedUntil = model.GetMillisForTime(until)
+	})
+	if err != nil {
+		return p.responsef(header, "Failed to mute the subscription. Error: %v.", err)
+	}
+	return p.responsef(header, "Subscription %q is muted until %s.", sub.Name, until.UTC().Format(snoozedUntilFormat))
+}
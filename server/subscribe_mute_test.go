@@ -0,0 +1,219 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriptionScheduleIsActive(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	require.NoError(t, err)
+	weekdays := &SubscriptionSchedule{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "09:00", End: "18:00", Timezone: "Europe/Paris"}
+	nights := &SubscriptionSchedule{Days: []string{"Fri"}, Start: "22:00", End: "06:00"}
+
+	for name, tc := range map[string]struct {
+		schedule *SubscriptionSchedule
+		now      time.Time
+		expected bool
+	}{
+		"weekday":                  {schedule: weekdays, now: time.Date(2026, 10, 19, 9, 0, 0, 0, paris), expected: true},
+		"weekday in UTC":           {schedule: weekdays, now: time.Date(2026, 10, 19, 15, 59, 0, 0, time.UTC), expected: true},
+		"weekday before the start": {schedule: weekdays, now: time.Date(2026, 10, 19, 8, 59, 0, 0, paris)},
+		"weekday at the end":       {schedule: weekdays, now: time.Date(2026, 10, 19, 18, 0, 0, 0, paris)},
+		"weekend":                  {schedule: weekdays, now: time.Date(2026, 10, 18, 12, 0, 0, 0, paris)},
+		"night":                    {schedule: nights, now: time.Date(2026, 10, 23, 23, 0, 0, 0, time.UTC), expected: true},
+		"night after midnight":     {schedule: nights, now: time.Date(2026, 10, 24, 5, 59, 0, 0, time.UTC), expected: true},
+		"night of another day":     {schedule: nights, now: time.Date(2026, 10, 24, 23, 0, 0, 0, time.UTC)},
+		"day":                      {schedule: nights, now: time.Date(2026, 10, 23, 12, 0, 0, 0, time.UTC)},
+		"every day":                {schedule: &SubscriptionSchedule{Start: "09:00", End: "10:00"}, now: time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC), expected: true},
+		"invalid":                  {schedule: &SubscriptionSchedule{Start: "9am", End: "10:00"}, now: time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC)},
+		"unknown timezone":         {schedule: &SubscriptionSchedule{Start: "09:00", End: "10:00", Timezone: "Mars/Olympus"}, now: time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := &Plugin{}
+			sub := ChannelSubscription{ID: "sub1", Schedule: tc.schedule}
+			assert.Equal(t, tc.expected, p.isScheduleActive(sub, tc.now))
+		})
+	}
+}
+
+func TestSubscriptionLocation(t *testing.T) {
+	p := &Plugin{}
+	sub := ChannelSubscription{ID: "sub1", Schedule: &SubscriptionSchedule{Start: "09:00", End: "18:00", Timezone: "Europe/Paris"}}
+	loc, err := p.subscriptionLocation(sub)
+	require.NoError(t, err)
+	assert.Equal(t, "Europe/Paris", loc.String())
+	cached, err := p.subscriptionLocation(sub)
+	require.NoError(t, err)
+	assert.Same(t, loc, cached)
+
+	// The timezone is replaced when it changes
+	sub.Schedule = &SubscriptionSchedule{Start: "09:00", End: "18:00"}
+	loc, err = p.subscriptionLocation(sub)
+	require.NoError(t, err)
+	assert.Equal(t, time.UTC, loc)
+
+	sub.Schedule.Timezone = "Mars/Olympus"
+	_, err = p.subscriptionLocation(sub)
+	require.Error(t, err)
+
+	// The timezones of unsaved subscriptions are not cached
+	_, err = p.subscriptionLocation(ChannelSubscription{Schedule: &SubscriptionSchedule{Timezone: "Asia/Tokyo"}})
+	require.NoError(t, err)
+	_, ok := p.subscriptionLocations.Load("")
+	assert.False(t, ok)
+
+	p.updateCachedSubscription(testInstance1.InstanceID, "sub1", nil)
+	_, ok = p.subscriptionLocations.Load("sub1")
+	assert.False(t, ok, "the timezone of a removed subscription is forgotten")
+}
+
+func TestSubscriptionScheduleValidate(t *testing.T) {
+	for name, tc := range map[string]struct {
+		schedule SubscriptionSchedule
+		expected string
+	}{
+		"valid":     {schedule: SubscriptionSchedule{Days: []string{"Mon", "fri"}, Start: "09:00", End: "18:00", Timezone: "America/New_York"}},
+		"overnight": {schedule: SubscriptionSchedule{Start: "22:00", End: "06:00"}},
+		"day":       {schedule: SubscriptionSchedule{Days: []string{"monday"}, Start: "09:00", End: "18:00"}, expected: `invalid day "monday" in the schedule, please use one of mon, tue, wed, thu, fri, sat or sun`},
+		"start":     {schedule: SubscriptionSchedule{Start: "9", End: "18:00"}, expected: `invalid start time "9" in the schedule, please use the 24-hour format, as 09:00`},
+		"end":       {schedule: SubscriptionSchedule{Start: "09:00", End: "6pm"}, expected: `invalid end time "6pm" in the schedule, please use the 24-hour format, as 18:00`},
+		"empty":     {schedule: SubscriptionSchedule{Start: "09:00", End: "09:00"}, expected: "the schedule must start and end at different times"},
+		"time zone": {schedule: SubscriptionSchedule{Start: "09:00", End: "18:00", Timezone: "Mars/Olympus"}, expected: `unknown timezone "Mars/Olympus" in the schedule`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := tc.schedule.validate()
+			if tc.expected == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tc.expected)
+		})
+	}
+}
+
+func TestParseMuteDuration(t *testing.T) {
+	for s, expected := range map[string]time.Duration{
+		"30m":   30 * time.Minute,
+		"1h30m": 90 * time.Minute,
+		"3d":    3 * 24 * time.Hour,
+		"2w":    14 * 24 * time.Hour,
+	} {
+		d, err := parseMuteDuration(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, expected, d, s)
+	}
+	for _, s := range []string{"", "forever", "d", "-1h", "0m", "400d"} {
+		_, err := parseMuteDuration(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestGetChannelsSubscribedInactive(t *testing.T) {
+	now := time.Now()
+	withState := func(sub ChannelSubscription, f func(sub *ChannelSubscription)) ChannelSubscription {
+		f(&sub)
+		return sub
+	}
+	p, _, _ := setupSubscriptionStoreTest(t, []ChannelSubscription{
+		storeTestSubscription("sub1", "channel1", "active", "TES", eventCreated),
+		withState(storeTestSubscription("sub2", "channel2", "muted", "TES", eventCreated), func(sub *ChannelSubscription) {
+			sub.Enabled = model.NewBool(false)
+		}),
+		withState(storeTestSubscription("sub3", "channel3", "snoozed", "TES", eventCreated), func(sub *ChannelSubscription) {
+			sub.SnoozedUntil = model.GetMillisForTime(now.Add(time.Hour))
+		}),
+		withState(storeTestSubscription("sub4", "channel4", "snoozed before", "TES", eventCreated), func(sub *ChannelSubscription) {
+			sub.SnoozedUntil = model.GetMillisForTime(now.Add(-time.Hour))
+		}),
+		withState(storeTestSubscription("sub5", "channel5", "out of schedule", "TES", eventCreated), func(sub *ChannelSubscription) {
+			// Active for a minute, twelve hours from now
+			start := now.Add(12 * time.Hour).UTC()
+			sub.Schedule = &SubscriptionSchedule{Start: start.Format("15:04"), End: start.Add(time.Minute).Format("15:04")}
+		}),
+	})
+
+	data, err := getJiraTestData("webhook-issue-created.json")
+	require.NoError(t, err)
+	wh, err := ParseWebhook(data)
+	require.NoError(t, err)
+	subscribed := func() []string {
+		subs, err := p.getChannelsSubscribed(wh.(*webhook), testInstance1.InstanceID)
+		require.NoError(t, err)
+		ids := []string{}
+		for _, sub := range subs {
+			ids = append(ids, sub.ID)
+		}
+		return ids
+	}
+	assert.Equal(t, []string{"sub1", "sub4"}, subscribed())
+
+	require.NoError(t, p.updateChannelSubscriptionState(testInstance1.InstanceID, "sub1", func(sub *ChannelSubscription) {
+		sub.Enabled = model.NewBool(false)
+	}))
+	require.NoError(t, p.updateChannelSubscriptionState(testInstance1.InstanceID, "sub2", func(sub *ChannelSubscription) {
+		sub.Enabled = nil
+	}))
+	assert.Equal(t, []string{"sub2", "sub4"}, subscribed())
+
+	sub, err := p.loadSubscription(testInstance1.InstanceID, "sub1")
+	require.NoError(t, err)
+	assert.Equal(t, "muted", p.subscriptionStateDescription(*sub, now))
+	assert.Error(t, p.updateChannelSubscriptionState(testInstance1.InstanceID, "unknown", func(sub *ChannelSubscription) {}))
+}
+
+func TestSubscriptionStateDescription(t *testing.T) {
+	p := &Plugin{}
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	sub := ChannelSubscription{ID: "sub1"}
+	assert.Equal(t, "", p.subscriptionStateDescription(sub, now))
+
+	sub.SnoozedUntil = model.GetMillisForTime(now.Add(90 * time.Minute))
+	assert.Equal(t, "snoozed until 2026-10-18 13:30 UTC", p.subscriptionStateDescription(sub, now))
+
+	sub.SnoozedUntil = 0
+	sub.Schedule = &SubscriptionSchedule{Days: []string{"mon", "fri"}, Start: "09:00", End: "18:00", Timezone: "Europe/Paris"}
+	assert.Equal(t, "active mon, fri 09:00-18:00 Europe/Paris, inactive now", p.subscriptionStateDescription(sub, now))
+}
+
+func TestFindChannelSubscriptionForCommand(t *testing.T) {
+	p, _, _ := setupSubscriptionStoreTest(t, []ChannelSubscription{
+		storeTestSubscription("sub1", "channel1", "Bugs", "TES", eventCreated),
+		storeTestSubscription("sub2", "channel1", "bugs", "TES", eventCreated),
+		storeTestSubscription("sub3", "channel1", "Release notes", "TES", eventCreated),
+	})
+
+	for name, tc := range map[string]struct {
+		args             []string
+		expectedID       string
+		expectedDuration string
+	}{
+		"exact name":                      {args: []string{"bugs"}, expectedID: "sub2"},
+		"other exact name":                {args: []string{"Bugs"}, expectedID: "sub1"},
+		"ambiguous case":                  {args: []string{"BUGS"}},
+		"case of a single subscription":   {args: []string{"release", "notes"}, expectedID: "sub3"},
+		"with a duration":                 {args: []string{"Bugs", "2h"}, expectedID: "sub1", expectedDuration: "2h"},
+		"unknown name":                    {args: []string{"stories"}},
+		"unknown name, with a duration":   {args: []string{"stories", "2h"}},
+		"ambiguous case, with a duration": {args: []string{"BUGS", "2h"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			sub, duration, err := p.findChannelSubscriptionForCommand(testInstance1.InstanceID, "channel1", tc.args)
+			require.NoError(t, err)
+			if tc.expectedID == "" {
+				assert.Nil(t, sub)
+				return
+			}
+			require.NotNil(t, sub)
+			assert.Equal(t, tc.expectedID, sub.ID)
+			assert.Equal(t, tc.expectedDuration, duration)
+		})
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+)
+
+// The subscription policy of a channel tells what is posted to the channel
+// when several of its subscriptions match an event.
+const (
+	prefixSubscriptionPolicy = "subpolicy_"
+
+	// subscriptionPolicyCacheTTL is how long the policy of a channel is
+	// cached. The cache is updated when the policy changes, and expires in
+	// case a cluster event was missed.
+	subscriptionPolicyCacheTTL = 10 * time.Minute
+
+	clusterEventSubscriptionPolicyChanged = "subscription_policy_changed"
+
+	// SubscriptionPolicyFirst posts the event once, with the matching
+	// subscription of the highest priority.
+	SubscriptionPolicyFirst = "first"
+	// SubscriptionPolicyMerge posts the event once, with the names of all
+	// the matching subscriptions.
+	SubscriptionPolicyMerge = "merge"
+	// SubscriptionPolicyEach posts the event once per matching subscription.
+	SubscriptionPolicyEach = "each"
+)
+
+var subscriptionPolicyDescriptions = map[string]string{
+	SubscriptionPolicyFirst: "posted once, with the matching subscription of the highest priority",
+	SubscriptionPolicyMerge: "posted once, with the names of all the matching subscriptions",
+	SubscriptionPolicyEach:  "posted once for each matching subscription",
+}
+
+// subscriptionPolicyCache caches the subscription policy of the channels, so
+// that it is not loaded for every event that matches several subscriptions.
+type subscriptionPolicyCache struct {
+	lock      sync.Mutex
+	byChannel map[string]cachedSubscriptionPolicy
+}
+
+type cachedSubscriptionPolicy struct {
+	policy   string
+	loadedAt time.Time
+}
+
+func (c *subscriptionPolicyCache) get(channelID string) (string, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	cached, ok := c.byChannel[channelID]
+	if !ok || time.Since(cached.loadedAt) > subscriptionPolicyCacheTTL {
+		return "", false
+	}
+	return cached.policy, true
+}
+
+func (c *subscriptionPolicyCache) set(channelID, policy string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.byChannel == nil {
+		c.byChannel = map[string]cachedSubscriptionPolicy{}
+	}
+	c.byChannel[channelID] = cachedSubscriptionPolicy{policy: policy, loadedAt: time.Now()}
+}
+
+func (c *subscriptionPolicyCache) invalidate(channelID string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.byChannel, channelID)
+}
+
+func subscriptionPolicyKey(channelID string) string {
+	return hashkey(prefixSubscriptionPolicy, channelID)
+}
+
+// getSubscriptionPolicy returns the subscription policy of a channel, from
+// the cache if it was loaded recently.
+func (p *Plugin) getSubscriptionPolicy(channelID string) (string, error) {
+	if policy, ok := p.subscriptionPolicies.get(channelID); ok {
+		return policy, nil
+	}
+	policy, err := p.loadSubscriptionPolicy(channelID)
+	if err != nil {
+		return "", err
+	}
+	p.subscriptionPolicies.set(channelID, policy)
+	return policy, nil
+}
+
+// loadSubscriptionPolicy returns the subscription policy of a channel,
+// SubscriptionPolicyFirst unless another was set.
+func (p *Plugin) loadSubscriptionPolicy(channelID string) (string, error) {
+	policy := ""
+	err := p.client.KV.Get(subscriptionPolicyKey(channelID), &policy)
+	if err != nil {
+		return "", err
+	}
+	if policy == "" {
+		return SubscriptionPolicyFirst, nil
+	}
+	return policy, nil
+}
+
+func (p *Plugin) storeSubscriptionPolicy(channelID, policy string) error {
+	if subscriptionPolicyDescriptions[policy] == "" {
+		return errors.Errorf("unknown subscription policy %q", policy)
+	}
+	var err error
+	if policy == SubscriptionPolicyFirst {
+		err = p.client.KV.Delete(subscriptionPolicyKey(channelID))
+	} else {
+		_, err = p.client.KV.Set(subscriptionPolicyKey(channelID), policy)
+	}
+	if err != nil {
+		return err
+	}
+
+	p.subscriptionPolicies.set(channelID, policy)
+	err = p.client.Cluster.PublishPluginEvent(
+		model.PluginClusterEvent{Id: clusterEventSubscriptionPolicyChanged, Data: []byte(channelID)},
+		model.PluginClusterEventSendOptions{SendType: model.PluginClusterEventSendTypeReliable},
+	)
+	if err != nil {
+		p.errorf("storeSubscriptionPolicy: failed to publish the cluster event: %v", err)
+	}
+	return nil
+}
+
+// sortSubscriptionsByPriority sorts subscriptions by decreasing priority, then
+// by name and ID, so that the outcome of the policies does not depend on the
+// order the subscriptions were loaded in.
+func sortSubscriptionsByPriority(subs []ChannelSubscription) {
+	sort.Slice(subs, func(i, j int) bool {
+		if subs[i].Priority != subs[j].Priority {
+			return subs[i].Priority > subs[j].Priority
+		}
+		if subs[i].Name != subs[j].Name {
+			return subs[i].Name < subs[j].Name
+		}
+		return subs[i].ID < subs[j].ID
+	})
+}
+
+// applySubscriptionPolicy returns the subscriptions to post an event with, out
+// of the subscriptions of a channel that match it.
+func (p *Plugin) applySubscriptionPolicy(channelID string, matched []ChannelSubscription) []ChannelSubscription {
+	if len(matched) <= 1 {
+		return matched
+	}
+	sortSubscriptionsByPriority(matched)
+
+	policy, err := p.getSubscriptionPolicy(channelID)
+	if err != nil {
+		p.errorf("applySubscriptionPolicy: failed to load the subscription policy of channel %s: %v", channelID, err)
+		policy = SubscriptionPolicyFirst
+	}
+
+	switch policy {
+	case SubscriptionPolicyEach:
+		return matched
+	case SubscriptionPolicyMerge:
+		// The subscription of the highest priority decides how the event is
+		// posted, the post is only given the names of the others
+		merged := matched[0]
+		names := []string{}
+		for _, sub := range matched {
+			if sub.Name != "" {
+				names = append(names, sub.Name)
+			}
+		}
+		merged.Name = strings.Join(names, ", ")
+		return []ChannelSubscription{merged}
+	default:
+		return matched[:1]
+	}
+}
+
+func executeSubscribePolicy(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	_, instance, args, err := p.loadFlagUserInstance(header.UserId, args)
+	if err != nil {
+		return p.responsef(header, "Failed to load your connection to Jira. Error: %v.", err)
+	}
+	if len(args) > 1 {
+		return p.responsef(header, "Please specify a policy in the form `/jira subscribe policy [first|merge|each]`.")
+	}
+
+	err = p.hasPermissionToManageSubscription(instance.GetID(), header.UserId, header.ChannelId)
+	if err != nil {
+		return p.responsef(header, "You don't have permission to manage subscriptions. Error: %v.", err)
+	}
+
+	if len(args) == 0 {
+		policy, err := p.loadSubscriptionPolicy(header.ChannelId)
+		if err != nil {
+			return p.responsef(header, "Failed to load the subscription policy of this channel. Error: %v.", err)
+		}
+		return p.responsef(header, "The Jira events matching several subscriptions of this channel are %s (`%s`).",
+			subscriptionPolicyDescriptions[policy], policy)
+	}
+
+	policy := strings.ToLower(args[0])
+	if subscriptionPolicyDescriptions[policy] == "" {
+		return p.responsef(header, "Unknown subscription policy %q. Please specify one of `first`, `merge` or `each`.", args[0])
+	}
+	err = p.storeSubscriptionPolicy(header.ChannelId, policy)
+	if err != nil {
+		return p.responsef(header, "Failed to store the subscription policy of this channel. Error: %v.", err)
+	}
+	return p.responsef(header, "The Jira events matching several subscriptions of this channel will be %s.",
+		subscriptionPolicyDescriptions[policy])
+}
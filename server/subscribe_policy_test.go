@@ -0,0 +1,96 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetChannelsSubscribedPolicy(t *testing.T) {
+	withPriority := func(sub ChannelSubscription, priority int) ChannelSubscription {
+		sub.Priority = priority
+		return sub
+	}
+	p, _, _ := setupSubscriptionStoreTest(t, []ChannelSubscription{
+		storeTestSubscription("sub1", "channel1", "bugs", "TES", eventCreated),
+		withPriority(storeTestSubscription("sub2", "channel1", "urgent", "TES", eventCreated), 10),
+		storeTestSubscription("sub3", "channel1", "all", "TES", eventCreated, eventDeleted),
+		storeTestSubscription("sub4", "channel1", "deleted", "TES", eventDeleted),
+		storeTestSubscription("sub5", "channel2", "other", "TES", eventCreated),
+	})
+
+	data, err := getJiraTestData("webhook-issue-created.json")
+	require.NoError(t, err)
+	wh, err := ParseWebhook(data)
+	require.NoError(t, err)
+
+	policy, err := p.loadSubscriptionPolicy("channel1")
+	require.NoError(t, err)
+	assert.Equal(t, SubscriptionPolicyFirst, policy)
+	require.Error(t, p.storeSubscriptionPolicy("channel1", "all"))
+
+	for _, tc := range []struct {
+		policy   string
+		expected []string
+	}{
+		{policy: SubscriptionPolicyFirst, expected: []string{"channel1/sub2/urgent", "channel2/sub5/other"}},
+		{policy: SubscriptionPolicyMerge, expected: []string{"channel1/sub2/urgent, all, bugs", "channel2/sub5/other"}},
+		{policy: SubscriptionPolicyEach, expected: []string{"channel1/sub2/urgent", "channel1/sub3/all", "channel1/sub1/bugs", "channel2/sub5/other"}},
+	} {
+		t.Run(tc.policy, func(t *testing.T) {
+			require.NoError(t, p.storeSubscriptionPolicy("channel1", tc.policy))
+			policy, err := p.loadSubscriptionPolicy("channel1")
+			require.NoError(t, err)
+			require.Equal(t, tc.policy, policy)
+
+			// The outcome does not depend on the order of the subscriptions
+			for i := 0; i < 10; i++ {
+				subs, err := p.getChannelsSubscribed(wh.(*webhook), testInstance1.InstanceID)
+				require.NoError(t, err)
+				actual := []string{}
+				for _, sub := range subs {
+					actual = append(actual, sub.ChannelID+"/"+sub.ID+"/"+sub.Name)
+				}
+				assert.Equal(t, tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSubscriptionPolicyCache(t *testing.T) {
+	p, api, store := setupSubscriptionStoreTest(t, nil)
+
+	policy, err := p.getSubscriptionPolicy("channel1")
+	require.NoError(t, err)
+	assert.Equal(t, SubscriptionPolicyFirst, policy)
+
+	// Another server changes the policy, the cached one is used until the
+	// cluster event is received.
+	other := &Plugin{}
+	other.SetAPI(api)
+	other.client = pluginapi.NewClient(api, other.Driver)
+	require.NoError(t, other.storeSubscriptionPolicy("channel1", SubscriptionPolicyMerge))
+	require.Contains(t, store, subscriptionPolicyKey("channel1"))
+
+	policy, err = p.getSubscriptionPolicy("channel1")
+	require.NoError(t, err)
+	assert.Equal(t, SubscriptionPolicyFirst, policy)
+
+	p.OnPluginClusterEvent(nil, model.PluginClusterEvent{Id: clusterEventSubscriptionPolicyChanged, Data: []byte("channel1")})
+	policy, err = p.getSubscriptionPolicy("channel1")
+	require.NoError(t, err)
+	assert.Equal(t, SubscriptionPolicyMerge, policy)
+
+	// The policy stored by this server is cached right away
+	require.NoError(t, p.storeSubscriptionPolicy("channel1", SubscriptionPolicyFirst))
+	require.Nil(t, store[subscriptionPolicyKey("channel1")])
+	policy, err = p.getSubscriptionPolicy("channel1")
+	require.NoError(t, err)
+	assert.Equal(t, SubscriptionPolicyFirst, policy)
+}
@@ -0,0 +1,260 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/utils/types"
+)
+
+const (
+	routeAPISubscriptionsChannelPreview = routeAPISubscriptionsChannel + "/preview"
+
+	// subscriptionPreviewIssues is the number of recent issues the filters of
+	// a subscription are previewed with.
+	subscriptionPreviewIssues = 20
+
+	// subscriptionTestMaxRows is the number of events listed by
+	// `/jira subscribe test`.
+	subscriptionTestMaxRows = 25
+)
+
+// subscriptionPreview tells which recent issues of the selected projects, and
+// which events, would match the filters of a subscription.
+type subscriptionPreview struct {
+	JQL     string                     `json:"jql"`
+	Results []subscriptionPreviewMatch `json:"results"`
+}
+
+// subscriptionPreviewMatch is the result of an event of an issue. The reason
+// tells why it does not match.
+type subscriptionPreviewMatch struct {
+	IssueKey string `json:"issue_key"`
+	Summary  string `json:"summary"`
+	Event    string `json:"event"`
+	Matched  bool   `json:"matched"`
+	Reason   string `json:"reason,omitempty"`
+
+	// NotEvaluated are the fields of the filters on the changes of a field,
+	// which can not be evaluated in a preview, as the recent issues have no
+	// changelog. An event that matches the other filters is matched.
+	NotEvaluated []string `json:"not_evaluated,omitempty"`
+}
+
+func (preview *subscriptionPreview) matchedCount() int {
+	count := 0
+	for _, result := range preview.Results {
+		if result.Matched {
+			count++
+		}
+	}
+	return count
+}
+
+// subscriptionPreviewJQL returns the query of the recent issues of the
+// projects of a subscription, or of all projects if it selects none, that
+// match the JQL of the subscription.
+func subscriptionPreviewJQL(filters SubscriptionFilters) string {
+	clauses := []string{}
+	if filters.Projects.Len() > 0 {
+		projects := []string{}
+		for _, key := range filters.Projects.Elems() {
+			projects = append(projects, fmt.Sprintf("%q", key))
+		}
+		sort.Strings(projects)
+		clauses = append(clauses, fmt.Sprintf("project in (%s)", strings.Join(projects, ", ")))
+	}
+	if jql := strings.TrimSpace(filters.JQL); jql != "" {
+		clauses = append(clauses, "("+jql+")")
+	}
+
+	order := "ORDER BY updated DESC"
+	if len(clauses) == 0 {
+		return order
+	}
+	return strings.Join(clauses, " AND ") + " " + order
+}
+
+// previewSubscription fetches the recent issues of the projects of a
+// subscription, and matches each event of the subscription on each of them.
+func (p *Plugin) previewSubscription(instanceID types.ID, client Client, filters SubscriptionFilters) (*subscriptionPreview, error) {
+	if filters.Events.Len() == 0 {
+		return nil, errors.New("please provide at least one event type")
+	}
+
+	jql := subscriptionPreviewJQL(filters)
+	issues, err := client.SearchIssues(jql, &jira.SearchOptions{
+		MaxResults: subscriptionPreviewIssues,
+		Fields:     []string{"*all"},
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to search for recent issues")
+	}
+
+	events := filters.Events.Elems()
+	sort.Strings(events)
+
+	// The filters on the changes of a field always fail without a
+	// changelog, they are left out.
+	notEvaluated := []string{}
+	evaluated := filters
+	evaluated.Fields = []FieldFilter{}
+	for _, field := range filters.Fields {
+		if field.Inclusion == FilterChanged {
+			notEvaluated = append(notEvaluated, field.Key)
+			continue
+		}
+		evaluated.Fields = append(evaluated.Fields, field)
+	}
+	if len(notEvaluated) == 0 {
+		notEvaluated = nil
+	}
+
+	preview := &subscriptionPreview{
+		JQL:     jql,
+		Results: []subscriptionPreviewMatch{},
+	}
+	for i := range issues {
+		issue := issues[i]
+		if issue.Fields == nil {
+			continue
+		}
+		for _, event := range events {
+			wh := &webhook{
+				JiraWebhook: &JiraWebhook{Issue: issue},
+				eventTypes:  NewStringSet(event),
+			}
+			reason := p.subscriptionMismatch(instanceID, wh, ChannelSubscription{Filters: evaluated})
+			result := subscriptionPreviewMatch{
+				IssueKey: issue.Key,
+				Summary:  issue.Fields.Summary,
+				Event:    event,
+				Matched:  reason == "",
+				Reason:   reason,
+			}
+			if result.Matched {
+				result.NotEvaluated = notEvaluated
+			}
+			preview.Results = append(preview.Results, result)
+		}
+	}
+	return preview, nil
+}
+
+// markdownTable returns the results of a preview as a Markdown table, of at
+// most maxRows events.
+func (preview *subscriptionPreview) markdownTable(baseURL string, maxRows int) string {
+	text := "|Issue|Event|Matches|Reason|\n|--|--|--|--|\n"
+	for i, result := range preview.Results {
+		if i == maxRows {
+			text += fmt.Sprintf("\n...and %d more events.\n", len(preview.Results)-maxRows)
+			break
+		}
+		matched := "No"
+		reason := result.Reason
+		if result.Matched {
+			matched = "Yes"
+			if len(result.NotEvaluated) > 0 {
+				reason = fmt.Sprintf("the filters on the changes of %s are not evaluated in a preview", strings.Join(result.NotEvaluated, ", "))
+			}
+		}
+		text += fmt.Sprintf("|[%s](%s/browse/%s) %s|%s|%s|%s|\n",
+			result.IssueKey, baseURL, result.IssueKey, escapeTableCell(truncate(result.Summary, 80)),
+			result.Event, matched, escapeTableCell(reason))
+	}
+	return text
+}
+
+func (p *Plugin) httpChannelPreviewSubscription(w http.ResponseWriter, r *http.Request) (int, error) {
+	mattermostUserID := r.Header.Get(HeaderMattermostUserID)
+	subscription := ChannelSubscription{}
+	err := json.NewDecoder(r.Body).Decode(&subscription)
+	if err != nil {
+		return respondErr(w, http.StatusBadRequest,
+			errors.WithMessage(err, "failed to decode incoming request"))
+	}
+
+	if len(subscription.ChannelID) != 26 {
+		return respondErr(w, http.StatusBadRequest,
+			fmt.Errorf("channel subscription invalid"))
+	}
+
+	_, err = p.client.Channel.GetMember(subscription.ChannelID, mattermostUserID)
+	if err != nil {
+		return respondErr(w, http.StatusForbidden,
+			errors.New("not a member of the channel specified"))
+	}
+
+	err = p.hasPermissionToManageSubscription(subscription.InstanceID, mattermostUserID, subscription.ChannelID)
+	if err != nil {
+		return respondErr(w, http.StatusForbidden,
+			errors.Wrap(err, "you don't have permission to manage subscriptions"))
+	}
+
+	client, _, _, err := p.getClient(subscription.InstanceID, types.ID(mattermostUserID))
+	if err != nil {
+		return respondErr(w, http.StatusInternalServerError, err)
+	}
+
+	preview, err := p.previewSubscription(subscription.InstanceID, client, subscription.Filters)
+	if err != nil {
+		return respondErr(w, http.StatusBadRequest, err)
+	}
+
+	return respondJSON(w, preview)
+}
+
+func executeSubscribeTest(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	user, instance, args, err := p.loadFlagUserInstance(header.UserId, args)
+	if err != nil {
+		return p.responsef(header, "Failed to load your connection to Jira. Error: %v.", err)
+	}
+	if len(args) == 0 {
+		return p.responsef(header, "Please specify a subscription name in the form `/jira subscribe test <subscription-name>`.")
+	}
+	name := strings.Join(args, " ")
+
+	err = p.hasPermissionToManageSubscription(instance.GetID(), header.UserId, header.ChannelId)
+	if err != nil {
+		return p.responsef(header, "You don't have permission to manage subscriptions. Error: %v.", err)
+	}
+
+	subs, err := p.getSubscriptionsForChannel(instance.GetID(), header.ChannelId)
+	if err != nil {
+		return p.responsef(header, "Failed to load the subscriptions of this channel. Error: %v.", err)
+	}
+	subscription := findSubscriptionByName(subs, name)
+	if subscription == nil {
+		return p.responsef(header, "This channel has no subscription named %q.", name)
+	}
+
+	client, _, _, err := p.getClient(instance.GetID(), user.MattermostUserID)
+	if err != nil {
+		return p.responsef(header, "Failed to get a Jira client. Error: %v.", err)
+	}
+
+	preview, err := p.previewSubscription(instance.GetID(), client, subscription.Filters)
+	if err != nil {
+		return p.responsef(header, "Failed to test the subscription. Error: %v.", err)
+	}
+	if len(preview.Results) == 0 {
+		return p.responsef(header, "No recent issues were found with `%s`.", preview.JQL)
+	}
+
+	text := fmt.Sprintf("Subscription %q would match %d of %d recent events of issues found with `%s`:\n\n",
+		subscription.Name, preview.matchedCount(), len(preview.Results), preview.JQL)
+	text += preview.markdownTable(instance.GetJiraBaseURL(), subscriptionTestMaxRows)
+	return p.responsef(header, "%s", text)
+}
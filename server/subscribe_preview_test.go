@@ -0,0 +1,210 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type searchTestClient struct {
+	testClient
+	jql     string
+	options *jira.SearchOptions
+	issues  []jira.Issue
+}
+
+func (client *searchTestClient) SearchIssues(jql string, options *jira.SearchOptions) ([]jira.Issue, error) {
+	client.jql = jql
+	client.options = options
+	return client.issues, nil
+}
+
+func previewTestIssue(key, projectKey, typeID, priorityID string, labels ...string) jira.Issue {
+	return jira.Issue{
+		Key: key,
+		Fields: &jira.IssueFields{
+			Summary:  "Summary of " + key,
+			Type:     jira.IssueType{ID: typeID, Name: "Type " + typeID},
+			Project:  jira.Project{Key: projectKey},
+			Priority: &jira.Priority{ID: priorityID},
+			Labels:   labels,
+		},
+	}
+}
+
+func TestPreviewSubscription(t *testing.T) {
+	p := &Plugin{}
+	p.updateConfig(func(conf *config) {})
+	client := &searchTestClient{issues: []jira.Issue{
+		previewTestIssue("TES-1", "TES", "10001", "1", "backend"),
+		previewTestIssue("TES-2", "TES", "10002", "1", "backend"),
+		previewTestIssue("TES-3", "TES", "10001", "3", "backend"),
+		previewTestIssue("TES-4", "TES", "10001", "1", "frontend"),
+	}}
+
+	preview, err := p.previewSubscription(testInstance1.InstanceID, client, SubscriptionFilters{
+		Events:     NewStringSet(eventCreated, eventUpdatedPriority),
+		Projects:   NewStringSet("TES", "OTHER"),
+		IssueTypes: NewStringSet("10001"),
+		Fields: []FieldFilter{
+			{Key: "priority", Inclusion: FilterIncludeAny, Values: NewStringSet("1", "2")},
+			{Key: "labels", Inclusion: FilterExcludeAny, Values: NewStringSet("frontend")},
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, `project in ("OTHER", "TES") ORDER BY updated DESC`, client.jql)
+	assert.Equal(t, subscriptionPreviewIssues, client.options.MaxResults)
+	assert.Equal(t, client.jql, preview.JQL)
+	assert.Equal(t, []subscriptionPreviewMatch{
+		{IssueKey: "TES-1", Summary: "Summary of TES-1", Event: eventCreated, Matched: true},
+		{IssueKey: "TES-1", Summary: "Summary of TES-1", Event: eventUpdatedPriority, Matched: true},
+		{IssueKey: "TES-2", Summary: "Summary of TES-2", Event: eventCreated, Reason: "the issue type Type 10002 (10002) is not selected"},
+		{IssueKey: "TES-2", Summary: "Summary of TES-2", Event: eventUpdatedPriority, Reason: "the issue type Type 10002 (10002) is not selected"},
+		{IssueKey: "TES-3", Summary: "Summary of TES-3", Event: eventCreated, Reason: "priority is 3, not any of 1, 2"},
+		{IssueKey: "TES-3", Summary: "Summary of TES-3", Event: eventUpdatedPriority, Reason: "priority is 3, not any of 1, 2"},
+		{IssueKey: "TES-4", Summary: "Summary of TES-4", Event: eventCreated, Reason: "labels is frontend, one of the excluded frontend"},
+		{IssueKey: "TES-4", Summary: "Summary of TES-4", Event: eventUpdatedPriority, Reason: "labels is frontend, one of the excluded frontend"},
+	}, preview.Results)
+	assert.Equal(t, 2, preview.matchedCount())
+
+	_, err = p.previewSubscription(testInstance1.InstanceID, client, SubscriptionFilters{})
+	assert.Error(t, err)
+}
+
+func TestSubscriptionPreviewJQL(t *testing.T) {
+	assert.Equal(t, "ORDER BY updated DESC", subscriptionPreviewJQL(SubscriptionFilters{}))
+	assert.Equal(t, `project in ("TES") ORDER BY updated DESC`,
+		subscriptionPreviewJQL(SubscriptionFilters{Projects: NewStringSet("TES")}))
+	assert.Equal(t, `(labels = urgent OR priority = High) ORDER BY updated DESC`,
+		subscriptionPreviewJQL(SubscriptionFilters{JQL: "labels = urgent OR priority = High"}))
+	assert.Equal(t, `project in ("OTHER", "TES") AND (labels = urgent) ORDER BY updated DESC`,
+		subscriptionPreviewJQL(SubscriptionFilters{Projects: NewStringSet("TES", "OTHER"), JQL: " labels = urgent "}))
+}
+
+func TestPreviewSubscriptionChangeFilters(t *testing.T) {
+	p := &Plugin{}
+	p.updateConfig(func(conf *config) {})
+	client := &searchTestClient{issues: []jira.Issue{
+		previewTestIssue("TES-1", "TES", "10001", "1"),
+		previewTestIssue("TES-2", "TES", "10002", "1"),
+	}}
+
+	preview, err := p.previewSubscription(testInstance1.InstanceID, client, SubscriptionFilters{
+		Events:     NewStringSet(eventUpdatedAny),
+		IssueTypes: NewStringSet("10001"),
+		Fields: []FieldFilter{
+			{Key: "status", Inclusion: FilterChanged, To: NewStringSet("Done")},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []subscriptionPreviewMatch{
+		{IssueKey: "TES-1", Summary: "Summary of TES-1", Event: eventUpdatedAny, Matched: true, NotEvaluated: []string{"status"}},
+		{IssueKey: "TES-2", Summary: "Summary of TES-2", Event: eventUpdatedAny, Reason: "the issue type Type 10002 (10002) is not selected"},
+	}, preview.Results)
+
+	table := preview.markdownTable("https://jira.example.com", subscriptionTestMaxRows)
+	assert.Contains(t, table, "|event_updated_any|Yes|the filters on the changes of status are not evaluated in a preview|")
+}
+
+func TestSubscriptionPreviewMarkdownTable(t *testing.T) {
+	preview := &subscriptionPreview{}
+	for i := 1; i <= 5; i++ {
+		preview.Results = append(preview.Results, subscriptionPreviewMatch{
+			IssueKey: fmt.Sprintf("TES-%d", i),
+			Summary:  "A | summary",
+			Event:    eventCreated,
+			Matched:  i%2 == 0,
+			Reason:   "reason",
+		})
+	}
+
+	table := preview.markdownTable("https://jira.example.com", 3)
+	assert.Equal(t, "|Issue|Event|Matches|Reason|\n|--|--|--|--|\n"+
+		"|[TES-1](https://jira.example.com/browse/TES-1) A \\| summary|event_created|No|reason|\n"+
+		"|[TES-2](https://jira.example.com/browse/TES-2) A \\| summary|event_created|Yes|reason|\n"+
+		"|[TES-3](https://jira.example.com/browse/TES-3) A \\| summary|event_created|No|reason|\n"+
+		"\n...and 2 more events.\n", table)
+	assert.NotContains(t, preview.markdownTable("https://jira.example.com", 5), "more events")
+}
+
+func TestSubscriptionMismatch(t *testing.T) {
+	issue := previewTestIssue("TES-1", "TES", "10001", "1", "backend")
+	for name, tc := range map[string]struct {
+		filters  SubscriptionFilters
+		event    string
+		expected string
+	}{
+		"matches": {
+			filters: SubscriptionFilters{Events: NewStringSet(eventCreated)},
+			event:   eventCreated,
+		},
+		"any update matches": {
+			filters: SubscriptionFilters{Events: NewStringSet(eventUpdatedAny)},
+			event:   eventUpdatedStatus,
+		},
+		"event": {
+			filters:  SubscriptionFilters{Events: NewStringSet(eventCreated)},
+			event:    eventDeleted,
+			expected: "the event event_deleted is not selected",
+		},
+		"project": {
+			filters:  SubscriptionFilters{Events: NewStringSet(eventCreated), Projects: NewStringSet("OTHER")},
+			event:    eventCreated,
+			expected: "the project TES is not selected",
+		},
+		"incomplete filter": {
+			filters:  SubscriptionFilters{Events: NewStringSet(eventCreated), Fields: []FieldFilter{{Key: "labels", Inclusion: FilterIncludeAny}}},
+			event:    eventCreated,
+			expected: "the filter on labels is incomplete",
+		},
+		"include all": {
+			filters: SubscriptionFilters{Events: NewStringSet(eventCreated), Fields: []FieldFilter{
+				{Key: "labels", Inclusion: FilterIncludeAll, Values: NewStringSet("backend", "urgent")},
+			}},
+			event:    eventCreated,
+			expected: "labels is backend, not all of backend, urgent",
+		},
+		"empty": {
+			filters: SubscriptionFilters{Events: NewStringSet(eventCreated), Fields: []FieldFilter{
+				{Key: "labels", Inclusion: FilterEmpty},
+			}},
+			event:    eventCreated,
+			expected: "labels is backend, not empty",
+		},
+		"include or empty": {
+			filters: SubscriptionFilters{Events: NewStringSet(eventCreated), Fields: []FieldFilter{
+				{Key: "labels", Inclusion: FilterIncludeOrEmpty, Values: NewStringSet("urgent")},
+			}},
+			event:    eventCreated,
+			expected: "labels is backend, neither empty nor any of urgent",
+		},
+		"regular expression": {
+			filters: SubscriptionFilters{Events: NewStringSet(eventCreated), Fields: []FieldFilter{
+				{Key: "summary", Inclusion: FilterMatches, Values: NewStringSet("^Bug")},
+			}},
+			event:    eventCreated,
+			expected: "summary does not match ^Bug",
+		},
+		"JQL": {
+			filters:  SubscriptionFilters{Events: NewStringSet(eventCreated), JQL: "labels = urgent"},
+			event:    eventCreated,
+			expected: "the issue does not match the JQL",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := &Plugin{}
+			p.updateConfig(func(conf *config) {})
+			wh := &webhook{JiraWebhook: &JiraWebhook{Issue: issue}, eventTypes: NewStringSet(tc.event)}
+			sub := ChannelSubscription{Filters: tc.filters}
+			assert.Equal(t, tc.expected, p.subscriptionMismatch(testInstance1.InstanceID, wh, sub))
+			assert.Equal(t, tc.expected == "", p.matchesSubsciptionFilters(testInstance1.InstanceID, wh, sub))
+		})
+	}
+}
@@ -0,0 +1,422 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/utils/types"
+)
+
+const (
+	prefixChannelPostBucket = "post_bucket_"
+	prefixChannelPostBurst  = "post_burst_"
+
+	// channelPostBurstWindow is how long a burst of events is collapsed after
+	// the first event over the limit. Later events are posted again, as the
+	// tokens of the bucket allow.
+	channelPostBurstWindow = time.Minute
+
+	// channelPostBurstMaxLines is the number of groups of events listed in
+	// the summary of a burst, the others are only counted.
+	channelPostBurstMaxLines = 10
+
+	// channelPostBurstMaxIssues is the number of issue keys kept in a group
+	// of a burst to count each issue once, the issues over it are counted
+	// with each of their events.
+	channelPostBurstMaxIssues = 100
+
+	// channelPostBurstUpdateInterval is how often the summary of a burst is
+	// rewritten at most. The events in between are added to it at the end
+	// of the interval.
+	channelPostBurstUpdateInterval = 5 * time.Second
+
+	// channelPostBucketTTL is how long a bucket is kept after its last post.
+	// By then it is refilled, and a new bucket is the same.
+	channelPostBucketTTL = 10 * time.Minute
+
+	// channelPostBurstTTL is how long a burst summary is kept, it is only
+	// updated during its window.
+	channelPostBurstTTL = time.Hour
+
+	maxChannelPostBucketUpdateAttempts = 5
+
+	// SubscriptionRateLimitNone disables the rate limit of a subscription.
+	SubscriptionRateLimitNone = -1
+)
+
+// channelPostBucket is the token bucket of the posts to a channel. It holds up
+// to the limit of posts per minute, and is refilled at that rate.
+type channelPostBucket struct {
+	Tokens    float64 `json:"tokens"`
+	UpdatedAt int64   `json:"updated_at"`
+
+	// BurstStart identifies the current burst of events over the limit, and
+	// BurstUntil is the end of its window. Both are zero outside a burst.
+	BurstStart int64 `json:"burst_start,omitempty"`
+	BurstUntil int64 `json:"burst_until,omitempty"`
+}
+
+// take takes a token for a post at the time now, in milliseconds. It returns
+// zero if the event can be posted, or the start of the burst it is collapsed
+// into. The events of a burst are collapsed until its window ends, even if
+// the bucket is refilled meanwhile, but they do not extend the window.
+func (b *channelPostBucket) take(limit int, now int64) int64 {
+	if b.UpdatedAt == 0 {
+		b.Tokens = float64(limit)
+	} else if now > b.UpdatedAt {
+		refill := float64(now-b.UpdatedAt) * float64(limit) / float64(time.Minute.Milliseconds())
+		b.Tokens = math.Min(float64(limit), b.Tokens+refill)
+	}
+	b.UpdatedAt = now
+
+	if b.BurstUntil > now {
+		return b.BurstStart
+	}
+	if b.Tokens >= 1 {
+		b.Tokens--
+		b.BurstStart = 0
+		b.BurstUntil = 0
+		return 0
+	}
+	b.BurstStart = now
+	b.BurstUntil = now + channelPostBurstWindow.Milliseconds()
+	return b.BurstStart
+}
+
+// channelPostBurst collapses the events of a burst in a channel into a summary
+// post, the details of the events are posted in its thread.
+type channelPostBurst struct {
+	PostID string                  `json:"post_id"`
+	Groups []channelPostBurstGroup `json:"groups"`
+
+	// UpdatedAt is when the summary post was last written, and Stale tells
+	// that events were added since.
+	UpdatedAt int64 `json:"updated_at,omitempty"`
+	Stale     bool  `json:"stale,omitempty"`
+}
+
+// channelPostBurstGroup is a line of the summary of a burst, the issues
+// changed the same way by the same user. More counts the events of the
+// issues over channelPostBurstMaxIssues.
+type channelPostBurstGroup struct {
+	Change string   `json:"change"`
+	User   string   `json:"user,omitempty"`
+	Issues []string `json:"issues"`
+	More   int      `json:"more,omitempty"`
+}
+
+func (g *channelPostBurstGroup) count() int {
+	return len(g.Issues) + g.More
+}
+
+// add counts the issue of an event in its group, and tells if it was not
+// already.
+func (b *channelPostBurst) add(change, user, issueKey string) bool {
+	for i := range b.Groups {
+		group := &b.Groups[i]
+		if group.Change != change || group.User != user {
+			continue
+		}
+		for _, key := range group.Issues {
+			if key == issueKey {
+				return false
+			}
+		}
+		if len(group.Issues) >= channelPostBurstMaxIssues {
+			group.More++
+			return true
+		}
+		group.Issues = append(group.Issues, issueKey)
+		return true
+	}
+	b.Groups = append(b.Groups, channelPostBurstGroup{Change: change, User: user, Issues: []string{issueKey}})
+	return true
+}
+
+func (b *channelPostBurst) message() string {
+	lines := []string{"Too many Jira events in a short time, they are collapsed into this summary. The details are in the thread."}
+	for i, group := range b.Groups {
+		if i == channelPostBurstMaxLines {
+			lines = append(lines, fmt.Sprintf("* ...and %d more", len(b.Groups)-i))
+			break
+		}
+		issues := "issues"
+		if group.count() == 1 {
+			issues = "issue"
+		}
+		line := fmt.Sprintf("* **%d %s** %s", group.count(), issues, group.Change)
+		if group.User != "" {
+			line += " by " + group.User
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// describeBurstChange describes the change of an issue by a webhook event, as
+// in "42 issues moved to Sprint 12".
+func describeBurstChange(wh *webhook) string {
+	events := wh.Events()
+	field := wh.fieldInfo
+	switch {
+	case events.ContainsAny(eventCreated):
+		return "created"
+	case events.ContainsAny(eventDeleted):
+		return "deleted"
+	case events.ContainsAny(eventCreatedComment, eventUpdatedComment, eventDeletedComment):
+		return "commented on"
+	case strings.EqualFold(field.name, "sprint") && field.to != "":
+		return "moved to " + field.to
+	case strings.EqualFold(field.name, "rank"):
+		return "re-ranked"
+	case field.name != "" && field.to != "":
+		return fmt.Sprintf("with %s changed to %s", field.name, field.to)
+	case field.name != "":
+		return fmt.Sprintf("with %s changed", field.name)
+	}
+	return "updated"
+}
+
+func burstUserName(wh *webhook) string {
+	switch {
+	case wh.JiraWebhook.User.DisplayName != "":
+		return wh.JiraWebhook.User.DisplayName
+	case wh.JiraWebhook.Comment.UpdateAuthor.DisplayName != "":
+		return wh.JiraWebhook.Comment.UpdateAuthor.DisplayName
+	}
+	return wh.JiraWebhook.Comment.Author.DisplayName
+}
+
+// subscriptionRateLimit returns the number of posts per minute of the
+// subscription to its channel, and the scope of its token bucket. The
+// subscriptions with a limit of their own have a bucket of their own, the
+// others share the bucket of the channel. A zero limit is unlimited.
+func (p *Plugin) subscriptionRateLimit(sub ChannelSubscription) (int, string) {
+	switch {
+	case sub.RateLimit == SubscriptionRateLimitNone:
+		return 0, ""
+	case sub.RateLimit > 0:
+		return sub.RateLimit, sub.ChannelID + "/" + sub.ID
+	}
+	return p.getConfig().SubscriptionPostsPerMinute, sub.ChannelID
+}
+
+// postRateLimited posts the webhook event to a subscribed channel, unless the
+// channel is over its rate limit. The event is then collapsed into the
+// summary of the burst, and posted in its thread.
+func (p *Plugin) postRateLimited(wh *webhook, instanceID types.ID, sub ChannelSubscription, fromUserID string) (*model.Post, int, error) {
+	limit, scope := p.subscriptionRateLimit(sub)
+	if limit <= 0 {
+		return p.postToSubscribedChannel(wh, instanceID, sub, fromUserID)
+	}
+
+	burstStart, err := p.takeChannelPostToken(instanceID, scope, limit, time.Now())
+	if err != nil {
+		// Prefer a post over the limit to a lost event
+		p.errorf("postRateLimited: failed to update the rate limit of channel %s: %v", sub.ChannelID, err)
+		return p.postToSubscribedChannel(wh, instanceID, sub, fromUserID)
+	}
+	if burstStart == 0 {
+		return p.postToSubscribedChannel(wh, instanceID, sub, fromUserID)
+	}
+	return p.postToChannelBurst(wh, instanceID, sub, fromUserID, channelPostBurstKey(instanceID, scope, burstStart), time.Now())
+}
+
+// takeChannelPostToken takes a token from the bucket of the scope, see
+// channelPostBucket.take.
+func (p *Plugin) takeChannelPostToken(instanceID types.ID, scope string, limit int, now time.Time) (int64, error) {
+	key := channelPostBucketKey(instanceID, scope)
+	for i := 0; i < maxChannelPostBucketUpdateAttempts; i++ {
+		var data []byte
+		err := p.client.KV.Get(key, &data)
+		if err != nil {
+			return 0, err
+		}
+
+		bucket := channelPostBucket{}
+		if len(data) != 0 {
+			err = json.Unmarshal(data, &bucket)
+			if err != nil {
+				return 0, err
+			}
+		}
+		burstStart := bucket.take(limit, model.GetMillisForTime(now))
+
+		saved, err := p.client.KV.Set(key, bucket, pluginapi.SetAtomic(data), pluginapi.SetExpiry(channelPostBucketTTL))
+		if err != nil {
+			return 0, err
+		}
+		if saved {
+			return burstStart, nil
+		}
+	}
+	return 0, errors.Errorf("failed to update %s after %d attempts", key, maxChannelPostBucketUpdateAttempts)
+}
+
+// postToChannelBurst adds the event to the summary of a burst, starting the
+// summary post with the first event, and posts the event as a reply to it.
+// The summary is rewritten at most every channelPostBurstUpdateInterval.
+func (p *Plugin) postToChannelBurst(wh *webhook, instanceID types.ID, sub ChannelSubscription, fromUserID, key string, now time.Time) (*model.Post, int, error) {
+	post, err := p.makeSubscriptionPost(wh, instanceID, sub, fromUserID)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	mutex, err := cluster.NewMutex(p.API, key)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.WithMessage(err, "failed to create the burst mutex")
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	burst := channelPostBurst{}
+	err = p.client.KV.Get(key, &burst)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.WithMessage(err, "failed to load the burst")
+	}
+
+	added := burst.add(describeBurstChange(wh), burstUserName(wh), wh.JiraWebhook.Issue.Key)
+	nextUpdate := time.Duration(burst.UpdatedAt+channelPostBurstUpdateInterval.Milliseconds()-model.GetMillisForTime(now)) * time.Millisecond
+	switch {
+	case burst.PostID == "":
+		summary := &model.Post{
+			ChannelId: sub.ChannelID,
+			UserId:    fromUserID,
+			Message:   burst.message(),
+		}
+		err = p.client.Post.CreatePost(summary)
+		if err != nil {
+			return nil, http.StatusInternalServerError, errors.WithMessage(err, "failed to post the burst summary")
+		}
+		burst.PostID = summary.Id
+		burst.UpdatedAt = model.GetMillisForTime(now)
+
+	case (added || burst.Stale) && nextUpdate <= 0:
+		err = p.updateChannelPostBurstSummary(&burst, now)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+
+	case added:
+		burst.Stale = true
+		p.channelPostBurstTimers.schedule(key, nextUpdate, func() {
+			if flushErr := p.flushChannelPostBurst(key, time.Now()); flushErr != nil {
+				p.errorf("postToChannelBurst: failed to update the burst summary: %v", flushErr)
+			}
+		})
+	}
+
+	_, err = p.client.KV.Set(key, burst, pluginapi.SetExpiry(channelPostBurstTTL))
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.WithMessage(err, "failed to store the burst")
+	}
+
+	post.RootId = burst.PostID
+	err = p.client.Post.CreatePost(post)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return post, http.StatusOK, nil
+}
+
+// flushChannelPostBurst rewrites the summary of a burst, if events were added
+// to the burst since it was last written.
+func (p *Plugin) flushChannelPostBurst(key string, now time.Time) error {
+	mutex, err := cluster.NewMutex(p.API, key)
+	if err != nil {
+		return errors.WithMessage(err, "failed to create the burst mutex")
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	burst := channelPostBurst{}
+	err = p.client.KV.Get(key, &burst)
+	if err != nil {
+		return errors.WithMessage(err, "failed to load the burst")
+	}
+	if !burst.Stale || burst.PostID == "" {
+		return nil
+	}
+
+	err = p.updateChannelPostBurstSummary(&burst, now)
+	if err != nil {
+		return err
+	}
+	_, err = p.client.KV.Set(key, burst, pluginapi.SetExpiry(channelPostBurstTTL))
+	if err != nil {
+		return errors.WithMessage(err, "failed to store the burst")
+	}
+	return nil
+}
+
+func (p *Plugin) updateChannelPostBurstSummary(burst *channelPostBurst, now time.Time) error {
+	summary, err := p.client.Post.GetPost(burst.PostID)
+	if err != nil {
+		return errors.WithMessage(err, "failed to load the burst summary")
+	}
+	summary.Message = burst.message()
+	err = p.client.Post.UpdatePost(summary)
+	if err != nil {
+		return errors.WithMessage(err, "failed to update the burst summary")
+	}
+	burst.UpdatedAt = model.GetMillisForTime(now)
+	burst.Stale = false
+	return nil
+}
+
+// channelPostBurstTimers are the pending rewrites of the burst summaries by
+// this server, by burst key.
+type channelPostBurstTimers struct {
+	lock   sync.Mutex
+	timers map[string]*time.Timer
+}
+
+// schedule calls f after delay, unless a call is already pending for the key.
+func (t *channelPostBurstTimers) schedule(key string, delay time.Duration, f func()) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.timers == nil {
+		t.timers = map[string]*time.Timer{}
+	}
+	if t.timers[key] != nil {
+		return
+	}
+	t.timers[key] = time.AfterFunc(delay, func() {
+		t.lock.Lock()
+		delete(t.timers, key)
+		t.lock.Unlock()
+		f()
+	})
+}
+
+// stop cancels the pending calls.
+func (t *channelPostBurstTimers) stop() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for key, timer := range t.timers {
+		timer.Stop()
+		delete(t.timers, key)
+	}
+}
+
+func channelPostBucketKey(instanceID types.ID, scope string) string {
+	return hashkey(prefixChannelPostBucket, fmt.Sprintf("%s/%s", instanceID, scope))
+}
+
+func channelPostBurstKey(instanceID types.ID, scope string, burstStart int64) string {
+	return hashkey(prefixChannelPostBurst, fmt.Sprintf("%s/%s/%d", instanceID, scope, burstStart))
+}
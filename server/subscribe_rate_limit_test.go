@@ -0,0 +1,257 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest/mock"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChannelPostBucketTake(t *testing.T) {
+	second := time.Second.Milliseconds()
+	start := int64(1000000)
+	bucket := channelPostBucket{}
+
+	assert.Zero(t, bucket.take(2, start))
+	assert.Zero(t, bucket.take(2, start))
+	assert.Equal(t, start, bucket.take(2, start), "the bucket is empty")
+
+	// The bucket is refilled, but the burst continues for its window, which
+	// the events of the burst do not extend
+	assert.Equal(t, start, bucket.take(2, start+40*second))
+	assert.Equal(t, start, bucket.take(2, start+59*second))
+
+	after := start + 90*second
+	assert.Zero(t, bucket.take(2, after), "the burst ended")
+	assert.Zero(t, bucket.take(2, after))
+	assert.Equal(t, after, bucket.take(2, after), "a new burst")
+}
+
+func TestDescribeBurstChange(t *testing.T) {
+	for expected, wh := range map[string]*webhook{
+		"created":                     {eventTypes: NewStringSet(eventCreated)},
+		"commented on":                {eventTypes: NewStringSet(eventCreatedComment)},
+		"moved to Sprint 12":          {eventTypes: NewStringSet(eventUpdatedAny), fieldInfo: webhookField{name: "Sprint", to: "Sprint 12"}},
+		"re-ranked":                   {eventTypes: NewStringSet(eventUpdatedAny), fieldInfo: webhookField{name: "Rank", to: "Ranked higher"}},
+		"with status changed to Done": {eventTypes: NewStringSet(eventUpdatedStatus), fieldInfo: webhookField{name: "status", to: "Done"}},
+		"with attachments changed":    {eventTypes: NewStringSet(eventUpdatedAttachment), fieldInfo: webhookField{name: "attachments"}},
+		"updated":                     {eventTypes: NewStringSet(eventUpdatedAny)},
+	} {
+		assert.Equal(t, expected, describeBurstChange(wh))
+	}
+}
+
+func TestChannelPostBurstMessage(t *testing.T) {
+	burst := channelPostBurst{}
+	assert.True(t, burst.add("moved to Sprint 12", "Alice", "TES-1"))
+	assert.True(t, burst.add("moved to Sprint 12", "Alice", "TES-2"))
+	assert.False(t, burst.add("moved to Sprint 12", "Alice", "TES-1"), "the issue is counted once")
+	assert.True(t, burst.add("re-ranked", "Bob", "TES-1"))
+	assert.True(t, burst.add("created", "", "TES-3"))
+
+	assert.Equal(t, "Too many Jira events in a short time, they are collapsed into this summary. The details are in the thread.\n"+
+		"* **2 issues** moved to Sprint 12 by Alice\n"+
+		"* **1 issue** re-ranked by Bob\n"+
+		"* **1 issue** created", burst.message())
+
+	// The summary lists a limited number of groups
+	for i := 0; i < channelPostBurstMaxLines; i++ {
+		assert.True(t, burst.add(fmt.Sprintf("moved to Sprint %d", i), "Alice", "TES-1"))
+	}
+	lines := strings.Split(burst.message(), "\n")
+	require.Len(t, lines, channelPostBurstMaxLines+2)
+	assert.Equal(t, "* **1 issue** moved to Sprint 6 by Alice", lines[channelPostBurstMaxLines])
+	assert.Equal(t, "* ...and 3 more", lines[channelPostBurstMaxLines+1])
+
+	// The issues over the limit of a group are only counted
+	burst = channelPostBurst{}
+	for i := 0; i < channelPostBurstMaxIssues+2; i++ {
+		assert.True(t, burst.add("created", "", fmt.Sprintf("TES-%d", i)))
+	}
+	assert.True(t, burst.add("created", "", "TES-101"))
+	assert.False(t, burst.add("created", "", "TES-1"))
+	assert.Len(t, burst.Groups[0].Issues, channelPostBurstMaxIssues)
+	assert.Equal(t, 3, burst.Groups[0].More)
+	assert.Equal(t, "Too many Jira events in a short time, they are collapsed into this summary. The details are in the thread.\n"+
+		fmt.Sprintf("* **%d issues** created", channelPostBurstMaxIssues+3), burst.message())
+}
+
+func TestSubscriptionRateLimit(t *testing.T) {
+	p := &Plugin{}
+	p.updateConfig(func(conf *config) {
+		conf.SubscriptionPostsPerMinute = 30
+	})
+	sub := ChannelSubscription{ID: "sub1", ChannelID: "channel1"}
+
+	limit, scope := p.subscriptionRateLimit(sub)
+	assert.Equal(t, 30, limit)
+	assert.Equal(t, "channel1", scope)
+
+	sub.RateLimit = 5
+	limit, scope = p.subscriptionRateLimit(sub)
+	assert.Equal(t, 5, limit)
+	assert.Equal(t, "channel1/sub1", scope)
+
+	sub.RateLimit = SubscriptionRateLimitNone
+	limit, _ = p.subscriptionRateLimit(sub)
+	assert.Zero(t, limit)
+}
+
+func TestPostRateLimited(t *testing.T) {
+	p := &Plugin{}
+	p.updateConfig(func(conf *config) {
+		conf.SubscriptionPostsPerMinute = 2
+	})
+	api := &plugintest.API{}
+	api.On("LogDebug", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe()
+	makeTestKVStore(api, nil)
+
+	posts := map[string]*model.Post{}
+	api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(func(post *model.Post) *model.Post {
+		created := post.Clone()
+		created.Id = model.NewId()
+		posts[created.Id] = created
+		return created
+	}, nil)
+	api.On("GetPost", mock.AnythingOfType("string")).Return(func(id string) *model.Post {
+		return posts[id].Clone()
+	}, nil)
+	api.On("UpdatePost", mock.AnythingOfType("*model.Post")).Return(func(post *model.Post) *model.Post {
+		posts[post.Id] = post.Clone()
+		return post.Clone()
+	}, nil)
+	p.SetAPI(api)
+	p.client = pluginapi.NewClient(api, p.Driver)
+	t.Cleanup(p.channelPostBurstTimers.stop)
+
+	data, err := getJiraTestData("webhook-issue-created.json")
+	require.NoError(t, err)
+	parsed, err := ParseWebhook(data)
+	require.NoError(t, err)
+	wh := parsed.(*webhook)
+	wh.JiraWebhook.User.DisplayName = "Alice"
+
+	sub := ChannelSubscription{ID: "sub1", ChannelID: "channel1", Name: "subscription"}
+	post := func(issueKey string) *model.Post {
+		wh.JiraWebhook.Issue.Key = issueKey
+		post, _, err := p.postRateLimited(wh, testInstance1.InstanceID, sub, "bot")
+		require.NoError(t, err)
+		return post
+	}
+
+	assert.Empty(t, post("TES-1").RootId)
+	assert.Empty(t, post("TES-2").RootId)
+
+	reply := post("TES-3")
+	require.NotEmpty(t, reply.RootId, "the events over the limit are posted in the thread of the summary")
+	assert.Equal(t, "Too many Jira events in a short time, they are collapsed into this summary. The details are in the thread.\n"+
+		"* **1 issue** created by Alice", posts[reply.RootId].Message)
+
+	assert.Equal(t, reply.RootId, post("TES-4").RootId)
+	assert.Equal(t, reply.RootId, post("TES-4").RootId)
+	assert.Equal(t, "Too many Jira events in a short time, they are collapsed into this summary. The details are in the thread.\n"+
+		"* **1 issue** created by Alice", posts[reply.RootId].Message, "the summary is not rewritten for each event")
+
+	burstKeys := []string{}
+	p.channelPostBurstTimers.lock.Lock()
+	for key := range p.channelPostBurstTimers.timers {
+		burstKeys = append(burstKeys, key)
+	}
+	p.channelPostBurstTimers.lock.Unlock()
+	require.Len(t, burstKeys, 1, "the summary is rewritten later")
+	p.channelPostBurstTimers.stop()
+	require.NoError(t, p.flushChannelPostBurst(burstKeys[0], time.Now()))
+	assert.Equal(t, "Too many Jira events in a short time, they are collapsed into this summary. The details are in the thread.\n"+
+		"* **2 issues** created by Alice", posts[reply.RootId].Message)
+
+	otherChannel := sub
+	otherChannel.ChannelID = "channel2"
+	wh.JiraWebhook.Issue.Key = "TES-5"
+	other, _, err := p.postRateLimited(wh, testInstance1.InstanceID, otherChannel, "bot")
+	require.NoError(t, err)
+	assert.Empty(t, other.RootId, "the limit is per channel")
+
+	unlimited := sub
+	unlimited.RateLimit = SubscriptionRateLimitNone
+	wh.JiraWebhook.Issue.Key = "TES-6"
+	other, _, err = p.postRateLimited(wh, testInstance1.InstanceID, unlimited, "bot")
+	require.NoError(t, err)
+	assert.Empty(t, other.RootId)
+}
+
+func TestPostToChannelBurstUpdateInterval(t *testing.T) {
+	p := &Plugin{}
+	api := &plugintest.API{}
+	makeTestKVStore(api, nil)
+	posts := map[string]*model.Post{}
+	updates := 0
+	api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(func(post *model.Post) *model.Post {
+		created := post.Clone()
+		created.Id = model.NewId()
+		posts[created.Id] = created
+		return created
+	}, nil)
+	api.On("GetPost", mock.AnythingOfType("string")).Return(func(id string) *model.Post {
+		return posts[id].Clone()
+	}, nil)
+	api.On("UpdatePost", mock.AnythingOfType("*model.Post")).Return(func(post *model.Post) *model.Post {
+		updates++
+		posts[post.Id] = post.Clone()
+		return post.Clone()
+	}, nil)
+	p.SetAPI(api)
+	p.client = pluginapi.NewClient(api, p.Driver)
+	t.Cleanup(p.channelPostBurstTimers.stop)
+
+	data, err := getJiraTestData("webhook-issue-created.json")
+	require.NoError(t, err)
+	parsed, err := ParseWebhook(data)
+	require.NoError(t, err)
+	wh := parsed.(*webhook)
+	wh.JiraWebhook.User.DisplayName = "Alice"
+
+	sub := ChannelSubscription{ID: "sub1", ChannelID: "channel1", Name: "subscription"}
+	key := channelPostBurstKey(testInstance1.InstanceID, "channel1", 1000)
+	start := time.Now()
+	post := func(issueKey string, at time.Duration) string {
+		wh.JiraWebhook.Issue.Key = issueKey
+		post, _, err := p.postToChannelBurst(wh, testInstance1.InstanceID, sub, "bot", key, start.Add(at))
+		require.NoError(t, err)
+		return post.RootId
+	}
+	rootID := post("TES-1", 0)
+	summary := func() string {
+		return strings.TrimPrefix(posts[rootID].Message,
+			"Too many Jira events in a short time, they are collapsed into this summary. The details are in the thread.\n")
+	}
+
+	assert.Equal(t, "* **1 issue** created by Alice", summary())
+	post("TES-2", time.Second)
+	post("TES-3", 2*time.Second)
+	assert.Equal(t, "* **1 issue** created by Alice", summary())
+	assert.Zero(t, updates)
+
+	// A flush without new events leaves the summary as it is
+	require.NoError(t, p.flushChannelPostBurst(key, start.Add(3*time.Second)))
+	assert.Equal(t, 1, updates)
+	require.NoError(t, p.flushChannelPostBurst(key, start.Add(4*time.Second)))
+	assert.Equal(t, 1, updates)
+	assert.Equal(t, "* **3 issues** created by Alice", summary())
+
+	// The first event after the interval rewrites the summary
+	post("TES-4", 4*time.Second)
+	assert.Equal(t, 1, updates)
+	post("TES-5", 8*time.Second)
+	assert.Equal(t, 2, updates)
+	assert.Equal(t, "* **5 issues** created by Alice", summary())
+}
@@ -0,0 +1,451 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/markup"
+	"github.com/mattermost/mattermost-plugin-jira/server/utils/types"
+)
+
+const (
+	defaultAttachmentColor = "#95b7d0"
+
+	MaxSubscriptionTemplateLength = 4000
+)
+
+var reAttachmentColor = regexp.MustCompile(`^#([[:xdigit:]]{3}|[[:xdigit:]]{6})$`)
+
+// subscriptionTemplateFuncs are the functions available to the subscription
+// templates, in addition to the text/template builtins.
+var subscriptionTemplateFuncs = template.FuncMap{
+	"join":     strings.Join,
+	"lower":    strings.ToLower,
+	"upper":    strings.ToUpper,
+	"truncate": func(max int, s string) string { return truncate(s, max) },
+}
+
+// SubscriptionTemplate customizes the posts of a subscription.
+type SubscriptionTemplate struct {
+	// Message is a text/template executed with a SubscriptionTemplateData to
+	// render the posts of the subscription. If empty, the default format is
+	// used.
+	Message string `json:"message,omitempty"`
+
+	// Colors of the post attachment.
+	Colors AttachmentColors `json:"colors,omitempty"`
+
+	// sample is the message rendered with a sample event by validate.
+	sample string
+}
+
+// AttachmentColors map the priority and status names of an issue, case
+// insensitive, to the color of its post attachment, e.g. "#ff0000". The color
+// of the priority wins over the one of the status.
+type AttachmentColors struct {
+	ByPriority map[string]string `json:"by_priority,omitempty"`
+	ByStatus   map[string]string `json:"by_status,omitempty"`
+}
+
+// SubscriptionTemplateData is the model the subscription templates are
+// executed with, e.g. "{{.Actor.DisplayName}} moved {{.Issue.Key}} to
+// {{.Issue.Status}}".
+type SubscriptionTemplateData struct {
+	// Subscription is the name of the subscription.
+	Subscription string
+	// Event is the first of the events, e.g. event_updated_status.
+	Event string
+	// Events are the subscription events of the webhook, e.g.
+	// event_created or event_created_comment.
+	Events []string
+	// Headline is the default headline of the post, e.g. "Jane Doe
+	// transitioned story ABC-1 from To Do to Done".
+	Headline string
+	// Text is the default text of the post, e.g. the description of a new
+	// issue, or a new comment, in Markdown.
+	Text string
+	// Actor is the user who made the change.
+	Actor TemplateUser
+	// Issue is the issue after the change.
+	Issue TemplateIssue
+	// Changes are the changed fields of the issue.
+	Changes []TemplateChange
+	// Comment is the comment that was added, updated or deleted, if any.
+	Comment *TemplateComment
+}
+
+// TemplateUser is a Jira user in the subscription templates.
+type TemplateUser struct {
+	Name        string
+	DisplayName string
+	AccountID   string
+}
+
+// TemplateIssue is a Jira issue in the subscription templates.
+type TemplateIssue struct {
+	Key         string
+	URL         string
+	Summary     string
+	Description string
+	Type        string
+	Status      string
+	Priority    string
+	Project     string
+	ProjectKey  string
+	Labels      []string
+	Assignee    *TemplateUser
+	Reporter    *TemplateUser
+}
+
+// TemplateChange is a changed field of an issue in the subscription templates.
+type TemplateChange struct {
+	Field string
+	From  string
+	To    string
+}
+
+// TemplateComment is a comment of an issue in the subscription templates.
+type TemplateComment struct {
+	Author TemplateUser
+	Body   string
+}
+
+func parseSubscriptionTemplate(text string) (*template.Template, error) {
+	return template.New("subscription").Funcs(subscriptionTemplateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// validate checks the template, and returns it rendered with a sample
+// webhook of the subscription.
+func (t *SubscriptionTemplate) validate(sub *ChannelSubscription) (string, error) {
+	if len(t.Message) > MaxSubscriptionTemplateLength {
+		return "", errors.Errorf("please provide a template less than %d characters", MaxSubscriptionTemplateLength)
+	}
+	for name, color := range t.Colors.ByPriority {
+		if !reAttachmentColor.MatchString(color) {
+			return "", errors.Errorf("invalid color %q for priority %q, please use a color like #ff0000", color, name)
+		}
+	}
+	for name, color := range t.Colors.ByStatus {
+		if !reAttachmentColor.MatchString(color) {
+			return "", errors.Errorf("invalid color %q for status %q, please use a color like #ff0000", color, name)
+		}
+	}
+	if strings.TrimSpace(t.Message) == "" {
+		return "", nil
+	}
+
+	// The template must render every kind of event, with or without the
+	// optional fields, and the first sample is the one shown to the user.
+	sample := ""
+	for _, kind := range sampleSubscriptionWebhookKinds {
+		wh, err := sampleSubscriptionWebhook(sub, kind)
+		if err != nil {
+			return "", err
+		}
+		message, err := t.render(newSubscriptionTemplateData(wh, sub.Name, false))
+		if err != nil {
+			return "", errors.WithMessagef(err, "with %s", kind.description)
+		}
+		if strings.TrimSpace(message) == "" {
+			return "", errors.Errorf("invalid template: the template renders an empty message with %s", kind.description)
+		}
+		if sample == "" {
+			sample = message
+		}
+	}
+	return sample, nil
+}
+
+func (t *SubscriptionTemplate) render(data *SubscriptionTemplateData) (string, error) {
+	tmpl, err := parseSubscriptionTemplate(t.Message)
+	if err != nil {
+		return "", errors.WithMessage(err, "invalid template")
+	}
+	return executeSubscriptionTemplate(tmpl, data)
+}
+
+func executeSubscriptionTemplate(tmpl *template.Template, data *SubscriptionTemplateData) (string, error) {
+	var out strings.Builder
+	err := tmpl.Execute(&out, data)
+	if err != nil {
+		return "", errors.WithMessage(err, "invalid template")
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// parsedSubscriptionTemplate is the cached template of a subscription, with
+// the message it was parsed from.
+type parsedSubscriptionTemplate struct {
+	message string
+	tmpl    *template.Template
+}
+
+// subscriptionTemplate returns the parsed template of a subscription. It is
+// parsed once and cached, until the template of the subscription changes.
+func (p *Plugin) subscriptionTemplate(sub ChannelSubscription) (*template.Template, error) {
+	if cached, ok := p.subscriptionTemplates.Load(sub.ID); ok {
+		if parsed := cached.(*parsedSubscriptionTemplate); parsed.message == sub.Template.Message {
+			return parsed.tmpl, nil
+		}
+	}
+
+	tmpl, err := parseSubscriptionTemplate(sub.Template.Message)
+	if err != nil {
+		return nil, errors.WithMessage(err, "invalid template")
+	}
+	p.subscriptionTemplates.Store(sub.ID, &parsedSubscriptionTemplate{message: sub.Template.Message, tmpl: tmpl})
+	return tmpl, nil
+}
+
+// color returns the attachment color of an issue, or the default color.
+func (c AttachmentColors) color(issue *jira.Issue) string {
+	if issue.Fields != nil {
+		if issue.Fields.Priority != nil {
+			if color := lookupFold(c.ByPriority, issue.Fields.Priority.Name); color != "" {
+				return color
+			}
+		}
+		if issue.Fields.Status != nil {
+			if color := lookupFold(c.ByStatus, issue.Fields.Status.Name); color != "" {
+				return color
+			}
+		}
+	}
+	return defaultAttachmentColor
+}
+
+func lookupFold(m map[string]string, name string) string {
+	for key, value := range m {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// makeSubscriptionPost makes the post of a webhook event for a subscribed
+// channel, with the template of the subscription if it has one. If the
+// template fails to render the event, the default post is made instead.
+func (p *Plugin) makeSubscriptionPost(wh *webhook, instanceID types.ID, sub ChannelSubscription, fromUserID string) (*model.Post, error) {
+	post, err := wh.makeChannelPost(p, instanceID, sub.ChannelID, fromUserID, sub.Name)
+	if err != nil || sub.Template == nil {
+		return post, err
+	}
+
+	color := sub.Template.Colors.color(&wh.Issue)
+	withColor := func() *model.Post {
+		for _, attachment := range post.Attachments() {
+			attachment.Color = color
+		}
+		return post
+	}
+	if strings.TrimSpace(sub.Template.Message) == "" {
+		return withColor(), nil
+	}
+
+	message, err := p.renderSubscriptionTemplate(wh, sub)
+	if err != nil {
+		p.errorf("Failed to render the template of subscription %q, using the default post, err: %v", sub.Name, err)
+		return withColor(), nil
+	}
+	message = p.replaceJiraAccountIds(instanceID, message)
+
+	post = &model.Post{
+		ChannelId: sub.ChannelID,
+		UserId:    fromUserID,
+	}
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{
+		{
+			Color:    color,
+			Fallback: message,
+			Text:     message,
+		},
+	})
+	return post, nil
+}
+
+func (p *Plugin) renderSubscriptionTemplate(wh *webhook, sub ChannelSubscription) (string, error) {
+	tmpl, err := p.subscriptionTemplate(sub)
+	if err != nil {
+		return "", err
+	}
+	message, err := executeSubscriptionTemplate(tmpl, newSubscriptionTemplateData(wh, sub.Name, p.getConfig().HideDecriptionComment))
+	if err != nil {
+		return "", err
+	}
+	if message == "" {
+		return "", errors.New("the template rendered an empty message")
+	}
+	return message, nil
+}
+
+// newSubscriptionTemplateData returns the model of a webhook event for the
+// subscription templates. The description and comments are left out if they
+// are hidden from the notifications.
+func newSubscriptionTemplateData(wh *webhook, subscriptionName string, hideText bool) *SubscriptionTemplateData {
+	jwh := wh.JiraWebhook
+	data := &SubscriptionTemplateData{
+		Subscription: subscriptionName,
+		Events:       wh.Events().Elems(),
+		Headline:     wh.headline,
+		Actor:        templateUser(jwh.User),
+		Issue:        templateIssue(jwh),
+		Changes:      []TemplateChange{},
+	}
+	if len(data.Events) > 0 {
+		data.Event = data.Events[0]
+	}
+	if data.Actor == (TemplateUser{}) {
+		data.Actor = templateUser(jwh.Comment.UpdateAuthor)
+	}
+	if !hideText {
+		data.Text = wh.text
+	} else {
+		data.Issue.Description = ""
+	}
+
+	for _, item := range jwh.ChangeLog.Items {
+		data.Changes = append(data.Changes, TemplateChange{
+			Field: item.Field,
+			From:  item.FromString,
+			To:    item.ToString,
+		})
+	}
+
+	if jwh.Comment.ID != "" {
+		data.Comment = &TemplateComment{Author: templateUser(jwh.Comment.UpdateAuthor)}
+		if !hideText {
+			data.Comment.Body = markup.WikiToMarkdown(jwh.Comment.Body)
+		}
+	}
+	return data
+}
+
+func templateUser(u jira.User) TemplateUser {
+	return TemplateUser{Name: u.Name, DisplayName: u.DisplayName, AccountID: u.AccountID}
+}
+
+func templateIssue(jwh *JiraWebhook) TemplateIssue {
+	issue := TemplateIssue{Key: jwh.Issue.Key}
+	if pos := strings.LastIndex(jwh.Issue.Self, "/rest/api"); pos >= 0 {
+		issue.URL = jwh.Issue.Self[:pos] + "/browse/" + jwh.Issue.Key
+	}
+
+	fields := jwh.Issue.Fields
+	if fields == nil {
+		return issue
+	}
+	issue.Summary = fields.Summary
+	issue.Description = issueDescriptionMarkdown(&jwh.Issue)
+	issue.Type = fields.Type.Name
+	issue.Project = fields.Project.Name
+	issue.ProjectKey = fields.Project.Key
+	issue.Labels = fields.Labels
+	if fields.Status != nil {
+		issue.Status = fields.Status.Name
+	}
+	if fields.Priority != nil {
+		issue.Priority = fields.Priority.Name
+	}
+	if fields.Assignee != nil {
+		u := templateUser(*fields.Assignee)
+		issue.Assignee = &u
+	}
+	if fields.Reporter != nil {
+		u := templateUser(*fields.Reporter)
+		issue.Reporter = &u
+	}
+	return issue
+}
+
+// sampleSubscriptionWebhookKind is a kind of webhook event the subscription
+// templates are validated with.
+type sampleSubscriptionWebhookKind struct {
+	description string
+	event       string
+	assigned    bool
+	commented   bool
+	changed     bool
+}
+
+var sampleSubscriptionWebhookKinds = []sampleSubscriptionWebhookKind{
+	{description: "a transitioned issue with a comment", event: "jira:issue_updated", assigned: true, commented: true, changed: true},
+	{description: "a created unassigned issue", event: "jira:issue_created"},
+	{description: "a comment on an unassigned issue", event: commentCreated, commented: true},
+}
+
+// sampleSubscriptionWebhook returns a sample webhook event of an issue of the
+// subscription, to validate its template.
+func sampleSubscriptionWebhook(sub *ChannelSubscription, kind sampleSubscriptionWebhookKind) (*webhook, error) {
+	projectKey := "PROJ"
+	if sub.Filters.Projects.Len() > 0 {
+		projectKey = sub.Filters.Projects.Elems()[0]
+	}
+	issueTypeID := "10001"
+	if sub.Filters.IssueTypes.Len() > 0 {
+		issueTypeID = sub.Filters.IssueTypes.Elems()[0]
+	}
+
+	status := "To Do"
+	assignee, comment, changelog := "", "", ""
+	if kind.assigned {
+		assignee = `,
+				"assignee": {"name": "john", "displayName": "John Smith", "accountId": "5c5f880629be9642ba529341"}`
+	}
+	if kind.commented {
+		comment = `,
+		"comment": {
+			"id": "10100",
+			"body": "Working on it.",
+			"updateAuthor": {"name": "jane", "displayName": "Jane Doe", "accountId": "5c5f880629be9642ba529340"}
+		}`
+	}
+	if kind.changed {
+		status = "In Progress"
+		changelog = `,
+		"changelog": {
+			"items": [{"field": "status", "fieldtype": "jira", "fromString": "To Do", "toString": "In Progress"}]
+		}`
+	}
+
+	data := fmt.Sprintf(`{
+		"webhookEvent": %[4]q,
+		"issue_event_type_name": "issue_generic",
+		"user": {"name": "jane", "displayName": "Jane Doe", "accountId": "5c5f880629be9642ba529340"},
+		"issue": {
+			"id": "10001",
+			"key": %[1]q,
+			"self": "https://jira.example.com/rest/api/2/issue/10001",
+			"fields": {
+				"summary": "Sample issue",
+				"description": "A *sample* issue to preview the subscription.",
+				"issuetype": {"id": %[2]q, "name": "Story"},
+				"project": {"key": %[3]q, "name": %[3]q},
+				"status": {"name": %[5]q},
+				"priority": {"name": "High"},
+				"labels": ["sample"],
+				"reporter": {"name": "jane", "displayName": "Jane Doe", "accountId": "5c5f880629be9642ba529340"}%[6]s
+			}
+		}%[7]s%[8]s
+	}`, projectKey+"-123", issueTypeID, projectKey, kind.event, status, assignee, comment, changelog)
+
+	wh, err := ParseWebhook([]byte(data))
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to make a sample webhook")
+	}
+	v, ok := wh.(*webhook)
+	if !ok {
+		return nil, errors.New("failed to make a sample webhook")
+	}
+	return v, nil
+}
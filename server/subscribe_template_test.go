@@ -0,0 +1,264 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest/mock"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriptionTemplateValidate(t *testing.T) {
+	for name, tc := range map[string]struct {
+		template       SubscriptionTemplate
+		expectedSample string
+		expectedErr    string
+	}{
+		"colors only": {
+			template: SubscriptionTemplate{Colors: AttachmentColors{
+				ByPriority: map[string]string{"Highest": "#ff0000"},
+				ByStatus:   map[string]string{"Done": "#0f0"},
+			}},
+		},
+		"template": {
+			template: SubscriptionTemplate{Message: "{{.Actor.DisplayName}} moved [{{.Issue.Key}}]({{.Issue.URL}}) to {{.Issue.Status}}" +
+				"{{range .Changes}}\n* {{.Field}}: {{.From}} → {{.To}}{{end}}" +
+				"{{with .Comment}}\n> {{truncate 20 .Body}}{{end}}" +
+				"{{if .Issue.Assignee}}\nAssigned to {{.Issue.Assignee.DisplayName}}{{end}}"},
+			expectedSample: "Jane Doe moved [TES-123](https://jira.example.com/browse/TES-123) to In Progress\n" +
+				"* status: To Do → In Progress\n> Working on it.\nAssigned to John Smith",
+		},
+		"invalid priority color": {
+			template:    SubscriptionTemplate{Colors: AttachmentColors{ByPriority: map[string]string{"High": "red"}}},
+			expectedErr: `invalid color "red" for priority "High"`,
+		},
+		"invalid status color": {
+			template:    SubscriptionTemplate{Colors: AttachmentColors{ByStatus: map[string]string{"Done": "#12345"}}},
+			expectedErr: `invalid color "#12345" for status "Done"`,
+		},
+		"syntax error": {
+			template:    SubscriptionTemplate{Message: "{{.Issue.Key"},
+			expectedErr: "invalid template",
+		},
+		"unknown field": {
+			template:    SubscriptionTemplate{Message: "{{.Issue.Owner}}"},
+			expectedErr: "can't evaluate field Owner",
+		},
+		"unknown function": {
+			template:    SubscriptionTemplate{Message: "{{shout .Issue.Key}}"},
+			expectedErr: `function "shout" not defined`,
+		},
+		"comment of an event without comment": {
+			template:    SubscriptionTemplate{Message: "{{.Issue.Key}}: {{.Comment.Body}}"},
+			expectedErr: "with a created unassigned issue",
+		},
+		"assignee of an unassigned issue": {
+			template:    SubscriptionTemplate{Message: "{{.Issue.Key}} is assigned to {{.Issue.Assignee.DisplayName}}"},
+			expectedErr: "with a created unassigned issue",
+		},
+		"empty message": {
+			template:    SubscriptionTemplate{Message: "{{if false}}never{{end}}"},
+			expectedErr: "the template renders an empty message",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			sub := &ChannelSubscription{
+				Name:    "sub",
+				Filters: SubscriptionFilters{Projects: NewStringSet("TES"), IssueTypes: NewStringSet("10002")},
+			}
+			sample, err := tc.template.validate(sub)
+			if tc.expectedErr == "" {
+				require.NoError(t, err)
+				assert.Equal(t, tc.expectedSample, sample)
+			} else {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedErr)
+			}
+		})
+	}
+}
+
+func TestSubscriptionTemplateParsedOnce(t *testing.T) {
+	p := &Plugin{}
+	sub := ChannelSubscription{ID: "sub1", Template: &SubscriptionTemplate{Message: "{{.Issue.Key}} by {{.Actor.DisplayName}}"}}
+
+	tmpl, err := p.subscriptionTemplate(sub)
+	require.NoError(t, err)
+	cached, err := p.subscriptionTemplate(sub)
+	require.NoError(t, err)
+	assert.Same(t, tmpl, cached)
+
+	// A changed template is parsed again.
+	sub.Template = &SubscriptionTemplate{Message: "{{.Issue.Key}}"}
+	changed, err := p.subscriptionTemplate(sub)
+	require.NoError(t, err)
+	assert.NotSame(t, tmpl, changed)
+	message, err := executeSubscriptionTemplate(changed, &SubscriptionTemplateData{Issue: TemplateIssue{Key: "TES-1"}})
+	require.NoError(t, err)
+	assert.Equal(t, "TES-1", message)
+
+	sub.Template = &SubscriptionTemplate{Message: "{{.Issue.Key"}
+	_, err = p.subscriptionTemplate(sub)
+	assert.Error(t, err)
+}
+
+func TestSubscriptionResponseTemplateSample(t *testing.T) {
+	sub := &ChannelSubscription{
+		ID:       "sub1",
+		Name:     "sub",
+		Filters:  SubscriptionFilters{Projects: NewStringSet("TES")},
+		Template: &SubscriptionTemplate{Message: "{{.Issue.Key}} is {{.Issue.Status}}"},
+	}
+	sample, err := sub.Template.validate(sub)
+	require.NoError(t, err)
+	sub.Template.sample = sample
+
+	data, err := json.Marshal(newSubscriptionResponse(sub))
+	require.NoError(t, err)
+	response := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(data, &response))
+	assert.Equal(t, "sub1", response["id"])
+	assert.Equal(t, "TES-123 is In Progress", response["template_sample"])
+	assert.Equal(t, "{{.Issue.Key}} is {{.Issue.Status}}", response["template"].(map[string]interface{})["message"])
+}
+
+func TestSampleSubscriptionWebhook(t *testing.T) {
+	sub := &ChannelSubscription{Filters: SubscriptionFilters{Projects: NewStringSet("TES")}}
+	wh, err := sampleSubscriptionWebhook(sub, sampleSubscriptionWebhookKinds[0])
+	require.NoError(t, err)
+
+	data := newSubscriptionTemplateData(wh, "sub", false)
+	assert.Equal(t, "TES-123", data.Issue.Key)
+	assert.Equal(t, "https://jira.example.com/browse/TES-123", data.Issue.URL)
+	assert.Equal(t, "TES", data.Issue.ProjectKey)
+	assert.Equal(t, "A **sample** issue to preview the subscription.", data.Issue.Description)
+	assert.Equal(t, "event_updated_status", data.Event)
+	assert.Equal(t, []TemplateChange{{Field: "status", From: "To Do", To: "In Progress"}}, data.Changes)
+	require.NotNil(t, data.Comment)
+	assert.Equal(t, "Working on it.", data.Comment.Body)
+	assert.Equal(t, "Jane Doe", data.Actor.DisplayName)
+	require.NotNil(t, data.Issue.Assignee)
+
+	wh, err = sampleSubscriptionWebhook(sub, sampleSubscriptionWebhookKinds[1])
+	require.NoError(t, err)
+	data = newSubscriptionTemplateData(wh, "sub", false)
+	assert.Equal(t, "event_created", data.Event)
+	assert.Empty(t, data.Changes)
+	assert.Nil(t, data.Comment)
+	assert.Nil(t, data.Issue.Assignee)
+
+	wh, err = sampleSubscriptionWebhook(sub, sampleSubscriptionWebhookKinds[2])
+	require.NoError(t, err)
+	data = newSubscriptionTemplateData(wh, "sub", false)
+	assert.Equal(t, "event_created_comment", data.Event)
+	require.NotNil(t, data.Comment)
+	assert.Nil(t, data.Issue.Assignee)
+}
+
+func TestMakeSubscriptionPost(t *testing.T) {
+	setup := func(t *testing.T, hideText bool) (*Plugin, *webhook) {
+		p := &Plugin{}
+		p.updateConfig(func(conf *config) {
+			conf.HideDecriptionComment = hideText
+		})
+		api := &plugintest.API{}
+		p.SetAPI(api)
+		p.client = pluginapi.NewClient(api, p.Driver)
+		p.userStore = mockUserStore{}
+
+		data, err := getJiraTestData("webhook-issue-created.json")
+		require.NoError(t, err)
+		wh, err := ParseWebhook(data)
+		require.NoError(t, err)
+		return p, wh.(*webhook)
+	}
+
+	sub := ChannelSubscription{
+		ID:        "subscription1",
+		ChannelID: "channel1",
+		Name:      "bugs",
+	}
+
+	t.Run("default format", func(t *testing.T) {
+		p, wh := setup(t, false)
+		post, err := p.makeSubscriptionPost(wh, testInstance1.InstanceID, sub, "bot")
+		require.NoError(t, err)
+		require.Len(t, post.Attachments(), 1)
+		assert.Equal(t, defaultAttachmentColor, post.Attachments()[0].Color)
+		assert.Contains(t, post.Attachments()[0].Pretext, "**created** story")
+	})
+
+	t.Run("colors", func(t *testing.T) {
+		p, wh := setup(t, false)
+		withColors := sub
+		withColors.Template = &SubscriptionTemplate{Colors: AttachmentColors{
+			ByPriority: map[string]string{"high": "#ff0000"},
+			ByStatus:   map[string]string{"To Do": "#00ff00"},
+		}}
+		post, err := p.makeSubscriptionPost(wh, testInstance1.InstanceID, withColors, "bot")
+		require.NoError(t, err)
+		require.Len(t, post.Attachments(), 1)
+		assert.Equal(t, "#ff0000", post.Attachments()[0].Color)
+		assert.Contains(t, post.Attachments()[0].Pretext, "**created** story")
+
+		withColors.Template = &SubscriptionTemplate{Colors: AttachmentColors{
+			ByPriority: map[string]string{"Highest": "#ff0000"},
+			ByStatus:   map[string]string{"to do": "#00ff00"},
+		}}
+		post, err = p.makeSubscriptionPost(wh, testInstance1.InstanceID, withColors, "bot")
+		require.NoError(t, err)
+		assert.Equal(t, "#00ff00", post.Attachments()[0].Color)
+	})
+
+	t.Run("template", func(t *testing.T) {
+		p, wh := setup(t, false)
+		templated := sub
+		templated.Template = &SubscriptionTemplate{
+			Message: "[{{.Subscription}}] {{.Actor.DisplayName}} {{.Event}} {{.Issue.Key}} ({{.Issue.Priority | upper}})\n{{.Text}}",
+			Colors:  AttachmentColors{ByPriority: map[string]string{"High": "#ff0000"}},
+		}
+		post, err := p.makeSubscriptionPost(wh, testInstance1.InstanceID, templated, "bot")
+		require.NoError(t, err)
+		assert.Equal(t, "channel1", post.ChannelId)
+		assert.Equal(t, "bot", post.UserId)
+		require.Len(t, post.Attachments(), 1)
+		attachment := post.Attachments()[0]
+		assert.Equal(t, "#ff0000", attachment.Color)
+		assert.Equal(t, "[bugs] Test User event_created TES-41 (HIGH)\n"+wh.text, attachment.Text)
+		assert.Empty(t, attachment.Fields)
+	})
+
+	t.Run("template without the hidden description", func(t *testing.T) {
+		p, wh := setup(t, true)
+		templated := sub
+		templated.Template = &SubscriptionTemplate{Message: "{{.Issue.Key}}:{{.Text}}{{.Issue.Description}}"}
+		post, err := p.makeSubscriptionPost(wh, testInstance1.InstanceID, templated, "bot")
+		require.NoError(t, err)
+		assert.Equal(t, "TES-41:", post.Attachments()[0].Text)
+	})
+
+	t.Run("template error falls back to the default format", func(t *testing.T) {
+		p, wh := setup(t, false)
+		p.API.(*plugintest.API).On("LogError", mock.MatchedBy(func(msg string) bool {
+			return strings.Contains(msg, `Failed to render the template of subscription "bugs"`)
+		})).Once()
+		templated := sub
+		templated.Template = &SubscriptionTemplate{
+			Message: "{{index .Issue.Labels 5}}",
+			Colors:  AttachmentColors{ByPriority: map[string]string{"High": "#ff0000"}},
+		}
+		post, err := p.makeSubscriptionPost(wh, testInstance1.InstanceID, templated, "bot")
+		require.NoError(t, err)
+		require.Len(t, post.Attachments(), 1)
+		assert.Equal(t, "#ff0000", post.Attachments()[0].Color)
+		assert.Contains(t, post.Attachments()[0].Pretext, "**created** story")
+		p.API.(*plugintest.API).AssertExpectations(t)
+	})
+}
@@ -5,17 +5,18 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
+	"fmt"
 	"io"
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
+	jira "github.com/andygrunwald/go-jira"
 	"github.com/stretchr/testify/require"
 
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
-	"github.com/mattermost/mattermost/server/public/plugin/plugintest/mock"
 	"github.com/mattermost/mattermost/server/public/pluginapi"
 	"github.com/stretchr/testify/assert"
 )
@@ -47,6 +48,21 @@ func TestValidateSubscription(t *testing.T) {
 			},
 			errorMessage: "please provide at least one event type",
 		},
+		"invalid rate limit": {
+			subscription: &ChannelSubscription{
+				ID:         "id",
+				Name:       "name",
+				ChannelID:  "channelid",
+				InstanceID: "instance_id",
+				Filters: SubscriptionFilters{
+					Events:     NewStringSet("issue_created"),
+					Projects:   NewStringSet("project"),
+					IssueTypes: NewStringSet("10001"),
+				},
+				RateLimit: -2,
+			},
+			errorMessage: "please provide a rate limit of at least 1 post per minute, 0 for the limit of the channel, or -1 for no limit",
+		},
 		"no project selected": {
 			subscription: &ChannelSubscription{
 				ID:         "id",
@@ -174,6 +190,62 @@ func TestValidateSubscription(t *testing.T) {
 			},
 			errorMessage: "invalid access to security level",
 		},
+		"JQL subscription without project and issue type": {
+			subscription: &ChannelSubscription{
+				ID:         "id",
+				Name:       "name",
+				ChannelID:  "channelid",
+				InstanceID: "instance_id",
+				Filters: SubscriptionFilters{
+					Events:     NewStringSet("issue_created"),
+					Projects:   NewStringSet(),
+					IssueTypes: NewStringSet(),
+					JQL:        "project = OPS AND priority in (P1, P2) AND labels != noise",
+				},
+			},
+			errorMessage: "",
+		},
+		"unsupported JQL clause": {
+			subscription: &ChannelSubscription{
+				ID:         "id",
+				Name:       "name",
+				ChannelID:  "channelid",
+				InstanceID: "instance_id",
+				Filters: SubscriptionFilters{
+					Events:     NewStringSet("issue_created"),
+					Projects:   NewStringSet("TEST"),
+					IssueTypes: NewStringSet("10001"),
+					JQL:        "project = TEST AND status CHANGED",
+				},
+			},
+			errorMessage: "invalid JQL: the CHANGED operator is not supported in subscription JQL",
+		},
+		"JQL subscription of any project": {
+			subscription: &ChannelSubscription{
+				ID:         "id",
+				Name:       "name",
+				ChannelID:  "channelid",
+				InstanceID: "instance_id",
+				Filters: SubscriptionFilters{
+					Events: NewStringSet("issue_created"),
+					JQL:    "project = OPS OR priority = P1",
+				},
+			},
+			errorMessage: "please provide a project identifier, or restrict the JQL to projects, as project in (KEY1, KEY2)",
+		},
+		"JQL subscription of a project the user can not read": {
+			subscription: &ChannelSubscription{
+				ID:         "id",
+				Name:       "name",
+				ChannelID:  "channelid",
+				InstanceID: "instance_id",
+				Filters: SubscriptionFilters{
+					Events: NewStringSet("issue_created"),
+					JQL:    "project in (OPS, FP) AND priority = P1",
+				},
+			},
+			errorMessage: "failed to get project \"FP\": Project FP not found",
+		},
 		"user does not have read access to the project": {
 			subscription: &ChannelSubscription{
 				ID:         "id",
@@ -194,7 +266,7 @@ func TestValidateSubscription(t *testing.T) {
 			p.SetAPI(api)
 			p.client = pluginapi.NewClient(p.API, p.Driver)
 
-			api.On("KVGet", testSubKey).Return(nil, nil)
+			mockSubscriptionStore(t, api, nil)
 
 			p.updateConfig(func(conf *config) {
 				conf.SecurityLevelEmptyForJiraSubscriptions = !tc.disableSecurityConfig
@@ -242,6 +314,34 @@ func TestListChannelSubscriptions(t *testing.T) {
 				assert.Equal(t, expected, actual)
 			},
 		},
+		"muted and snoozed subscriptions": {
+			Subs: withExistingChannelSubscriptions([]ChannelSubscription{
+				{
+					ID:        model.NewId(),
+					ChannelID: "channel1",
+					Name:      "Sub Name X",
+					Filters: SubscriptionFilters{
+						Projects: NewStringSet("PROJ"),
+					},
+					InstanceID: testInstance1.GetID(),
+					Enabled:    model.NewBool(false),
+				},
+				{
+					ID:        model.NewId(),
+					ChannelID: "channel1",
+					Name:      "Sub Name Y",
+					Filters: SubscriptionFilters{
+						Projects: NewStringSet("PROJ"),
+					},
+					InstanceID:   testInstance1.GetID(),
+					SnoozedUntil: model.GetMillisForTime(time.Date(2099, 1, 2, 15, 4, 0, 0, time.UTC)),
+				},
+			}),
+			RunAssertions: func(t *testing.T, actual string) {
+				expected := "The following channels have subscribed to Jira notifications. To modify a subscription, navigate to the channel and type `/jira subscribe edit`\n\n#### Team 1 Display Name\n* **~channel-1-name** (2):\n\t* (2) https://jiraurl1.com\n\t\t* PROJ - Sub Name X (muted)\n\t\t* PROJ - Sub Name Y (snoozed until 2099-01-02 15:04 UTC)"
+				assert.Equal(t, expected, actual)
+			},
+		},
 		"zero subscriptions": {
 			Subs: withExistingChannelSubscriptions([]ChannelSubscription{}),
 			RunAssertions: func(t *testing.T, actual string) {
@@ -396,10 +496,9 @@ func TestListChannelSubscriptions(t *testing.T) {
 			})
 			p.SetAPI(api)
 
-			subscriptionBytes, err := json.Marshal(tc.Subs)
-			assert.Nil(t, err)
-
-			api.On("KVGet", testSubKey).Return(subscriptionBytes, nil)
+			// Each case has its own KV store
+			p.subscriptionCache.invalidate(testInstance1.InstanceID)
+			mockSubscriptionStore(t, api, tc.Subs)
 
 			channel1 := &model.Channel{
 				Id:          "channel1",
@@ -449,10 +548,6 @@ func TestListChannelSubscriptions(t *testing.T) {
 			}
 			api.On("GetTeam", "team2Id").Return(team2, nil)
 
-			api.On("KVCompareAndSet", testSubKey, subscriptionBytes, mock.MatchedBy(func(data []byte) bool {
-				return true
-			})).Return(nil)
-
 			p.client = pluginapi.NewClient(api, p.Driver)
 			actual, err := p.listChannelSubscriptions(testInstance1.InstanceID, team1.Id)
 			assert.Nil(t, err)
@@ -517,6 +612,36 @@ func TestGetChannelsSubscribed(t *testing.T) {
 				},
 			},
 		},
+		"JQL matches": {
+			WebhookTestData: "webhook-issue-created.json",
+			Subs: withExistingChannelSubscriptions([]ChannelSubscription{
+				{
+					ID:        "rg86cd65efdjdjezgisgxaitzh",
+					ChannelID: "sampleChannelId",
+					Filters: SubscriptionFilters{
+						Events: NewStringSet("event_created"),
+						JQL:    "project = TES AND priority in (High, Highest) AND labels != noise",
+					},
+				},
+			}),
+			ChannelSubscriptions: []ChannelSubscription{{ChannelID: "sampleChannelId"}},
+		},
+		"JQL does not match": {
+			WebhookTestData: "webhook-issue-created.json",
+			Subs: withExistingChannelSubscriptions([]ChannelSubscription{
+				{
+					ID:        "rg86cd65efdjdjezgisgxaitzh",
+					ChannelID: "sampleChannelId",
+					Filters: SubscriptionFilters{
+						Events:     NewStringSet("event_created"),
+						Projects:   NewStringSet("TES"),
+						IssueTypes: NewStringSet("10001"),
+						JQL:        "labels in (noise, test-label) AND summary !~ unit",
+					},
+				},
+			}),
+			ChannelSubscriptions: []ChannelSubscription{},
+		},
 		"project does not match": {
 			WebhookTestData: "webhook-issue-created.json",
 			Subs: withExistingChannelSubscriptions([]ChannelSubscription{
@@ -1641,14 +1766,9 @@ func TestGetChannelsSubscribed(t *testing.T) {
 			})
 			p.SetAPI(api)
 
-			subscriptionBytes, err := json.Marshal(tc.Subs)
-			assert.Nil(t, err)
-
-			api.On("KVGet", testSubKey).Return(subscriptionBytes, nil)
-
-			api.On("KVCompareAndSet", testSubKey, subscriptionBytes, mock.MatchedBy(func(data []byte) bool {
-				return true
-			})).Return(nil)
+			// Each case has its own KV store
+			p.subscriptionCache.invalidate(testInstance1.InstanceID)
+			mockSubscriptionStore(t, api, tc.Subs)
 
 			p.client = pluginapi.NewClient(api, p.Driver)
 
@@ -1678,3 +1798,149 @@ func TestGetChannelsSubscribed(t *testing.T) {
 		})
 	}
 }
+
+func TestFindSubscriptionByName(t *testing.T) {
+	subs := []ChannelSubscription{{ID: "1", Name: "Bugs"}, {ID: "2", Name: "bugs"}, {ID: "3", Name: "Releases"}}
+	for name, expectedID := range map[string]string{
+		"Bugs":     "1",
+		"bugs":     "2",
+		"BUGS":     "",
+		"releases": "3",
+		"Stories":  "",
+	} {
+		t.Run(name, func(t *testing.T) {
+			sub := findSubscriptionByName(subs, name)
+			if expectedID == "" {
+				assert.Nil(t, sub)
+				return
+			}
+			require.NotNil(t, sub)
+			assert.Equal(t, expectedID, sub.ID)
+		})
+	}
+}
+
+func TestSubscriptionCandidateIDs(t *testing.T) {
+	subs := NewChannelSubscriptions()
+	for _, sub := range []ChannelSubscription{
+		{ID: "created", Filters: SubscriptionFilters{Events: NewStringSet(eventCreated), Projects: NewStringSet("TES"), IssueTypes: NewStringSet("10001")}},
+		{ID: "other-project", Filters: SubscriptionFilters{Events: NewStringSet(eventCreated), Projects: NewStringSet("OTHER"), IssueTypes: NewStringSet("10001")}},
+		{ID: "other-type", Filters: SubscriptionFilters{Events: NewStringSet(eventCreated), Projects: NewStringSet("TES"), IssueTypes: NewStringSet("10002")}},
+		{ID: "any-project", Filters: SubscriptionFilters{Events: NewStringSet(eventCreated, eventDeleted)}},
+		{ID: "any-update", Filters: SubscriptionFilters{Events: NewStringSet(eventUpdatedAny), Projects: NewStringSet("TES", "OTHER")}},
+		{ID: "status", Filters: SubscriptionFilters{Events: NewStringSet(eventUpdatedStatus), Projects: NewStringSet("TES"), IssueTypes: NewStringSet("10001", "10002")}},
+	} {
+		sub := sub
+		subs.add(&sub)
+	}
+
+	for name, tc := range map[string]struct {
+		events   StringSet
+		expected []string
+	}{
+		"created":         {events: NewStringSet(eventCreated), expected: []string{"any-project", "created"}},
+		"status":          {events: NewStringSet(eventUpdatedStatus), expected: []string{"any-update", "status"}},
+		"comment":         {events: NewStringSet(eventCreatedComment), expected: []string{"any-update"}},
+		"deleted":         {events: NewStringSet(eventDeleted), expected: []string{"any-project"}},
+		"several events":  {events: NewStringSet(eventCreated, eventUpdatedStatus), expected: []string{"any-project", "any-update", "created", "status"}},
+		"unknown event":   {events: NewStringSet("event_unknown"), expected: []string{}},
+		"no subscription": {events: NewStringSet(eventDeletedUnresolved), expected: []string{}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			wh := &webhook{
+				JiraWebhook: &JiraWebhook{Issue: jira.Issue{Fields: &jira.IssueFields{
+					Project: jira.Project{Key: "TES"},
+					Type:    jira.IssueType{ID: "10001"},
+				}}},
+				eventTypes: tc.events,
+			}
+			assert.Equal(t, tc.expected, subs.candidateIDs(wh))
+		})
+	}
+
+	subs.remove(&ChannelSubscription{ID: "any-project", Filters: SubscriptionFilters{Events: NewStringSet(eventCreated, eventDeleted)}})
+	assert.Empty(t, subs.IDByProject[""])
+	assert.Empty(t, subs.IDByIssueType[""].Subtract("any-update"))
+}
+
+// benchmarkSubscriptions returns n subscriptions spread over 1000 channels and
+// 500 projects, 1% of them on the project of the benchmark webhook.
+func benchmarkSubscriptions(n int) *Subscriptions {
+	events := []string{eventCreated, eventDeleted, eventUpdatedStatus, eventUpdatedAny, eventCreatedComment}
+	list := []ChannelSubscription{}
+	for i := 0; i < n; i++ {
+		project := fmt.Sprintf("PRJ%d", i%500)
+		if i%100 == 0 {
+			project = "TES"
+		}
+		list = append(list, ChannelSubscription{
+			ID:         fmt.Sprintf("sub%06d", i),
+			ChannelID:  fmt.Sprintf("channel%d", i%1000),
+			Name:       fmt.Sprintf("subscription %d", i),
+			InstanceID: testInstance1.InstanceID,
+			Filters: SubscriptionFilters{
+				Events:     NewStringSet(events[i%len(events)]),
+				Projects:   NewStringSet(project),
+				IssueTypes: NewStringSet("10001", "10002"),
+				Fields: []FieldFilter{
+					{Key: "priority", Inclusion: FilterIncludeAny, Values: NewStringSet("1", "2")},
+				},
+			},
+		})
+	}
+	subs := NewSubscriptions()
+	subs.Channel.addAll(list)
+	return subs
+}
+
+func benchmarkWebhook(b *testing.B) *webhook {
+	data, err := getJiraTestData("webhook-issue-created.json")
+	require.NoError(b, err)
+	wh, err := ParseWebhook(data)
+	require.NoError(b, err)
+	return wh.(*webhook)
+}
+
+func BenchmarkGetChannelsSubscribed(b *testing.B) {
+	for _, n := range []int{1000, 10000} {
+		b.Run(fmt.Sprintf("%d subscriptions", n), func(b *testing.B) {
+			p := &Plugin{}
+			p.updateConfig(func(conf *config) {})
+			api := &plugintest.API{}
+			makeTestKVStore(api, testKVStore{})
+			p.SetAPI(api)
+			p.client = pluginapi.NewClient(api, p.Driver)
+			p.subscriptionCache.set(testInstance1.InstanceID, benchmarkSubscriptions(n), 0)
+			wh := benchmarkWebhook(b)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := p.getChannelsSubscribed(wh, testInstance1.InstanceID)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkMatchAllSubscriptions evaluates the filters of all the
+// subscriptions, as getChannelsSubscribed did before the subscriptions were
+// indexed, to compare with BenchmarkGetChannelsSubscribed.
+func BenchmarkMatchAllSubscriptions(b *testing.B) {
+	for _, n := range []int{1000, 10000} {
+		b.Run(fmt.Sprintf("%d subscriptions", n), func(b *testing.B) {
+			p := &Plugin{}
+			p.updateConfig(func(conf *config) {})
+			subs := benchmarkSubscriptions(n)
+			wh := benchmarkWebhook(b)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for _, sub := range subs.Channel.ByID {
+					p.matchesSubsciptionFilters(testInstance1.InstanceID, wh, sub)
+				}
+			}
+		})
+	}
+}
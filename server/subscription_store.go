@@ -0,0 +1,636 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/utils/types"
+)
+
+// The channel subscriptions of an instance are stored one record per
+// subscription, with indexes of their IDs: all of them, split in shards by
+// subscription ID so that concurrent changes rarely update the same record,
+// and by channel. The channel index maps the IDs to the names of the
+// subscriptions, so that the names are kept unique in a channel with an atomic
+// update of the index.
+//
+// The subscriptions used to be stored in a single Subscriptions document per
+// instance, that is migrated the first time the subscriptions of the instance
+// are accessed. The document is left in place, for a downgrade of the plugin.
+const (
+	prefixSubscription      = "sub_"
+	prefixSubscriptionIndex = "subidx_"
+	prefixSubscriptionStore = "substore_"
+
+	subscriptionIndexAll     = "all"
+	subscriptionIndexChannel = "channel"
+
+	// subscriptionIndexShards is the number of records the index of all the
+	// subscriptions of an instance is split into.
+	subscriptionIndexShards = 8
+
+	// subscriptionLoadConcurrency is the number of records read at a time
+	// when the subscriptions of an instance are loaded.
+	subscriptionLoadConcurrency = 8
+
+	subscriptionStoreVersion = 1
+
+	// subscriptionCacheTTL is how long the subscriptions of an instance are
+	// cached. The cache is updated on the changes of the subscriptions, and
+	// reloaded in case a cluster event was missed.
+	subscriptionCacheTTL = 10 * time.Minute
+
+	clusterEventSubscriptionChanged = "subscription_changed"
+)
+
+// subscriptionChangedEvent is the cluster event of a subscription that was
+// added, edited or removed.
+type subscriptionChangedEvent struct {
+	InstanceID     types.ID `json:"instance_id"`
+	SubscriptionID string   `json:"subscription_id"`
+}
+
+// subscriptionCache caches the subscriptions of each instance. The cached
+// Subscriptions are never modified, but replaced, so they can be used without
+// holding the lock.
+type subscriptionCache struct {
+	lock       sync.Mutex
+	byInstance map[types.ID]*cachedSubscriptions
+	// generation of the subscriptions of each instance, incremented on each
+	// change, so that subscriptions loaded before a change are not cached.
+	generation map[types.ID]int
+}
+
+type cachedSubscriptions struct {
+	subs     *Subscriptions
+	loadedAt time.Time
+}
+
+func subscriptionKey(instanceID types.ID, subscriptionID string) string {
+	return hashkey(prefixSubscription, fmt.Sprintf("%s/%s", instanceID, subscriptionID))
+}
+
+func subscriptionIndexKey(instanceID types.ID, index, value string) string {
+	return hashkey(prefixSubscriptionIndex, fmt.Sprintf("%s/%s/%s", instanceID, index, value))
+}
+
+func subscriptionStoreKey(instanceID types.ID) string {
+	return hashkey(prefixSubscriptionStore, string(instanceID))
+}
+
+// subscriptionIndexShard returns the shard of the index of all the
+// subscriptions that has the ID of a subscription.
+func subscriptionIndexShard(subscriptionID string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(subscriptionID))
+	return strconv.Itoa(int(h.Sum32() % subscriptionIndexShards))
+}
+
+// forEachConcurrently calls f for 0 to n-1, at most concurrency at a time,
+// and returns one of the errors.
+func forEachConcurrently(n, concurrency int, f func(i int) error) error {
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var firstErr error
+	slots := make(chan struct{}, concurrency)
+	for i := 0; i < n; i++ {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			if err := f(i); err != nil {
+				lock.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				lock.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+func (c *subscriptionCache) get(instanceID types.ID) (*Subscriptions, int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	cached := c.byInstance[instanceID]
+	if cached == nil || time.Since(cached.loadedAt) > subscriptionCacheTTL {
+		return nil, c.generation[instanceID]
+	}
+	return cached.subs, c.generation[instanceID]
+}
+
+// set caches the subscriptions of an instance, unless they changed since the
+// generation the subscriptions were loaded at.
+func (c *subscriptionCache) set(instanceID types.ID, subs *Subscriptions, generation int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.generation[instanceID] != generation {
+		return
+	}
+	if c.byInstance == nil {
+		c.byInstance = map[types.ID]*cachedSubscriptions{}
+	}
+	c.byInstance[instanceID] = &cachedSubscriptions{subs: subs, loadedAt: time.Now()}
+}
+
+// update replaces a subscription in the cached subscriptions of an instance,
+// or removes it if sub is nil.
+func (c *subscriptionCache) update(instanceID types.ID, subscriptionID string, sub *ChannelSubscription) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.generation == nil {
+		c.generation = map[types.ID]int{}
+	}
+	c.generation[instanceID]++
+
+	cached := c.byInstance[instanceID]
+	if cached == nil {
+		return
+	}
+	updated := &Subscriptions{
+		PluginVersion: cached.subs.PluginVersion,
+		Channel: &ChannelSubscriptions{
+			ByID:          make(map[string]ChannelSubscription, len(cached.subs.Channel.ByID)),
+			IDByChannelID: make(map[string]StringSet, len(cached.subs.Channel.IDByChannelID)),
+			IDByEvent:     make(map[string]StringSet, len(cached.subs.Channel.IDByEvent)),
+			IDByProject:   make(map[string]StringSet, len(cached.subs.Channel.IDByProject)),
+			IDByIssueType: make(map[string]StringSet, len(cached.subs.Channel.IDByIssueType)),
+		},
+	}
+	for id, s := range cached.subs.Channel.ByID {
+		updated.Channel.ByID[id] = s
+	}
+	for channelID, ids := range cached.subs.Channel.IDByChannelID {
+		updated.Channel.IDByChannelID[channelID] = ids
+	}
+	for event, ids := range cached.subs.Channel.IDByEvent {
+		updated.Channel.IDByEvent[event] = ids
+	}
+	for project, ids := range cached.subs.Channel.IDByProject {
+		updated.Channel.IDByProject[project] = ids
+	}
+	for issueType, ids := range cached.subs.Channel.IDByIssueType {
+		updated.Channel.IDByIssueType[issueType] = ids
+	}
+
+	if old, ok := updated.Channel.ByID[subscriptionID]; ok {
+		updated.Channel.remove(&old)
+	}
+	if sub != nil {
+		updated.Channel.add(sub)
+	}
+	c.byInstance[instanceID] = &cachedSubscriptions{subs: updated, loadedAt: cached.loadedAt}
+}
+
+// getSubscriptions returns the subscriptions of an instance, from the cache if
+// they were loaded recently. They must not be modified.
+func (p *Plugin) getSubscriptions(instanceID types.ID) (*Subscriptions, error) {
+	subs, generation := p.subscriptionCache.get(instanceID)
+	if subs != nil {
+		return subs, nil
+	}
+
+	subs, err := p.loadSubscriptions(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	p.subscriptionCache.set(instanceID, subs, generation)
+	return subs, nil
+}
+
+// loadSubscriptions loads the subscriptions of an instance from the KV store.
+func (p *Plugin) loadSubscriptions(instanceID types.ID) (*Subscriptions, error) {
+	err := p.ensureSubscriptionStore(instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := p.loadSubscriptionIDs(instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	loaded := make([]*ChannelSubscription, len(ids))
+	err = forEachConcurrently(len(ids), subscriptionLoadConcurrency, func(i int) error {
+		var loadErr error
+		loaded[i], loadErr = p.loadSubscription(instanceID, ids[i])
+		return loadErr
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	subs := NewSubscriptions()
+	for _, sub := range loaded {
+		// A subscription is missing if it was left over by a failed change,
+		// or removed since the index was loaded.
+		if sub != nil {
+			subs.Channel.add(sub)
+		}
+	}
+	return subs, nil
+}
+
+// loadSubscriptionIDs returns the IDs of all the subscriptions of an
+// instance, from the shards of the index.
+func (p *Plugin) loadSubscriptionIDs(instanceID types.ID) ([]string, error) {
+	shards := make([]StringSet, subscriptionIndexShards)
+	err := forEachConcurrently(subscriptionIndexShards, subscriptionLoadConcurrency, func(i int) error {
+		var loadErr error
+		shards[i], loadErr = p.loadSubscriptionIndex(instanceID, subscriptionIndexAll, strconv.Itoa(i))
+		return loadErr
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	for _, shard := range shards {
+		ids = append(ids, shard.Elems()...)
+	}
+	return ids, nil
+}
+
+// loadSubscription loads a subscription, or returns nil if it does not exist.
+func (p *Plugin) loadSubscription(instanceID types.ID, subscriptionID string) (*ChannelSubscription, error) {
+	var data []byte
+	err := p.client.KV.Get(subscriptionKey(instanceID, subscriptionID), &data)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to load subscription %s", subscriptionID)
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	sub := &ChannelSubscription{}
+	err = json.Unmarshal(data, sub)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to parse subscription %s", subscriptionID)
+	}
+	sub.InstanceID = instanceID
+	return sub, nil
+}
+
+func (p *Plugin) loadSubscriptionIndex(instanceID types.ID, index, value string) (StringSet, error) {
+	ids := NewStringSet()
+	err := p.client.KV.Get(subscriptionIndexKey(instanceID, index, value), &ids)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to load the %s subscription index", index)
+	}
+	return ids, nil
+}
+
+// loadSubscriptionChannelIndex returns the names of the subscriptions of a
+// channel, by ID.
+func (p *Plugin) loadSubscriptionChannelIndex(instanceID types.ID, channelID string) (map[string]string, error) {
+	names := map[string]string{}
+	err := p.client.KV.Get(subscriptionIndexKey(instanceID, subscriptionIndexChannel, channelID), &names)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to load the channel subscription index")
+	}
+	return names, nil
+}
+
+// updateSubscriptionIndex updates the shard of the index of all the
+// subscriptions that has the ID of a subscription.
+func (p *Plugin) updateSubscriptionIndex(instanceID types.ID, subscriptionID string, f func(ids StringSet) StringSet) error {
+	key := subscriptionIndexKey(instanceID, subscriptionIndexAll, subscriptionIndexShard(subscriptionID))
+	err := p.client.KV.SetAtomicWithRetries(key, func(initialBytes []byte) (interface{}, error) {
+		ids := NewStringSet()
+		if len(initialBytes) > 0 {
+			err := json.Unmarshal(initialBytes, &ids)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		ids = f(ids)
+		if ids.Len() == 0 {
+			return nil, nil
+		}
+		return ids, nil
+	})
+	if err != nil {
+		return errors.WithMessage(err, "failed to update the subscription index")
+	}
+	return nil
+}
+
+// setSubscriptionChannelName sets the name of a subscription in the index of
+// its channel, unless another subscription of the channel has that name.
+func (p *Plugin) setSubscriptionChannelName(instanceID types.ID, channelID, subscriptionID, name string) error {
+	return p.client.KV.SetAtomicWithRetries(subscriptionIndexKey(instanceID, subscriptionIndexChannel, channelID), func(initialBytes []byte) (interface{}, error) {
+		names := map[string]string{}
+		if len(initialBytes) > 0 {
+			err := json.Unmarshal(initialBytes, &names)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for id, existing := range names {
+			if existing == name && id != subscriptionID {
+				return nil, errors.Errorf("Subscription name, '%s', already exists. Please choose another name.", name)
+			}
+		}
+		names[subscriptionID] = name
+		return names, nil
+	})
+}
+
+func (p *Plugin) removeSubscriptionChannelName(instanceID types.ID, channelID, subscriptionID string) error {
+	return p.client.KV.SetAtomicWithRetries(subscriptionIndexKey(instanceID, subscriptionIndexChannel, channelID), func(initialBytes []byte) (interface{}, error) {
+		names := map[string]string{}
+		if len(initialBytes) > 0 {
+			err := json.Unmarshal(initialBytes, &names)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		delete(names, subscriptionID)
+		if len(names) == 0 {
+			return nil, nil
+		}
+		return names, nil
+	})
+}
+
+// storeNewSubscription stores a subscription that has a new ID.
+func (p *Plugin) storeNewSubscription(instanceID types.ID, sub *ChannelSubscription) error {
+	err := p.setSubscriptionChannelName(instanceID, sub.ChannelID, sub.ID, sub.Name)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.client.KV.Set(subscriptionKey(instanceID, sub.ID), sub)
+	if err != nil {
+		if removeErr := p.removeSubscriptionChannelName(instanceID, sub.ChannelID, sub.ID); removeErr != nil {
+			p.errorf("storeNewSubscription: failed to remove subscription %s from the channel index: %v", sub.ID, removeErr)
+		}
+		return errors.WithMessage(err, "failed to store the subscription")
+	}
+
+	err = p.updateSubscriptionIndex(instanceID, sub.ID, func(ids StringSet) StringSet {
+		return ids.Add(sub.ID)
+	})
+	if err != nil {
+		return err
+	}
+
+	p.subscriptionChanged(instanceID, sub.ID, sub)
+	return nil
+}
+
+// updateStoredSubscription changes a stored subscription atomically. f is
+// called with the stored subscription, again if it is changed concurrently,
+// and returns the subscription to store. The channel index is updated if the
+// channel or name of the subscription changed, and the subscription is
+// restored if its new name is taken in the channel.
+func (p *Plugin) updateStoredSubscription(instanceID types.ID, subscriptionID string, f func(old *ChannelSubscription) (*ChannelSubscription, error)) error {
+	var old, sub *ChannelSubscription
+	var oldData, data []byte
+	var updateErr error
+	key := subscriptionKey(instanceID, subscriptionID)
+	err := p.client.KV.SetAtomicWithRetries(key, func(initialBytes []byte) (interface{}, error) {
+		if len(initialBytes) == 0 {
+			updateErr = errors.New("could not find subscription")
+			return nil, updateErr
+		}
+		old = &ChannelSubscription{}
+		if err := json.Unmarshal(initialBytes, old); err != nil {
+			updateErr = errors.WithMessagef(err, "failed to parse subscription %s", subscriptionID)
+			return nil, updateErr
+		}
+		old.InstanceID = instanceID
+
+		current := *old
+		sub, updateErr = f(&current)
+		if updateErr != nil {
+			return nil, updateErr
+		}
+		oldData = initialBytes
+		data, updateErr = json.Marshal(sub)
+		if updateErr != nil {
+			return nil, updateErr
+		}
+		return data, nil
+	})
+	if updateErr != nil {
+		return updateErr
+	}
+	if err != nil {
+		return errors.WithMessage(err, "failed to store the subscription")
+	}
+
+	if old.ChannelID != sub.ChannelID || old.Name != sub.Name {
+		err = p.setSubscriptionChannelName(instanceID, sub.ChannelID, sub.ID, sub.Name)
+		if err != nil {
+			// Restore the subscription, unless it was changed since
+			if _, restoreErr := p.client.KV.Set(key, oldData, pluginapi.SetAtomic(data)); restoreErr != nil {
+				p.errorf("updateStoredSubscription: failed to restore subscription %s: %v", sub.ID, restoreErr)
+			}
+			return err
+		}
+	}
+	if old.ChannelID != sub.ChannelID {
+		err = p.removeSubscriptionChannelName(instanceID, old.ChannelID, sub.ID)
+		if err != nil {
+			return errors.WithMessage(err, "failed to update the channel subscription index")
+		}
+	}
+
+	p.subscriptionChanged(instanceID, sub.ID, sub)
+	return nil
+}
+
+func (p *Plugin) deleteSubscription(instanceID types.ID, sub *ChannelSubscription) error {
+	err := p.client.KV.Delete(subscriptionKey(instanceID, sub.ID))
+	if err != nil {
+		return errors.WithMessage(err, "failed to delete the subscription")
+	}
+
+	err = p.removeSubscriptionChannelName(instanceID, sub.ChannelID, sub.ID)
+	if err != nil {
+		return errors.WithMessage(err, "failed to update the channel subscription index")
+	}
+	err = p.updateSubscriptionIndex(instanceID, sub.ID, func(ids StringSet) StringSet {
+		return ids.Subtract(sub.ID)
+	})
+	if err != nil {
+		return err
+	}
+
+	p.subscriptionChanged(instanceID, sub.ID, nil)
+	return nil
+}
+
+// subscriptionChanged updates the cached subscriptions, and notifies the other
+// servers of the cluster.
+func (p *Plugin) subscriptionChanged(instanceID types.ID, subscriptionID string, sub *ChannelSubscription) {
+	p.updateCachedSubscription(instanceID, subscriptionID, sub)
+
+	data, err := json.Marshal(subscriptionChangedEvent{InstanceID: instanceID, SubscriptionID: subscriptionID})
+	if err != nil {
+		p.errorf("subscriptionChanged: failed to marshal the cluster event: %v", err)
+		return
+	}
+	err = p.client.Cluster.PublishPluginEvent(
+		model.PluginClusterEvent{Id: clusterEventSubscriptionChanged, Data: data},
+		model.PluginClusterEventSendOptions{SendType: model.PluginClusterEventSendTypeReliable},
+	)
+	if err != nil {
+		p.errorf("subscriptionChanged: failed to publish the cluster event: %v", err)
+	}
+}
+
+// OnPluginClusterEvent updates the cached subscriptions when a subscription,
+// or the subscription policy of a channel, is changed on another server of
+// the cluster.
+func (p *Plugin) OnPluginClusterEvent(c *plugin.Context, ev model.PluginClusterEvent) {
+	switch ev.Id {
+	case clusterEventSubscriptionPolicyChanged:
+		p.subscriptionPolicies.invalidate(string(ev.Data))
+		return
+	case clusterEventSubscriptionChanged:
+	default:
+		return
+	}
+
+	event := subscriptionChangedEvent{}
+	err := json.Unmarshal(ev.Data, &event)
+	if err != nil {
+		p.errorf("OnPluginClusterEvent: failed to parse the subscription event: %v", err)
+		return
+	}
+
+	sub, err := p.loadSubscription(event.InstanceID, event.SubscriptionID)
+	if err != nil {
+		// The subscriptions are reloaded on their next access
+		p.errorf("OnPluginClusterEvent: %v", err)
+		p.subscriptionCache.invalidate(event.InstanceID)
+		return
+	}
+	p.updateCachedSubscription(event.InstanceID, event.SubscriptionID, sub)
+}
+
+// updateCachedSubscription updates the cached subscriptions with a changed
+// subscription, or removes a deleted one, with its parsed template and JQL.
+func (p *Plugin) updateCachedSubscription(instanceID types.ID, subscriptionID string, sub *ChannelSubscription) {
+	p.subscriptionCache.update(instanceID, subscriptionID, sub)
+	if sub == nil {
+		p.subscriptionTemplates.Delete(subscriptionID)
+		p.subscriptionJQLs.Delete(subscriptionID)
+		p.subscriptionRegexps.Delete(subscriptionID)
+		p.subscriptionLocations.Delete(subscriptionID)
+	}
+}
+
+func (c *subscriptionCache) invalidate(instanceID types.ID) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.generation == nil {
+		c.generation = map[types.ID]int{}
+	}
+	c.generation[instanceID]++
+	delete(c.byInstance, instanceID)
+}
+
+// ensureSubscriptionStore migrates the subscriptions of an instance from the
+// single Subscriptions document, if they were not yet.
+func (p *Plugin) ensureSubscriptionStore(instanceID types.ID) error {
+	version := 0
+	err := p.client.KV.Get(subscriptionStoreKey(instanceID), &version)
+	if err != nil {
+		return errors.WithMessage(err, "failed to load the subscription store version")
+	}
+	if version >= subscriptionStoreVersion {
+		return nil
+	}
+
+	mutex, err := cluster.NewMutex(p.API, subscriptionStoreKey(instanceID))
+	if err != nil {
+		return errors.WithMessage(err, "failed to create the subscription migration mutex")
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// Another server may have migrated the subscriptions meanwhile
+	err = p.client.KV.Get(subscriptionStoreKey(instanceID), &version)
+	if err != nil {
+		return errors.WithMessage(err, "failed to load the subscription store version")
+	}
+	if version >= subscriptionStoreVersion {
+		return nil
+	}
+
+	var data []byte
+	err = p.client.KV.Get(keyWithInstanceID(instanceID, JiraSubscriptionsKey), &data)
+	if err != nil {
+		return errors.WithMessage(err, "failed to load the subscriptions to migrate")
+	}
+	legacy, err := SubscriptionsFromJSON(data, instanceID)
+	if err != nil {
+		return errors.WithMessage(err, "failed to parse the subscriptions to migrate")
+	}
+
+	shards := map[string]StringSet{}
+	channelNames := map[string]map[string]string{}
+	for id, sub := range legacy.Channel.ByID {
+		sub := sub
+		_, err = p.client.KV.Set(subscriptionKey(instanceID, id), &sub)
+		if err != nil {
+			return errors.WithMessagef(err, "failed to migrate subscription %s", id)
+		}
+
+		if channelNames[sub.ChannelID] == nil {
+			channelNames[sub.ChannelID] = map[string]string{}
+		}
+		channelNames[sub.ChannelID][id] = sub.Name
+		shard := subscriptionIndexShard(id)
+		if shards[shard] == nil {
+			shards[shard] = NewStringSet()
+		}
+		shards[shard][id] = true
+	}
+	for shard, ids := range shards {
+		_, err = p.client.KV.Set(subscriptionIndexKey(instanceID, subscriptionIndexAll, shard), ids)
+		if err != nil {
+			return errors.WithMessage(err, "failed to migrate the subscription index")
+		}
+	}
+	for channelID, names := range channelNames {
+		_, err = p.client.KV.Set(subscriptionIndexKey(instanceID, subscriptionIndexChannel, channelID), names)
+		if err != nil {
+			return errors.WithMessage(err, "failed to migrate the channel subscription index")
+		}
+	}
+
+	_, err = p.client.KV.Set(subscriptionStoreKey(instanceID), subscriptionStoreVersion)
+	if err != nil {
+		return errors.WithMessage(err, "failed to store the subscription store version")
+	}
+	if len(legacy.Channel.ByID) > 0 {
+		p.infof("Migrated %d subscriptions of %s to the sharded subscription store", len(legacy.Channel.ByID), instanceID)
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupSubscriptionStoreTest(t *testing.T, existing []ChannelSubscription) (*Plugin, *plugintest.API, testKVStore) {
+	p := &Plugin{}
+	p.updateConfig(func(conf *config) {})
+	api := &plugintest.API{}
+	p.SetAPI(api)
+	p.client = pluginapi.NewClient(api, p.Driver)
+	store := mockSubscriptionStore(t, api, withExistingChannelSubscriptions(existing))
+	return p, api, store
+}
+
+func storeTestSubscription(id, channelID, name, project string, events ...string) ChannelSubscription {
+	return ChannelSubscription{
+		ID:        id,
+		ChannelID: channelID,
+		Name:      name,
+		Filters: SubscriptionFilters{
+			Events:     NewStringSet(events...),
+			Projects:   NewStringSet(project),
+			IssueTypes: NewStringSet("10001"),
+		},
+	}
+}
+
+func TestSubscriptionStoreMigration(t *testing.T) {
+	p, _, store := setupSubscriptionStoreTest(t, []ChannelSubscription{
+		storeTestSubscription("sub1", "channel1", "bugs", "TES", eventCreated),
+		storeTestSubscription("sub2", "channel1", "stories", "TES", eventCreated, eventDeleted),
+		storeTestSubscription("sub3", "channel2", "bugs", "OTHER", eventDeleted),
+	})
+	legacy := store[testSubKey]
+
+	subs, err := p.getSubscriptions(testInstance1.InstanceID)
+	require.NoError(t, err)
+	assert.Len(t, subs.Channel.ByID, 3)
+	assert.Equal(t, NewStringSet("sub1", "sub2"), subs.Channel.IDByChannelID["channel1"])
+	assert.Equal(t, NewStringSet("sub2", "sub3"), subs.Channel.IDByEvent[eventDeleted])
+	assert.Equal(t, testInstance1.InstanceID, subs.Channel.ByID["sub3"].InstanceID)
+
+	ids, err := p.loadSubscriptionIDs(testInstance1.InstanceID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"sub1", "sub2", "sub3"}, ids)
+	shard, err := p.loadSubscriptionIndex(testInstance1.InstanceID, subscriptionIndexAll, subscriptionIndexShard("sub1"))
+	require.NoError(t, err)
+	assert.True(t, shard["sub1"])
+	names, err := p.loadSubscriptionChannelIndex(testInstance1.InstanceID, "channel1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"sub1": "bugs", "sub2": "stories"}, names)
+
+	// The document is kept, but not migrated again
+	assert.Equal(t, legacy, store[testSubKey])
+	store[testSubKey], err = json.Marshal(withExistingChannelSubscriptions(nil))
+	require.NoError(t, err)
+	subs, err = p.loadSubscriptions(testInstance1.InstanceID)
+	require.NoError(t, err)
+	assert.Len(t, subs.Channel.ByID, 3)
+}
+
+func TestSubscriptionStoreChanges(t *testing.T) {
+	p, api, _ := setupSubscriptionStoreTest(t, []ChannelSubscription{
+		storeTestSubscription("sub1", "channel1", "bugs", "TES", eventCreated),
+	})
+	client := testClient{}
+
+	// The cache is loaded, and updated with the changes
+	_, err := p.getSubscriptions(testInstance1.InstanceID)
+	require.NoError(t, err)
+
+	added := storeTestSubscription("", "channel1", "stories", "TES", eventCreated, eventDeleted)
+	require.NoError(t, p.addChannelSubscription(testInstance1.InstanceID, &added, client))
+	require.NotEmpty(t, added.ID)
+
+	duplicate := storeTestSubscription("", "channel1", "bugs", "TES", eventCreated)
+	err = p.addChannelSubscription(testInstance1.InstanceID, &duplicate, client)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Subscription name, 'bugs', already exists")
+
+	edited := storeTestSubscription("sub1", "channel1", "all bugs", "OTHER", eventDeleted)
+	require.NoError(t, p.editChannelSubscription(testInstance1.InstanceID, &edited, client))
+
+	for name, subs := range map[string]func() *Subscriptions{
+		"cache": func() *Subscriptions {
+			subs, err := p.getSubscriptions(testInstance1.InstanceID)
+			require.NoError(t, err)
+			return subs
+		},
+		"KV store": func() *Subscriptions {
+			return loadTestSubscriptions(t, api)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			subs := subs()
+			assert.Len(t, subs.Channel.ByID, 2)
+			assert.Equal(t, "all bugs", subs.Channel.ByID["sub1"].Name)
+			assert.Equal(t, NewStringSet("sub1", added.ID), subs.Channel.IDByChannelID["channel1"])
+			assert.Equal(t, NewStringSet(added.ID), subs.Channel.IDByEvent[eventCreated])
+			assert.Equal(t, NewStringSet("sub1", added.ID), subs.Channel.IDByEvent[eventDeleted])
+		})
+	}
+
+	ids, err := p.loadSubscriptionIDs(testInstance1.InstanceID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"sub1", added.ID}, ids)
+
+	// The name of the edited subscription can be reused
+	reused := storeTestSubscription("", "channel1", "bugs", "TES", eventCreated)
+	require.NoError(t, p.addChannelSubscription(testInstance1.InstanceID, &reused, client))
+
+	require.NoError(t, p.removeChannelSubscription(testInstance1.InstanceID, "sub1"))
+	require.Error(t, p.removeChannelSubscription(testInstance1.InstanceID, "sub1"))
+
+	subs, err := p.getSubscriptions(testInstance1.InstanceID)
+	require.NoError(t, err)
+	assert.Equal(t, NewStringSet(added.ID, reused.ID), subs.Channel.IDByChannelID["channel1"])
+	assert.Equal(t, subs.Channel.ByID, loadTestSubscriptions(t, api).Channel.ByID)
+	ids, err = p.loadSubscriptionIDs(testInstance1.InstanceID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{added.ID, reused.ID}, ids)
+}
+
+func TestUpdateStoredSubscription(t *testing.T) {
+	p, api, store := setupSubscriptionStoreTest(t, []ChannelSubscription{
+		storeTestSubscription("sub1", "channel1", "bugs", "TES", eventCreated),
+		storeTestSubscription("sub2", "channel1", "stories", "TES", eventCreated),
+	})
+	_, err := p.getSubscriptions(testInstance1.InstanceID)
+	require.NoError(t, err)
+
+	// The subscription is snoozed while it is muted, the update is retried
+	// with the snoozed subscription.
+	calls := 0
+	err = p.updateStoredSubscription(testInstance1.InstanceID, "sub1", func(sub *ChannelSubscription) (*ChannelSubscription, error) {
+		calls++
+		if calls == 1 {
+			snoozed := *sub
+			snoozed.SnoozedUntil = 1000
+			data, marshalErr := json.Marshal(&snoozed)
+			require.NoError(t, marshalErr)
+			store[subscriptionKey(testInstance1.InstanceID, "sub1")] = data
+		}
+		sub.Enabled = model.NewBool(false)
+		return sub, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, calls)
+	stored := loadTestSubscriptions(t, api).Channel.ByID["sub1"]
+	assert.Equal(t, int64(1000), stored.SnoozedUntil)
+	assert.False(t, stored.isEnabled())
+
+	// The channel index is updated with the name
+	err = p.updateStoredSubscription(testInstance1.InstanceID, "sub1", func(sub *ChannelSubscription) (*ChannelSubscription, error) {
+		sub.ChannelID = "channel2"
+		sub.Name = "stories"
+		return sub, nil
+	})
+	require.NoError(t, err)
+	names, err := p.loadSubscriptionChannelIndex(testInstance1.InstanceID, "channel1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"sub2": "stories"}, names)
+	names, err = p.loadSubscriptionChannelIndex(testInstance1.InstanceID, "channel2")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"sub1": "stories"}, names)
+
+	err = p.updateStoredSubscription(testInstance1.InstanceID, "sub2", func(sub *ChannelSubscription) (*ChannelSubscription, error) {
+		sub.ChannelID = "channel2"
+		return sub, nil
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Subscription name, 'stories', already exists")
+	assert.Equal(t, "channel1", loadTestSubscriptions(t, api).Channel.ByID["sub2"].ChannelID)
+
+	err = p.updateStoredSubscription(testInstance1.InstanceID, "unknown", func(sub *ChannelSubscription) (*ChannelSubscription, error) {
+		return sub, nil
+	})
+	assert.EqualError(t, err, "could not find subscription")
+
+	subs, err := p.getSubscriptions(testInstance1.InstanceID)
+	require.NoError(t, err)
+	assert.Equal(t, "channel2", subs.Channel.ByID["sub1"].ChannelID)
+	assert.Equal(t, NewStringSet("sub1"), subs.Channel.IDByChannelID["channel2"])
+}
+
+func TestForEachConcurrently(t *testing.T) {
+	results := make([]int, 20)
+	err := forEachConcurrently(len(results), 3, func(i int) error {
+		results[i] = i * i
+		return nil
+	})
+	require.NoError(t, err)
+	for i, result := range results {
+		assert.Equal(t, i*i, result)
+	}
+
+	err = forEachConcurrently(5, 2, func(i int) error {
+		if i == 3 {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	assert.EqualError(t, err, "failed")
+}
+
+func TestSubscriptionStoreClusterEvent(t *testing.T) {
+	p, api, _ := setupSubscriptionStoreTest(t, []ChannelSubscription{
+		storeTestSubscription("sub1", "channel1", "bugs", "TES", eventCreated),
+	})
+	subs, err := p.getSubscriptions(testInstance1.InstanceID)
+	require.NoError(t, err)
+	require.Len(t, subs.Channel.ByID, 1)
+
+	// Another server adds a subscription
+	other := &Plugin{}
+	other.updateConfig(func(conf *config) {})
+	other.SetAPI(api)
+	other.client = pluginapi.NewClient(api, other.Driver)
+	added := storeTestSubscription("", "channel2", "stories", "TES", eventDeleted)
+	require.NoError(t, other.addChannelSubscription(testInstance1.InstanceID, &added, testClient{}))
+
+	subs, err = p.getSubscriptions(testInstance1.InstanceID)
+	require.NoError(t, err)
+	assert.Len(t, subs.Channel.ByID, 1)
+
+	data, err := json.Marshal(subscriptionChangedEvent{InstanceID: testInstance1.InstanceID, SubscriptionID: added.ID})
+	require.NoError(t, err)
+	p.OnPluginClusterEvent(nil, model.PluginClusterEvent{Id: clusterEventSubscriptionChanged, Data: data})
+
+	updated, err := p.getSubscriptions(testInstance1.InstanceID)
+	require.NoError(t, err)
+	assert.Len(t, updated.Channel.ByID, 2)
+	assert.Equal(t, NewStringSet(added.ID), updated.Channel.IDByChannelID["channel2"])
+	// The subscriptions returned before are not modified
+	assert.Len(t, subs.Channel.ByID, 1)
+
+	// Then removes it
+	require.NoError(t, other.removeChannelSubscription(testInstance1.InstanceID, added.ID))
+	p.OnPluginClusterEvent(nil, model.PluginClusterEvent{Id: clusterEventSubscriptionChanged, Data: data})
+	updated, err = p.getSubscriptions(testInstance1.InstanceID)
+	require.NoError(t, err)
+	assert.Len(t, updated.Channel.ByID, 1)
+	assert.Empty(t, updated.Channel.IDByChannelID["channel2"])
+}
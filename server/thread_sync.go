@@ -0,0 +1,437 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/utils/types"
+)
+
+const (
+	prefixThreadLink    = "thread_link_"
+	prefixLinkedThreads = "linked_threads_"
+	prefixSyncedComment = "synced_comment_"
+)
+
+// threadLink binds a Mattermost thread to a Jira issue: replies in the thread
+// are added as comments to the issue, and the comments of the issue are
+// posted as replies in the thread.
+type threadLink struct {
+	InstanceID types.ID `json:"instance_id"`
+	IssueKey   string   `json:"issue_key"`
+	ChannelID  string   `json:"channel_id"`
+	RootID     string   `json:"root_id"`
+	LinkedBy   string   `json:"linked_by"`
+}
+
+// syncedComment is the Mattermost post of a Jira comment in a linked thread.
+type syncedComment struct {
+	PostID string `json:"post_id"`
+	// FromMattermost is set if the comment was added from the post, rather
+	// than the post being created from the comment.
+	FromMattermost bool `json:"from_mattermost,omitempty"`
+}
+
+// syncedComments maps the root IDs of the linked threads to the post of the comment.
+type syncedComments map[string]syncedComment
+
+var permalinkPostIDRegex = regexp.MustCompile(`/pl/([a-z0-9]{26})`)
+
+func executeIssueLinkThread(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	user, instance, args, err := p.loadFlagUserInstance(header.UserId, args)
+	if err != nil {
+		return p.responsef(header, "Failed to load your connection to Jira. Error: %v.", err)
+	}
+	if len(args) != 1 {
+		return p.responsef(header, "Please specify an issue key in the form `/jira issue link-thread <issue-key>`.")
+	}
+	issueKey := strings.ToUpper(args[0])
+
+	conn, err := p.userStore.LoadConnection(instance.GetID(), user.MattermostUserID)
+	if err != nil {
+		return p.responsef(header, "Your username is not connected to Jira. Please type `jira connect`.")
+	}
+	client, err := instance.GetClient(conn)
+	if err != nil {
+		return p.responsef(header, "Failed to get a Jira client. Error: %v.", err)
+	}
+	issue, err := client.GetIssue(issueKey, nil)
+	if err != nil {
+		return p.responsef(header, "We couldn't find the issue key `%s`, or you do not have the appropriate permissions to view the issue.", issueKey)
+	}
+
+	rootID := header.RootId
+	if rootID != "" {
+		if link, _ := p.loadThreadLink(rootID); link != nil {
+			return p.responsef(header, "This thread is already linked to %s. Please type `/jira issue unlink-thread` first.", link.IssueKey)
+		}
+	}
+
+	linkedBy := conn.DisplayName
+	if mattermostUser, userErr := p.client.User.Get(header.UserId); userErr == nil {
+		linkedBy = "@" + mattermostUser.Username
+	}
+	post := &model.Post{
+		UserId:    p.getUserID(),
+		ChannelId: header.ChannelId,
+		RootId:    rootID,
+		Message: fmt.Sprintf("%s linked this thread to [%s](%s/browse/%s): %s\n"+
+			"Replies in this thread are added as comments to the issue, and comments on the issue are posted here.",
+			linkedBy, issue.Key, instance.GetJiraBaseURL(), issue.Key, issue.Fields.Summary),
+	}
+	err = p.client.Post.CreatePost(post)
+	if err != nil {
+		return p.responsef(header, "Failed to link the thread. Error: %v.", err)
+	}
+	if rootID == "" {
+		rootID = post.Id
+	}
+
+	err = p.linkThread(&threadLink{
+		InstanceID: instance.GetID(),
+		IssueKey:   issue.Key,
+		ChannelID:  header.ChannelId,
+		RootID:     rootID,
+		LinkedBy:   header.UserId,
+	})
+	if err != nil {
+		_ = p.client.Post.DeletePost(post.Id)
+		return p.responsef(header, "Failed to link the thread. Error: %v.", err)
+	}
+
+	return &model.CommandResponse{}
+}
+
+func executeIssueUnlinkThread(p *Plugin, c *plugin.Context, header *model.CommandArgs, args ...string) *model.CommandResponse {
+	if header.RootId == "" {
+		return p.responsef(header, "Please run `/jira issue unlink-thread` in the thread to unlink.")
+	}
+
+	link, err := p.loadThreadLink(header.RootId)
+	if err != nil {
+		return p.responsef(header, "Failed to unlink the thread. Error: %v.", err)
+	}
+	if link == nil {
+		return p.responsef(header, "This thread is not linked to a Jira issue.")
+	}
+	if link.LinkedBy != header.UserId {
+		if err = p.hasPermissionToManageChannel(header.UserId, link.ChannelID); err != nil {
+			return p.responsef(header, "Only the user who linked this thread, or a channel admin, can unlink it.")
+		}
+	}
+
+	link, err = p.unlinkThread(header.RootId)
+	if err != nil {
+		return p.responsef(header, "Failed to unlink the thread. Error: %v.", err)
+	}
+	if link == nil {
+		return p.responsef(header, "This thread is not linked to a Jira issue.")
+	}
+
+	return p.responsef(header, "This thread is no longer linked to %s.", link.IssueKey)
+}
+
+// loadThreadLink returns the link of the thread, or nil if the thread is not linked.
+func (p *Plugin) loadThreadLink(rootID string) (*threadLink, error) {
+	var link *threadLink
+	err := p.client.KV.Get(prefixThreadLink+rootID, &link)
+	if err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+func (p *Plugin) linkThread(link *threadLink) error {
+	saved, err := p.client.KV.Set(prefixThreadLink+link.RootID, link, pluginapi.SetAtomic(nil))
+	if err != nil {
+		return err
+	}
+	if !saved {
+		return errors.New("the thread is already linked")
+	}
+
+	return p.updateLinkedThreads(link.InstanceID, link.IssueKey, func(rootIDs StringSet) StringSet {
+		return rootIDs.Add(link.RootID)
+	})
+}
+
+// unlinkThread removes the link of the thread, and returns it. It returns nil
+// if the thread was not linked.
+func (p *Plugin) unlinkThread(rootID string) (*threadLink, error) {
+	link, err := p.loadThreadLink(rootID)
+	if err != nil || link == nil {
+		return nil, err
+	}
+
+	err = p.client.KV.Delete(prefixThreadLink + rootID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.updateLinkedThreads(link.InstanceID, link.IssueKey, func(rootIDs StringSet) StringSet {
+		return rootIDs.Subtract(rootID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// unlinkIssueThreads removes the links of all the threads of an issue.
+func (p *Plugin) unlinkIssueThreads(instanceID types.ID, issueKey string) error {
+	rootIDs, err := p.loadLinkedThreads(instanceID, issueKey)
+	if err != nil {
+		return err
+	}
+	for _, rootID := range rootIDs.Elems() {
+		err = p.client.KV.Delete(prefixThreadLink + rootID)
+		if err != nil {
+			return err
+		}
+	}
+	return p.client.KV.Delete(linkedThreadsKey(instanceID, issueKey))
+}
+
+func (p *Plugin) loadLinkedThreads(instanceID types.ID, issueKey string) (StringSet, error) {
+	rootIDs := NewStringSet()
+	err := p.client.KV.Get(linkedThreadsKey(instanceID, issueKey), &rootIDs)
+	if err != nil {
+		return nil, err
+	}
+	return rootIDs, nil
+}
+
+func (p *Plugin) updateLinkedThreads(instanceID types.ID, issueKey string, update func(rootIDs StringSet) StringSet) error {
+	return p.client.KV.SetAtomicWithRetries(linkedThreadsKey(instanceID, issueKey), func(initialBytes []byte) (interface{}, error) {
+		rootIDs := NewStringSet()
+		if len(initialBytes) != 0 {
+			err := json.Unmarshal(initialBytes, &rootIDs)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return update(rootIDs), nil
+	})
+}
+
+func linkedThreadsKey(instanceID types.ID, issueKey string) string {
+	return hashkey(prefixLinkedThreads, fmt.Sprintf("%s/%s", instanceID, issueKey))
+}
+
+func (p *Plugin) loadSyncedComments(instanceID types.ID, commentID string) (syncedComments, error) {
+	comments := syncedComments{}
+	err := p.client.KV.Get(syncedCommentKey(instanceID, commentID), &comments)
+	if err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+func (p *Plugin) updateSyncedComments(instanceID types.ID, commentID string, update func(comments syncedComments) syncedComments) error {
+	return p.client.KV.SetAtomicWithRetries(syncedCommentKey(instanceID, commentID), func(initialBytes []byte) (interface{}, error) {
+		comments := syncedComments{}
+		if len(initialBytes) != 0 {
+			err := json.Unmarshal(initialBytes, &comments)
+			if err != nil {
+				return nil, err
+			}
+		}
+		comments = update(comments)
+		if len(comments) == 0 {
+			return nil, nil
+		}
+		return comments, nil
+	})
+}
+
+func syncedCommentKey(instanceID types.ID, commentID string) string {
+	return hashkey(prefixSyncedComment, fmt.Sprintf("%s/%s", instanceID, commentID))
+}
+
+// isPluginPost returns true for the posts created by this, or any other,
+// plugin or integration. They are never synchronized to Jira, so that the
+// comments posted from Jira do not echo back.
+func (p *Plugin) isPluginPost(post *model.Post) bool {
+	return post.UserId == p.getUserID() ||
+		post.IsSystemMessage() ||
+		post.GetProp("from_plugin") != nil ||
+		post.GetProp("from_bot") != nil ||
+		post.GetProp("from_webhook") != nil
+}
+
+// syncPostToIssue adds a reply in a linked thread as a comment to the issue,
+// as the connected Jira user who posted it.
+func (p *Plugin) syncPostToIssue(post *model.Post) {
+	if post.RootId == "" || p.isPluginPost(post) {
+		return
+	}
+
+	link, err := p.loadThreadLink(post.RootId)
+	if err != nil {
+		p.errorf("syncPostToIssue: failed to load the link of thread %s: %v", post.RootId, err)
+		return
+	}
+	if link == nil {
+		return
+	}
+
+	notify := func(format string, args ...interface{}) {
+		p.client.Post.SendEphemeralPost(post.UserId, &model.Post{
+			UserId:    p.getUserID(),
+			ChannelId: post.ChannelId,
+			RootId:    post.RootId,
+			Message:   fmt.Sprintf(format, args...),
+		})
+	}
+
+	client, instance, _, err := p.getClient(link.InstanceID, types.ID(post.UserId))
+	if err != nil {
+		notify("Your reply was not added to %s because your account is not connected to Jira. Please type `/jira connect`.", link.IssueKey)
+		return
+	}
+
+	permalink := getPermaLink(instance, post.Id, "_redirect")
+	comment := p.newMarkdownComment(client, link.InstanceID, fmt.Sprintf("%s\n\n_(posted from [Mattermost](%s))_", post.Message, permalink))
+	added, err := comment.add(client, link.IssueKey)
+	if err != nil {
+		p.errorf("syncPostToIssue: failed to add a comment to %s: %v", link.IssueKey, err)
+		notify("Your reply could not be added to %s. Error: %v.", link.IssueKey, err)
+		return
+	}
+
+	err = p.updateSyncedComments(link.InstanceID, added.ID, func(comments syncedComments) syncedComments {
+		comments[link.RootID] = syncedComment{PostID: post.Id, FromMattermost: true}
+		return comments
+	})
+	if err != nil {
+		p.errorf("syncPostToIssue: failed to store comment %s of %s: %v", added.ID, link.IssueKey, err)
+	}
+}
+
+// syncCommentToThreads posts the created, edited or deleted Jira comment of the
+// webhook event to the threads linked to the issue.
+func (p *Plugin) syncCommentToThreads(wh *webhook, instanceID types.ID) error {
+	events := wh.Events()
+	if !events.ContainsAny(eventCreatedComment, eventUpdatedComment, eventDeletedComment) ||
+		wh.Issue.Key == "" || wh.Comment.ID == "" {
+		return nil
+	}
+
+	rootIDs, err := p.loadLinkedThreads(instanceID, wh.Issue.Key)
+	if err != nil {
+		return err
+	}
+	if rootIDs.Len() == 0 {
+		return nil
+	}
+
+	comments, err := p.loadSyncedComments(instanceID, wh.Comment.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, rootID := range rootIDs.Elems() {
+		link, err := p.loadThreadLink(rootID)
+		if err != nil {
+			return err
+		}
+		if link == nil {
+			continue
+		}
+
+		synced, isSynced := comments[rootID]
+		switch {
+		case events.ContainsAny(eventCreatedComment):
+			if isSynced || p.isEchoedComment(wh.Comment.Body, rootID) {
+				continue
+			}
+			if !p.markWebhookSeen(instanceID, wh, "thread/"+rootID) {
+				continue
+			}
+			post, err := wh.makeChannelPost(p, instanceID, link.ChannelID, p.getUserID(), "")
+			if err != nil {
+				return err
+			}
+			post.RootId = rootID
+			err = p.client.Post.CreatePost(post)
+			if err != nil {
+				p.unmarkWebhookSeen(instanceID, wh, "thread/"+rootID)
+				return err
+			}
+			err = p.updateSyncedComments(instanceID, wh.Comment.ID, func(comments syncedComments) syncedComments {
+				comments[rootID] = syncedComment{PostID: post.Id}
+				return comments
+			})
+			if err != nil {
+				return err
+			}
+
+		case events.ContainsAny(eventUpdatedComment):
+			// The posts of the comments added from Mattermost belong to their authors
+			if !isSynced || synced.FromMattermost {
+				continue
+			}
+			updated, err := wh.makeChannelPost(p, instanceID, link.ChannelID, p.getUserID(), "")
+			if err != nil {
+				return err
+			}
+			post, err := p.client.Post.GetPost(synced.PostID)
+			if err != nil {
+				p.debugf("syncCommentToThreads: failed to load the post of comment %s: %v", wh.Comment.ID, err)
+				continue
+			}
+			post.Message = updated.Message
+			post.SetProps(updated.GetProps())
+			err = p.client.Post.UpdatePost(post)
+			if err != nil {
+				return err
+			}
+
+		case events.ContainsAny(eventDeletedComment):
+			if !isSynced {
+				continue
+			}
+			if !synced.FromMattermost {
+				err = p.client.Post.DeletePost(synced.PostID)
+				if err != nil {
+					p.debugf("syncCommentToThreads: failed to delete the post of comment %s: %v", wh.Comment.ID, err)
+				}
+			}
+			err = p.updateSyncedComments(instanceID, wh.Comment.ID, func(comments syncedComments) syncedComments {
+				delete(comments, rootID)
+				return comments
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// isEchoedComment returns true if the comment was added from a post of the
+// thread, and the webhook event arrived before the comment was recorded.
+func (p *Plugin) isEchoedComment(body, rootID string) bool {
+	for _, match := range permalinkPostIDRegex.FindAllStringSubmatch(body, -1) {
+		post, err := p.client.Post.GetPost(match[1])
+		if err != nil {
+			continue
+		}
+		if post.RootId == rootID && !p.isPluginPost(post) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest/mock"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testLinkedRootID = "linkedrootidaaaaaaaaaaaaaa"
+	testUserPostID   = "userpostidaaaaaaaaaaaaaaaa"
+)
+
+func setupTestThreadSync(t *testing.T) (*Plugin, *plugintest.API, map[string]*model.Post) {
+	p := &Plugin{}
+	p.updateConfig(func(conf *config) {
+		conf.botUserID = "bot"
+	})
+	api := &plugintest.API{}
+	api.On("LogDebug", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe()
+	makeTestKVStore(api, nil)
+
+	posts := map[string]*model.Post{
+		testUserPostID: {Id: testUserPostID, RootId: testLinkedRootID, UserId: "user"},
+	}
+	api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(func(post *model.Post) *model.Post {
+		created := post.Clone()
+		created.Id = model.NewId()
+		posts[created.Id] = created
+		return created
+	}, nil)
+	api.On("GetPost", mock.AnythingOfType("string")).Return(func(id string) *model.Post {
+		return posts[id]
+	}, func(id string) *model.AppError {
+		if posts[id] == nil {
+			return model.NewAppError("GetPost", "app.post.get.app_error", nil, "", 404)
+		}
+		return nil
+	})
+	api.On("UpdatePost", mock.AnythingOfType("*model.Post")).Return(func(post *model.Post) *model.Post {
+		posts[post.Id] = post.Clone()
+		return post.Clone()
+	}, nil)
+	api.On("DeletePost", mock.AnythingOfType("string")).Return(func(id string) *model.AppError {
+		delete(posts, id)
+		return nil
+	})
+	p.SetAPI(api)
+	p.client = pluginapi.NewClient(api, p.Driver)
+
+	require.NoError(t, p.linkThread(&threadLink{
+		InstanceID: testInstance1.InstanceID,
+		IssueKey:   "TES-41",
+		ChannelID:  "channel1",
+		RootID:     testLinkedRootID,
+		LinkedBy:   "user",
+	}))
+
+	return p, api, posts
+}
+
+func parseTestCommentWebhook(t *testing.T, filename string) *webhook {
+	data, err := getJiraTestData(filename)
+	require.NoError(t, err)
+	wh, err := ParseWebhook(data)
+	require.NoError(t, err)
+	v := wh.(*webhook)
+	v.Issue.Key = "TES-41"
+	v.Comment.ID = "10019"
+	return v
+}
+
+func repliesTo(posts map[string]*model.Post, rootID string) []*model.Post {
+	var replies []*model.Post
+	for _, post := range posts {
+		if post.RootId == rootID && post.UserId == "bot" {
+			replies = append(replies, post)
+		}
+	}
+	return replies
+}
+
+func TestSyncCommentToThreads(t *testing.T) {
+	t.Run("comments are posted, edited and deleted in the thread", func(t *testing.T) {
+		p, _, posts := setupTestThreadSync(t)
+
+		created := parseTestCommentWebhook(t, "webhook-cloud-comment-created.json")
+		require.NoError(t, p.syncCommentToThreads(created, testInstance1.InstanceID))
+		replies := repliesTo(posts, testLinkedRootID)
+		require.Len(t, replies, 1)
+		assert.Equal(t, "channel1", replies[0].ChannelId)
+
+		// Redelivery
+		require.NoError(t, p.syncCommentToThreads(created, testInstance1.InstanceID))
+		require.Len(t, repliesTo(posts, testLinkedRootID), 1)
+
+		updated := parseTestCommentWebhook(t, "webhook-cloud-comment-updated.json")
+		require.NoError(t, p.syncCommentToThreads(updated, testInstance1.InstanceID))
+		replies = repliesTo(posts, testLinkedRootID)
+		require.Len(t, replies, 1)
+		assert.Contains(t, replies[0].Attachments()[0].Text, "then edited it")
+
+		deleted := parseTestCommentWebhook(t, "webhook-cloud-comment-deleted.json")
+		require.NoError(t, p.syncCommentToThreads(deleted, testInstance1.InstanceID))
+		assert.Empty(t, repliesTo(posts, testLinkedRootID))
+	})
+
+	t.Run("comments added from the thread are not echoed", func(t *testing.T) {
+		p, _, posts := setupTestThreadSync(t)
+		require.NoError(t, p.updateSyncedComments(testInstance1.InstanceID, "10019", func(comments syncedComments) syncedComments {
+			comments[testLinkedRootID] = syncedComment{PostID: testUserPostID, FromMattermost: true}
+			return comments
+		}))
+
+		for _, filename := range []string{
+			"webhook-cloud-comment-created.json",
+			"webhook-cloud-comment-updated.json",
+			"webhook-cloud-comment-deleted.json",
+		} {
+			require.NoError(t, p.syncCommentToThreads(parseTestCommentWebhook(t, filename), testInstance1.InstanceID))
+		}
+		assert.Empty(t, repliesTo(posts, testLinkedRootID))
+		assert.NotNil(t, posts[testUserPostID], "the post of the author must not be deleted")
+	})
+
+	t.Run("comment not yet recorded is recognized by its permalink", func(t *testing.T) {
+		p, _, posts := setupTestThreadSync(t)
+
+		created := parseTestCommentWebhook(t, "webhook-cloud-comment-created.json")
+		created.Comment.Body = "Looks good\n\n_(posted from [Mattermost|https://mm.example.com/_redirect/pl/" + testUserPostID + "])_"
+		require.NoError(t, p.syncCommentToThreads(created, testInstance1.InstanceID))
+		assert.Empty(t, repliesTo(posts, testLinkedRootID))
+	})
+
+	t.Run("unlinked thread", func(t *testing.T) {
+		p, _, posts := setupTestThreadSync(t)
+		link, err := p.unlinkThread(testLinkedRootID)
+		require.NoError(t, err)
+		require.NotNil(t, link)
+		assert.Equal(t, "TES-41", link.IssueKey)
+
+		created := parseTestCommentWebhook(t, "webhook-cloud-comment-created.json")
+		require.NoError(t, p.syncCommentToThreads(created, testInstance1.InstanceID))
+		assert.Empty(t, repliesTo(posts, testLinkedRootID))
+
+		link, err = p.unlinkThread(testLinkedRootID)
+		require.NoError(t, err)
+		assert.Nil(t, link)
+	})
+}
+
+func TestExecuteIssueUnlinkThread(t *testing.T) {
+	for name, tc := range map[string]struct {
+		userID       string
+		channelAdmin bool
+		unlinked     bool
+	}{
+		"user who linked the thread": {userID: "user", unlinked: true},
+		"channel admin":              {userID: "admin", channelAdmin: true, unlinked: true},
+		"other channel member":       {userID: "member"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p, api, _ := setupTestThreadSync(t)
+			api.On("GetChannel", "channel1").Return(&model.Channel{Id: "channel1", Type: model.ChannelTypeOpen}, nil).Maybe()
+			api.On("HasPermissionToChannel", tc.userID, "channel1", model.PermissionManagePublicChannelProperties).Return(tc.channelAdmin).Maybe()
+			api.On("SendEphemeralPost", tc.userID, mock.AnythingOfType("*model.Post")).Return(&model.Post{})
+
+			executeIssueUnlinkThread(p, nil, &model.CommandArgs{UserId: tc.userID, ChannelId: "channel1", RootId: testLinkedRootID})
+
+			link, err := p.loadThreadLink(testLinkedRootID)
+			require.NoError(t, err)
+			assert.Equal(t, tc.unlinked, link == nil)
+		})
+	}
+}
+
+func TestSyncPostToIssueIgnoresPluginPosts(t *testing.T) {
+	p, api, _ := setupTestThreadSync(t)
+
+	for name, post := range map[string]*model.Post{
+		"root post":   {Id: model.NewId(), UserId: "user"},
+		"bot post":    {Id: model.NewId(), RootId: testLinkedRootID, UserId: "bot"},
+		"plugin post": {Id: model.NewId(), RootId: testLinkedRootID, UserId: "user", Props: model.StringInterface{"from_plugin": "true"}},
+		"system post": {Id: model.NewId(), RootId: testLinkedRootID, UserId: "user", Type: model.PostTypeJoinChannel},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p.syncPostToIssue(post)
+			api.AssertNotCalled(t, "SendEphemeralPost", mock.Anything, mock.Anything)
+		})
+	}
+}
@@ -53,6 +53,25 @@ func (c *Connection) JiraAccountID() types.ID {
 
 type ConnectionSettings struct {
 	Notifications bool `json:"notifications"`
+
+	// DeliveryMode is how notifications are delivered, one of the
+	// DeliveryMode* values. Empty means instant.
+	DeliveryMode string `json:"delivery_mode,omitempty"`
+	// DeliveryTime is the time of day of the daily and weekly digests, in the
+	// timezone of the user, formatted as HH:MM.
+	DeliveryTime string `json:"delivery_time,omitempty"`
+	// DeliveryDay is the day of the weekly digest, e.g. "monday".
+	DeliveryDay string `json:"delivery_day,omitempty"`
+
+	// QuietHoursStart and QuietHoursEnd bound the time of day, in the
+	// timezone of the user and formatted as HH:MM, when notifications are
+	// held. Empty means no quiet hours.
+	QuietHoursStart string `json:"quiet_hours_start,omitempty"`
+	QuietHoursEnd   string `json:"quiet_hours_end,omitempty"`
+
+	// NotificationCategories holds the notification categories that were
+	// turned on or off. Missing categories are on, except the opt-in ones.
+	NotificationCategories map[string]bool `json:"notification_categories,omitempty"`
 }
 
 func (s *ConnectionSettings) String() string {
@@ -60,7 +79,17 @@ func (s *ConnectionSettings) String() string {
 	if s != nil && s.Notifications {
 		notifications = "on"
 	}
-	return fmt.Sprintf("\tNotifications: %s", notifications)
+	str := fmt.Sprintf("\tNotifications: %s", notifications)
+	if s.isDigest() {
+		str += fmt.Sprintf("\n\tDelivery: %s", s.deliveryString())
+	}
+	if s.hasQuietHours() {
+		str += fmt.Sprintf("\n\tQuiet hours: %s to %s", s.QuietHoursStart, s.QuietHoursEnd)
+	}
+	if disabled := s.disabledNotificationCategories(); len(disabled) > 0 {
+		str += fmt.Sprintf("\n\tTurned off notifications: %s", strings.Join(disabled, ", "))
+	}
+	return str
 }
 
 func NewUser(mattermostUserID types.ID) *User {
@@ -198,17 +227,82 @@ func (p *Plugin) UpdateUserDefaults(mattermostUserID, instanceID types.ID, saved
 	)
 }
 
+type notificationCategorySetting struct {
+	notificationCategory
+	Enabled bool `json:"enabled"`
+}
+
 func (p *Plugin) httpGetSettingsInfo(w http.ResponseWriter, r *http.Request) (int, error) {
 	conf := p.getConfig()
 	return respondJSON(w, struct {
-		UIEnabled                              bool `json:"ui_enabled"`
-		SecurityLevelEmptyForJiraSubscriptions bool `json:"security_level_empty_for_jira_subscriptions"`
+		UIEnabled                              bool                          `json:"ui_enabled"`
+		SecurityLevelEmptyForJiraSubscriptions bool                          `json:"security_level_empty_for_jira_subscriptions"`
+		NotificationCategories                 []notificationCategorySetting `json:"notification_categories,omitempty"`
 	}{
 		UIEnabled:                              conf.EnableJiraUI,
 		SecurityLevelEmptyForJiraSubscriptions: conf.SecurityLevelEmptyForJiraSubscriptions,
+		NotificationCategories:                 p.getNotificationCategorySettings(types.ID(r.Header.Get("Mattermost-User-Id")), types.ID(r.FormValue("instance_id"))),
 	})
 }
 
+// httpSetNotificationCategories turns notification categories of the user on
+// or off, and returns all their notification categories.
+func (p *Plugin) httpSetNotificationCategories(w http.ResponseWriter, r *http.Request) (int, error) {
+	mattermostUserID := types.ID(r.Header.Get("Mattermost-User-Id"))
+	payload := struct {
+		InstanceID types.ID        `json:"instance_id"`
+		Categories map[string]bool `json:"categories"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		return respondErr(w, http.StatusBadRequest, errors.WithMessage(err, "failed to decode request"))
+	}
+
+	user, instance, err := p.LoadUserInstance(mattermostUserID, payload.InstanceID.String())
+	if err != nil {
+		return respondErr(w, http.StatusNotFound, errors.WithMessage(err, "failed to load the connected instance"))
+	}
+	connection, err := p.userStore.LoadConnection(instance.GetID(), user.MattermostUserID)
+	if err != nil {
+		return respondErr(w, http.StatusNotFound, errors.WithMessage(err, "failed to load the connection"))
+	}
+
+	for category := range payload.Categories {
+		if !isNotificationCategory(category) {
+			return respondErr(w, http.StatusBadRequest, errors.Errorf("%q is not a notification category", category))
+		}
+	}
+	err = p.setNotificationCategories(instance.GetID(), user.MattermostUserID, connection, payload.Categories)
+	if err != nil {
+		return respondErr(w, http.StatusInternalServerError, errors.WithMessage(err, "failed to store the notification categories"))
+	}
+
+	return respondJSON(w, p.getNotificationCategorySettings(user.MattermostUserID, instance.GetID()))
+}
+
+// getNotificationCategorySettings returns the notification categories of the
+// user for the instance, or their default instance. It returns nil if the user
+// is not connected.
+func (p *Plugin) getNotificationCategorySettings(mattermostUserID, instanceID types.ID) []notificationCategorySetting {
+	user, instance, err := p.LoadUserInstance(mattermostUserID, instanceID.String())
+	if err != nil {
+		return nil
+	}
+	connection, err := p.userStore.LoadConnection(instance.GetID(), user.MattermostUserID)
+	if err != nil {
+		return nil
+	}
+
+	settings := []notificationCategorySetting{}
+	for _, c := range notificationCategories {
+		settings = append(settings, notificationCategorySetting{
+			notificationCategory: c,
+			Enabled:              connection.Settings.isNotificationCategoryEnabled(c.ID),
+		})
+	}
+	return settings
+}
+
 func (p *Plugin) connectUser(instance Instance, mattermostUserID types.ID, connection *Connection) error {
 	user, err := p.userStore.LoadUser(mattermostUserID)
 	if err != nil {
@@ -95,9 +95,7 @@ func (p *Plugin) httpACUserInteractive(w http.ResponseWriter, r *http.Request, i
 			DisplayName: jUser.DisplayName,
 		},
 		// Set default settings the first time a user connects
-		Settings: &ConnectionSettings{
-			Notifications: true,
-		},
+		Settings: newConnectionSettings(),
 	}
 
 	secretCookie, err := r.Cookie(cookieSecretName)
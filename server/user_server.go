@@ -100,7 +100,7 @@ func (p *Plugin) httpOAuth1aComplete(w http.ResponseWriter, r *http.Request, ins
 	connection.User = *juser
 
 	// Set default settings the first time a user connects
-	connection.Settings = &ConnectionSettings{Notifications: true}
+	connection.Settings = newConnectionSettings()
 
 	err = p.connectUser(instance, types.ID(mattermostUserID), connection)
 	if err != nil {
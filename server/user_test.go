@@ -1,8 +1,10 @@
 package main
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	jira "github.com/andygrunwald/go-jira"
@@ -11,6 +13,7 @@ import (
 	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
 	"github.com/mattermost/mattermost/server/public/pluginapi"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestUserSettings_String(t *testing.T) {
@@ -66,6 +69,69 @@ func TestRouteUserStart(t *testing.T) {
 	}
 }
 
+func TestRouteSetNotificationCategories(t *testing.T) {
+	tests := map[string]struct {
+		userID     string
+		body       string
+		statusCode int
+		enabled    map[string]bool
+	}{
+		"categories are turned on and off": {
+			userID:     mockUserIDWithNotifications,
+			body:       `{"categories": {"watching": false, "status-changes": true}}`,
+			statusCode: http.StatusOK,
+			enabled: map[string]bool{
+				notificationCategoryAssigned:         true,
+				notificationCategoryReporterComments: false,
+				notificationCategoryStatusChanges:    true,
+				notificationCategoryWatching:         false,
+			},
+		},
+		"unknown category": {
+			userID:     mockUserIDWithNotifications,
+			body:       `{"categories": {"everything": false}}`,
+			statusCode: http.StatusBadRequest,
+		},
+		"user not connected": {
+			userID:     "non_connected_user",
+			body:       `{"categories": {"watching": false}}`,
+			statusCode: http.StatusNotFound,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			api := &plugintest.API{}
+			api.On("LogWarn", mockAnythingOfTypeBatch("string", 13)...).Return(nil).Maybe()
+			api.On("LogDebug", mockAnythingOfTypeBatch("string", 11)...).Return(nil).Maybe()
+
+			p := Plugin{}
+			p.initializeRouter()
+			p.SetAPI(api)
+			p.userStore = getMockUserStoreKV()
+			p.instanceStore = p.getMockInstanceStoreKV(1)
+
+			request := httptest.NewRequest(http.MethodPost, routeAPI+routeAPISettingsNotificationCategories, strings.NewReader(tc.body))
+			request.Header.Set("Mattermost-User-Id", tc.userID)
+			w := httptest.NewRecorder()
+			p.ServeHTTP(&plugin.Context{}, w, request)
+			require.Equal(t, tc.statusCode, w.Result().StatusCode)
+			if tc.enabled == nil {
+				return
+			}
+
+			settings := []notificationCategorySetting{}
+			require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&settings))
+			enabled := map[string]bool{}
+			for _, setting := range settings {
+				enabled[setting.ID] = setting.Enabled
+			}
+			for category, expected := range tc.enabled {
+				assert.Equal(t, expected, enabled[category], category)
+			}
+		})
+	}
+}
+
 func TestGetJiraUserFromMentions(t *testing.T) {
 	p := Plugin{}
 	p.userStore = getMockUserStoreKV()
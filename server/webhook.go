@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -21,6 +22,7 @@ const (
 	commentUpdated = "comment_updated"
 	commentCreated = "comment_created"
 	issueCreated   = "jira:issue_created"
+	issueDeleted   = "jira:issue_deleted"
 
 	worklogUpdated = "jira:worklog_updated"
 )
@@ -54,6 +56,8 @@ type webhookUserNotification struct {
 	message       string
 	postType      string
 	commentSelf   string
+	// category is the notification category the user may opt out of
+	category string
 }
 
 func (wh *webhook) Events() StringSet {
@@ -61,8 +65,22 @@ func (wh *webhook) Events() StringSet {
 }
 
 func (wh webhook) PostToChannel(p *Plugin, instanceID types.ID, channelID, fromUserID, subscriptionName string) (*model.Post, int, error) {
+	post, err := wh.makeChannelPost(p, instanceID, channelID, fromUserID, subscriptionName)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	err = p.client.Post.CreatePost(post)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return post, http.StatusOK, nil
+}
+
+func (wh webhook) makeChannelPost(p *Plugin, instanceID types.ID, channelID, fromUserID, subscriptionName string) (*model.Post, error) {
 	if wh.headline == "" {
-		return nil, http.StatusBadRequest, errors.Errorf("unsupported webhook")
+		return nil, errors.Errorf("unsupported webhook")
 	} else if p.getConfig().DisplaySubscriptionNameInNotifications && subscriptionName != "" {
 		wh.headline = fmt.Sprintf("%s\nSubscription: **%s**", wh.headline, subscriptionName)
 	}
@@ -81,7 +99,7 @@ func (wh webhook) PostToChannel(p *Plugin, instanceID types.ID, channelID, fromU
 		model.ParseSlackAttachment(post, []*model.SlackAttachment{
 			{
 				// TODO is this supposed to be themed?
-				Color:    "#95b7d0",
+				Color:    defaultAttachmentColor,
 				Fallback: wh.headline,
 				Pretext:  wh.headline,
 				Text:     text,
@@ -92,16 +110,11 @@ func (wh webhook) PostToChannel(p *Plugin, instanceID types.ID, channelID, fromU
 		post.Message = wh.headline
 	}
 
-	err := p.client.Post.CreatePost(post)
-	if err != nil {
-		return nil, http.StatusInternalServerError, err
-	}
-
-	return post, http.StatusOK, nil
+	return post, nil
 }
 
 func (wh *webhook) PostNotifications(p *Plugin, instanceID types.ID) ([]*model.Post, int, error) {
-	if len(wh.notifications) == 0 {
+	if len(wh.notifications) == 0 && wh.Events().Intersection(watcherEvents).Len() == 0 {
 		return nil, http.StatusOK, nil
 	}
 
@@ -112,8 +125,17 @@ func (wh *webhook) PostNotifications(p *Plugin, instanceID types.ID) ([]*model.P
 		return nil, http.StatusOK, nil
 	}
 
+	// The watchers come last, so that a watcher who is also mentioned or
+	// assigned gets the more specific notification.
+	notifications := append([]webhookUserNotification{}, wh.notifications...)
+	notifications = append(notifications, p.watcherNotifications(instance, wh)...)
+
 	posts := []*model.Post{}
-	for _, notification := range wh.notifications {
+	notified := map[types.ID]bool{}
+	// The users who turned off a specific notification of the event are not
+	// notified of it as watchers either.
+	turnedOff := map[types.ID]bool{}
+	for _, notification := range notifications {
 		var mattermostUserID types.ID
 		var err error
 
@@ -127,12 +149,23 @@ func (wh *webhook) PostNotifications(p *Plugin, instanceID types.ID) ([]*model.P
 			continue
 		}
 
+		// Send a single notification per user, for the first category they
+		// did not turn off.
+		if notified[mattermostUserID] || (notification.category == notificationCategoryWatching && turnedOff[mattermostUserID]) {
+			continue
+		}
+
 		// Check if the user has permissions.
 		c, err2 := p.userStore.LoadConnection(instance.GetID(), mattermostUserID)
 		if err2 != nil {
 			// Not connected to Jira, so can't check permissions
 			continue
 		}
+		if !c.Settings.isNotificationCategoryEnabled(notification.category) {
+			turnedOff[mattermostUserID] = true
+			continue
+		}
+		notified[mattermostUserID] = true
 		client, err2 := instance.GetClient(c)
 		if err2 != nil {
 			p.errorf("PostNotifications: error while getting jiraClient, err: %v", err2)
@@ -144,7 +177,7 @@ func (wh *webhook) PostNotifications(p *Plugin, instanceID types.ID) ([]*model.P
 		isCommentEvent := wh.Events().Intersection(commentEvents).Len() > 0
 		if isCommentEvent {
 			if instance.Common().IsCloudInstance() {
-				err = client.RESTGet(fmt.Sprintf("/2/issue/%s/comment/%s", wh.Issue.ID, wh.Comment.ID), nil, &struct{}{})
+				err = client.RESTGet(fmt.Sprintf("/3/issue/%s/comment/%s", wh.Issue.ID, wh.Comment.ID), nil, &struct{}{})
 			} else {
 				err = client.RESTGet(notification.commentSelf, nil, &struct{}{})
 			}
@@ -158,6 +191,26 @@ func (wh *webhook) PostNotifications(p *Plugin, instanceID types.ID) ([]*model.P
 
 		notification.message = p.replaceJiraAccountIds(instance.GetID(), notification.message)
 
+		if c.Settings.isDigest() {
+			if c.Settings.Notifications {
+				err = p.addToDigest(instance.GetID(), mattermostUserID, c.Settings, wh, notification.message)
+				if err != nil {
+					p.errorf("PostNotifications: failed to add notification to the digest, err: %v", err)
+				}
+			}
+			continue
+		}
+
+		if c.Settings != nil && c.Settings.Notifications && !p.bypassesQuietHours(wh) {
+			if until, quiet := p.quietUntil(mattermostUserID, c.Settings, time.Now()); quiet {
+				err = p.holdNotification(instance.GetID(), mattermostUserID, until, wh, notification.message)
+				if err != nil {
+					p.errorf("PostNotifications: failed to hold notification, err: %v", err)
+				}
+				continue
+			}
+		}
+
 		post, err := p.CreateBotDMPost(instance.GetID(), mattermostUserID, notification.message, notification.postType)
 		if err != nil {
 			p.errorf("PostNotifications: failed to create notification post, err: %v", err)
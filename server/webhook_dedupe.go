@@ -0,0 +1,76 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/utils/types"
+)
+
+const (
+	prefixWebhookSeen = "webhook_seen_"
+
+	// webhookDedupeTTL is how long a webhook event fingerprint is remembered.
+	// It must cover Jira's redelivery window, and the retries of the webhook queue.
+	webhookDedupeTTL = 24 * time.Hour
+
+	// Scope of the fingerprints for the user notifications, channel
+	// posts are scoped by the channel ID, and by the subscription ID if the
+	// event is posted once per subscription of the channel.
+	webhookDedupeScopeNotifications = "notifications"
+)
+
+// fingerprint identifies a Jira event, regardless of the webhook it was
+// delivered to. It returns an empty string if the payload does not carry
+// enough information to tell redeliveries apart from distinct events.
+func (jwh *JiraWebhook) fingerprint() string {
+	if jwh.Timestamp == 0 && jwh.ChangeLog.ID == "" && jwh.Comment.ID == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/%d/%s/%s/%s",
+		jwh.WebhookEvent, jwh.Timestamp, jwh.Issue.ID, jwh.ChangeLog.ID, jwh.Comment.ID)
+}
+
+// markWebhookSeen records the event fingerprint for the given scope, and
+// returns false if it was already recorded, i.e. the event is a duplicate.
+func (p *Plugin) markWebhookSeen(instanceID types.ID, wh *webhook, scope string) bool {
+	fingerprint := wh.fingerprint()
+	if fingerprint == "" {
+		return true
+	}
+
+	key := webhookSeenKey(instanceID, fingerprint, scope)
+	saved, err := p.client.KV.Set(key, []byte{1}, pluginapi.SetAtomic(nil), pluginapi.SetExpiry(webhookDedupeTTL))
+	if err != nil {
+		// Prefer a duplicate to a lost event
+		p.errorf("markWebhookSeen: failed to store webhook event fingerprint: %v", err)
+		return true
+	}
+	if !saved {
+		p.debugf("Dropping duplicate webhook event %s for %s", fingerprint, scope)
+	}
+	return saved
+}
+
+// unmarkWebhookSeen forgets the event fingerprint for the given scope, so that
+// a redelivery of an event that failed to be posted is not dropped.
+func (p *Plugin) unmarkWebhookSeen(instanceID types.ID, wh *webhook, scope string) {
+	fingerprint := wh.fingerprint()
+	if fingerprint == "" {
+		return
+	}
+
+	err := p.client.KV.Delete(webhookSeenKey(instanceID, fingerprint, scope))
+	if err != nil {
+		p.errorf("unmarkWebhookSeen: failed to delete webhook event fingerprint: %v", err)
+	}
+}
+
+func webhookSeenKey(instanceID types.ID, fingerprint, scope string) string {
+	return hashkey(prefixWebhookSeen, fmt.Sprintf("%s/%s/%s", instanceID, fingerprint, scope))
+}
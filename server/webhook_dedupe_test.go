@@ -0,0 +1,60 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest/mock"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookFingerprint(t *testing.T) {
+	data, err := getJiraTestData("webhook-issue-updated-labels.json")
+	require.NoError(t, err)
+	wh, err := ParseWebhook(data)
+	require.NoError(t, err)
+	assert.Equal(t, "jira:issue_updated/1550351530065/10040/10233/", wh.(*webhook).fingerprint())
+
+	assert.Equal(t, "", (&JiraWebhook{WebhookEvent: "jira:issue_updated"}).fingerprint())
+}
+
+func TestMarkWebhookSeen(t *testing.T) {
+	p := &Plugin{}
+	api := &plugintest.API{}
+	api.On("LogDebug", mock.Anything).Maybe()
+	kv := map[string][]byte{}
+	api.On("KVSetWithOptions", mock.AnythingOfType("string"), mock.Anything, mock.AnythingOfType("model.PluginKVSetOptions")).Return(
+		func(key string, value []byte, options model.PluginKVSetOptions) bool {
+			if value == nil {
+				delete(kv, key)
+				return true
+			}
+			if _, exists := kv[key]; exists && options.Atomic && options.OldValue == nil {
+				return false
+			}
+			kv[key] = value
+			return true
+		}, nil)
+	p.SetAPI(api)
+	p.client = pluginapi.NewClient(api, p.Driver)
+
+	data, err := getJiraTestData("webhook-issue-updated-labels.json")
+	require.NoError(t, err)
+	wh, err := ParseWebhook(data)
+	require.NoError(t, err)
+	v := wh.(*webhook)
+
+	assert.True(t, p.markWebhookSeen(testInstance1.InstanceID, v, "channel1"))
+	assert.False(t, p.markWebhookSeen(testInstance1.InstanceID, v, "channel1"), "redelivery must be dropped")
+	assert.True(t, p.markWebhookSeen(testInstance1.InstanceID, v, "channel2"), "other channels are not affected")
+	assert.True(t, p.markWebhookSeen(testInstance2.InstanceID, v, "channel1"), "other instances are not affected")
+
+	p.unmarkWebhookSeen(testInstance1.InstanceID, v, "channel1")
+	assert.True(t, p.markWebhookSeen(testInstance1.InstanceID, v, "channel1"))
+}
@@ -19,6 +19,7 @@ import (
 const (
 	PostTypeComment = "custom_jira_comment"
 	PostTypeMention = "custom_jira_mention"
+	PostTypeDigest  = "custom_jira_digest"
 )
 
 // The keys listed here can be used in the Jira webhook URL to control what events
@@ -103,9 +104,18 @@ func (p *Plugin) httpWebhook(w http.ResponseWriter, r *http.Request, instanceID
 		return http.StatusOK, nil
 	}
 
+	// Skip events already posted to the channel, e.g. by a subscription
+	v, isWebhook := wh.(*webhook)
+	if isWebhook && !p.markWebhookSeen(instanceID, v, channel.Id) {
+		return http.StatusOK, nil
+	}
+
 	// Post the event to the channel
 	_, statusCode, err := wh.PostToChannel(p, instanceID, channel.Id, p.getUserID(), "")
 	if err != nil {
+		if isWebhook {
+			p.unmarkWebhookSeen(instanceID, v, channel.Id)
+		}
 		return respondErr(w, statusCode, err)
 	}
 
@@ -10,15 +10,19 @@ import (
 	"github.com/andygrunwald/go-jira"
 	"github.com/pkg/errors"
 
+	"github.com/mattermost/mattermost-plugin-jira/server/markup"
+	"github.com/mattermost/mattermost-plugin-jira/server/utils/kvstore"
 	"github.com/mattermost/mattermost-plugin-jira/server/utils/types"
 )
 
 type JiraWebhook struct {
+	Timestamp    int64        `json:"timestamp,omitempty"`
 	WebhookEvent string       `json:"webhookEvent,omitempty"`
 	Issue        jira.Issue   `json:"issue,omitempty"`
 	User         jira.User    `json:"user,omitempty"`
 	Comment      jira.Comment `json:"comment,omitempty"`
 	ChangeLog    struct {
+		ID    string `json:"id,omitempty"`
 		Items []struct {
 			From       string
 			FromString string
@@ -35,6 +39,10 @@ type JiraWebhook struct {
 func (jwh *JiraWebhook) expandIssue(p *Plugin, instanceID types.ID) error {
 	instance, err := p.instanceStore.LoadInstance(instanceID)
 	if err != nil {
+		if errors.Cause(err) == kvstore.ErrNotFound {
+			// The instance was uninstalled
+			return permanentWebhookError(err)
+		}
 		return err
 	}
 
@@ -63,7 +71,7 @@ func (jwh *JiraWebhook) expandIssue(p *Plugin, instanceID types.ID) error {
 			if err != nil {
 				// User is not connected, so we try to fall back to JWT bot
 				if instance.JWTInstance == nil {
-					return errors.Wrap(err, "Cannot create subscription posts for this comment as the Jira comment author is not connected to Mattermost.")
+					return permanentWebhookError(errors.Wrap(err, "Cannot create subscription posts for this comment as the Jira comment author is not connected to Mattermost."))
 				}
 
 				// Fetch issue details with bot JWT bot
@@ -110,7 +118,7 @@ func (jwh *JiraWebhook) mdJiraLink(title, suffix string) string {
 }
 
 func (jwh *JiraWebhook) mdIssueDescription() string {
-	return truncate(jwh.Issue.Fields.Description, 3000)
+	return markup.TruncateMarkdown(issueDescriptionMarkdown(&jwh.Issue), 3000)
 }
 
 func (jwh *JiraWebhook) mdIssueSummary() string {
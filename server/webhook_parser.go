@@ -7,16 +7,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"regexp"
-	"strconv"
 	"strings"
 	"time"
 
+	jira "github.com/andygrunwald/go-jira"
 	"github.com/pkg/errors"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
 
 	"github.com/mattermost/mattermost/server/public/model"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/markup"
 )
 
 var errWebhookeventUnsupported = errors.New("Unsupported webhook event")
@@ -143,6 +144,7 @@ func parseWebhookChangeLog(jwh *JiraWebhook) Webhook {
 			event = parseWebhookResolved(jwh, to)
 		case field == statusField:
 			event = parseWebhookUpdatedField(jwh, eventUpdatedStatus, field, fieldID, fromWithDefault, toWithDefault)
+			appendNotificationsForStatusChange(event, fromWithDefault, toWithDefault)
 		case field == priorityField:
 			event = parseWebhookUpdatedField(jwh, eventUpdatedPriority, field, fieldID, fromWithDefault, toWithDefault)
 		case field == "summary":
@@ -247,7 +249,7 @@ func parseWebhookCommentCreated(jwh *JiraWebhook) (Webhook, error) {
 		JiraWebhook: jwh,
 		eventTypes:  NewStringSet(eventCreatedComment),
 		headline:    fmt.Sprintf("%s **commented** on %s", commentAuthor, jwh.mdKeySummaryLink()),
-		text:        truncate(quoteIssueComment(preProcessText(jwh.Comment.Body)), 3000),
+		text:        quoteIssueComment(markup.TruncateMarkdown(markup.WikiToMarkdown(jwh.Comment.Body), 3000)),
 	}
 
 	appendCommentNotifications(wh, "**mentioned** you in a new comment on")
@@ -260,8 +262,9 @@ func appendCommentNotifications(wh *webhook, verb string) {
 	jwh := wh.JiraWebhook
 	commentAuthor := mdUser(&jwh.Comment.UpdateAuthor)
 
+	comment := quoteIssueComment(markup.WikiToMarkdown(jwh.Comment.Body))
 	message := fmt.Sprintf("%s %s %s:\n%s",
-		commentAuthor, verb, jwh.mdKeySummaryLink(), quoteIssueComment(jwh.Comment.Body))
+		commentAuthor, verb, jwh.mdKeySummaryLink(), comment)
 	assigneeMentioned := false
 
 	for _, u := range parseJIRAUsernamesFromText(wh.Comment.Body) {
@@ -285,6 +288,7 @@ func appendCommentNotifications(wh *webhook, verb string) {
 			message:     message,
 			postType:    PostTypeMention,
 			commentSelf: jwh.Comment.Self,
+			category:    notificationCategoryMentioned,
 		}
 
 		if isAccountID {
@@ -299,18 +303,35 @@ func appendCommentNotifications(wh *webhook, verb string) {
 	// Don't send a notification to the assignee if they don't exist, or if are also the author.
 	// Also, if the assignee was mentioned above, avoid sending a duplicate notification here.
 	// Jira Server uses name field, Jira Cloud uses the AccountID field.
-	if assigneeMentioned || jwh.Issue.Fields.Assignee == nil ||
-		(jwh.Issue.Fields.Assignee.Name != "" && jwh.Issue.Fields.Assignee.Name == jwh.User.Name) ||
-		(jwh.Issue.Fields.Assignee.AccountID != "" && jwh.Issue.Fields.Assignee.AccountID == jwh.Comment.UpdateAuthor.AccountID) {
-		return
+	if !assigneeMentioned && jwh.Issue.Fields.Assignee != nil &&
+		(jwh.Issue.Fields.Assignee.Name == "" || jwh.Issue.Fields.Assignee.Name != jwh.User.Name) &&
+		(jwh.Issue.Fields.Assignee.AccountID == "" || jwh.Issue.Fields.Assignee.AccountID != jwh.Comment.UpdateAuthor.AccountID) {
+		wh.notifications = append(wh.notifications, webhookUserNotification{
+			jiraUsername:  jwh.Issue.Fields.Assignee.Name,
+			jiraAccountID: jwh.Issue.Fields.Assignee.AccountID,
+			message:       fmt.Sprintf("%s **commented** on %s:\n%s", commentAuthor, jwh.mdKeySummaryLink(), comment),
+			postType:      PostTypeComment,
+			commentSelf:   jwh.Comment.Self,
+			category:      notificationCategoryAssigneeComments,
+		})
 	}
 
+	// Same for the reporter. If the reporter is also the assignee or was
+	// mentioned, PostNotifications only sends them the first notification
+	// they opted in for.
+	reporter := jwh.Issue.Fields.Reporter
+	if reporter == nil ||
+		(reporter.Name != "" && reporter.Name == jwh.User.Name) ||
+		(reporter.AccountID != "" && reporter.AccountID == jwh.Comment.UpdateAuthor.AccountID) {
+		return
+	}
 	wh.notifications = append(wh.notifications, webhookUserNotification{
-		jiraUsername:  jwh.Issue.Fields.Assignee.Name,
-		jiraAccountID: jwh.Issue.Fields.Assignee.AccountID,
-		message:       fmt.Sprintf("%s **commented** on %s:\n>%s", commentAuthor, jwh.mdKeySummaryLink(), jwh.Comment.Body),
+		jiraUsername:  reporter.Name,
+		jiraAccountID: reporter.AccountID,
+		message:       fmt.Sprintf("%s **commented** on %s, which you reported:\n%s", commentAuthor, jwh.mdKeySummaryLink(), comment),
 		postType:      PostTypeComment,
 		commentSelf:   jwh.Comment.Self,
+		category:      notificationCategoryReporterComments,
 	})
 }
 
@@ -318,94 +339,6 @@ func quoteIssueComment(comment string) string {
 	return "> " + strings.ReplaceAll(comment, "\n", "\n> ")
 }
 
-// preProcessText processes the given string to apply various formatting transformations.
-// The purpose of the function is to convert the formatting provided by JIRA into the corresponding formatting supported by Mattermost.
-// This includes converting asterisks to bold, hyphens to strikethrough, JIRA-style headings to Markdown headings,
-// JIRA code blocks to inline code, numbered lists to Markdown lists, colored text to plain text, and JIRA links to Markdown links.
-// For more reference, please visit https://github.com/mattermost/mattermost-plugin-jira/issues/1096
-func preProcessText(jiraMarkdownString string) string {
-	asteriskRegex := regexp.MustCompile(`\*(\w+)\*`)
-	hyphenRegex := regexp.MustCompile(`-(\w+)-`)
-	headingRegex := regexp.MustCompile(`(?m)^(h[1-6]\.)\s+`)
-	langSpecificCodeBlockRegex := regexp.MustCompile(`\{code:[^}]+\}(.+?)\{code\}`)
-	numberedListRegex := regexp.MustCompile(`^#\s+`)
-	colouredTextRegex := regexp.MustCompile(`\{color:[^}]+\}(.*?)\{color\}`)
-	linkRegex := regexp.MustCompile(`\[(.*?)\|([^|\]]+)(?:\|([^|\]]+))?\]`)
-	quoteRegex := regexp.MustCompile(`\{quote\}(.*?)\{quote\}`)
-	codeBlockRegex := regexp.MustCompile(`\{\{(.+?)\}\}`)
-
-	// the below code converts lines starting with "#" into a numbered list. It increments the counter if consecutive lines are numbered,
-	// otherwise resets it to 1. The "#" is replaced with the corresponding number and period. Non-numbered lines are added unchanged.
-	var counter int
-	var lastLineWasNumberedList bool
-	var result []string
-	lines := strings.Split(jiraMarkdownString, "\n")
-	for _, line := range lines {
-		if numberedListRegex.MatchString(line) {
-			if !lastLineWasNumberedList {
-				counter = 1
-			} else {
-				counter++
-			}
-			line = strconv.Itoa(counter) + ". " + strings.TrimPrefix(line, "# ")
-			lastLineWasNumberedList = true
-		} else {
-			lastLineWasNumberedList = false
-		}
-		result = append(result, line)
-	}
-	processedString := strings.Join(result, "\n")
-
-	// the below code converts links in the format "[text|url]" or "[text|url|optional]" to Markdown links. If the text is empty,
-	// the URL is used for both the text and link. If the optional part is present, it's ignored. Unrecognized patterns remain unchanged.
-	processedString = linkRegex.ReplaceAllStringFunc(processedString, func(link string) string {
-		parts := linkRegex.FindStringSubmatch(link)
-		if len(parts) == 4 {
-			if parts[1] == "" {
-				return "[" + parts[2] + "](" + parts[2] + ")"
-			}
-			if parts[3] != "" {
-				return "[" + parts[1] + "](" + parts[2] + ")"
-			}
-			return "[" + parts[1] + "](" + parts[2] + ")"
-		}
-		return link
-	})
-
-	processedString = asteriskRegex.ReplaceAllStringFunc(processedString, func(word string) string {
-		return "**" + strings.Trim(word, "*") + "**"
-	})
-
-	processedString = hyphenRegex.ReplaceAllStringFunc(processedString, func(word string) string {
-		return "~~" + strings.Trim(word, "-") + "~~"
-	})
-
-	processedString = headingRegex.ReplaceAllStringFunc(processedString, func(heading string) string {
-		level := heading[1]
-		hashes := strings.Repeat("#", int(level-'0'))
-		return hashes + " "
-	})
-
-	processedString = langSpecificCodeBlockRegex.ReplaceAllStringFunc(processedString, func(codeBlock string) string {
-		codeContent := codeBlock[strings.Index(codeBlock, "}")+1 : strings.LastIndex(codeBlock, "{code}")]
-		return "`" + codeContent + "`"
-	})
-
-	processedString = codeBlockRegex.ReplaceAllStringFunc(processedString, func(match string) string {
-		curlyContent := codeBlockRegex.FindStringSubmatch(match)[1]
-		return "`" + curlyContent + "`"
-	})
-
-	processedString = colouredTextRegex.ReplaceAllString(processedString, "$1")
-
-	processedString = quoteRegex.ReplaceAllStringFunc(processedString, func(quote string) string {
-		quotedText := quote[strings.Index(quote, "}")+1 : strings.LastIndex(quote, "{quote}")]
-		return "> " + quotedText
-	})
-
-	return processedString
-}
-
 func parseWebhookCommentDeleted(jwh *JiraWebhook) (Webhook, error) {
 	if jwh.Issue.ID == "" {
 		return nil, ErrWebhookIgnored
@@ -438,7 +371,7 @@ func parseWebhookCommentUpdated(jwh *JiraWebhook) (Webhook, error) {
 		JiraWebhook: jwh,
 		eventTypes:  NewStringSet(eventUpdatedComment),
 		headline:    fmt.Sprintf("%s **edited comment** in %s", mdUser(&jwh.Comment.UpdateAuthor), jwh.mdKeySummaryLink()),
-		text:        truncate(quoteIssueComment(preProcessText(jwh.Comment.Body)), 3000),
+		text:        quoteIssueComment(markup.TruncateMarkdown(markup.WikiToMarkdown(jwh.Comment.Body), 3000)),
 	}
 
 	return wh, nil
@@ -478,9 +411,34 @@ func appendNotificationForAssignee(wh *webhook) {
 		jiraUsername:  jwh.Issue.Fields.Assignee.Name,
 		jiraAccountID: jwh.Issue.Fields.Assignee.AccountID,
 		message:       fmt.Sprintf("%s **assigned** you to %s", jwh.mdUser(), jwh.mdKeySummaryLink()),
+		category:      notificationCategoryAssigned,
 	})
 }
 
+// appendNotificationsForStatusChange modifies wh, notifying the assignee and
+// the reporter of the issue, unless they made the change.
+func appendNotificationsForStatusChange(wh *webhook, from, to string) {
+	jwh := wh.JiraWebhook
+	if jwh.Issue.Fields == nil {
+		return
+	}
+
+	message := fmt.Sprintf("%s **changed the status** of %s from %s to %s", jwh.mdUser(), jwh.mdKeySummaryLink(), from, to)
+	for _, u := range []*jira.User{jwh.Issue.Fields.Assignee, jwh.Issue.Fields.Reporter} {
+		if u == nil ||
+			(jwh.User.Name != "" && jwh.User.Name == u.Name) ||
+			(jwh.User.AccountID != "" && jwh.User.AccountID == u.AccountID) {
+			continue
+		}
+		wh.notifications = append(wh.notifications, webhookUserNotification{
+			jiraUsername:  u.Name,
+			jiraAccountID: u.AccountID,
+			message:       message,
+			category:      notificationCategoryStatusChanges,
+		})
+	}
+}
+
 func parseWebhookReopened(jwh *JiraWebhook, from string) *webhook {
 	wh := newWebhook(jwh, eventUpdatedReopened, "**reopened**")
 	wh.fieldInfo = webhookField{"reopened", resolutionField, from, "Open"}
@@ -501,10 +459,10 @@ func parseWebhookUpdatedField(jwh *JiraWebhook, eventType string, field, fieldID
 
 func parseWebhookUpdatedDescription(jwh *JiraWebhook, from, to string) *webhook {
 	wh := newWebhook(jwh, eventUpdatedDescription, "**edited** the description of")
-	fromFmttd := "\n**From:** " + truncate(from, 500)
-	toFmttd := "\n**To:** " + truncate(to, 500)
+	fromFmttd := "\n**From:** " + markup.TruncateMarkdown(markup.WikiToMarkdown(from), 500)
+	toFmttd := "\n**To:** " + markup.TruncateMarkdown(markup.WikiToMarkdown(to), 500)
 	wh.fieldInfo = webhookField{descriptionField, descriptionField, fromFmttd, toFmttd}
-	wh.text = preProcessText(jwh.mdIssueDescription())
+	wh.text = jwh.mdIssueDescription()
 	return wh
 }
 
@@ -530,6 +488,7 @@ func mergeWebhookEvents(events []*webhook) Webhook {
 
 	for _, event := range events {
 		merged.eventTypes = merged.eventTypes.Union(event.eventTypes)
+		merged.notifications = append(merged.notifications, event.notifications...)
 		strike := "~~"
 		if event.fieldInfo.name == descriptionField || strings.HasPrefix(event.fieldInfo.from, strike) {
 			strike = ""
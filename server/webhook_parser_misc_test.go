@@ -4,6 +4,7 @@
 package main
 
 import (
+	"encoding/json"
 	"io"
 	"os"
 	"strings"
@@ -137,79 +138,91 @@ func TestWebhookQuotedComment(t *testing.T) {
 	}
 }
 
-func TestPreProcessText(t *testing.T) {
-	tests := map[string]struct {
-		input          string
-		expectedOutput string
-	}{
-		"BOLD formatting": {
-			input:          "*BOLD*",
-			expectedOutput: "**BOLD**",
-		},
-		"STRIKETHROUGH formatting": {
-			input:          "-STRIKETHROUGH-",
-			expectedOutput: "~~STRIKETHROUGH~~",
-		},
-		"Colored text formatting": {
-			input:          "{color:#ff5630}RED{color} {color:#4c9aff}BLUE{color} {color:#36b37e}GREEN{color}",
-			expectedOutput: "RED BLUE GREEN",
-		},
-		"Numbered list with mixed content formatting": {
-			input: `# NUMBERED LIST ROW 1
-# NUMBERED LIST ROW 2
-non-numbered list text
-# NUMBERED LIST ROW 1`,
-			expectedOutput: `1. NUMBERED LIST ROW 1
-2. NUMBERED LIST ROW 2
-non-numbered list text
-1. NUMBERED LIST ROW 1`,
-		},
-		"Code block formatting": {
-			input:          "{code:go}fruit := \"APPLE\"{code}",
-			expectedOutput: "`fruit := \"APPLE\"`",
-		},
-		"Bullet list formatting": {
-			input: `* BULLET LIST ROW 1
-* BULLET LIST ROW 2`,
-			expectedOutput: `* BULLET LIST ROW 1
-* BULLET LIST ROW 2`,
-		},
-		"Heading formatting": {
-			input: `h1. HEADING 1
-h2. HEADING 2
-h3. HEADING 3
-h4. HEADING 4
-h5. HEADING 5
-h6. HEADING 6`,
-			expectedOutput: `# HEADING 1
-## HEADING 2
-### HEADING 3
-#### HEADING 4
-##### HEADING 5
-###### HEADING 6`,
-		},
-		"Link formatting with text": {
-			input:          "[www.googlesd.com|http://www.googlesd.com]",
-			expectedOutput: "[www.googlesd.com](http://www.googlesd.com)",
-		},
-		"Link formatting with smart-link": {
-			input:          "[http://www.google.com|http://www.google.com|smart-link]",
-			expectedOutput: "[http://www.google.com](http://www.google.com)",
-		},
-		"Link formatting with title": {
-			input:          "[google|http://www.google.com]",
-			expectedOutput: "[google](http://www.google.com)",
-		},
-		"Quote formatting": {
-			input:          "{quote}This is a quote{quote}",
-			expectedOutput: "> This is a quote",
-		},
-	}
+func TestWebhookUpdatedDescriptionMarkdown(t *testing.T) {
+	jwh := &JiraWebhook{}
+	jwh.Issue.Fields = &jira.IssueFields{}
+	wh := parseWebhookUpdatedDescription(jwh, "h1. Old\n*bold*", "{code}\n"+strings.Repeat("line\n", 200)+"{code}")
+	assert.Equal(t, "\n**From:** # Old\n**bold**", wh.fieldInfo.from)
+	assert.True(t, strings.HasPrefix(wh.fieldInfo.to, "\n**To:** ```\nline\n"))
+	assert.True(t, strings.HasSuffix(wh.fieldInfo.to, "line\n```\n\n..."), "the code block must be closed")
+}
+
+func TestNotificationCategories(t *testing.T) {
+	author := jira.User{AccountID: "author", DisplayName: "Author"}
+	assignee := &jira.User{AccountID: "assignee"}
+	reporter := &jira.User{AccountID: "reporter"}
 
-	for name, tc := range tests {
-		t.Run(name, func(t *testing.T) {
-			actualOutput := preProcessText(tc.input)
-			assert.Equal(t, tc.expectedOutput, actualOutput)
-		})
+	categories := func(wh *webhook) map[string]string {
+		m := map[string]string{}
+		for _, n := range wh.notifications {
+			m[n.jiraAccountID] = n.category
+		}
+		return m
 	}
+
+	t.Run("comment", func(t *testing.T) {
+		jwh := &JiraWebhook{User: author}
+		jwh.Issue.Key = "TES-41"
+		jwh.Issue.Fields = &jira.IssueFields{Assignee: assignee, Reporter: reporter}
+		jwh.Comment.UpdateAuthor = author
+		jwh.Comment.Author = author
+		jwh.Comment.Body = "Ping [~accountid:someone]"
+		wh := &webhook{JiraWebhook: jwh}
+
+		appendCommentNotifications(wh, "**mentioned** you in a new comment on")
+		assert.Equal(t, map[string]string{
+			"someone":  notificationCategoryMentioned,
+			"assignee": notificationCategoryAssigneeComments,
+			"reporter": notificationCategoryReporterComments,
+		}, categories(wh))
+	})
+
+	t.Run("comment by the reporter", func(t *testing.T) {
+		jwh := &JiraWebhook{User: *reporter}
+		jwh.Issue.Fields = &jira.IssueFields{Assignee: assignee, Reporter: reporter}
+		jwh.Comment.UpdateAuthor = *reporter
+		jwh.Comment.Author = *reporter
+		wh := &webhook{JiraWebhook: jwh}
+
+		appendCommentNotifications(wh, "**mentioned** you in a new comment on")
+		assert.Equal(t, map[string]string{
+			"assignee": notificationCategoryAssigneeComments,
+		}, categories(wh))
+	})
+
+	t.Run("status change", func(t *testing.T) {
+		jwh := &JiraWebhook{}
+		require.NoError(t, json.Unmarshal([]byte(`{"changelog": {"items": [{"field": "status", "fromString": "To Do", "toString": "Done"}]}}`), jwh))
+		jwh.User = *assignee
+		jwh.Issue.Fields = &jira.IssueFields{Assignee: assignee, Reporter: reporter}
+
+		wh := parseWebhookChangeLog(jwh).(*webhook)
+		assert.Equal(t, map[string]string{
+			"reporter": notificationCategoryStatusChanges,
+		}, categories(wh))
+		assert.Contains(t, wh.notifications[0].message, "**changed the status** of")
+	})
+}
+
+func TestIsNotificationCategoryEnabled(t *testing.T) {
+	var nilSettings *ConnectionSettings
+	assert.True(t, nilSettings.isNotificationCategoryEnabled(notificationCategoryAssigned))
+
+	assert.False(t, nilSettings.isNotificationCategoryEnabled(notificationCategoryStatusChanges))
+
+	settings := newConnectionSettings()
+	settings.NotificationCategories[notificationCategoryWatching] = false
+	assert.True(t, settings.isNotificationCategoryEnabled(notificationCategoryAssigned))
+	assert.True(t, settings.isNotificationCategoryEnabled(""))
+	assert.False(t, settings.isNotificationCategoryEnabled(notificationCategoryWatching))
+	assert.True(t, settings.isNotificationCategoryEnabled(notificationCategoryReporterComments))
+	assert.Equal(t, "\tNotifications: on\n\tTurned off notifications: watching", settings.String())
+
+	// The opt-in categories are off for the connections made before they
+	// were added.
+	existing := &ConnectionSettings{Notifications: true}
+	assert.True(t, existing.isNotificationCategoryEnabled(notificationCategoryAssigned))
+	assert.False(t, existing.isNotificationCategoryEnabled(notificationCategoryReporterComments))
+	assert.False(t, existing.isNotificationCategoryEnabled(notificationCategoryStatusChanges))
+	assert.False(t, existing.isNotificationCategoryEnabled(notificationCategoryWatching))
 }
@@ -0,0 +1,413 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/utils/kvstore"
+	"github.com/mattermost/mattermost-plugin-jira/server/utils/types"
+)
+
+const (
+	prefixWebhookQueueIndex   = "webhook_queue_index_"
+	keyWebhookDeadLetterIndex = "webhook_deadletter_index"
+	prefixWebhookQueue        = "webhook_queue_"
+	prefixWebhookDeadLetter   = "webhook_deadletter_"
+
+	webhookRetryJobKey = "webhook_retry_job"
+
+	// WebhookMaxAttempts is the number of times a webhook event is processed
+	// before it is moved to the dead-letter list.
+	WebhookMaxAttempts = 6
+
+	// WebhookRetryInterval is how often the queue is scanned for events that
+	// are due for a retry, or that were orphaned by a restarted server.
+	WebhookRetryInterval = 30 * time.Second
+
+	// webhookLease is how long a queued event is reserved for the worker that
+	// picked it up. If the worker does not complete or reschedule the event
+	// within the lease (e.g. the plugin was restarted), it is picked up again.
+	webhookLease = 2 * time.Minute
+
+	webhookBackoffBase = 30 * time.Second
+	webhookBackoffMax  = time.Hour
+
+	// webhookQueueIndexShards is the number of records the index of the
+	// queued events is split into, so that the events received at the same
+	// time do not all update the same record.
+	webhookQueueIndexShards = 16
+)
+
+// webhookPermanentError is an error of processing a webhook event that a
+// retry can not fix, as a malformed event or one of a user who is not
+// connected. The event is dropped instead of being retried.
+type webhookPermanentError struct {
+	error
+}
+
+func (e *webhookPermanentError) Unwrap() error {
+	return e.error
+}
+
+func permanentWebhookError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &webhookPermanentError{err}
+}
+
+// isPermanentWebhookError tells if the error of processing a webhook event is
+// permanent, see webhookPermanentError. Unsupported events are never retried.
+func isPermanentWebhookError(err error) bool {
+	var permanent *webhookPermanentError
+	return errors.As(err, &permanent) || errors.Is(err, errWebhookeventUnsupported)
+}
+
+type WebhookQueueStore interface {
+	EnqueueWebhook(msg *webhookMessage) error
+	StoreWebhook(msg *webhookMessage) error
+	DeleteWebhook(id string) error
+	ClaimWebhook(id string, now time.Time, lease time.Duration) (*webhookMessage, error)
+	RenewWebhook(msg *webhookMessage, now time.Time, lease time.Duration) (bool, error)
+	ListWebhookIDs() ([]string, error)
+	StoreDeadLetter(msg *webhookMessage) error
+	LoadDeadLetter(id string) (*webhookMessage, error)
+	DeleteDeadLetter(id string) error
+	ListDeadLetters() ([]*webhookMessage, error)
+}
+
+func newWebhookMessage(instanceID types.ID, data []byte, now time.Time) *webhookMessage {
+	return &webhookMessage{
+		ID:          model.NewId(),
+		Token:       model.NewId(),
+		InstanceID:  instanceID,
+		Data:        data,
+		CreatedAt:   now.UnixMilli(),
+		NextAttempt: now.Add(webhookLease).UnixMilli(),
+	}
+}
+
+// webhookBackoff returns the delay before the next attempt, after the given
+// number of failed attempts.
+func webhookBackoff(attempts int) time.Duration {
+	if attempts < 1 {
+		return 0
+	}
+	d := webhookBackoffBase
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= webhookBackoffMax {
+			return webhookBackoffMax
+		}
+	}
+	return d
+}
+
+// webhookQueueIndexKey returns the key of the shard of the queue index that
+// has the ID of an event.
+func webhookQueueIndexKey(id string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(id))
+	return prefixWebhookQueueIndex + strconv.Itoa(int(h.Sum32()%webhookQueueIndexShards))
+}
+
+func (store store) EnqueueWebhook(msg *webhookMessage) error {
+	err := store.StoreWebhook(msg)
+	if err != nil {
+		return err
+	}
+	return store.updateIDIndex(webhookQueueIndexKey(msg.ID), func(ids StringSet) StringSet {
+		return ids.Add(msg.ID)
+	})
+}
+
+func (store store) StoreWebhook(msg *webhookMessage) error {
+	_, err := store.plugin.client.KV.Set(prefixWebhookQueue+msg.ID, msg)
+	if err != nil {
+		return errors.WithMessagef(err, "failed to store webhook event %s", msg.ID)
+	}
+	return nil
+}
+
+func (store store) DeleteWebhook(id string) error {
+	err := store.plugin.client.KV.Delete(prefixWebhookQueue + id)
+	if err != nil {
+		return errors.WithMessagef(err, "failed to delete webhook event %s", id)
+	}
+	return store.updateIDIndex(webhookQueueIndexKey(id), func(ids StringSet) StringSet {
+		return ids.Subtract(id)
+	})
+}
+
+// ClaimWebhook atomically reserves a queued event that is due for processing
+// for the duration of the lease. It returns kvstore.ErrNotFound if the event
+// does not exist, or nil if it is not due or was claimed by another server.
+func (store store) ClaimWebhook(id string, now time.Time, lease time.Duration) (*webhookMessage, error) {
+	return store.updateWebhookAtomic(id, func(msg *webhookMessage) bool {
+		if msg.NextAttempt > now.UnixMilli() {
+			return false
+		}
+		msg.Token = model.NewId()
+		msg.NextAttempt = now.Add(lease).UnixMilli()
+		return true
+	})
+}
+
+// RenewWebhook extends the lease of a queued event, if it is still owned by
+// the caller, i.e. was not claimed by another server in the meantime.
+func (store store) RenewWebhook(msg *webhookMessage, now time.Time, lease time.Duration) (bool, error) {
+	renewed, err := store.updateWebhookAtomic(msg.ID, func(stored *webhookMessage) bool {
+		if stored.Token != msg.Token {
+			return false
+		}
+		stored.NextAttempt = now.Add(lease).UnixMilli()
+		return true
+	})
+	if err != nil || renewed == nil {
+		return false, err
+	}
+	msg.NextAttempt = renewed.NextAttempt
+	return true, nil
+}
+
+func (store store) updateWebhookAtomic(id string, update func(msg *webhookMessage) bool) (*webhookMessage, error) {
+	key := prefixWebhookQueue + id
+	var data []byte
+	err := store.plugin.client.KV.Get(key, &data)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errors.Wrap(kvstore.ErrNotFound, key)
+	}
+
+	msg := &webhookMessage{}
+	err = json.Unmarshal(data, msg)
+	if err != nil {
+		return nil, err
+	}
+	if !update(msg) {
+		return nil, nil
+	}
+
+	saved, err := store.plugin.client.KV.Set(key, msg, pluginapi.SetAtomic(data))
+	if err != nil {
+		return nil, err
+	}
+	if !saved {
+		return nil, nil
+	}
+	return msg, nil
+}
+
+func (store store) ListWebhookIDs() ([]string, error) {
+	ids := []string{}
+	for i := 0; i < webhookQueueIndexShards; i++ {
+		shard := NewStringSet()
+		err := store.plugin.client.KV.Get(prefixWebhookQueueIndex+strconv.Itoa(i), &shard)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, shard.Elems()...)
+	}
+	return ids, nil
+}
+
+func (store store) StoreDeadLetter(msg *webhookMessage) error {
+	_, err := store.plugin.client.KV.Set(prefixWebhookDeadLetter+msg.ID, msg)
+	if err != nil {
+		return errors.WithMessagef(err, "failed to store dead-letter webhook event %s", msg.ID)
+	}
+	return store.updateIDIndex(keyWebhookDeadLetterIndex, func(ids StringSet) StringSet {
+		return ids.Add(msg.ID)
+	})
+}
+
+func (store store) LoadDeadLetter(id string) (*webhookMessage, error) {
+	msg := &webhookMessage{}
+	err := store.plugin.client.KV.Get(prefixWebhookDeadLetter+id, msg)
+	if err != nil {
+		return nil, err
+	}
+	if msg.ID == "" {
+		return nil, errors.Wrap(kvstore.ErrNotFound, "dead-letter webhook event "+id)
+	}
+	return msg, nil
+}
+
+func (store store) DeleteDeadLetter(id string) error {
+	err := store.plugin.client.KV.Delete(prefixWebhookDeadLetter + id)
+	if err != nil {
+		return errors.WithMessagef(err, "failed to delete dead-letter webhook event %s", id)
+	}
+	return store.updateIDIndex(keyWebhookDeadLetterIndex, func(ids StringSet) StringSet {
+		return ids.Subtract(id)
+	})
+}
+
+func (store store) ListDeadLetters() ([]*webhookMessage, error) {
+	ids := NewStringSet()
+	err := store.plugin.client.KV.Get(keyWebhookDeadLetterIndex, &ids)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := []*webhookMessage{}
+	for _, id := range ids.Elems() {
+		msg, err := store.LoadDeadLetter(id)
+		if errors.Cause(err) == kvstore.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+
+	sort.Slice(msgs, func(i, j int) bool {
+		return msgs[i].CreatedAt < msgs[j].CreatedAt
+	})
+	return msgs, nil
+}
+
+func (store store) updateIDIndex(key string, f func(ids StringSet) StringSet) error {
+	return store.plugin.client.KV.SetAtomicWithRetries(key, func(initialBytes []byte) (interface{}, error) {
+		ids := NewStringSet()
+		if len(initialBytes) != 0 {
+			err := json.Unmarshal(initialBytes, &ids)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return f(ids), nil
+	})
+}
+
+// queueWebhook persists the webhook event before handing it to the workers,
+// so that it survives a restart of the plugin.
+func (p *Plugin) queueWebhook(instanceID types.ID, data []byte) error {
+	msg := newWebhookMessage(instanceID, data, time.Now())
+	err := p.webhookQueueStore.EnqueueWebhook(msg)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case p.webhookQueue <- msg:
+	default:
+		// The in-memory queue is full, make the event immediately available
+		// to the retry job instead of waiting for the lease to expire.
+		msg.NextAttempt = 0
+		err = p.webhookQueueStore.StoreWebhook(msg)
+		if err != nil {
+			p.errorf("queueWebhook: failed to reschedule webhook event %s: %v", msg.ID, err)
+		}
+	}
+	return nil
+}
+
+// completeWebhook records the outcome of processing a queued webhook event.
+// Successful events and the events that failed permanently are removed from
+// the queue, other failed ones are rescheduled with an exponential backoff,
+// until WebhookMaxAttempts is reached and they are moved to the dead-letter
+// list.
+func (p *Plugin) completeWebhook(msg *webhookMessage, processErr error) error {
+	if processErr == nil || isPermanentWebhookError(processErr) {
+		return p.webhookQueueStore.DeleteWebhook(msg.ID)
+	}
+
+	msg.Attempts++
+	msg.LastError = processErr.Error()
+	if msg.Attempts < WebhookMaxAttempts {
+		msg.NextAttempt = time.Now().Add(webhookBackoff(msg.Attempts)).UnixMilli()
+		return p.webhookQueueStore.StoreWebhook(msg)
+	}
+
+	msg.FailedAt = time.Now().UnixMilli()
+	err := p.webhookQueueStore.StoreDeadLetter(msg)
+	if err != nil {
+		return err
+	}
+	p.infof("Webhook event %s moved to the dead-letter list after %d attempts, last error: %v", msg.ID, msg.Attempts, processErr)
+	return p.webhookQueueStore.DeleteWebhook(msg.ID)
+}
+
+// retryQueuedWebhooks is run periodically by a cluster job. It hands the
+// queued events that are due to the workers, as long as there is room for them.
+func (p *Plugin) retryQueuedWebhooks() {
+	ids, err := p.webhookQueueStore.ListWebhookIDs()
+	if err != nil {
+		p.errorf("retryQueuedWebhooks: failed to list queued webhook events: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, id := range ids {
+		if len(p.webhookQueue) >= cap(p.webhookQueue) {
+			return
+		}
+
+		msg, err := p.webhookQueueStore.ClaimWebhook(id, now, webhookLease)
+		if errors.Cause(err) == kvstore.ErrNotFound {
+			// Stale index entry
+			_ = p.webhookQueueStore.DeleteWebhook(id)
+			continue
+		}
+		if err != nil {
+			p.errorf("retryQueuedWebhooks: failed to claim webhook event %s: %v", id, err)
+			continue
+		}
+		if msg == nil {
+			continue
+		}
+
+		select {
+		case p.webhookQueue <- msg:
+		default:
+			return
+		}
+	}
+}
+
+// replayDeadLetter moves a dead-letter event back to the queue, with its
+// attempts reset.
+func (p *Plugin) replayDeadLetter(id string) error {
+	msg, err := p.webhookQueueStore.LoadDeadLetter(id)
+	if err != nil {
+		return err
+	}
+
+	msg.Attempts = 0
+	msg.NextAttempt = 0
+	msg.FailedAt = 0
+	err = p.webhookQueueStore.EnqueueWebhook(msg)
+	if err != nil {
+		return err
+	}
+	return p.webhookQueueStore.DeleteDeadLetter(id)
+}
+
+// describe returns the webhook event name and issue key, for display purposes.
+func (msg *webhookMessage) describe() (string, string) {
+	jwh := struct {
+		WebhookEvent string `json:"webhookEvent"`
+		Issue        struct {
+			Key string `json:"key"`
+		} `json:"issue"`
+	}{}
+	if err := json.Unmarshal(msg.Data, &jwh); err != nil {
+		return "(invalid)", ""
+	}
+	return jwh.WebhookEvent, jwh.Issue.Key
+}
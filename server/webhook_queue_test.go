@@ -0,0 +1,154 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest/mock"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupTestWebhookQueue(t *testing.T) *Plugin {
+	p := &Plugin{}
+	api := &plugintest.API{}
+	api.On("LogInfo", mock.Anything).Maybe()
+	api.On("LogError", mock.Anything).Maybe()
+	makeTestKVStore(api, nil)
+	p.SetAPI(api)
+	p.client = pluginapi.NewClient(api, p.Driver)
+	p.webhookQueueStore = NewStore(p)
+	p.webhookQueue = make(chan *webhookMessage, 2)
+	return p
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	for attempts, expected := range map[int]time.Duration{
+		0:  0,
+		1:  30 * time.Second,
+		2:  time.Minute,
+		5:  8 * time.Minute,
+		20: time.Hour,
+	} {
+		assert.Equal(t, expected, webhookBackoff(attempts), "attempts: %d", attempts)
+	}
+}
+
+func TestWebhookQueueIndexShards(t *testing.T) {
+	p := setupTestWebhookQueue(t)
+	enqueued := []string{}
+	shards := NewStringSet()
+	for i := 0; i < 20; i++ {
+		msg := newWebhookMessage(testInstance1.InstanceID, []byte("{}"), time.Now())
+		require.NoError(t, p.webhookQueueStore.EnqueueWebhook(msg))
+		enqueued = append(enqueued, msg.ID)
+		shards = shards.Add(webhookQueueIndexKey(msg.ID))
+	}
+	assert.Greater(t, shards.Len(), 1, "the events are spread over the shards")
+
+	ids, err := p.webhookQueueStore.ListWebhookIDs()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, enqueued, ids)
+
+	require.NoError(t, p.webhookQueueStore.DeleteWebhook(enqueued[0]))
+	ids, err = p.webhookQueueStore.ListWebhookIDs()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, enqueued[1:], ids)
+}
+
+func TestCompleteWebhook(t *testing.T) {
+	t.Run("success removes the event from the queue", func(t *testing.T) {
+		p := setupTestWebhookQueue(t)
+		msg := newWebhookMessage(testInstance1.InstanceID, []byte("{}"), time.Now())
+		require.NoError(t, p.webhookQueueStore.EnqueueWebhook(msg))
+
+		require.NoError(t, p.completeWebhook(msg, nil))
+
+		ids, err := p.webhookQueueStore.ListWebhookIDs()
+		require.NoError(t, err)
+		assert.Empty(t, ids)
+	})
+
+	t.Run("failure reschedules the event", func(t *testing.T) {
+		p := setupTestWebhookQueue(t)
+		msg := newWebhookMessage(testInstance1.InstanceID, []byte("{}"), time.Now())
+		require.NoError(t, p.webhookQueueStore.EnqueueWebhook(msg))
+
+		require.NoError(t, p.completeWebhook(msg, errors.New("failed")))
+
+		ids, err := p.webhookQueueStore.ListWebhookIDs()
+		require.NoError(t, err)
+		assert.Equal(t, []string{msg.ID}, ids)
+
+		// Not due yet
+		claimed, err := p.webhookQueueStore.ClaimWebhook(msg.ID, time.Now(), webhookLease)
+		require.NoError(t, err)
+		assert.Nil(t, claimed)
+
+		claimed, err = p.webhookQueueStore.ClaimWebhook(msg.ID, time.Now().Add(webhookBackoff(1)+time.Second), webhookLease)
+		require.NoError(t, err)
+		require.NotNil(t, claimed)
+		assert.Equal(t, 1, claimed.Attempts)
+		assert.Equal(t, "failed", claimed.LastError)
+		assert.NotEqual(t, msg.Token, claimed.Token)
+
+		// The original holder of the event lost it to the new claim
+		owned, err := p.webhookQueueStore.RenewWebhook(msg, time.Now(), webhookLease)
+		require.NoError(t, err)
+		assert.False(t, owned)
+	})
+
+	t.Run("permanent failure drops the event", func(t *testing.T) {
+		p := setupTestWebhookQueue(t)
+		msg := newWebhookMessage(testInstance1.InstanceID, []byte(`{}`), time.Now())
+		require.NoError(t, p.webhookQueueStore.EnqueueWebhook(msg))
+
+		require.NoError(t, p.completeWebhook(msg, permanentWebhookError(errors.New("malformed"))))
+
+		ids, err := p.webhookQueueStore.ListWebhookIDs()
+		require.NoError(t, err)
+		assert.Empty(t, ids)
+		deadLetters, err := p.webhookQueueStore.ListDeadLetters()
+		require.NoError(t, err)
+		assert.Empty(t, deadLetters)
+	})
+
+	t.Run("last failure moves the event to the dead-letter list", func(t *testing.T) {
+		p := setupTestWebhookQueue(t)
+		msg := newWebhookMessage(testInstance1.InstanceID, []byte(`{"webhookEvent":"jira:issue_created","issue":{"key":"TES-41"}}`), time.Now())
+		msg.Attempts = WebhookMaxAttempts - 1
+		require.NoError(t, p.webhookQueueStore.EnqueueWebhook(msg))
+
+		require.NoError(t, p.completeWebhook(msg, errors.New("failed")))
+
+		ids, err := p.webhookQueueStore.ListWebhookIDs()
+		require.NoError(t, err)
+		assert.Empty(t, ids)
+
+		deadLetters, err := p.webhookQueueStore.ListDeadLetters()
+		require.NoError(t, err)
+		require.Len(t, deadLetters, 1)
+		assert.Equal(t, WebhookMaxAttempts, deadLetters[0].Attempts)
+		event, issueKey := deadLetters[0].describe()
+		assert.Equal(t, "jira:issue_created", event)
+		assert.Equal(t, "TES-41", issueKey)
+
+		require.NoError(t, p.replayDeadLetter(msg.ID))
+
+		deadLetters, err = p.webhookQueueStore.ListDeadLetters()
+		require.NoError(t, err)
+		assert.Empty(t, deadLetters)
+
+		p.retryQueuedWebhooks()
+		require.Len(t, p.webhookQueue, 1)
+		replayed := <-p.webhookQueue
+		assert.Equal(t, msg.ID, replayed.ID)
+		assert.Equal(t, 0, replayed.Attempts)
+	})
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/utils/types"
+)
+
+const (
+	prefixIssueThread = "issue_thread_"
+
+	// issueThreadTTL is how long an issue thread is continued after its last
+	// post. Later events start a new thread.
+	issueThreadTTL = 30 * 24 * time.Hour
+
+	maxIssueThreadUpdateAttempts = 5
+)
+
+// issueThreads maps the channel IDs to the root post ID of the thread of an
+// issue in that channel.
+type issueThreads map[string]string
+
+// postToSubscribedChannel posts the webhook event to a subscribed channel. If
+// the subscription threads by issue, the event is posted as a reply to the
+// thread of the issue in the channel, or starts that thread.
+func (p *Plugin) postToSubscribedChannel(wh *webhook, instanceID types.ID, sub ChannelSubscription, fromUserID string) (*model.Post, int, error) {
+	post, err := p.makeSubscriptionPost(wh, instanceID, sub, fromUserID)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	issueKey := wh.JiraWebhook.Issue.Key
+	if !sub.ThreadByIssue || issueKey == "" {
+		err = p.client.Post.CreatePost(post)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		return post, http.StatusOK, nil
+	}
+
+	rootID, err := p.loadIssueThread(instanceID, issueKey, sub.ChannelID)
+	if err != nil {
+		p.errorf("postToSubscribedChannel: failed to load the thread of issue %s: %v", issueKey, err)
+	}
+
+	if rootID != "" {
+		post.RootId = rootID
+		err = p.client.Post.CreatePost(post)
+		if err == nil {
+			p.storeIssueThread(instanceID, issueKey, sub.ChannelID, rootID)
+			return post, http.StatusOK, nil
+		}
+
+		// The root post may have been deleted, start a new thread
+		p.debugf("postToSubscribedChannel: failed to reply to the thread of issue %s, starting a new thread: %v", issueKey, err)
+		post.Id = ""
+		post.RootId = ""
+	}
+
+	err = p.client.Post.CreatePost(post)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	p.storeIssueThread(instanceID, issueKey, sub.ChannelID, post.Id)
+	return post, http.StatusOK, nil
+}
+
+func (p *Plugin) loadIssueThread(instanceID types.ID, issueKey, channelID string) (string, error) {
+	threads := issueThreads{}
+	err := p.client.KV.Get(issueThreadKey(instanceID, issueKey), &threads)
+	if err != nil {
+		return "", err
+	}
+	return threads[channelID], nil
+}
+
+// storeIssueThread records the root post of the issue thread in the channel,
+// and extends the expiry of all the threads of the issue.
+func (p *Plugin) storeIssueThread(instanceID types.ID, issueKey, channelID, rootID string) {
+	err := p.updateIssueThreads(instanceID, issueKey, func(threads issueThreads) issueThreads {
+		threads[channelID] = rootID
+		return threads
+	})
+	if err != nil {
+		p.errorf("storeIssueThread: failed to store the thread of issue %s: %v", issueKey, err)
+	}
+}
+
+// deleteIssueThreads forgets the threads of an issue in all channels.
+func (p *Plugin) deleteIssueThreads(instanceID types.ID, issueKey string) error {
+	return p.client.KV.Delete(issueThreadKey(instanceID, issueKey))
+}
+
+func (p *Plugin) updateIssueThreads(instanceID types.ID, issueKey string, update func(threads issueThreads) issueThreads) error {
+	key := issueThreadKey(instanceID, issueKey)
+	for i := 0; i < maxIssueThreadUpdateAttempts; i++ {
+		var data []byte
+		err := p.client.KV.Get(key, &data)
+		if err != nil {
+			return err
+		}
+
+		threads := issueThreads{}
+		if len(data) != 0 {
+			err = json.Unmarshal(data, &threads)
+			if err != nil {
+				return err
+			}
+		}
+
+		saved, err := p.client.KV.Set(key, update(threads), pluginapi.SetAtomic(data), pluginapi.SetExpiry(issueThreadTTL))
+		if err != nil {
+			return err
+		}
+		if saved {
+			return nil
+		}
+	}
+	return errors.Errorf("failed to update %s after %d attempts", key, maxIssueThreadUpdateAttempts)
+}
+
+func issueThreadKey(instanceID types.ID, issueKey string) string {
+	return hashkey(prefixIssueThread, fmt.Sprintf("%s/%s", instanceID, issueKey))
+}
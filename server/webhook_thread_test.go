@@ -0,0 +1,120 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest/mock"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostToSubscribedChannel(t *testing.T) {
+	setup := func(t *testing.T, deletedRootIDs ...string) (*Plugin, *webhook) {
+		p := &Plugin{}
+		p.updateConfig(func(conf *config) {})
+		api := &plugintest.API{}
+		api.On("LogDebug", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe()
+		makeTestKVStore(api, nil)
+		api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(
+			func(post *model.Post) *model.Post {
+				for _, id := range deletedRootIDs {
+					if post.RootId == id {
+						return nil
+					}
+				}
+				created := post.Clone()
+				created.Id = model.NewId()
+				return created
+			},
+			func(post *model.Post) *model.AppError {
+				for _, id := range deletedRootIDs {
+					if post.RootId == id {
+						return model.NewAppError("CreatePost", "api.post.create_post.root_id.app_error", nil, "", 400)
+					}
+				}
+				return nil
+			})
+		p.SetAPI(api)
+		p.client = pluginapi.NewClient(api, p.Driver)
+
+		data, err := getJiraTestData("webhook-issue-created.json")
+		require.NoError(t, err)
+		wh, err := ParseWebhook(data)
+		require.NoError(t, err)
+		return p, wh.(*webhook)
+	}
+
+	sub := ChannelSubscription{
+		ID:            "subscription1",
+		ChannelID:     "channel1",
+		Name:          "subscription",
+		ThreadByIssue: true,
+	}
+
+	t.Run("later events are replies to the first one", func(t *testing.T) {
+		p, wh := setup(t)
+
+		root, _, err := p.postToSubscribedChannel(wh, testInstance1.InstanceID, sub, "bot")
+		require.NoError(t, err)
+		assert.Empty(t, root.RootId)
+
+		reply, _, err := p.postToSubscribedChannel(wh, testInstance1.InstanceID, sub, "bot")
+		require.NoError(t, err)
+		assert.Equal(t, root.Id, reply.RootId)
+
+		otherChannel := sub
+		otherChannel.ChannelID = "channel2"
+		post, _, err := p.postToSubscribedChannel(wh, testInstance1.InstanceID, otherChannel, "bot")
+		require.NoError(t, err)
+		assert.Empty(t, post.RootId, "threads are per channel")
+
+		reply, _, err = p.postToSubscribedChannel(wh, testInstance1.InstanceID, sub, "bot")
+		require.NoError(t, err)
+		assert.Equal(t, root.Id, reply.RootId)
+	})
+
+	t.Run("subscription not threaded by issue", func(t *testing.T) {
+		p, wh := setup(t)
+		notThreaded := sub
+		notThreaded.ThreadByIssue = false
+
+		for i := 0; i < 2; i++ {
+			post, _, err := p.postToSubscribedChannel(wh, testInstance1.InstanceID, notThreaded, "bot")
+			require.NoError(t, err)
+			assert.Empty(t, post.RootId)
+		}
+	})
+
+	t.Run("deleted issue starts a new thread", func(t *testing.T) {
+		p, wh := setup(t)
+
+		root, _, err := p.postToSubscribedChannel(wh, testInstance1.InstanceID, sub, "bot")
+		require.NoError(t, err)
+
+		require.NoError(t, p.deleteIssueThreads(testInstance1.InstanceID, wh.Issue.Key))
+
+		post, _, err := p.postToSubscribedChannel(wh, testInstance1.InstanceID, sub, "bot")
+		require.NoError(t, err)
+		assert.Empty(t, post.RootId)
+		assert.NotEqual(t, root.Id, post.Id)
+	})
+
+	t.Run("deleted root post starts a new thread", func(t *testing.T) {
+		p, wh := setup(t, "deleted_root")
+		p.storeIssueThread(testInstance1.InstanceID, wh.Issue.Key, sub.ChannelID, "deleted_root")
+
+		root, _, err := p.postToSubscribedChannel(wh, testInstance1.InstanceID, sub, "bot")
+		require.NoError(t, err)
+		assert.Empty(t, root.RootId)
+
+		reply, _, err := p.postToSubscribedChannel(wh, testInstance1.InstanceID, sub, "bot")
+		require.NoError(t, err)
+		assert.Equal(t, root.Id, reply.RootId)
+	})
+}
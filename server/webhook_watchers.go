@@ -0,0 +1,220 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"time"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/utils/types"
+)
+
+const (
+	prefixIssueWatchers = "issue_watchers_"
+	prefixConnectedUser = "connected_user_"
+
+	// issueWatchersTTL is how long the watchers of an issue are cached, so
+	// that a burst of events on the same issue loads them once.
+	issueWatchersTTL = 2 * time.Minute
+
+	// connectedUserTTL is how long the connected user found for an instance
+	// is cached, so that the users are not listed on every event.
+	connectedUserTTL = time.Hour
+)
+
+// watcherEvents are the events the watchers of an issue are notified of.
+var watcherEvents = NewStringSet(
+	eventCreatedComment,
+	eventUpdatedAssignee,
+	eventUpdatedPriority,
+	eventUpdatedReopened,
+	eventUpdatedResolved,
+	eventUpdatedStatus,
+)
+
+// issueWatcher is the part of a Jira user needed to find the Mattermost user.
+type issueWatcher struct {
+	Name      string `json:"name,omitempty"`
+	AccountID string `json:"account_id,omitempty"`
+}
+
+// watcherNotifications returns a notification for every watcher of the
+// issue, except the user who made the change.
+func (p *Plugin) watcherNotifications(instance Instance, wh *webhook) []webhookUserNotification {
+	if wh.Events().Intersection(watcherEvents).Len() == 0 || wh.Issue.ID == "" {
+		return nil
+	}
+
+	watchers, err := p.loadIssueWatchers(instance, wh)
+	if err != nil {
+		p.errorf("watcherNotifications: failed to load the watchers of issue %s: %v", wh.Issue.Key, err)
+		return nil
+	}
+
+	message := wh.headline
+	postType := ""
+	if wh.Events().Intersection(commentEvents).Len() > 0 {
+		message += "\n" + wh.text
+		postType = PostTypeComment
+	}
+
+	notifications := []webhookUserNotification{}
+	for _, w := range watchers {
+		if (w.Name != "" && w.Name == wh.User.Name) ||
+			(w.AccountID != "" && (w.AccountID == wh.User.AccountID || w.AccountID == wh.Comment.UpdateAuthor.AccountID)) {
+			continue
+		}
+		notifications = append(notifications, webhookUserNotification{
+			jiraUsername:  w.Name,
+			jiraAccountID: w.AccountID,
+			message:       message,
+			postType:      postType,
+			commentSelf:   wh.Comment.Self,
+			category:      notificationCategoryWatching,
+		})
+	}
+	return notifications
+}
+
+// loadIssueWatchers returns the watchers of the issue from the cache, or
+// from Jira. The watchers are fetched as the first connected user among the
+// author of the change or comment, the assignee and the reporter, or else as
+// any user connected to the instance. If no user is connected, the issue is
+// considered to have no watchers.
+func (p *Plugin) loadIssueWatchers(instance Instance, wh *webhook) ([]issueWatcher, error) {
+	key := hashkey(prefixIssueWatchers, instance.GetID().String()+"/"+wh.Issue.ID)
+	var cached []issueWatcher
+	err := p.client.KV.Get(key, &cached)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil {
+		return cached, nil
+	}
+
+	candidates := []*jira.User{&wh.User, &wh.Comment.UpdateAuthor}
+	if wh.Issue.Fields != nil {
+		candidates = append(candidates, wh.Issue.Fields.Assignee, wh.Issue.Fields.Reporter)
+	}
+
+	var client Client
+	for _, u := range candidates {
+		var ok bool
+		client, ok = p.getClientForJiraUser(instance, u)
+		if ok {
+			break
+		}
+	}
+	if client == nil {
+		client, _ = p.getClientForAnyConnectedUser(instance)
+	}
+
+	watchers := []issueWatcher{}
+	if client != nil {
+		users, err := client.GetWatchers(wh.Issue.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			watchers = append(watchers, issueWatcher{Name: user.Name, AccountID: user.AccountID})
+		}
+	}
+
+	_, err = p.client.KV.Set(key, watchers, pluginapi.SetExpiry(issueWatchersTTL))
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to cache the watchers")
+	}
+	return watchers, nil
+}
+
+// getClientForJiraUser returns a client for the Jira user, if they are
+// connected to Mattermost.
+func (p *Plugin) getClientForJiraUser(instance Instance, u *jira.User) (Client, bool) {
+	if u == nil || (u.AccountID == "" && u.Name == "") {
+		return nil, false
+	}
+	jiraUserID := u.AccountID
+	if jiraUserID == "" {
+		jiraUserID = u.Name
+	}
+
+	mattermostUserID, err := p.userStore.LoadMattermostUserID(instance.GetID(), jiraUserID)
+	if err != nil {
+		return nil, false
+	}
+	return p.getClientForMattermostUser(instance, mattermostUserID)
+}
+
+// getClientForMattermostUser returns a client for the Mattermost user, if
+// they are connected to the instance.
+func (p *Plugin) getClientForMattermostUser(instance Instance, mattermostUserID types.ID) (Client, bool) {
+	connection, err := p.userStore.LoadConnection(instance.GetID(), mattermostUserID)
+	if err != nil {
+		return nil, false
+	}
+	client, err := instance.GetClient(connection)
+	if err != nil {
+		return nil, false
+	}
+	return client, true
+}
+
+// connectedUser is the cached user found by getClientForAnyConnectedUser. An
+// empty MattermostUserID records that no user is connected.
+type connectedUser struct {
+	MattermostUserID types.ID `json:"mattermost_user_id"`
+}
+
+// getClientForAnyConnectedUser returns a client for a user connected to the
+// instance, for the requests that need credentials but are not made on behalf
+// of a particular user.
+func (p *Plugin) getClientForAnyConnectedUser(instance Instance) (Client, bool) {
+	key := hashkey(prefixConnectedUser, instance.GetID().String())
+	var cached *connectedUser
+	err := p.client.KV.Get(key, &cached)
+	if err == nil && cached != nil {
+		if cached.MattermostUserID == "" {
+			return nil, false
+		}
+		if client, ok := p.getClientForMattermostUser(instance, cached.MattermostUserID); ok {
+			return client, true
+		}
+	}
+
+	found := connectedUser{}
+	var client Client
+	errFound := errors.New("found a connected user")
+	err = p.userStore.MapUsers(func(user *User) error {
+		if user.ConnectedInstances == nil || !user.ConnectedInstances.Contains(instance.GetID()) {
+			return nil
+		}
+		var ok bool
+		client, ok = p.getClientForMattermostUser(instance, user.MattermostUserID)
+		if !ok {
+			return nil
+		}
+		found.MattermostUserID = user.MattermostUserID
+		return errFound
+	})
+	if err != nil && err != errFound {
+		p.errorf("getClientForAnyConnectedUser: failed to list the users: %v", err)
+		return nil, false
+	}
+
+	// A user may connect soon, so the absence of connected users is not
+	// cached as long.
+	ttl := connectedUserTTL
+	if client == nil {
+		ttl = issueWatchersTTL
+	}
+	_, err = p.client.KV.Set(key, found, pluginapi.SetExpiry(ttl))
+	if err != nil {
+		p.errorf("getClientForAnyConnectedUser: failed to cache the connected user: %v", err)
+	}
+	return client, client != nil
+}
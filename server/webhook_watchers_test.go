@@ -0,0 +1,175 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest/mock"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-plugin-jira/server/utils/kvstore"
+	"github.com/mattermost/mattermost-plugin-jira/server/utils/types"
+)
+
+type watchersTestClient struct {
+	testClient
+	calls *int
+}
+
+func (client watchersTestClient) GetWatchers(issueKey string) ([]jira.User, error) {
+	*client.calls++
+	return []jira.User{
+		{AccountID: "5c5f880629be9642ba529340"}, // the author of the comment
+		{AccountID: "watcher1"},
+		{Name: "watcher2"},
+	}, nil
+}
+
+type watchersTestInstance struct {
+	*testInstance
+	client Client
+}
+
+func (ti watchersTestInstance) GetClient(*Connection) (Client, error) {
+	return ti.client, nil
+}
+
+func TestWatcherNotifications(t *testing.T) {
+	p := &Plugin{}
+	api := &plugintest.API{}
+	makeTestKVStore(api, nil)
+	p.SetAPI(api)
+	p.client = pluginapi.NewClient(api, p.Driver)
+	p.userStore = mockUserStore{}
+
+	calls := 0
+	instance := watchersTestInstance{testInstance: testInstance1, client: watchersTestClient{calls: &calls}}
+
+	created := parseTestCommentWebhook(t, "webhook-cloud-comment-created.json")
+	notifications := p.watcherNotifications(instance, created)
+	require.Len(t, notifications, 2)
+	assert.Equal(t, "watcher1", notifications[0].jiraAccountID)
+	assert.Equal(t, "watcher2", notifications[1].jiraUsername)
+	for _, n := range notifications {
+		assert.Equal(t, notificationCategoryWatching, n.category)
+		assert.Equal(t, PostTypeComment, n.postType)
+		assert.Equal(t, created.headline+"\n"+created.text, n.message)
+	}
+
+	// The watchers are cached
+	notifications = p.watcherNotifications(instance, parseTestCommentWebhook(t, "webhook-cloud-comment-updated.json"))
+	assert.Empty(t, notifications, "edited comments are not sent to the watchers")
+	notifications = p.watcherNotifications(instance, created)
+	assert.Len(t, notifications, 2)
+	assert.Equal(t, 1, calls)
+}
+
+// unconnectedAuthorsUserStore has a single connected user, who is neither the
+// author of the events nor the assignee or reporter of the issues.
+type unconnectedAuthorsUserStore struct {
+	mockUserStore
+	mapped *int
+}
+
+func (store unconnectedAuthorsUserStore) LoadMattermostUserID(types.ID, string) (types.ID, error) {
+	return "", kvstore.ErrNotFound
+}
+
+func (store unconnectedAuthorsUserStore) MapUsers(f func(*User) error) error {
+	*store.mapped++
+	for _, id := range []types.ID{"disconnected", "connected"} {
+		user := NewUser(id)
+		if id == "connected" {
+			user.ConnectedInstances.Set(testInstance1.Common())
+		}
+		if err := f(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestWatcherNotificationsAnyConnectedUser(t *testing.T) {
+	p := &Plugin{}
+	api := &plugintest.API{}
+	makeTestKVStore(api, nil)
+	p.SetAPI(api)
+	p.client = pluginapi.NewClient(api, p.Driver)
+	mapped := 0
+	p.userStore = unconnectedAuthorsUserStore{mapped: &mapped}
+
+	calls := 0
+	instance := watchersTestInstance{testInstance: testInstance1, client: watchersTestClient{calls: &calls}}
+
+	created := parseTestCommentWebhook(t, "webhook-cloud-comment-created.json")
+	require.Len(t, p.watcherNotifications(instance, created), 2)
+	assert.Equal(t, 1, calls)
+
+	// The connected user is cached
+	created.Issue.ID = "another issue"
+	require.Len(t, p.watcherNotifications(instance, created), 2)
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, 1, mapped)
+}
+
+// categoriesUserStore connects every Jira user, as the Mattermost user of the
+// same ID, with the same settings.
+type categoriesUserStore struct {
+	mockUserStore
+	settings *ConnectionSettings
+}
+
+func (store categoriesUserStore) LoadMattermostUserID(_ types.ID, jiraUserID string) (types.ID, error) {
+	return types.ID(jiraUserID), nil
+}
+
+func (store categoriesUserStore) LoadConnection(types.ID, types.ID) (*Connection, error) {
+	return &Connection{Settings: store.settings}, nil
+}
+
+func TestWatcherNotificationsTurnedOffCategory(t *testing.T) {
+	p := &Plugin{}
+	p.updateConfig(func(conf *config) {})
+	api := &plugintest.API{}
+	makeTestKVStore(api, nil)
+	dms := []string{}
+	api.On("GetDirectChannel", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(func(userID, botUserID string) *model.Channel {
+		return &model.Channel{Id: "dm_" + userID}
+	}, nil)
+	api.On("GetUser", mock.AnythingOfType("string")).Return(&model.User{}, nil)
+	api.On("GetUserStatus", mock.AnythingOfType("string")).Return(&model.Status{Status: model.StatusOnline}, nil)
+	api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(func(post *model.Post) *model.Post {
+		dms = append(dms, post.ChannelId)
+		return post.Clone()
+	}, nil)
+	p.SetAPI(api)
+	p.client = pluginapi.NewClient(api, p.Driver)
+
+	// The reporter turned off the status changes, but not the watched issues
+	settings := newConnectionSettings()
+	settings.NotificationCategories[notificationCategoryStatusChanges] = false
+	p.userStore = categoriesUserStore{settings: settings}
+	calls := 0
+	p.instanceStore = previewInstanceStore{instance: watchersTestInstance{testInstance: testInstance1, client: watchersTestClient{calls: &calls}}}
+
+	jwh := &JiraWebhook{}
+	require.NoError(t, json.Unmarshal([]byte(`{"changelog": {"items": [{"field": "status", "fromString": "To Do", "toString": "Done"}]}}`), jwh))
+	jwh.User = jira.User{AccountID: "5c5f880629be9642ba529340"}
+	jwh.Issue.ID = "10000"
+	jwh.Issue.Key = "TES-1"
+	jwh.Issue.Fields = &jira.IssueFields{Reporter: &jira.User{AccountID: "watcher1"}}
+	wh := parseWebhookChangeLog(jwh).(*webhook)
+
+	_, _, err := wh.PostNotifications(p, testInstance1.InstanceID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, []string{"dm_watcher2"}, dms, "the reporter is not notified as a watcher")
+}
@@ -4,6 +4,10 @@
 package main
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/pkg/errors"
 
 	"github.com/mattermost/mattermost-plugin-jira/server/utils/types"
@@ -15,21 +19,48 @@ type webhookWorker struct {
 	workQueue <-chan *webhookMessage
 }
 
+// webhookMessage is a webhook event persisted in the queue until it is
+// successfully processed, or moved to the dead-letter list.
 type webhookMessage struct {
-	InstanceID types.ID
-	Data       []byte
+	ID          string   `json:"id"`
+	Token       string   `json:"token"`
+	InstanceID  types.ID `json:"instance_id"`
+	Data        []byte   `json:"data"`
+	Attempts    int      `json:"attempts"`
+	NextAttempt int64    `json:"next_attempt"`
+	LastError   string   `json:"last_error,omitempty"`
+	CreatedAt   int64    `json:"created_at"`
+	FailedAt    int64    `json:"failed_at,omitempty"`
 }
 
 func (ww webhookWorker) work() {
 	for msg := range ww.workQueue {
-		err := ww.process(msg)
+		// Make sure the event was not picked up by another server after its
+		// lease expired while it was waiting in the queue.
+		owned, err := ww.p.webhookQueueStore.RenewWebhook(msg, time.Now(), webhookLease)
+		if err != nil {
+			ww.p.errorf("WebhookWorker id: %d, failed to renew webhook event %s, err: %v", ww.id, msg.ID, err)
+			continue
+		}
+		if !owned {
+			continue
+		}
+
+		err = ww.process(msg)
 		if err != nil {
-			if errors.Is(err, errWebhookeventUnsupported) {
+			switch {
+			case errors.Is(err, errWebhookeventUnsupported):
 				ww.p.debugf("WebhookWorker id: %d, error processing, err: %v", ww.id, err)
-			} else {
-				ww.p.errorf("WebhookWorker id: %d, error processing, err: %v", ww.id, err)
+			case isPermanentWebhookError(err):
+				ww.p.errorf("WebhookWorker id: %d, error processing, dropping the event, err: %v", ww.id, err)
+			default:
+				ww.p.errorf("WebhookWorker id: %d, error processing, attempt: %d, err: %v", ww.id, msg.Attempts+1, err)
 			}
 		}
+
+		if err = ww.p.completeWebhook(msg, err); err != nil {
+			ww.p.errorf("WebhookWorker id: %d, failed to update webhook event %s, err: %v", ww.id, msg.ID, err)
+		}
 	}
 }
 
@@ -43,14 +74,21 @@ func (ww webhookWorker) process(msg *webhookMessage) (err error) {
 
 	wh, err := ParseWebhook(msg.Data)
 	if err != nil {
-		return err
+		// The event will not parse any better next time
+		return permanentWebhookError(err)
 	}
 
-	if _, _, err = wh.PostNotifications(ww.p, msg.InstanceID); err != nil {
-		ww.p.errorf("WebhookWorker id: %d, error posting notifications, err: %v", ww.id, err)
+	// The notifications and posts are deduplicated per scope, so the event
+	// is retried if any of them failed, without posting the others again.
+	failures := []string{}
+	v := wh.(*webhook)
+	if ww.p.markWebhookSeen(msg.InstanceID, v, webhookDedupeScopeNotifications) {
+		if _, _, err = wh.PostNotifications(ww.p, msg.InstanceID); err != nil {
+			ww.p.unmarkWebhookSeen(msg.InstanceID, v, webhookDedupeScopeNotifications)
+			failures = append(failures, "failed to post the notifications: "+err.Error())
+		}
 	}
 
-	v := wh.(*webhook)
 	if err = v.JiraWebhook.expandIssue(ww.p, msg.InstanceID); err != nil {
 		return err
 	}
@@ -60,12 +98,42 @@ func (ww webhookWorker) process(msg *webhookMessage) (err error) {
 		return err
 	}
 
+	postsByChannelID := map[string]int{}
+	for _, channelSubscribed := range channelsSubscribed {
+		postsByChannelID[channelSubscribed.ChannelID]++
+	}
+
 	botUserID := ww.p.getUserID()
 	for _, channelSubscribed := range channelsSubscribed {
-		if _, _, err1 := wh.PostToChannel(ww.p, msg.InstanceID, channelSubscribed.ChannelID, botUserID, channelSubscribed.Name); err1 != nil {
-			ww.p.errorf("WebhookWorker id: %d, error posting to channel, err: %v", ww.id, err1)
+		scope := channelSubscribed.ChannelID
+		if postsByChannelID[channelSubscribed.ChannelID] > 1 {
+			// The event is posted once per subscription of the channel
+			scope += "/" + channelSubscribed.ID
+		}
+		if !ww.p.markWebhookSeen(msg.InstanceID, v, scope) {
+			continue
+		}
+		if _, _, err1 := ww.p.postRateLimited(v, msg.InstanceID, channelSubscribed, botUserID); err1 != nil {
+			ww.p.unmarkWebhookSeen(msg.InstanceID, v, scope)
+			failures = append(failures, fmt.Sprintf("failed to post to channel %s: %v", channelSubscribed.ChannelID, err1))
+		}
+	}
+
+	if err = ww.p.syncCommentToThreads(v, msg.InstanceID); err != nil {
+		return err
+	}
+
+	if v.WebhookEvent == issueDeleted && v.Issue.Key != "" {
+		if err = ww.p.deleteIssueThreads(msg.InstanceID, v.Issue.Key); err != nil {
+			ww.p.errorf("WebhookWorker id: %d, failed to delete the threads of issue %s, err: %v", ww.id, v.Issue.Key, err)
+		}
+		if err = ww.p.unlinkIssueThreads(msg.InstanceID, v.Issue.Key); err != nil {
+			ww.p.errorf("WebhookWorker id: %d, failed to unlink the threads of issue %s, err: %v", ww.id, v.Issue.Key, err)
 		}
 	}
 
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
 	return nil
 }
@@ -0,0 +1,54 @@
+// Copyright (c) 2017-present Mattermost, Inc. All Rights Reserved.
+// See License for license information.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookWorkerProcessRetriesFailedPosts(t *testing.T) {
+	p, api, _ := setupSubscriptionStoreTest(t, []ChannelSubscription{
+		storeTestSubscription("sub1", "channel1", "bugs", "TES", eventCreated),
+		storeTestSubscription("sub2", "channel2", "bugs", "TES", eventCreated),
+	})
+	p.instanceStore = previewInstanceStore{instance: testInstance1}
+	p.userStore = mockUserStore{}
+	api.On("LogDebug", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe()
+
+	posted := map[string]int{}
+	failChannel1 := true
+	api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(func(post *model.Post) *model.Post {
+		if post.ChannelId == "channel1" && failChannel1 {
+			return nil
+		}
+		posted[post.ChannelId]++
+		return post.Clone()
+	}, func(post *model.Post) *model.AppError {
+		if post.ChannelId == "channel1" && failChannel1 {
+			return &model.AppError{Message: "unavailable"}
+		}
+		return nil
+	})
+
+	data, err := getJiraTestData("webhook-issue-created.json")
+	require.NoError(t, err)
+	msg := newWebhookMessage(testInstance1.InstanceID, data, model.GetTimeForMillis(0))
+	ww := webhookWorker{p: p}
+
+	err = ww.process(msg)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to post to channel channel1")
+	assert.False(t, isPermanentWebhookError(err))
+	assert.Equal(t, map[string]int{"channel2": 1}, posted)
+
+	// The retry only posts to the channel that failed
+	failChannel1 = false
+	require.NoError(t, ww.process(msg))
+	assert.Equal(t, map[string]int{"channel1": 1, "channel2": 1}, posted)
+}
@@ -233,6 +233,7 @@ describe('components/EditChannelSubscription', () => {
                 filters: channelSubscriptionForCloud.filters,
                 name: channelSubscriptionForCloud.name,
                 instance_id: 'https://something.atlassian.net',
+                thread_by_issue: false,
             },
         );
         expect(editChannelSubscription).not.toHaveBeenCalled();
@@ -285,6 +286,7 @@ describe('components/EditChannelSubscription', () => {
                 filters: channelSubscriptionForServer.filters,
                 name: null,
                 instance_id: 'https://something.atlassian.net',
+                thread_by_issue: false,
             },
         );
         expect(editChannelSubscription).not.toHaveBeenCalled();
@@ -357,10 +359,37 @@ describe('components/EditChannelSubscription', () => {
                 },
                 name: 'SubTestName',
                 instance_id: 'https://something.atlassian.net',
+                thread_by_issue: false,
             },
         );
     });
 
+    test('should thread the events by issue', async () => {
+        const createChannelSubscription = jest.fn().mockResolvedValue({});
+        const props = {
+            ...baseProps,
+            createChannelSubscription,
+            channelSubscriptions: [],
+            selectedSubscription: null,
+        };
+        const wrapper = shallow<EditChannelSubscription>(
+            <EditChannelSubscription {...props}/>,
+        );
+        wrapper.setState(baseState);
+        wrapper.setState({
+            filters: channelSubscriptionForCloud.filters,
+            subscriptionName: channelSubscriptionForCloud.name,
+        });
+
+        wrapper.find('input[type="checkbox"]').simulate('change', {target: {checked: true}});
+        expect(wrapper.state().threadByIssue).toBe(true);
+
+        wrapper.instance().handleCreate({preventDefault: jest.fn()});
+        expect(createChannelSubscription).toHaveBeenCalledWith(
+            expect.objectContaining({thread_by_issue: true}),
+        );
+    });
+
     test('should edit a subscription', async () => {
         const createChannelSubscription = jest.fn().mockResolvedValue({});
         const editChannelSubscription = jest.fn().mockResolvedValue({});
@@ -389,6 +418,7 @@ describe('components/EditChannelSubscription', () => {
                 filters: channelSubscriptionForCloud.filters,
                 name: channelSubscriptionForCloud.name,
                 instance_id: 'https://something.atlassian.net',
+                thread_by_issue: false,
             },
         );
         expect(createChannelSubscription).not.toHaveBeenCalled();
@@ -75,6 +75,7 @@ export type State = {
     getMetaDataErr: string | null;
     submitting: boolean;
     subscriptionName: string | null;
+    threadByIssue: boolean;
     showConfirmModal: boolean;
     conflictingError: string | null;
 };
@@ -93,9 +94,11 @@ export default class EditChannelSubscription extends PureComponent<Props, State>
         };
 
         let subscriptionName = null;
+        let threadByIssue = false;
         if (props.selectedSubscription) {
             filters = Object.assign({}, filters, props.selectedSubscription.filters);
             subscriptionName = props.selectedSubscription.name;
+            threadByIssue = Boolean(props.selectedSubscription.thread_by_issue);
         }
 
         filters.fields = filters.fields || [];
@@ -119,6 +122,7 @@ export default class EditChannelSubscription extends PureComponent<Props, State>
             fetchingIssueMetadata,
             jiraIssueMetadata: null,
             subscriptionName,
+            threadByIssue,
             showConfirmModal: false,
             conflictingError: null,
             instanceID,
@@ -138,6 +142,10 @@ export default class EditChannelSubscription extends PureComponent<Props, State>
         this.setState({subscriptionName: value});
     };
 
+    handleThreadByIssueChange = (e: React.ChangeEvent<HTMLInputElement>) => {
+        this.setState({threadByIssue: e.target.checked});
+    };
+
     deleteChannelSubscription = () => {
         if (this.props.selectedSubscription) {
             this.props.deleteChannelSubscription(this.props.selectedSubscription).then((res) => {
@@ -322,12 +330,19 @@ export default class EditChannelSubscription extends PureComponent<Props, State>
             filters,
             name: this.state.subscriptionName,
             instance_id: this.state.instanceID,
+            thread_by_issue: this.state.threadByIssue,
         } as ChannelSubscription;
 
         this.setState({submitting: true, error: null});
 
         if (this.props.selectedSubscription) {
             subscription.id = this.props.selectedSubscription.id;
+            subscription.template = this.props.selectedSubscription.template;
+            subscription.priority = this.props.selectedSubscription.priority;
+            subscription.enabled = this.props.selectedSubscription.enabled;
+            subscription.snoozed_until = this.props.selectedSubscription.snoozed_until;
+            subscription.schedule = this.props.selectedSubscription.schedule;
+            subscription.rate_limit = this.props.selectedSubscription.rate_limit;
             this.props.editChannelSubscription(subscription).then((edited) => {
                 if (edited.error) {
                     this.setState({error: edited.error.message, submitting: false});
@@ -411,6 +426,16 @@ export default class EditChannelSubscription extends PureComponent<Props, State>
                             instanceID={this.state.instanceID}
                             securityLevelEmptyForJiraSubscriptions={this.props.securityLevelEmptyForJiraSubscriptions}
                         />
+                        <div className='checkbox'>
+                            <label>
+                                <input
+                                    type='checkbox'
+                                    onChange={this.handleThreadByIssueChange}
+                                    checked={this.state.threadByIssue}
+                                />
+                                {'Post the events of an issue as replies to the thread of its first event'}
+                            </label>
+                        </div>
                         <div>
                             <label className='control-label margin-bottom'>
                                 {'Approximate JQL Output'}
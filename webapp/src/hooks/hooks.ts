@@ -27,6 +27,9 @@ const instanceDisconnectCommand = '/jira instance disconnect';
 const subscribeCommand = '/jira subscribe';
 const subscribeEditCommand = '/jira subscribe edit';
 
+// Matches the flags of the server-side create command, e.g. `--project=OPS`
+const createFlagsPattern = /(^|\s)--[a-z]+=/;
+
 export default class Hooks {
     private store: any;
     private settings: any;
@@ -88,6 +91,10 @@ export default class Hooks {
         } else if (message.startsWith(issueCreateCommand)) {
             description = message.slice(issueCreateCommand.length).trim();
         }
+        if (createFlagsPattern.test(description)) {
+            // Let the server take care of the command
+            return Promise.resolve({message, args: contextArgs});
+        }
         this.store.dispatch(openCreateModalWithoutPost(description, contextArgs.channel_id));
         return Promise.resolve({});
     };
@@ -149,12 +149,21 @@ export enum FilterFieldInclusion {
     EXCLUDE_ANY = 'exclude_any',
     EMPTY = 'empty',
     INCLUDE_OR_EMPTY = 'include_or_empty',
+    CHANGED = 'changed',
+    GREATER_THAN = 'greater_than',
+    LESS_THAN = 'less_than',
+    BETWEEN = 'between',
+    WITHIN_DAYS = 'within_days',
+    MATCHES = 'matches',
 }
 
 export type FilterValue = {
     key: string;
     values: string[];
     inclusion: FilterFieldInclusion;
+    from?: string[];
+    to?: string[];
+    to_groups?: string[];
 }
 
 export type ChannelSubscriptionFilters = {
@@ -162,6 +171,7 @@ export type ChannelSubscriptionFilters = {
     events: string[];
     issue_types: string[];
     fields: FilterValue[];
+    jql?: string;
 };
 
 export type ChannelSubscription = {
@@ -170,6 +180,28 @@ export type ChannelSubscription = {
     filters: ChannelSubscriptionFilters;
     name: string;
     instance_id: string;
+    thread_by_issue?: boolean;
+    template?: SubscriptionTemplate;
+    priority?: number;
+    enabled?: boolean;
+    snoozed_until?: number;
+    schedule?: SubscriptionSchedule;
+    rate_limit?: number;
+}
+
+export type SubscriptionSchedule = {
+    days?: string[];
+    start: string;
+    end: string;
+    timezone?: string;
+}
+
+export type SubscriptionTemplate = {
+    message?: string;
+    colors?: {
+        by_priority?: {[name: string]: string};
+        by_status?: {[name: string]: string};
+    };
 }
 
 export enum InstanceType {